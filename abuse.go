@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Abuse detection for the public search endpoints. A client is its IP
+// (its /64 network for IPv6), not its User-Agent, which it sets at will.
+// Per minute we count its requests, the ones with no result and the
+// distinct queries (scrapers walk the alphabet); over cfg.AbuseRate,
+// cfg.AbuseZeroRatio or cfg.AbuseDistinct it is banned for cfg.AbuseBan
+// seconds. The counts are kept for up to cfg.CacheSize clients, the least
+// recently seen go first, and expired bans are swept every minute.
+// Operators ban and unban clients or single IPs by hand.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/blocklist
+// $ curl -i -H 'X-Api-Key: secret' -d '{"client":"203.0.113.7","ttl":3600}' http://localhost:8080/admin/blocklist
+// $ curl -i -H 'X-Api-Key: secret' -d '{"client":"203.0.113.7","unban":true}' http://localhost:8080/admin/blocklist
+
+type clientStats struct {
+	id       string
+	last     time.Time // of the last request
+	window   time.Time
+	requests int
+	zero     int
+	queries  map[string]struct{}
+	elem     *list.Element // in abuse.seen
+}
+
+type ban struct {
+	Client  string    `json:"client"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+var abuse = struct {
+	sync.Mutex
+	stats map[string]*clientStats
+	seen  *list.List      // of the stats, the most recent first
+	bans  map[string]*ban // client or IP
+}{stats: make(map[string]*clientStats), seen: list.New(), bans: make(map[string]*ban)}
+
+type visitKey struct{}
+
+type visit struct {
+	client string
+	zero   bool
+}
+
+// clientID is the IP of the client, or its /64 network for IPv6 where a
+// host easily has a lot of addresses
+func clientID(r *http.Request) string {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil || ip.To4() != nil {
+		return clientIP(r)
+	}
+	n := &net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return n.String()
+}
+
+// abuseGuard rejects banned clients and counts the others
+func abuseGuard(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AbuseRate <= 0 {
+			h(w, r)
+			return
+		}
+
+		id := clientID(r)
+		if b := banned(id, clientIP(r)); b != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(b.Expires).Seconds())+1))
+			internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusTooManyRequests)), http.StatusTooManyRequests)
+			return
+		}
+
+		// the query is peeked at for the distinct count
+		var q string
+		if r.Method == "POST" {
+			b, err := ioutil.ReadAll(r.Body)
+			_ = r.Body.Close()
+			if err != nil {
+				internalServerError(w, err, bodyStatus(err))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(b))
+			v := struct {
+				Name string `json:"name"`
+			}{}
+			_ = json.Unmarshal(b, &v)
+			q = strings.ToLower(strings.TrimSpace(sanitizeQuery(v.Name)))
+		} else {
+			q = r.URL.Path + "?" + r.URL.RawQuery
+		}
+
+		v := &visit{client: id}
+		h(w, r.WithContext(context.WithValue(r.Context(), visitKey{}, v)))
+
+		countVisit(id, q, v.zero)
+	}
+}
+
+// noteEmpty marks the request as one without results, cached responses
+// are not counted (they cost nothing)
+func noteEmpty(r *http.Request) {
+	if v, ok := r.Context().Value(visitKey{}).(*visit); ok {
+		v.zero = true
+	}
+}
+
+func banned(id, ip string) *ban {
+	abuse.Lock()
+	defer abuse.Unlock()
+
+	for _, k := range []string{id, ip} {
+		if b, ok := abuse.bans[k]; ok {
+			if time.Now().Before(b.Expires) {
+				return b
+			}
+			delete(abuse.bans, k)
+		}
+	}
+	return nil
+}
+
+func countVisit(id, q string, zero bool) {
+	abuse.Lock()
+	defer abuse.Unlock()
+
+	now := time.Now()
+	s := abuse.stats[id]
+	if s == nil {
+		for len(abuse.stats) >= cfg.CacheSize && abuse.seen.Len() > 0 {
+			dropStats(abuse.seen.Back().Value.(*clientStats))
+		}
+		s = &clientStats{id: id}
+		s.elem = abuse.seen.PushFront(s)
+		abuse.stats[id] = s
+	} else {
+		abuse.seen.MoveToFront(s.elem)
+	}
+	s.last = now
+	if now.Sub(s.window) >= time.Minute {
+		s.window, s.requests, s.zero = now, 0, 0
+		s.queries = make(map[string]struct{})
+	}
+
+	s.requests++
+	if zero {
+		s.zero++
+	}
+	if len(s.queries) <= cfg.AbuseDistinct {
+		s.queries[q] = struct{}{}
+	}
+
+	var reason string
+	switch {
+	case s.requests > cfg.AbuseRate:
+		reason = fmt.Sprintf("rate: %d/min", s.requests)
+	case s.requests >= 20 && cfg.AbuseZeroRatio > 0 && float64(s.zero)/float64(s.requests) >= cfg.AbuseZeroRatio:
+		reason = fmt.Sprintf("zero results: %d of %d", s.zero, s.requests)
+	case cfg.AbuseDistinct > 0 && len(s.queries) > cfg.AbuseDistinct:
+		reason = fmt.Sprintf("distinct queries: >%d/min", cfg.AbuseDistinct)
+	default:
+		return
+	}
+	abuse.bans[id] = &ban{Client: id, Reason: reason, Expires: now.Add(time.Duration(cfg.AbuseBan) * time.Second)}
+	dropStats(s)
+}
+
+// dropStats forgets the counts of a client, the caller holds abuse
+func dropStats(s *clientStats) {
+	abuse.seen.Remove(s.elem)
+	delete(abuse.stats, s.id)
+}
+
+// sweepAbuse drops the expired bans and the counts of the clients not
+// seen for a minute
+func sweepAbuse(now time.Time) {
+	abuse.Lock()
+	defer abuse.Unlock()
+
+	for k, b := range abuse.bans {
+		if !now.Before(b.Expires) {
+			delete(abuse.bans, k)
+		}
+	}
+	for e := abuse.seen.Back(); e != nil; e = abuse.seen.Back() {
+		s := e.Value.(*clientStats)
+		if now.Sub(s.last) < time.Minute {
+			break
+		}
+		dropStats(s)
+	}
+}
+
+func abuseLoop() {
+	for now := range time.Tick(time.Minute) {
+		sweepAbuse(now)
+	}
+}
+
+func adminBlocklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		v := struct {
+			Client string `json:"client"`
+			TTL    int    `json:"ttl"`
+			Reason string `json:"reason"`
+			Unban  bool   `json:"unban"`
+		}{}
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		if v.Client == "" {
+			internalServerError(w, fmt.Errorf("no client"), http.StatusBadRequest)
+			return
+		}
+		if v.TTL <= 0 {
+			v.TTL = cfg.AbuseBan
+		}
+		if v.Reason == "" {
+			v.Reason = "manual"
+		}
+
+		abuse.Lock()
+		if v.Unban {
+			delete(abuse.bans, v.Client)
+		} else {
+			abuse.bans[v.Client] = &ban{Client: v.Client, Reason: v.Reason, Expires: time.Now().Add(time.Duration(v.TTL) * time.Second)}
+		}
+		abuse.Unlock()
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := []*ban{}
+	abuse.Lock()
+	for _, b := range abuse.bans {
+		if time.Now().Before(b.Expires) {
+			res = append(res, b)
+		}
+	}
+	abuse.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Client < res[j].Client })
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
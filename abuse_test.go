@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientID(t *testing.T) {
+	for _, v := range []struct{ addr, ua, want string }{
+		{"203.0.113.7:1", "curl/8.0", "203.0.113.7"},
+		{"203.0.113.7:2", "Mozilla/5.0", "203.0.113.7"},
+		{"[2001:db8:1:2:3:4:5:6]:1", "curl/8.0", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff::1]:1", "Mozilla/5.0", "2001:db8:1:2::/64"},
+	} {
+		r := httptest.NewRequest("GET", "/test/suggest", nil)
+		r.RemoteAddr = v.addr
+		r.Header.Set("User-Agent", v.ua)
+		if got := clientID(r); got != v.want {
+			t.Errorf("%s %s: got %q, want %q", v.addr, v.ua, got, v.want)
+		}
+	}
+}
+
+// TestAbuseBounded checks that new clients push out the least recently
+// seen ones over cfg.CacheSize, and that the sweep drops expired bans
+func TestAbuseBounded(t *testing.T) {
+	defer func(n int) { cfg.CacheSize = n }(cfg.CacheSize)
+	cfg.CacheSize = 3
+	defer sweepAbuse(time.Now().Add(time.Hour))
+
+	for i := 1; i <= 5; i++ {
+		countVisit("198.51.100."+strconv.Itoa(i), "q", false)
+	}
+	countVisit("198.51.100.3", "q", false)
+
+	abuse.Lock()
+	n, seen := len(abuse.stats), abuse.seen.Len()
+	_, old := abuse.stats["198.51.100.2"]
+	_, kept := abuse.stats["198.51.100.3"]
+	abuse.bans["198.51.100.9"] = &ban{Client: "198.51.100.9", Expires: time.Now().Add(time.Second)}
+	abuse.bans["198.51.100.10"] = &ban{Client: "198.51.100.10", Expires: time.Now().Add(time.Hour)}
+	abuse.Unlock()
+	if n != 3 || seen != 3 || old || !kept {
+		t.Errorf("got %d stats, %d seen, evicted .2 %t, kept .3 %t", n, seen, !old, kept)
+	}
+
+	sweepAbuse(time.Now().Add(2 * time.Minute))
+	abuse.Lock()
+	n = len(abuse.stats)
+	_, expired := abuse.bans["198.51.100.9"]
+	_, current := abuse.bans["198.51.100.10"]
+	delete(abuse.bans, "198.51.100.10")
+	abuse.Unlock()
+	if n != 0 || expired || !current {
+		t.Errorf("after sweep: %d stats, expired ban %t, current ban %t", n, expired, current)
+	}
+}
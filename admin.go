@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/kinds
+// $ curl -i -H 'X-Api-Key: secret' -d '{"org": false}' http://localhost:8080/admin/kinds
+
+// adminOnly guards a handler with the admin API key from the config
+// (X-Api-Key or "Authorization: Bearer" header) or an OIDC token with the
+// role the request needs, see needRole, from an allowed address, see
+// ipAllowed. Without a configured key or OIDC
+// the admin endpoints are open, which is fine for local testing only.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ipAllowed(w, r) && authorized(w, r, needRole(r)) {
+			h(w, r)
+		}
+	}
+}
+
+// roleOnly is adminOnly with the OIDC role given by the route, not by
+// needRole
+func roleOnly(need string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ipAllowed(w, r) && authorized(w, r, need) {
+			h(w, r)
+		}
+	}
+}
+
+// authorized tells if the request has the admin key or an OIDC token with
+// the role need, and answers 401 or 403 if not
+func authorized(w http.ResponseWriter, r *http.Request, need string) bool {
+	if cfg.AdminKey == "" && cfg.OIDC == nil || cfg.AdminKey != "" && validKey(r, cfg.AdminKey) {
+		return true
+	}
+	if cfg.OIDC == nil {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized)
+		return false
+	}
+
+	role, err := oidcRole(r)
+	if err != nil {
+		log.Printf("err: admin auth: %s", err.Error())
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized)
+		return false
+	}
+	if roleLevel(role) < roleLevel(need) {
+		internalServerError(w, fmt.Errorf("%s: %s role required", http.StatusText(http.StatusForbidden), need), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func validKey(r *http.Request, key string) bool {
+	k := r.Header.Get("X-Api-Key")
+	if k == "" {
+		k = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1
+}
+
+// disabledKinds are kinds taken out of the search fan-out by an operator
+var disabledKinds sync.Map // kind -> struct{}
+
+func kindDisabled(kind string) bool {
+	_, ok := disabledKinds.Load(kind)
+	return ok
+}
+
+func adminKinds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		v := map[string]bool{}
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		for k := range v {
+			if !contains(kindOrder, k) {
+				internalServerError(w, fmt.Errorf("unknown kind: %s", k), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for k, on := range v {
+			if on {
+				disabledKinds.Delete(k)
+			} else {
+				disabledKinds.Store(k, struct{}{})
+			}
+		}
+		resCache.purge()
+		staleETags()
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := make(map[string]bool, len(kindOrder))
+	for _, k := range kindOrder {
+		res[k] = !kindDisabled(k)
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// $ curl -i -H 'X-Api-Key: secret' 'http://localhost:8080/admin/terms/inf-ru?prefix=кисл&field=name&limit=100'
+
+func adminTerms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/admin/terms/")
+	idx, err := indexDB.getIndex(key)
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	f := q.Get("field")
+	if f == "" {
+		f = "name"
+	}
+	limit := 100
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			internalServerError(w, fmt.Errorf("invalid limit: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dict, err := idx.FieldDictPrefix(field(key, f), []byte(q.Get("prefix")))
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	defer func() { _ = dict.Close() }()
+
+	type term struct {
+		Term  string `json:"term"`
+		Count uint64 `json:"count"`
+	}
+	res := make([]term, 0, limit)
+	for len(res) < limit {
+		e, err := dict.Next()
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if e == nil {
+			break
+		}
+		res = append(res, term{e.Term, e.Count})
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
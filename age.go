@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Products (inf) may be for an age range in years after the 11th csv
+// column, "age": "0-6", "12+", "adult" (18+) or "child" (0-17). A search
+// for a patient ("patient_age": 4 in the body or ?patient_age=4) leaves
+// the products not for the age out of sugg_inf (sugg for /test/select-sugg)
+// and counts them in meta.hidden["age"], the ones without an age are kept.
+// The doc lookups return the age of a product to annotate it with.
+//
+// $ curl -i -d '{"name":"парацетамол","patient_age":4}' http://localhost:8080/test/select-suggestion
+
+const (
+	ageAny        = -1 // no patient age
+	maxPatientAge = 130
+)
+
+// parseAge reads the age column as "lo-hi" or "lo+", "" when not given
+// or invalid
+func parseAge(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "adult":
+		return "18+"
+	case "child", "pediatric":
+		return "0-17"
+	}
+	lo, hi, ok := ageRange(s)
+	switch {
+	case !ok:
+		return ""
+	case hi == ageAny:
+		return strconv.Itoa(lo) + "+"
+	}
+	return strconv.Itoa(lo) + "-" + strconv.Itoa(hi)
+}
+
+// ageRange returns the years of the age, hi is ageAny for no upper limit
+func ageRange(s string) (int, int, bool) {
+	if strings.HasSuffix(s, "+") {
+		lo, err := strconv.Atoi(strings.TrimSuffix(s, "+"))
+		return lo, ageAny, err == nil && lo >= 0
+	}
+	p := strings.SplitN(s, "-", 2)
+	if len(p) != 2 {
+		return 0, 0, false
+	}
+	lo, err1 := strconv.Atoi(strings.TrimSpace(p[0]))
+	hi, err2 := strconv.Atoi(strings.TrimSpace(p[1]))
+	return lo, hi, err1 == nil && err2 == nil && lo >= 0 && lo <= hi
+}
+
+// forAge tells if a product of the age (see parseAge) suits the patient
+func forAge(age string, patient int) bool {
+	lo, hi, ok := ageRange(age)
+	return !ok || (patient >= lo && (hi == ageAny || patient <= hi))
+}
+
+// requestAge returns the patient age of the request, in the body (req) or
+// the URL, ageAny for none
+func requestAge(r *http.Request, req *int) (int, error) {
+	if req != nil {
+		if *req < 0 || *req > maxPatientAge {
+			return 0, fmt.Errorf("invalid patient_age: %d (0..%d)", *req, maxPatientAge)
+		}
+		return *req, nil
+	}
+	s := r.URL.Query().Get("patient_age")
+	if s == "" {
+		return ageAny, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > maxPatientAge {
+		return 0, fmt.Errorf("invalid patient_age: %q (0..%d)", s, maxPatientAge)
+	}
+	return n, nil
+}
+
+// aged keeps the keys of the inf hits (name -> keys) for the patient age,
+// the hits are not changed
+func (r *result) aged(key string, hits map[string][]string, patient int) map[string][]string {
+	if patient == ageAny || len(hits) == 0 {
+		return hits
+	}
+	vlt, err := r.index().getVault(key)
+	if err != nil {
+		return hits
+	}
+
+	out := make(map[string][]string, len(hits))
+	hidden := make(map[string]bool)
+	for name, keys := range hits {
+		var kept []string
+		for _, k := range keys {
+			if v, ok := vlt.Load(k); !ok || forAge(v.(*baseDoc).Age, patient) {
+				kept = append(kept, k)
+				continue
+			}
+			if hidden[k] {
+				continue
+			}
+			hidden[k] = true
+			m := r.meta()
+			if m.Hidden == nil {
+				m.Hidden = make(map[string]int, 1)
+			}
+			m.Hidden["age"]++
+		}
+		if len(kept) > 0 {
+			out[name] = kept
+		}
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// When a query finds fewer than cfg.AlternatesBelow entries, the response
+// offers up to cfg.Alternates other formulations that find more, so the
+// client can show "Search instead for ...":
+//
+//	"alternates": [{"query": "ибупрофен 200", "reason": "synonym", "count": 12}]
+//
+// Candidates are a word replaced by its synonym (cfg.Synonyms), the words
+// typed without a space split (see splitVariants), a word removed and the
+// keyboard layout conversion of the whole query.
+
+// alternate is another formulation of the query and the number of entries
+// it finds, counted as the endpoint would show them.
+type alternate struct {
+	Query  string `json:"query"`
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// maxAltCandidates bounds the searches made for one response
+const maxAltCandidates = 10
+
+// total counts the entries of the result, see sectionLen
+func (r *result) total() int {
+	n := len(r.Sugg)
+	for k, s := range r.sections() {
+		n += sectionLen(k, *s)
+	}
+	return n
+}
+
+// addAlternates fills r.Alternates for a low-yield result of the query
+// (the rewrite rules not applied yet).
+func (r *result) addAlternates(query string, conj, ua bool) {
+	have := r.total()
+	if cfg.AlternatesBelow <= 0 || have >= cfg.AlternatesBelow {
+		return
+	}
+
+	seen := map[string]bool{strings.ToLower(query): true}
+	for _, c := range altCandidates(r.db, query, ua) {
+		if len(r.Alternates) >= cfg.Alternates {
+			break
+		}
+		if seen[strings.ToLower(c.Query)] {
+			continue
+		}
+		seen[strings.ToLower(c.Query)] = true
+
+		c.Count = queryYield(r.context(), r.db, r.slop, c.Query, conj, ua)
+		if c.Count > have {
+			r.Alternates = append(r.Alternates, c)
+		}
+	}
+}
+
+// altCandidates lists the formulations to try, synonyms first
+func altCandidates(db *index, query string, ua bool) []alternate {
+	words := strings.Fields(query)
+	var res []alternate
+
+	for i, w := range words {
+		for _, s := range synonymsOf(w) {
+			alt := append([]string{}, words...)
+			alt[i] = s
+			res = append(res, alternate{Query: strings.Join(alt, " "), Reason: "synonym"})
+		}
+	}
+
+	keys := make([]string, len(kindOrder))
+	for i, k := range kindOrder {
+		keys[i] = kindKey(k, ua)
+	}
+	for _, s := range searcher.Split(db, keys, query) {
+		res = append(res, alternate{Query: s, Reason: "split"})
+	}
+
+	var kept []string
+	for _, w := range words {
+		if !strings.HasPrefix(w, "-") {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) > 1 {
+		for i, w := range words {
+			if strings.HasPrefix(w, "-") {
+				continue
+			}
+			alt := append(append([]string{}, words[:i]...), words[i+1:]...)
+			if len([]rune(strings.Join(alt, " "))) > 2 {
+				res = append(res, alternate{Query: strings.Join(alt, " "), Reason: "removed"})
+			}
+		}
+	}
+
+	conv := layoutConv(query, ua)
+	if conv != query {
+		res = append(res, alternate{Query: conv, Reason: "layout"})
+	}
+
+	if len(res) > maxAltCandidates {
+		res = res[:maxAltCandidates]
+	}
+	return res
+}
+
+// synonymsOf returns the other words of the synonym groups of the word
+func synonymsOf(word string) []string {
+	w := strings.ToLower(word)
+	var res []string
+	for _, g := range cfg.Synonyms {
+		for _, s := range g {
+			if strings.ToLower(s) != w {
+				continue
+			}
+			for _, o := range g {
+				if strings.ToLower(o) != w && !contains(res, o) {
+					res = append(res, o)
+				}
+			}
+			break
+		}
+	}
+	return res
+}
+
+// queryYield runs the search of the endpoint for the query and counts what
+// it would show: the distinct names for select-sugg (conj), the names and
+// inf keys for select-suggestion, with the slop of the request.
+func queryYield(ctx context.Context, db *index, slop int, query string, conj, ua bool) int {
+	res := &result{ctx: ctx, db: db, slop: slop}
+	name := rewriteQuery(query)
+	res.infer(name)
+	if knownZero(conj, ua, res, name) {
+		return 0
+	}
+
+	ep := epSuggestion
+	if conj {
+		ep = epSugg
+	}
+	hits, _ := res.findAll(ep, name, ua)
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for k, m := range hits {
+		for n, kk := range m {
+			if k == "atc" {
+				n = strings.TrimSpace(strings.Replace(atcName(n), "|", " ", 1))
+			}
+			switch {
+			case conj:
+				names[strings.ToUpper(n)] = true
+			case k == "inf":
+				for _, v := range kk {
+					keys[v] = true
+				}
+			default:
+				names[k+"|"+n] = true
+			}
+		}
+	}
+
+	if len(names)+len(keys) == 0 {
+		markZero(conj, ua, res, name)
+	}
+	return len(names) + len(keys)
+}
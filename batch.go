@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/blevesearch/bleve"
+)
+
+// The rows of an upload are indexed in batches of cfg.IndexBatch docs,
+// cfg.IndexWorkers batches at a time per index, while the next rows are
+// read. A failed batch fails its rows, see ingestFailedRows.
+
+type indexBatch struct {
+	b    *bleve.Batch
+	line int // of its first row
+}
+
+// indexer indexes the docs of a generation build
+type indexer struct {
+	wg    sync.WaitGroup
+	open  map[bleve.Index]*indexBatch
+	queue map[bleve.Index]chan *indexBatch
+}
+
+func newIndexer() *indexer {
+	return &indexer{
+		open:  make(map[bleve.Index]*indexBatch, 2*len(kindOrder)),
+		queue: make(map[bleve.Index]chan *indexBatch, 2*len(kindOrder)),
+	}
+}
+
+// index adds the doc of the row at line to the batch of idx
+func (x *indexer) index(idx bleve.Index, id string, data interface{}, line int) error {
+	ib, ok := x.open[idx]
+	if !ok {
+		ib = &indexBatch{b: idx.NewBatch(), line: line}
+		x.open[idx] = ib
+	}
+	err := ib.b.Index(id, data)
+	if err != nil {
+		return err
+	}
+	if ib.b.Size() >= cfg.IndexBatch {
+		x.flush(idx)
+	}
+	return nil
+}
+
+// flush hands the batch of idx to its workers, started on the first one
+func (x *indexer) flush(idx bleve.Index) {
+	ib, ok := x.open[idx]
+	if !ok {
+		return
+	}
+	delete(x.open, idx)
+
+	q, ok := x.queue[idx]
+	if !ok {
+		q = make(chan *indexBatch, cfg.IndexWorkers)
+		x.queue[idx] = q
+		for i := 0; i < cfg.IndexWorkers; i++ {
+			x.wg.Add(1)
+			go func() {
+				defer x.wg.Done()
+				for ib := range q {
+					err := idx.Batch(ib.b)
+					if err != nil {
+						log.Printf("err: batch at line %d: %s", ib.line, err.Error())
+						ingestFailedRows(ib.b.Size(), fmt.Errorf("batch of %d rows at line %d: %s", ib.b.Size(), ib.line, err.Error()))
+					}
+				}
+			}()
+		}
+	}
+	q <- ib
+}
+
+// close indexes the rest and waits for the workers
+func (x *indexer) close() {
+	for idx := range x.open {
+		x.flush(idx)
+	}
+	for _, q := range x.queue {
+		close(q)
+	}
+	x.wg.Wait()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// breaker is a per index circuit breaker: after cfg.BreakerFailures failed
+// searches in a row the index is skipped for cfg.BreakerCooldown seconds,
+// then a single trial search decides whether it closes again (half-open).
+type breaker struct {
+	sync.Mutex
+	fails  int
+	opened time.Time
+	trial  bool
+}
+
+var breakers sync.Map // index key -> *breaker
+
+func breakerFor(key string) *breaker {
+	b, _ := breakers.LoadOrStore(key, &breaker{})
+	return b.(*breaker)
+}
+
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.fails < cfg.BreakerFailures || cfg.BreakerFailures <= 0 {
+		return true
+	}
+	if b.trial || time.Since(b.opened) < time.Duration(cfg.BreakerCooldown)*time.Second {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// cancel ends a search stopped by its request, which tells nothing about
+// the index
+func (b *breaker) cancel() {
+	b.Lock()
+	defer b.Unlock()
+	b.trial = false
+}
+
+func (b *breaker) done(err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+	if err == nil {
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.fails >= cfg.BreakerFailures {
+		b.opened = time.Now()
+	}
+}
+
+// searchIndex runs a search guarded by the breaker of the index and
+// bounded by cfg.SearchTimeout milliseconds. It ends with ctx, the context
+// of the request, and gives its slots back then.
+func searchIndex(ctx context.Context, key string, idx bleve.Index, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	release, err := acquire(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	b := breakerFor(key)
+	if !b.allow() {
+		return nil, fmt.Errorf("circuit breaker is open (%s)", key)
+	}
+
+	parent := ctx
+	if cfg.SearchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.SearchTimeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	if cfg.IndexLayout == layoutSingle {
+		r := *req
+		r.Query = scopeQuery(key, req.Query)
+		req = &r
+	}
+	res, err := idx.SearchInContext(ctx, req)
+	if parent.Err() != nil {
+		b.cancel()
+		return nil, parent.Err()
+	}
+	b.done(err)
+	return res, err
+}
+
+// find searches one kind index. A failing index yields no matches and is
+// reported as degraded instead of failing the whole request.
+func (r *result) find(key, name string, mode searchMode) map[string][]string {
+	kind := strings.Split(key, "-")[0]
+	if kindDisabled(kind) {
+		if m := r.meta(); !contains(m.Disabled, kind) {
+			m.Disabled = append(m.Disabled, kind)
+		}
+		return nil
+	}
+	if r.busy || r.Meta != nil && contains(r.Meta.Degraded, kind) {
+		return nil
+	}
+	if r.only != nil && !contains(r.only, kind) {
+		return nil
+	}
+
+	if err := r.context().Err(); err != nil {
+		r.err = err
+		return nil
+	}
+
+	res, err := searcher.Find(r.context(), r.db, key, name, mode)
+	if r.context().Err() != nil {
+		r.err = r.context().Err() // the request is gone, the kind is not degraded
+		return nil
+	}
+	if errors.Is(err, errBusy) {
+		r.busy = true
+		r.err = err
+		return nil
+	}
+	if err != nil {
+		log.Printf("err: %s", err.Error())
+		m := r.meta()
+		m.Degraded = append(m.Degraded, kind)
+		r.err = err
+		return nil
+	}
+	return res
+}
+
+// failed returns an error only if no enabled kind could be searched, a
+// search was shed (errBusy) or the request was canceled
+func (r *result) failed() error {
+	if r.busy {
+		return r.err
+	}
+	if err := r.context().Err(); err != nil {
+		return err
+	}
+	if r.Meta == nil || len(r.Meta.Degraded) == 0 || len(r.Meta.Degraded)+len(r.Meta.Disabled) < len(kindOrder) {
+		return nil
+	}
+	return r.err
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// context is the one of the request the result is searched for
+func (r *result) context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// index is what the result is searched in, see atGeneration
+func (r *result) index() *index {
+	if r.db != nil {
+		return r.db
+	}
+	return indexDB
+}
+
+func (r *result) meta() *meta {
+	if r.Meta == nil {
+		r.Meta = &meta{}
+	}
+	return r.Meta
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// The A-Z catalog pages list every name of a kind starting with a letter,
+// in the order of the alphabet and browsePage names a page. The listing
+// is built with the generation (see letterIndex), a page is a slice of it
+// less the deleted docs. Names starting with a digit are under "0-9", with
+// a Latin letter under "A-Z", the rest without a letter under "#". Without
+// a letter the letters are listed in order with their counts.
+//
+// $ curl -i http://localhost:8080/test/browse/inf/ru
+// $ curl -i 'http://localhost:8080/test/browse/inf/ru/А?page=2'
+// $ curl -i http://localhost:8080/test/browse-counts/inf/ru
+
+const browsePage = 100
+
+// alphabets are the letters of the navigation, see browseCounts
+var alphabets = map[string][]string{
+	"ru": strings.Split("АБВГДЕЁЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯ", ""),
+	"ua": strings.Split("АБВГҐДЕЄЖЗИІЇЙКЛМНОПРСТУФХЦЧШЩЬЮЯ", ""),
+}
+
+// nameLetter is the catalog letter of a name
+func nameLetter(name string) string {
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			return "A-Z"
+		case unicode.IsLetter(r):
+			return string(unicode.ToUpper(r))
+		case unicode.IsDigit(r):
+			return "0-9"
+		}
+	}
+	return "#"
+}
+
+// letterIndex lists the names of every vault by letter, sorted by the
+// collation of the language; docs of the same name share an entry
+func letterIndex(vaults map[string]*sync.Map) map[string]map[string][]sugg {
+	res := make(map[string]map[string][]sugg, len(vaults))
+	for key, vlt := range vaults {
+		keys := make(map[string][]string)
+		vlt.Range(func(k, v interface{}) bool {
+			d := v.(*baseDoc)
+			keys[d.Name] = append(keys[d.Name], k.(string))
+			return true
+		})
+
+		byLetter := make(map[string][]string)
+		for name := range keys {
+			l := nameLetter(name)
+			byLetter[l] = append(byLetter[l], name)
+		}
+
+		// a collation key per name, comparing the names in a sort is slow
+		c := letterCollator(key[len(key)-2:])
+		buf := &collate.Buffer{}
+		ckeys := make(map[string]string, len(keys))
+		for name := range keys {
+			ckeys[name] = string(c.KeyFromString(buf, name))
+			buf.Reset()
+		}
+		res[key] = make(map[string][]sugg, len(byLetter))
+		for l, names := range byLetter {
+			sort.Slice(names, func(i, j int) bool {
+				if a, b := ckeys[names[i]], ckeys[names[j]]; a != b {
+					return a < b
+				}
+				return names[i] < names[j]
+			})
+			list := make([]sugg, len(names))
+			for i, name := range names {
+				ks := keys[name]
+				sort.Slice(ks, func(i, j int) bool { return keyLess(ks[i], ks[j]) })
+				list[i] = sugg{Name: name, Keys: ks}
+			}
+			res[key][l] = list
+		}
+	}
+	return res
+}
+
+// letterCollator sorts in the alphabet of the lang, ru or ua
+func letterCollator(lang string) *collate.Collator {
+	if lang == "ua" {
+		return collate.New(language.Ukrainian)
+	}
+	return collate.New(language.Russian)
+}
+
+// keyLess orders doc keys by number
+func keyLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// browsePath checks the kind and lang of a browse path, uk is ua
+func browsePath(kind, lang string) (string, string, error) {
+	if !contains(kindOrder, kind) {
+		return "", "", fmt.Errorf("unknown kind: %q", kind)
+	}
+	if lang == "uk" {
+		lang = "ua"
+	}
+	if !contains(langOrder, lang) {
+		return "", "", fmt.Errorf("unknown lang: %q", lang)
+	}
+	return kind, lang, nil
+}
+
+type letterCount struct {
+	Letter string `json:"letter"`
+	Count  int    `json:"count"`
+}
+
+// letterCounts counts the names of every letter of the listing: the
+// letters of the alphabet (empty ones too), "0-9" and "A-Z", then any
+// other. The deleted docs are looked up by their tombstones, there are
+// few of them.
+func letterCounts(kind, lang string, letters map[string][]sugg, vlt *sync.Map) []letterCount {
+	count := make(map[string]int, len(letters))
+	for l, list := range letters {
+		count[l] = len(list)
+	}
+	if vlt != nil {
+		deleted := make(map[string]bool)
+		tombstones.Range(func(_, v interface{}) bool {
+			if t := v.(*tombstone); t.Kind == kind {
+				if d, ok := vlt.Load(t.ID); ok {
+					deleted[d.(*baseDoc).Name] = true
+				}
+			}
+			return true
+		})
+		for name := range deleted {
+			l := nameLetter(name)
+			for _, s := range letters[l] {
+				if s.Name == name && len(liveKeys(kind, s.Keys)) == 0 {
+					count[l]--
+				}
+			}
+		}
+	}
+
+	ls := append(append([]string(nil), alphabets[lang]...), "0-9", "A-Z")
+	var rest []string
+	for l := range count {
+		if !contains(ls, l) {
+			rest = append(rest, l)
+		}
+	}
+	sortNames(letterCollator(lang), rest)
+
+	res := make([]letterCount, 0, len(ls)+len(rest))
+	for _, l := range append(ls, rest...) {
+		res = append(res, letterCount{l, count[l]})
+	}
+	return res
+}
+
+func browseCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/test/browse-counts/"), "/")
+	if len(p) != 2 {
+		internalServerError(w, fmt.Errorf("%s: want /test/browse-counts/{kind}/{lang}", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
+		return
+	}
+	kind, lang, err := browsePath(p[0], p[1])
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+
+	g, _ := dataIndex(r).generations()
+	if g == nil {
+		internalServerError(w, fmt.Errorf("no data uploaded"), http.StatusServiceUnavailable)
+		return
+	}
+	if notModified(w, r, cacheKey(r, nil)) {
+		return
+	}
+	letters := g.lettersOf(kind + "-" + lang)
+	if kindDisabled(kind) {
+		letters = nil
+	}
+
+	b, err := marshalJSON(r, letterCounts(kind, lang, letters, g.vault[kind+"-"+lang]))
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+func selectBrowse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/test/browse/"), "/")
+	if len(p) < 2 || len(p) > 3 {
+		internalServerError(w, fmt.Errorf("%s: want /test/browse/{kind}/{lang}[/{letter}]", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
+		return
+	}
+	kind, lang, err := browsePath(p[0], p[1])
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+
+	page := 1
+	if s := r.URL.Query().Get("page"); s != "" {
+		page, err = strconv.Atoi(s)
+		if err != nil || page <= 0 {
+			internalServerError(w, fmt.Errorf("invalid page: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	g, _ := dataIndex(r).generations()
+	if g == nil {
+		internalServerError(w, fmt.Errorf("no data uploaded"), http.StatusServiceUnavailable)
+		return
+	}
+	if notModified(w, r, cacheKey(r, nil)) {
+		return
+	}
+	letters := g.lettersOf(kind + "-" + lang)
+	if kindDisabled(kind) {
+		letters = nil
+	}
+
+	var out interface{}
+	if len(p) == 2 || p[2] == "" {
+		res := []letterCount{}
+		for _, c := range letterCounts(kind, lang, letters, g.vault[kind+"-"+lang]) {
+			if c.Count > 0 {
+				res = append(res, c)
+			}
+		}
+		out = res
+	} else {
+		list := liveNames(kind, letters[strings.ToUpper(p[2])])
+		res := struct {
+			Kind   string `json:"kind"`
+			Lang   string `json:"lang"`
+			Letter string `json:"letter"`
+			Page   int    `json:"page"`
+			Pages  int    `json:"pages"`
+			Total  int    `json:"total"`
+			Names  []sugg `json:"names"`
+		}{Kind: kind, Lang: lang, Letter: strings.ToUpper(p[2]), Page: page, Total: len(list), Names: []sugg{}}
+		res.Pages = (len(list) + browsePage - 1) / browsePage
+		if from := (page - 1) * browsePage; from < len(list) {
+			to := from + browsePage
+			if to > len(list) {
+				to = len(list)
+			}
+			res.Names = list[from:to]
+		}
+		out = res
+	}
+
+	b, err := marshalJSON(r, out)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// liveNames drops the deleted keys of the listing, and the names left
+// without any
+func liveNames(kind string, list []sugg) []sugg {
+	res := list[:0:0]
+	for _, s := range list {
+		keys := liveKeys(kind, s.Keys)
+		if len(keys) == len(s.Keys) {
+			res = append(res, s)
+			continue
+		}
+		if len(keys) > 0 {
+			res = append(res, sugg{Name: s.Name, Keys: keys})
+		}
+	}
+	return res
+}
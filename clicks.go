@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind priors: users click some kinds far more than others (products
+// mostly), so the kinds are boosted after their share of the clicks. The
+// clicks are JSON lines in cfg.ClickLog, written by /test/click or
+// exported there by the analytics:
+//
+//	{"ts":"2026-10-15T16:04:07+03:00","query":"кислота","kind":"inf","key":"101"}
+//
+// Every cfg.KindPriorsEvery minutes (and on POST /admin/kind-priors) the
+// clicks of the last cfg.ClickWindow days are counted again: the boost of
+// a kind is its share of the clicks over the even share, smoothed with
+// cfg.ClickSmoothing clicks per kind and bounded by cfg.KindBoostMax. It
+// scales the match rank of the names in the flat suggestion list and
+// orders the kinds that get the rest of the limit, see capResult. The
+// clicks of a state import (/admin/state/import) are counted with them.
+//
+// $ curl -i -d '{"query":"кислота","kind":"inf","key":"101"}' http://localhost:8080/test/click
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/kind-priors
+// $ curl -i -H 'X-Api-Key: secret' -X POST http://localhost:8080/admin/kind-priors
+
+type click struct {
+	Time  time.Time `json:"ts"`
+	Query string    `json:"query"`
+	Kind  string    `json:"kind"`
+	Key   string    `json:"key,omitempty"`
+}
+
+var kindPriors = struct {
+	sync.RWMutex
+	learned time.Time
+	clicks  map[string]int
+	boost   map[string]float64
+	seed    map[string]int // imported clicks, see adminStateImport
+}{}
+
+var clickLog sync.Mutex
+
+func clickLogPath() string {
+	if cfg.ClickLog != "" {
+		return cfg.ClickLog
+	}
+	return filepath.Join(os.TempDir(), "test-bleve-clicks.jsonl")
+}
+
+// kindBoost returns the boost of the kind, 1 before any clicks
+func kindBoost(kind string) float64 {
+	kindPriors.RLock()
+	defer kindPriors.RUnlock()
+
+	if b, ok := kindPriors.boost[kind]; ok {
+		return b
+	}
+	return 1
+}
+
+// boostedKinds returns kindOrder with the most boosted kinds first
+func boostedKinds() []string {
+	kinds := append([]string(nil), kindOrder...)
+	sort.SliceStable(kinds, func(i, j int) bool { return kindBoost(kinds[i]) > kindBoost(kinds[j]) })
+	return kinds
+}
+
+// learnKindPriors counts the clicks of the window into the kind boosts
+func learnKindPriors() error {
+	clicks := make(map[string]int, len(kindOrder))
+	f, err := os.Open(clickLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		since := time.Now().AddDate(0, 0, -cfg.ClickWindow)
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64<<10), 1<<20)
+		for s.Scan() {
+			var c click
+			if json.Unmarshal(s.Bytes(), &c) != nil || !contains(kindOrder, c.Kind) || c.Time.Before(since) {
+				continue
+			}
+			clicks[c.Kind]++
+		}
+		err = s.Err()
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	kindPriors.RLock()
+	for k, v := range kindPriors.seed {
+		clicks[k] += v
+	}
+	kindPriors.RUnlock()
+
+	n := 0
+	for _, v := range clicks {
+		n += v
+	}
+	boost := make(map[string]float64, len(kindOrder))
+	if n > 0 {
+		smooth := float64(cfg.ClickSmoothing)
+		for _, k := range kindOrder {
+			b := (float64(clicks[k]) + smooth) / (float64(n) + smooth*float64(len(kindOrder))) * float64(len(kindOrder))
+			if b > cfg.KindBoostMax {
+				b = cfg.KindBoostMax
+			}
+			if b < 1/cfg.KindBoostMax {
+				b = 1 / cfg.KindBoostMax
+			}
+			boost[k] = b
+		}
+	}
+
+	kindPriors.Lock()
+	kindPriors.learned, kindPriors.clicks, kindPriors.boost = time.Now(), clicks, boost
+	kindPriors.Unlock()
+
+	resCache.purge()
+	staleETags()
+	log.Printf("kind priors: %d clicks", n)
+	return nil
+}
+
+func kindPriorsLoop() {
+	if cfg.KindPriorsEvery <= 0 {
+		return
+	}
+	if err := learnKindPriors(); err != nil {
+		log.Printf("err: kind priors: %s", err.Error())
+	}
+	for range time.Tick(time.Duration(cfg.KindPriorsEvery) * time.Minute) {
+		if err := learnKindPriors(); err != nil {
+			log.Printf("err: kind priors: %s", err.Error())
+		}
+	}
+}
+
+func writeClick(c *click) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	clickLog.Lock()
+	defer clickLog.Unlock()
+
+	f, err := os.OpenFile(clickLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func selectClick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	c := &click{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(c.Key, ":") {
+		key, _, err := parseKey(c.Key)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		if c.Kind == "" {
+			c.Kind = strings.Split(key, "-")[0]
+		}
+	}
+	if !contains(kindOrder, c.Kind) {
+		internalServerError(w, fmt.Errorf("unknown kind: %q", c.Kind), http.StatusBadRequest)
+		return
+	}
+	c.Time = time.Now()
+
+	err = writeClick(c)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminKindPriors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		err := learnKindPriors()
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := struct {
+		Learned *time.Time         `json:"learned,omitempty"`
+		Clicks  map[string]int     `json:"clicks"`
+		Boost   map[string]float64 `json:"boost"`
+	}{Clicks: make(map[string]int, len(kindOrder)), Boost: make(map[string]float64, len(kindOrder))}
+	kindPriors.RLock()
+	if !kindPriors.learned.IsZero() {
+		t := kindPriors.learned
+		res.Learned = &t
+	}
+	for _, k := range kindOrder {
+		res.Clicks[k] = kindPriors.clicks[k]
+	}
+	kindPriors.RUnlock()
+	for _, k := range kindOrder {
+		res.Boost[k] = kindBoost(k)
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distributed mode: a coordinator started with -topology owns no data for
+// the kinds listed there and forwards their searches to the nodes owning
+// them (plain instances of this service), merging the answers into one
+// response as if the indexes were local. Kinds not in the topology are
+// searched locally.
+//
+// topology.json:
+//
+//	{"nodes": [
+//		{"url": "http://10.0.0.1:8080", "kinds": ["inf"]},
+//		{"url": "http://10.0.0.2:8080", "kinds": ["atc", "inn", "act", "org"]}
+//	]}
+//
+// Nodes return the sort fields of the docs with their keys, which the
+// coordinator keeps by index for the data generation of the node (see
+// remoteVault), so the keys of several names and nodes are ordered as the
+// local ones, see magicLess. The coordinator authenticates with
+// cfg.NodeToken, see nodeOnly.
+
+type topology struct {
+	Nodes []struct {
+		URL   string   `json:"url"`
+		Kinds []string `json:"kinds"`
+	} `json:"nodes"`
+
+	owner map[string]string // kind or index key -> node url
+
+	sync.Mutex
+	docs map[string]*remoteDocs // index key -> docs
+}
+
+// remoteDocs are the docs a node returned for an index in its data
+// generation gen: key -> *baseDoc, n of them
+type remoteDocs struct {
+	gen string
+	n   int
+	vlt *sync.Map
+}
+
+// remoteVault returns the docs the nodes returned for the index key, nil
+// for a local one or none yet
+func remoteVault(key string) *sync.Map {
+	if nodeFor(key) == "" {
+		return nil
+	}
+	cluster.Lock()
+	defer cluster.Unlock()
+	if d, ok := cluster.docs[key]; ok {
+		return d.vlt
+	}
+	return nil
+}
+
+// storeRemote keeps the docs a node returned for the index key in its
+// generation gen. The docs of another generation are dropped, so are all
+// when there would be more than cfg.CacheSize.
+func storeRemote(key, gen string, docs map[string]*baseDoc) {
+	cluster.Lock()
+	defer cluster.Unlock()
+
+	d := cluster.docs[key]
+	if d == nil || d.gen != gen || cfg.CacheSize > 0 && d.n+len(docs) > cfg.CacheSize {
+		d = &remoteDocs{gen: gen, vlt: &sync.Map{}}
+		if cluster.docs == nil {
+			cluster.docs = make(map[string]*remoteDocs)
+		}
+		cluster.docs[key] = d
+	}
+	for k, v := range docs {
+		if _, ok := d.vlt.LoadOrStore(k, v); !ok {
+			d.n++
+		}
+	}
+}
+
+// nodeGeneration tells the data of the node apart: its generation and
+// the changes since, see memoState
+func nodeGeneration() string {
+	g, seq := memoState()
+	return fmt.Sprintf("%d.%d", g, seq)
+}
+
+// nodeOnly lets a coordinator in with the node token, anyone else goes
+// through adminOnly
+func nodeOnly(h http.HandlerFunc) http.HandlerFunc {
+	admin := adminOnly(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.NodeToken == "" || r.Header.Get(nodeTokenHeader) == "" {
+			admin(w, r)
+			return
+		}
+		if !ipAllowed(w, r) {
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(nodeTokenHeader)), []byte(cfg.NodeToken)) != 1 {
+			internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+const nodeTokenHeader = "X-Node-Token"
+
+var cluster *topology
+
+var clusterClient = &http.Client{Timeout: 5 * time.Second}
+
+func loadTopology(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	t := &topology{}
+	err = json.Unmarshal(b, t)
+	if err != nil {
+		return err
+	}
+
+	t.owner = make(map[string]string)
+	for _, n := range t.Nodes {
+		for _, k := range n.Kinds {
+			if _, ok := t.owner[k]; ok {
+				return fmt.Errorf("topology: %s has two owners", k)
+			}
+			t.owner[k] = strings.TrimRight(n.URL, "/")
+		}
+	}
+
+	cluster = t
+	return nil
+}
+
+// nodeFor returns the node owning the index or "" for a local one
+func nodeFor(key string) string {
+	if cluster == nil {
+		return ""
+	}
+	if u, ok := cluster.owner[key]; ok {
+		return u
+	}
+	return cluster.owner[strings.Split(key, "-")[0]]
+}
+
+type findRequest struct {
+	Key  string     `json:"key"`
+	Name string     `json:"name"`
+	Conj bool       `json:"conj"`
+	Mode searchMode `json:"mode,omitempty"` // over Conj, see nameQuery
+	Docs bool       `json:"docs,omitempty"` // answer a findResponse
+}
+
+// findResponse is the answer to a findRequest with docs: the names with
+// their keys, the sort fields of the docs by key and the generation of the
+// node they are of, see nodeGeneration
+type findResponse struct {
+	Names      map[string][]string `json:"names"`
+	Docs       map[string]*baseDoc `json:"docs"`
+	Generation string              `json:"generation"`
+}
+
+// findAny runs findByName locally or on the owning node
+func findAny(ctx context.Context, key, name string, mode searchMode) (map[string][]string, error) {
+	node := nodeFor(key)
+	if node == "" {
+		return findByName(ctx, key, name, mode)
+	}
+
+	b, err := json.Marshal(findRequest{key, name, mode == modeInfix, mode, true})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", node+"/internal/find", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.NodeToken != "" {
+		req.Header.Set(nodeTokenHeader, cfg.NodeToken)
+	}
+
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", node, strings.TrimSpace(string(b)))
+	}
+
+	out := findResponse{}
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		return nil, err
+	}
+	storeRemote(key, out.Generation, out.Docs)
+	return out.Names, nil
+}
+
+// internalFind serves findByName to a coordinator, keys ranked by sales
+func internalFind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := findRequest{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if v.Mode == "" {
+		v.Mode = conjMode(v.Conj)
+	}
+	out, err := findByName(r.Context(), v.Key, v.Name, v.Mode)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	rankShared()
+	for k := range out {
+		out[k] = indexDB.sortMagic(v.Key, out[k]...)
+	}
+
+	var res interface{} = out
+	if v.Docs {
+		res = findResponse{Names: out, Docs: sortFields(v.Key, out), Generation: nodeGeneration()}
+	}
+	b, err = json.Marshal(res)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// sortFields are the fields of magicLess of the docs of the names
+func sortFields(key string, names map[string][]string) map[string]*baseDoc {
+	res := make(map[string]*baseDoc)
+	vlt, err := indexDB.getVault(key)
+	if err != nil {
+		return res
+	}
+	for _, keys := range names {
+		for _, k := range keys {
+			if v, ok := vlt.Load(k); ok {
+				d := v.(*baseDoc)
+				res[k] = &baseDoc{ID: d.ID, Name: d.Name, Info: d.Info, Sale: d.Sale}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestNodeToken runs a coordinator search on a node (the test handler
+// itself) in OIDC-only mode, where there is no admin key to forward
+func TestNodeToken(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.AdminKey = ""
+	cfg.OIDC = &oidcConfig{Issuer: "https://idp.invalid", Audience: "test-bleve"}
+	cfg.NodeToken = "node"
+
+	srv := httptest.NewServer(testHandler)
+	defer srv.Close()
+	defer func(c *topology) { cluster = c }(cluster)
+	cluster = &topology{owner: map[string]string{"act": srv.URL}}
+
+	for _, v := range []struct {
+		token string
+		code  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"other", http.StatusUnauthorized},
+		{"node", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/internal/find", strings.NewReader(`{"key":"act-ru","name":"кислота"}`))
+		r.RemoteAddr = "127.0.0.1:1"
+		if v.token != "" {
+			r.Header.Set(nodeTokenHeader, v.token)
+		}
+		testHandler.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("token %q: got %d, want %d", v.token, w.Code, v.code)
+		}
+	}
+
+	out, err := findAny(context.Background(), "act-ru", "кислота", modeInfix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out["Кислота Аскорбиновая"], []string{"302"}) {
+		t.Errorf("got %v", out)
+	}
+	if _, ok := remoteVault("act-ru").Load("302"); !ok {
+		t.Errorf("no sort fields of 302")
+	}
+}
+
+// TestRemoteOrder checks that keys of several nodes are ordered by their
+// sort fields, not by node
+func TestRemoteOrder(t *testing.T) {
+	defer func(c *topology) { cluster = c }(cluster)
+	cluster = &topology{owner: map[string]string{"inf": "http://node"}}
+
+	docs := make(map[string]*baseDoc)
+	for _, d := range []*baseDoc{
+		{ID: 1, Name: "a", Sale: 10},
+		{ID: 2, Name: "b", Sale: 30, Info: 1},
+		{ID: 3, Name: "c", Sale: 20},
+	} {
+		docs[strconv.Itoa(d.ID)] = d
+	}
+	storeRemote("inf-ru", "1.0", docs)
+
+	idx := &index{}
+	if got, want := idx.sortMagic("inf-ru", "1", "3", "2", "4"), []string{"2", "3", "1", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestRemoteDocs checks that the docs of a node are dropped with its
+// generation and kept within cfg.CacheSize
+func TestRemoteDocs(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	defer func(c *topology) { cluster = c }(cluster)
+	cluster = &topology{owner: map[string]string{"inf": "http://node"}}
+	cfg.CacheSize = 3
+
+	if remoteVault("inf-ru") != nil {
+		t.Fatal("docs before any response")
+	}
+	storeRemote("inf-ru", "1.0", map[string]*baseDoc{"1": {ID: 1, Sale: 10}, "2": {ID: 2}})
+	storeRemote("inf-ru", "1.0", map[string]*baseDoc{"2": {ID: 2}})
+	if _, ok := remoteVault("inf-ru").Load("1"); !ok {
+		t.Errorf("doc of the generation dropped")
+	}
+
+	storeRemote("inf-ru", "1.1", map[string]*baseDoc{"1": {ID: 1, Sale: 20}})
+	if v, ok := remoteVault("inf-ru").Load("1"); !ok || v.(*baseDoc).Sale != 20 {
+		t.Errorf("new generation: got %v, want the sale of 20", v)
+	}
+	if _, ok := remoteVault("inf-ru").Load("2"); ok {
+		t.Errorf("doc of the old generation kept")
+	}
+
+	storeRemote("inf-ru", "1.1", map[string]*baseDoc{"3": {ID: 3}, "4": {ID: 4}})
+	storeRemote("inf-ru", "1.1", map[string]*baseDoc{"5": {ID: 5}})
+	n := 0
+	remoteVault("inf-ru").Range(func(_, _ interface{}) bool { n++; return true })
+	if n != 1 {
+		t.Errorf("got %d docs over cache_size 3, want only the last response", n)
+	}
+}
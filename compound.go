@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// A query typed without a space ("парацетамолкофеин") is one word no name
+// has. The split stage (see stageQuery) and the alternates cut such words
+// into the terms of the name field, taken from the term dictionary of the
+// index once per generation: the variants with the fewest parts first,
+// then the ones of the most frequent terms.
+
+const (
+	compoundMinPart  = 3 // runes of a part
+	compoundMaxParts = 3
+	compoundVariants = 3 // per query
+)
+
+// compoundDict is the term dictionary of the name field of an index
+type compoundDict map[string]uint64
+
+// compoundsOf returns the dictionary of the key in g, built on first use.
+// An index shared by several keys shares it.
+func (g *generation) compoundsOf(key string) compoundDict {
+	name := indexName(key) + "/" + field(key, "name")
+	if d, ok := g.compounds.Load(name); ok {
+		return d.(compoundDict)
+	}
+
+	d := compoundDict{}
+	if idx, ok := g.store[key]; ok {
+		dict, err := idx.FieldDict(field(key, "name"))
+		if err == nil {
+			for e, err := dict.Next(); e != nil && err == nil; e, err = dict.Next() {
+				d[e.Term] = e.Count
+			}
+			_ = dict.Close()
+		}
+	}
+	v, _ := g.compounds.LoadOrStore(name, d)
+	return v.(compoundDict)
+}
+
+// splits returns the ways to cut the word into terms, best first
+func (d compoundDict) splits(word string) [][]string {
+	w := []rune(word)
+	if len(w) < 2*compoundMinPart || d[word] > 0 {
+		return nil
+	}
+
+	var res [][]string
+	var cut func(from int, parts []string)
+	cut = func(from int, parts []string) {
+		if from == len(w) {
+			if len(parts) > 1 {
+				res = append(res, append([]string(nil), parts...))
+			}
+			return
+		}
+		if len(parts) == compoundMaxParts {
+			return
+		}
+		for to := from + compoundMinPart; to <= len(w); to++ {
+			if p := string(w[from:to]); d[p] > 0 {
+				cut(to, append(parts, p))
+			}
+		}
+	}
+	cut(0, nil)
+
+	score := func(parts []string) float64 {
+		s := 0.0
+		for _, p := range parts {
+			s += math.Log(float64(d[p]) + 1)
+		}
+		return s
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if len(res[i]) != len(res[j]) {
+			return len(res[i]) < len(res[j])
+		}
+		return score(res[i]) > score(res[j])
+	})
+	return res
+}
+
+// splitVariants returns up to compoundVariants queries with the unknown
+// words split by the dictionaries of the keys, none if nothing splits
+func (i *index) splitVariants(keys []string, query string) []string {
+	g, _ := i.generations()
+	if g == nil {
+		return nil
+	}
+	return splitWith(g.compoundsOf, keys, query)
+}
+
+// splitWith splits the query by the dictionaries dictOf returns
+func splitWith(dictOf func(key string) compoundDict, keys []string, query string) []string {
+	words := strings.Fields(query)
+	splits := make([][][]string, len(words))
+	found := false
+	for n, w := range words {
+		lw := strings.ToLower(w)
+		if strings.HasPrefix(w, "-") || len(queryWords(lw)) != 1 || queryWords(lw)[0] != lw {
+			continue
+		}
+		for _, k := range keys {
+			d := dictOf(k)
+			if d[lw] > 0 {
+				splits[n] = nil
+				break
+			}
+			for _, s := range d.splits(lw) {
+				if !containsSplit(splits[n], s) {
+					splits[n] = append(splits[n], s)
+				}
+			}
+		}
+		found = found || len(splits[n]) > 0
+	}
+	if !found {
+		return nil
+	}
+
+	// variant v takes the v-th split of every word that has as many
+	var res []string
+	for v := 0; v < compoundVariants; v++ {
+		alt := make([]string, len(words))
+		more := false
+		for n, w := range words {
+			switch {
+			case len(splits[n]) > v:
+				alt[n], more = strings.Join(splits[n][v], " "), true
+			case len(splits[n]) > 0:
+				alt[n] = strings.Join(splits[n][0], " ")
+			default:
+				alt[n] = w
+			}
+		}
+		if !more {
+			break
+		}
+		res = append(res, strings.Join(alt, " "))
+	}
+	return res
+}
+
+func containsSplit(list [][]string, s []string) bool {
+	for _, l := range list {
+		if strings.Join(l, " ") == strings.Join(s, " ") {
+			return true
+		}
+	}
+	return false
+}
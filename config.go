@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+type config struct {
+	// AdminKey protects the /admin/ endpoints, see adminOnly
+	AdminKey string `json:"admin_key,omitempty"`
+	// AdminAllow limits the upload and admin routes to these networks,
+	// TrustedProxies may set X-Forwarded-For, see clientIP
+	AdminAllow     []string `json:"admin_allow,omitempty"`
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	allowNets      []*net.IPNet
+	proxyNets      []*net.IPNet
+	// NodeToken is shared by a coordinator and its nodes, see nodeOnly
+	NodeToken string `json:"node_token,omitempty"`
+	// OIDC lets people in with tokens of the identity provider, see oidcRole
+	OIDC *oidcConfig `json:"oidc,omitempty"`
+	// UploadSecret is shared with the data pipeline to sign upload URLs
+	UploadSecret string `json:"upload_secret,omitempty"`
+	// RecordDir keeps the recorded samples (temp dir by default),
+	// RecordScrub are more patterns to mask in them, see scrub
+	RecordDir   string   `json:"record_dir,omitempty"`
+	RecordScrub []string `json:"record_scrub,omitempty"`
+	recordScrub []*regexp.Regexp
+	// UploadDir keeps the chunks of resumable uploads (temp dir by default),
+	// UploadTTL is the hours an upload is kept since its last chunk
+	UploadDir string `json:"upload_dir,omitempty"`
+	UploadTTL int    `json:"upload_ttl,omitempty"`
+
+	// Kinds are the kinds indexed and searched in their order and
+	// Languages (ru, ua) the languages of their names, both read at
+	// startup: a new kind is a name list like inn, see kindOrder
+	Kinds     []string `json:"kinds,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+
+	// Labels are display titles of the suggestion sections: kind -> lang -> label
+	Labels map[string]map[string]string `json:"labels,omitempty"`
+
+	// MinPerKind is the number of entries each kind keeps when a request
+	// limits the total, MinPerKindDefault is used for kinds not listed
+	MinPerKind        map[string]int `json:"min_per_kind,omitempty"`
+	MinPerKindDefault int            `json:"min_per_kind_default,omitempty"`
+
+	// IntentGap is the lead of the best kind's top hit (0..1 by match
+	// rank, 2 for the whole name) over the other kinds that focuses a
+	// query on it, 0 is off. IntentShare is the percent of a limit left
+	// after the minimums that goes to the focused kinds first.
+	IntentGap   float64 `json:"intent_gap,omitempty"`
+	IntentShare int     `json:"intent_share,omitempty"`
+
+	// Fuzziness is the most edits of a query word in the search after no
+	// hits (0 for none), the words up to 5 letters get 1 at most, see
+	// findAll; a request can ask for its own ("fuzziness")
+	Fuzziness int `json:"fuzziness"`
+
+	// InfixMinLen is the length a word needs to be searched anywhere in a
+	// name word ("*word*"), shorter ones only match word starts, see
+	// prefixOnly. 0 or 1 allows any.
+	InfixMinLen int `json:"infix_min_len,omitempty"`
+
+	// DupSimilarity is the trigram similarity (0..1) from which names are
+	// reported as duplicates, see findDuplicates
+	DupSimilarity float64 `json:"dup_similarity,omitempty"`
+
+	// SectionOrder is the order of the suggestion sections, ClientOrders
+	// the one of the clients sending an X-Api-Key, see sectionOrder
+	SectionOrder []string            `json:"section_order,omitempty"`
+	ClientOrders map[string][]string `json:"client_orders,omitempty"`
+
+	// Canaries are the queries of /admin/selfcheck, see canary
+	Canaries []canary `json:"canaries,omitempty"`
+
+	// Units is the conversion table of dosage units applied to names and
+	// queries, see normUnits
+	Units   map[string]unit `json:"units,omitempty"`
+	unitsRe *regexp.Regexp
+
+	// MaxHits caps the hits taken from one index per search
+	MaxHits int `json:"max_hits,omitempty"`
+	// SearchTimeout is the limit of one index search in milliseconds
+	SearchTimeout int `json:"search_timeout,omitempty"`
+
+	// MaxSearches is the number of index searches running at once (4 per
+	// CPU by default, 0 or less is no limit), MaxKindSearches the number
+	// per kind. A search waits SearchQueueWait milliseconds behind at most
+	// SearchQueue others for a slot, then the request fails with 503 and
+	// Retry-After: SearchRetryAfter seconds. See acquire.
+	MaxSearches      int            `json:"max_searches,omitempty"`
+	MaxKindSearches  map[string]int `json:"max_kind_searches,omitempty"`
+	SearchQueue      int            `json:"search_queue,omitempty"`
+	SearchQueueWait  int            `json:"search_queue_wait,omitempty"`
+	SearchRetryAfter int            `json:"search_retry_after,omitempty"`
+
+	// QueryLog is the query log file (test-bleve-queries.jsonl in the temp
+	// dir by default), rotated over QueryLogMaxSize MiB keeping
+	// QueryLogKeep old files, see logQueries. QueryLogOff turns it off.
+	QueryLog        string `json:"query_log,omitempty"`
+	QueryLogMaxSize int    `json:"query_log_max_size,omitempty"`
+	QueryLogKeep    int    `json:"query_log_keep,omitempty"`
+	QueryLogOff     bool   `json:"query_log_off,omitempty"`
+
+	// BatchKeys are the API keys (X-Api-Key) of bulk jobs on the search
+	// endpoints, Priorities the request pools of the classes, see
+	// prioritized
+	BatchKeys  []string                 `json:"batch_keys,omitempty"`
+	Priorities map[string]priorityClass `json:"priorities,omitempty"`
+	// BreakerFailures failed searches in a row take an index out of the
+	// fan-out for BreakerCooldown seconds, see breaker
+	BreakerFailures int `json:"breaker_failures,omitempty"`
+	BreakerCooldown int `json:"breaker_cooldown,omitempty"`
+
+	// Rules are the initial query rewrite rules, see /admin/rules
+	Rules []rewriteRule `json:"rules,omitempty"`
+
+	// QueryLimits are the body size and query length limits of the search
+	// endpoints, see queryLimit
+	QueryLimits map[string]queryLimit `json:"query_limits,omitempty"`
+
+	// Synonyms are groups of interchangeable words offered as alternate
+	// queries when fewer than AlternatesBelow entries are found (0 is off),
+	// up to Alternates of them, see addAlternates
+	Synonyms        [][]string `json:"synonyms,omitempty"`
+	AlternatesBelow int        `json:"alternates_below,omitempty"`
+	Alternates      int        `json:"alternates,omitempty"`
+
+	// Pipelines are the search stages per endpoint or endpoint/kind,
+	// LogPipeline logs every stage run, see runPipeline
+	Pipelines   map[string][]string `json:"pipelines,omitempty"`
+	LogPipeline bool                `json:"log_pipeline,omitempty"`
+
+	// KindInference is off, boost or restrict, see inferKinds
+	KindInference string `json:"kind_inference,omitempty"`
+
+	// ClickLog keeps the result clicks, learned every KindPriorsEvery
+	// minutes (0 is on demand) from the last ClickWindow days into kind
+	// boosts up to KindBoostMax, see kindBoost
+	ClickLog        string  `json:"click_log,omitempty"`
+	ClickWindow     int     `json:"click_window,omitempty"`
+	ClickSmoothing  int     `json:"click_smoothing,omitempty"`
+	KindBoostMax    float64 `json:"kind_boost_max,omitempty"`
+	KindPriorsEvery int     `json:"kind_priors_every,omitempty"`
+
+	// CacheTTL (seconds) enables the response cache of up to CacheSize
+	// entries in memory, RedisURL moves the cache and the sales to Redis.
+	// A coordinator keeps the sort fields of up to CacheSize remote docs
+	// per index, see storeRemote.
+	CacheTTL  int    `json:"cache_ttl,omitempty"`
+	CacheSize int    `json:"cache_size,omitempty"`
+	RedisURL  string `json:"redis_url,omitempty"`
+	// Store keeps the sales and a copy of the vaults: "memory", "bolt" (a
+	// file at StorePath) or "redis" (RedisURL), the default is redis with
+	// RedisURL and memory without, see Store
+	Store     string `json:"store,omitempty"`
+	StorePath string `json:"store_path,omitempty"`
+	// GzipMin (bytes) is the size from which search responses are gzipped
+	// for the clients accepting it, 0 is never
+	GzipMin int `json:"gzip_min,omitempty"`
+	// SuggestMaxAge (seconds) is the Cache-Control max-age of the GET
+	// suggestions (/test/suggest) for the browsers and the CDNs, 0 is none
+	SuggestMaxAge int `json:"suggest_max_age,omitempty"`
+
+	// LangPacks are the files (globs) of the keyboard layouts and the
+	// transliteration of other markets, see loadLangPacks
+	LangPacks []string `json:"lang_packs,omitempty"`
+	// NegativeTTL (seconds) keeps queries without results, see knownZero
+	NegativeTTL int `json:"negative_ttl,omitempty"`
+
+	// AbuseRate requests per minute (0 is off), AbuseZeroRatio of requests
+	// without results or AbuseDistinct queries per minute ban a client for
+	// AbuseBan seconds, see abuseGuard
+	AbuseRate      int     `json:"abuse_rate,omitempty"`
+	AbuseZeroRatio float64 `json:"abuse_zero_ratio,omitempty"`
+	AbuseDistinct  int     `json:"abuse_distinct,omitempty"`
+	AbuseBan       int     `json:"abuse_ban,omitempty"`
+
+	// ValidateResponses checks JSON responses against schema/, see
+	// validateResponse (debugging only)
+	ValidateResponses bool `json:"validate_responses,omitempty"`
+
+	// DB is the database to build the suggestions from, see dbSource
+	DB *dbSource `json:"db,omitempty"`
+
+	// IngestQueue uploads may wait for a running ingest, see runIngest
+	IngestQueue int `json:"ingest_queue,omitempty"`
+	// IndexBatch docs are indexed at once, IndexWorkers batches at a time
+	// per index, see indexer
+	IndexBatch   int `json:"index_batch,omitempty"`
+	IndexWorkers int `json:"index_workers,omitempty"`
+	// KeepGenerations previous uploads are kept for rollback
+	KeepGenerations int `json:"keep_generations,omitempty"`
+	// Webhooks are the initial ingest webhook urls, see notify
+	Webhooks []string `json:"webhooks,omitempty"`
+
+	// DisplayCase is the casing of names at ingest: title, sentence, upper,
+	// lower or "" to keep the source one, see displayName
+	DisplayCase string   `json:"display_case,omitempty"`
+	Acronyms    []string `json:"acronyms,omitempty"`
+	LowerWords  []string `json:"lower_words,omitempty"`
+
+	// Mappings tune the index of each kind, see kindMapping
+	Mappings map[string]kindMapping `json:"mappings,omitempty"`
+	// IndexLayout is how the docs are split into indexes: one per kind
+	// and language (""), one per kind with the fields of both languages
+	// (name_ru, name_ua, ...: "kind") or one for all ("single", kind and
+	// lang are filter fields; substring searches scan the terms of every
+	// kind, so it is the slowest), see indexName
+	IndexLayout string `json:"index_layout,omitempty"`
+
+	// ColdDir keeps the full indexes on disk, HotSize most returned docs
+	// per index are searched first in memory, see hotIndex
+	ColdDir    string `json:"cold_dir,omitempty"`
+	HotSize    int    `json:"hot_size,omitempty"`
+	HotMin     int    `json:"hot_min,omitempty"`
+	HotRefresh int    `json:"hot_refresh,omitempty"`
+
+	// MigrateOnStart rebuilds the stale indexes of the restored cold
+	// generation at startup, otherwise /admin/migrate does, see
+	// restoreColdDir
+	MigrateOnStart bool `json:"migrate_on_start"`
+
+	// MaxOpenIndexes bounds the cold indexes open at once (0 for no
+	// bound), the least recently used are closed and opened again on the
+	// next search, see lazyIndex
+	MaxOpenIndexes int `json:"max_open_indexes,omitempty"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() *config {
+	c := &config{
+		Labels: map[string]map[string]string{
+			"atc": {"ru": "АТХ", "ua": "АТХ", "en": "ATC"},
+			"inf": {"ru": "Препараты", "ua": "Препарати", "en": "Products"},
+			"inn": {"ru": "МНН", "ua": "МНН", "en": "INN"},
+			"act": {"ru": "Действующее вещество", "ua": "Діюча речовина", "en": "Active substance"},
+			"org": {"ru": "Производитель", "ua": "Виробник", "en": "Manufacturer"},
+		},
+		Kinds:             []string{"atc", "inf", "inn", "act", "org"},
+		Languages:         []string{"ru", "ua"},
+		MinPerKindDefault: 1,
+		IntentGap:         0.5,
+		IntentShare:       80,
+		InfixMinLen:       3,
+		Units:             defaultUnits(),
+		MaxHits:           1000,
+		SearchTimeout:     1000,
+		MaxSearches:       4 * runtime.NumCPU(),
+		SearchQueue:       64,
+		SearchQueueWait:   200,
+		SearchRetryAfter:  1,
+		Priorities:        defaultPriorities(),
+		QueryLogMaxSize:   100,
+		MigrateOnStart:    true,
+		QueryLogKeep:      5,
+		BreakerFailures:   5,
+		BreakerCooldown:   30,
+		QueryLimits:       defaultQueryLimits(),
+		AlternatesBelow:   3,
+		Alternates:        3,
+		KindInference:     "boost",
+		ClickWindow:       30,
+		ClickSmoothing:    20,
+		KindBoostMax:      1.5,
+		KindPriorsEvery:   60,
+		CacheTTL:          60,
+		GzipMin:           1024,
+		SuggestMaxAge:     60,
+		DupSimilarity:     0.7,
+		CacheSize:         10000,
+		NegativeTTL:       10,
+		AbuseRate:         600,
+		AbuseZeroRatio:    0.9,
+		AbuseDistinct:     300,
+		AbuseBan:          600,
+		KeepGenerations:   1,
+		IngestQueue:       1,
+		UploadTTL:         24,
+		Fuzziness:         2,
+		IndexBatch:        1000,
+		IndexWorkers:      2,
+		DisplayCase:       "title",
+		Mappings:          defaultMappings(),
+		HotMin:            10,
+		HotRefresh:        60,
+		Acronyms:          []string{"АЦЦ", "ОРВИ", "ОРВІ", "ЖКТ", "ООО", "ОАО", "ЗАО", "ПАО", "ТОВ", "ПАТ", "ПрАТ", "ЗАТ", "АТ", "ФФ", "ХФЗ", "ГмбХ", "AG", "GmbH", "LLC", "Ltd", "SA", "UK", "USA", "ICN"},
+		LowerWords:        []string{"и", "в", "во", "с", "со", "для", "на", "по", "от", "із", "з", "та", "і", "й", "у", "від", "до", "and", "for", "with", "of"},
+	}
+	c.unitsRe = unitsRegexp(c.Units)
+	c.proxyNets, _ = parseNets(c.TrustedProxies)
+	return c
+}
+
+// loadConfig reads a JSON config file over the defaults, so the file only
+// needs to carry the settings it changes.
+func loadConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	c, err := parseConfig(b, defaultConfig(), nil)
+	if err != nil {
+		return err
+	}
+	cfg, kindOrder, langOrder = c, c.Kinds, c.Languages
+	return nil
+}
+
+// parseConfig reads the config over c and checks it, with the environment
+// of env (see keepEnv) if not nil. It checks against the kinds and
+// languages of c, the caller makes them current.
+func parseConfig(b []byte, c, env *config) (*config, error) {
+	err := json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		c.keepEnv(env)
+	}
+	err = checkKinds(c.Kinds, c.Languages)
+	if err != nil {
+		return nil, err
+	}
+	if c.Mappings == nil {
+		c.Mappings = make(map[string]kindMapping, len(c.Kinds))
+	}
+	for _, k := range c.Kinds {
+		if _, ok := c.Mappings[k]; !ok {
+			c.Mappings[k] = kindMapping{Ngram: []int{3, 8}}
+		}
+	}
+	c.unitsRe = unitsRegexp(c.Units)
+	for _, p := range c.RecordScrub {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("record_scrub: %v", err)
+		}
+		c.recordScrub = append(c.recordScrub, re)
+	}
+	c.allowNets, err = parseNets(c.AdminAllow)
+	if err != nil {
+		return nil, fmt.Errorf("admin_allow: %v", err)
+	}
+	c.proxyNets, err = parseNets(c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %v", err)
+	}
+	err = checkPipelines(c.Pipelines, c.Kinds)
+	if err != nil {
+		return nil, err
+	}
+	if c.OIDC != nil {
+		err = c.OIDC.check()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.DB != nil {
+		err = c.DB.check()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkPriorities(c.Priorities)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCanaries(c.Canaries, c.Kinds)
+	if err != nil {
+		return nil, err
+	}
+	if c.DupSimilarity <= 0 || c.DupSimilarity > 1 {
+		return nil, fmt.Errorf("dup_similarity: %v, want 0..1", c.DupSimilarity)
+	}
+	err = checkOrder(c.SectionOrder, c.Kinds)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range c.ClientOrders {
+		err = checkOrder(v, c.Kinds)
+		if err != nil {
+			return nil, fmt.Errorf("client_orders: %s", err.Error()) // not the key, it is a secret
+		}
+	}
+	if c.IndexLayout != layoutKindLang && c.IndexLayout != layoutKind && c.IndexLayout != layoutSingle {
+		return nil, fmt.Errorf("unknown index_layout %q", c.IndexLayout)
+	}
+	if c.Fuzziness < 0 || c.Fuzziness > maxFuzziness {
+		return nil, fmt.Errorf("fuzziness: %d, want 0..%d", c.Fuzziness, maxFuzziness)
+	}
+	if c.IndexBatch < 1 || c.IndexWorkers < 1 {
+		return nil, fmt.Errorf("index_batch, index_workers: %d, %d, want 1 or more", c.IndexBatch, c.IndexWorkers)
+	}
+	if c.UploadTTL < 1 {
+		return nil, fmt.Errorf("upload_ttl: %d, want 1 or more", c.UploadTTL)
+	}
+	if c.MinPerKindDefault < 0 {
+		return nil, fmt.Errorf("min_per_kind_default: %d is below 0", c.MinPerKindDefault)
+	}
+	for k, n := range c.MinPerKind {
+		if n < 0 {
+			return nil, fmt.Errorf("min_per_kind %s: %d is below 0", k, n)
+		}
+	}
+	if c.KindBoostMax < 1 {
+		return nil, fmt.Errorf("kind_boost_max: %g is below 1", c.KindBoostMax)
+	}
+	if c.Store == "" && c.RedisURL != "" {
+		c.Store = storeRedis
+	}
+	switch c.Store {
+	case "", storeMemory:
+		c.Store = storeMemory
+	case storeBolt:
+		if c.StorePath == "" {
+			return nil, fmt.Errorf("store bolt: no store_path")
+		}
+	case storeRedis:
+		if c.RedisURL == "" {
+			return nil, fmt.Errorf("store redis: no redis_url")
+		}
+	default:
+		return nil, fmt.Errorf("unknown store %q", c.Store)
+	}
+	if c.SuggestMaxAge < 0 {
+		return nil, fmt.Errorf("suggest_max_age: %d is below 0", c.SuggestMaxAge)
+	}
+	if c.MaxOpenIndexes < 0 {
+		return nil, fmt.Errorf("max_open_indexes: %d is below 0", c.MaxOpenIndexes)
+	}
+	if c.IntentShare < 0 || c.IntentShare > 100 {
+		return nil, fmt.Errorf("intent_share: %d is not a percent", c.IntentShare)
+	}
+
+	return c, nil
+}
+
+// labels returns section titles in the language of the request
+func (c *config) labels(lang string) map[string]string {
+	res := make(map[string]string, len(c.Labels))
+	for k, v := range c.Labels {
+		if !contains(kindOrder, k) {
+			continue
+		}
+		if l, ok := v[lang]; ok {
+			res[k] = l
+		} else {
+			res[k] = v["en"]
+		}
+	}
+	for _, k := range extraKinds() {
+		if _, ok := res[k]; !ok {
+			res[k] = k
+		}
+	}
+	return res
+}
+
+func labelLang(h http.Header) string {
+	if langUA(h) {
+		return "ua"
+	}
+	if strings.HasPrefix(h.Get("Accept-Language"), "en") {
+		return "en"
+	}
+	return "ru"
+}
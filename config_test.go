@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigMinPerKind(t *testing.T) {
+	// loadConfig sets the kinds and languages too
+	defer func(c *config, kinds, langs []string) {
+		cfg, kindOrder, langOrder = c, kinds, langs
+	}(cfg, kindOrder, langOrder)
+	path := filepath.Join(t.TempDir(), "config.json")
+	for _, v := range []struct {
+		conf, want string
+	}{
+		{`{"min_per_kind": {"inf": 2}, "min_per_kind_default": 0}`, ""},
+		{`{"min_per_kind": {"inf": -1}}`, "min_per_kind inf"},
+		{`{"min_per_kind_default": -1}`, "min_per_kind_default"},
+	} {
+		err := os.WriteFile(path, []byte(v.conf), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = loadConfig(path)
+		if v.want == "" && err != nil || v.want != "" && (err == nil || !strings.Contains(err.Error(), v.want)) {
+			t.Errorf("%s: %v, want %q", v.conf, err, v.want)
+		}
+	}
+}
+
+// TestParseConfigKinds checks a config against its own kinds and leaves
+// the current ones as they are, whether it is valid or not
+func TestParseConfigKinds(t *testing.T) {
+	kinds, langs := kindOrder, langOrder
+	for _, v := range []struct {
+		conf, want string
+	}{
+		{`{"kinds": ["inf", "brd"], "section_order": ["brd", "inf"]}`, ""},
+		{`{"kinds": ["inf"], "section_order": ["org"]}`, "unknown kind"},
+		{`{"kinds": ["inf", "brd"], "dup_similarity": 2}`, "dup_similarity"},
+	} {
+		_, err := parseConfig([]byte(v.conf), defaultConfig(), nil)
+		if v.want == "" && err != nil || v.want != "" && (err == nil || !strings.Contains(err.Error(), v.want)) {
+			t.Errorf("%s: %v, want %q", v.conf, err, v.want)
+		}
+		if strings.Join(kindOrder, ",") != strings.Join(kinds, ",") || strings.Join(langOrder, ",") != strings.Join(langs, ",") {
+			t.Fatalf("%s: kinds %v %v, want %v %v", v.conf, kindOrder, langOrder, kinds, langs)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // mysql
+	_ "github.com/lib/pq"              // postgres
+)
+
+// The suggestions can be built straight from a database instead of a CSV
+// upload, on demand or every cfg.DB.Every minutes:
+//
+// $ curl -i -X POST -H 'X-Api-Key: secret' 'http://localhost:8080/admin/db-ingest?queue=1'
+//
+//	"db": {
+//		"driver": "postgres",
+//		"dsn": "postgres://search@db/pharma?sslmode=disable",
+//		"queries": {
+//			"inf/RU": "SELECT id, name_ru, name_ua, info, latin, ean, reg FROM products",
+//			"org/RU": "SELECT id, name_ru, name_ua FROM makers"
+//		},
+//		"every": 60
+//	}
+//
+// Every query selects the rows of one kind/lang with the columns named
+// after the CSV ones, missing columns are empty.
+
+// dbSource is the database to build the suggestions from
+type dbSource struct {
+	Driver  string            `json:"driver"` // postgres or mysql
+	DSN     string            `json:"dsn"`
+	Queries map[string]string `json:"queries"`
+	Every   int               `json:"every,omitempty"`   // minutes, 0 is on demand only
+	Timeout int               `json:"timeout,omitempty"` // seconds for all queries, 300 by default
+}
+
+// csvHeader is the column order of the suggestion CSV
+var csvHeader = []string{"kind", "id", "name_ru", "name_ua", "info", "lang", "latin", "ean", "reg"}
+
+// check validates the source config
+func (s *dbSource) check() error {
+	if s.Driver == "" || s.DSN == "" {
+		return fmt.Errorf("db: driver and dsn are required")
+	}
+	if len(s.Queries) == 0 {
+		return fmt.Errorf("db: no queries")
+	}
+	for k := range s.Queries {
+		p := strings.SplitN(k, "/", 2)
+		if len(p) != 2 || p[1] == "" {
+			return fmt.Errorf("db: query %q is not kind/lang", k)
+		}
+		if !contains(kindOrder, p[0]) {
+			return fmt.Errorf("db: query %q: unknown kind", k)
+		}
+	}
+	return nil
+}
+
+// readDB runs the queries and returns their rows as CSV records, the
+// header included
+func readDB(s *dbSource) ([][]string, error) {
+	timeout := 300 * time.Second
+	if s.Timeout > 0 {
+		timeout = time.Duration(s.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	db, err := sql.Open(s.Driver, s.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	keys := make([]string, 0, len(s.Queries))
+	for k := range s.Queries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rec := [][]string{csvHeader}
+	for _, k := range keys {
+		p := strings.SplitN(k, "/", 2)
+		rec, err = readQuery(ctx, db, s.Queries[k], p[0], p[1], rec)
+		if err != nil {
+			return nil, fmt.Errorf("db: %s: %v", k, err)
+		}
+	}
+	return rec, nil
+}
+
+// readQuery appends the rows of one query to rec
+func readQuery(ctx context.Context, db *sql.DB, query, kind, lang string, rec [][]string) ([][]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	pos := make([]int, len(cols))
+	for i, c := range cols {
+		pos[i] = -1
+		for j, h := range csvHeader {
+			if strings.EqualFold(c, h) {
+				pos[i] = j
+			}
+		}
+	}
+
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]string, len(csvHeader))
+		row[0], row[5] = kind, lang
+		for i, v := range vals {
+			if pos[i] >= 0 && v.Valid {
+				row[pos[i]] = v.String
+			}
+		}
+		rec = append(rec, row)
+	}
+	return rec, rows.Err()
+}
+
+// ingestDB builds a generation from the database
+func ingestDB(queue bool) (rows int, queued bool, err error) {
+	if cfg.DB == nil {
+		return 0, false, fmt.Errorf("db: no source configured")
+	}
+
+	rec, err := readDB(cfg.DB)
+	if err != nil {
+		notify(hookEvent{Event: "ingest.failed", Error: err.Error()})
+		return 0, false, err
+	}
+	queued, err = runIngest("db", len(rec)-1, queue, func() error { return ingestSugg(rec) })
+	return len(rec) - 1, queued, err
+}
+
+// dbLoop rebuilds from the database on schedule, a running ingest
+// postpones it to the next tick
+func dbLoop() {
+	if cfg.DB == nil || cfg.DB.Every <= 0 {
+		return
+	}
+	for range time.Tick(time.Duration(cfg.DB.Every) * time.Minute) {
+		n, _, err := ingestDB(false)
+		if err != nil {
+			log.Printf("err: db ingest: %s", err.Error())
+			continue
+		}
+		log.Printf("db ingest: %d rows", n)
+	}
+}
+
+func adminDBIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, queued, err := ingestDB(wantQueue(r))
+	if ingestFailed(w, queued, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, n)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// The CSV repeats a name under many product ids. The rows of a key with the
+// same indexed content (nameDoc) are indexed once, as a doc whose posting
+// lists their vault keys and keeps its names, so a hit is resolved without
+// loading the stored doc (see hitIDs and findByName). The doc ID hashes the
+// content, so the postings built again from the rows match an index kept
+// on disk after its docs were edited one by one (see editDoc). The hot tier
+// still indexes a doc per row with the "id|hash" ID.
+
+// posting is a doc indexed once for the identical rows of a key
+type posting struct {
+	names []string // the values of its name field
+	ids   []string // vault keys
+}
+
+// postings are the postings of a generation: key -> doc ID ->
+type postings map[string]map[string]*posting
+
+// post adds the row k of doc to the doc of its content in the index of the
+// key and returns its ID, with true for a new doc to be indexed
+func (p postings) post(key, k string, doc *baseDoc) (string, bool) {
+	if p[key] == nil {
+		p[key] = make(map[string]*posting)
+	}
+	id := docID(key, doc)
+	if ps, ok := p[key][id]; ok {
+		ps.ids = append(ps.ids, k)
+		return id, false
+	}
+	p[key][id] = &posting{names: doc.nameDoc().Name, ids: []string{k}}
+	return id, true
+}
+
+// edit is post for a generation in use, the postings are replaced instead
+// of changed: with add false it removes the row k of doc and tells if its
+// doc is left without rows, to be deleted from the index
+func (p postings) edit(key, k string, doc *baseDoc, add bool) (string, bool) {
+	if p[key] == nil {
+		p[key] = make(map[string]*posting)
+	}
+	id := docID(key, doc)
+	ps, ok := p[key][id]
+	switch {
+	case add && !ok:
+		p[key][id] = &posting{names: doc.nameDoc().Name, ids: []string{k}}
+		return id, true
+	case add:
+		p[key][id] = &posting{names: ps.names, ids: append(append([]string(nil), ps.ids...), k)}
+		return id, false
+	case !ok:
+		return id, false
+	}
+
+	ids := make([]string, 0, len(ps.ids))
+	for _, v := range ps.ids {
+		if v != k {
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) == 0 {
+		delete(p[key], id)
+		return id, true
+	}
+	p[key][id] = &posting{names: ps.names, ids: ids}
+	return id, false
+}
+
+// docID returns the ID of the doc indexed under the key: "n" and the hash
+// of its content, a shared index adds what it holds more than the key
+func docID(key string, doc *baseDoc) string {
+	b, _ := json.Marshal(doc.indexDoc(key))
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	id := "n" + strconv.FormatUint(h.Sum64(), 36)
+	switch cfg.IndexLayout {
+	case layoutKind:
+		id += "|" + keyLang(key)
+	case layoutSingle:
+		id += "|" + key
+	}
+	return id
+}
+
+// postingOf returns the posting of the doc ID in the index of the key
+func (i *index) postingOf(key, id string) *posting {
+	i.RLock()
+	g := i.gen
+	i.RUnlock()
+
+	if g == nil {
+		return nil
+	}
+	g.edit.RLock()
+	defer g.edit.RUnlock()
+	return g.postings[key][id]
+}
+
+// hitIDs returns the vault keys of a hit
+func (i *index) hitIDs(key, id string) []string {
+	if p := i.postingOf(key, id); p != nil {
+		return p.ids
+	}
+	return []string{strings.Split(id, "|")[0]}
+}
+
+// hitKeys converts the hits into vault keys
+func (i *index) hitKeys(key string, res *bleve.SearchResult) []string {
+	out := make([]string, 0, len(res.Hits))
+	for _, v := range res.Hits {
+		out = append(out, i.hitIDs(key, v.ID)...)
+	}
+	return out
+}
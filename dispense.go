@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Products (inf) are dispensed by prescription (rx) or over the counter
+// (otc) after the 10th csv column, "dispense". A search asked for one of
+// them ("dispense": "otc" in the body or ?dispense=otc, e.g. at checkout)
+// leaves the other products out of sugg_inf (sugg for /test/select-sugg)
+// and counts them in meta.hidden by their own flag, "unknown" for the ones
+// without. The other kinds are not filtered.
+//
+// $ curl -i -d '{"name":"парацетамол","dispense":"otc"}' http://localhost:8080/test/select-suggestion
+
+const (
+	dispenseRx  = "rx"
+	dispenseOTC = "otc"
+)
+
+// parseDispense reads the dispense column, "" when not given
+func parseDispense(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "rx", "1", "true", "yes":
+		return dispenseRx
+	case "otc", "0", "false", "no":
+		return dispenseOTC
+	}
+	return ""
+}
+
+// requestDispense returns the dispense filter of the request, in the body
+// (req) or the URL, "" for none
+func requestDispense(r *http.Request, req string) (string, error) {
+	if req == "" {
+		req = r.URL.Query().Get("dispense")
+	}
+	switch req {
+	case "", dispenseRx, dispenseOTC:
+		return req, nil
+	}
+	return "", fmt.Errorf("invalid dispense: %q (%s, %s)", req, dispenseRx, dispenseOTC)
+}
+
+// productFilter is what the products of a search are filtered on
+type productFilter struct {
+	dispense string // see requestDispense
+	age      int    // of the patient, see requestAge
+}
+
+// anyProduct filters no products
+var anyProduct = productFilter{age: ageAny}
+
+// products keeps the keys of the inf hits (name -> keys) that pass f
+func (r *result) products(key string, hits map[string][]string, f productFilter) map[string][]string {
+	return r.aged(key, r.dispensed(key, hits, f.dispense), f.age)
+}
+
+// dispensed keeps the keys of the inf hits (name -> keys) dispensed so,
+// the hits are not changed
+func (r *result) dispensed(key string, hits map[string][]string, dispense string) map[string][]string {
+	if dispense == "" || len(hits) == 0 {
+		return hits
+	}
+	vlt, err := r.index().getVault(key)
+	if err != nil {
+		return hits
+	}
+
+	out := make(map[string][]string, len(hits))
+	hidden := make(map[string]bool)
+	for name, keys := range hits {
+		var kept []string
+		for _, k := range keys {
+			flag := ""
+			if v, ok := vlt.Load(k); ok {
+				flag = v.(*baseDoc).Dispense
+			}
+			if flag == dispense {
+				kept = append(kept, k)
+				continue
+			}
+			if hidden[k] {
+				continue
+			}
+			hidden[k] = true
+			if flag == "" {
+				flag = "unknown"
+			}
+			m := r.meta()
+			if m.Hidden == nil {
+				m.Hidden = make(map[string]int, 3)
+			}
+			m.Hidden[flag]++
+		}
+		if len(kept) > 0 {
+			out[name] = kept
+		}
+	}
+	return out
+}
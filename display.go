@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// displayName brings a source name ("НУРОФЕН ФОРТЕ", "нурофен форте") to
+// the configured display casing, see config.DisplayCase. Acronyms keep
+// their configured casing, words with digits their source one, dosage
+// units and cfg.LowerWords stay lowercase after the first word. Hyphenated
+// words are one word ("Нурофен-экспресс").
+func displayName(s string) string {
+	mode := cfg.DisplayCase
+	if mode == "" {
+		return s
+	}
+
+	r := []rune(s)
+	first := true
+	for i := 0; i < len(r); {
+		if !isWordRune(r[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(r) && (isWordRune(r[j]) || r[j] == '-' && j+1 < len(r) && unicode.IsLetter(r[j+1])) {
+			j++
+		}
+		copy(r[i:j], []rune(displayWord(string(r[i:j]), mode, first)))
+		first = false
+		i = j
+	}
+	return string(r)
+}
+
+// isWordRune keeps stress marks (combining) inside of words
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func displayWord(w, mode string, first bool) string {
+	low := strings.ToLower(w)
+	for _, a := range cfg.Acronyms {
+		if strings.ToLower(a) == low && len([]rune(a)) == len([]rune(w)) {
+			return a
+		}
+	}
+	if strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+		return w
+	}
+
+	switch mode {
+	case "upper":
+		return strings.ToUpper(w)
+	case "lower":
+		return low
+	}
+	if !first {
+		if _, ok := cfg.Units[low]; ok || contains(cfg.LowerWords, low) || mode == "sentence" {
+			return low
+		}
+	}
+	r := []rune(low)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestDisplayName(t *testing.T) {
+	for _, v := range []struct{ in, want string }{
+		{"НУРОФЕН ФОРТЕ", "Нурофен Форте"},
+		{"нурофен форте", "Нурофен Форте"},
+		{"АЦЦ ЛОНГ", "АЦЦ Лонг"},
+		{"ацц лонг", "АЦЦ Лонг"},
+		{"НУРОФЕН-ЭКСПРЕСС 200 МГ", "Нурофен-экспресс 200 мг"},
+		{"ВИТАМИН B12", "Витамин B12"},
+		{"ІБУПРО́ФЕН", "Ібупро́фен"}, // the stress mark stays inside the word
+	} {
+		if got := displayName(v.in); got != v.want {
+			t.Errorf("%q: got %q, want %q", v.in, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// $ curl -i http://localhost:8080/docs
+
+// orderingDoc is the ordering guarantee of the search endpoints: equal
+// input on the same data generation gives a byte-identical response.
+const orderingDoc = `Ordering
+
+Names (sugg, sugg_* names) are ordered by, in turn:
+  1. match rank: whole words > word prefixes > infixes, best of the query
+     and its keyboard-layout conversion
+  2. collation of the response language (ru, or uk with Accept-Language uk/ua)
+  3. bytes of the name
+/test/select-sugg then moves names starting with the query to the front and
+names of inferred kinds before the rest, keeping the order otherwise.
+
+Keys (sugg_*.keys) are ordered by, in turn:
+  1. info, descending
+  2. sales, descending
+  3. name, ascending by bytes
+  4. id, ascending
+The product keys of /test/select-suggestion (sugg_inf) are first ordered by
+the match rank of their names, as above, so an exact match comes before a
+better selling partial one.
+Keys missing from the data are kept at the end in index order.
+
+The order only changes with the data: a new upload, a rollback, sales,
+rewrite rules or disabled kinds. The ETag of a response changes with it.
+`
+
+func apiDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, orderingDoc)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The duplicates report finds names of a kind that are most likely the
+// same entry uploaded twice, which splits its sales and clicks. Names are
+// compared normalized (case, stress, units, punctuation, ё) by their
+// trigrams: a pair is reported when the Jaccard similarity reaches
+// cfg.DupSimilarity, with the edit distance for the eye. Docs with the
+// same normalized name are one entry with several keys and reported on
+// their own. POST starts the job on the current generation, GET returns
+// the last report (?format=csv for a spreadsheet).
+//
+// $ curl -i -H 'X-Api-Key: secret' -X POST http://localhost:8080/admin/duplicates
+// $ curl -i -H 'X-Api-Key: secret' 'http://localhost:8080/admin/duplicates?format=csv'
+
+const dupMaxPairs = 10000 // per report, the most similar
+
+type dupName struct {
+	Name  string   `json:"name"`
+	Keys  []string `json:"keys"`
+	Sales int      `json:"sales"`
+}
+
+type dupPair struct {
+	Key        string    `json:"key"` // kind-lang
+	Similarity float64   `json:"similarity"`
+	Distance   int       `json:"distance"`
+	Names      []dupName `json:"names"` // one with several keys or two
+}
+
+type dupReport struct {
+	Generation int64      `json:"generation"`
+	Similarity float64    `json:"min_similarity"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Pairs      []dupPair  `json:"pairs"`
+}
+
+var dupJob = struct {
+	sync.Mutex
+	running bool
+	report  *dupReport
+}{}
+
+// dupNorm is the name as compared
+func dupNorm(name string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(strings.ToLower(normName(name))), " "), "ё", "е")
+}
+
+// trigrams of the name padded with a space, without repeats
+func trigrams(s string) []string {
+	r := []rune(" " + s + " ")
+	seen := make(map[string]bool, len(r))
+	res := make([]string, 0, len(r))
+	for i := 0; i+3 <= len(r); i++ {
+		t := string(r[i : i+3])
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// findDuplicates compares the names of every vault of g
+func findDuplicates(g *generation, min float64) []dupPair {
+	var res []dupPair
+	keys := make([]string, 0, len(g.vault))
+	for k := range g.vault {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		res = append(res, vaultDuplicates(key, g.vault[key], min)...)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Similarity > res[j].Similarity })
+	if len(res) > dupMaxPairs {
+		res = res[:dupMaxPairs]
+	}
+	return res
+}
+
+func vaultDuplicates(key string, vlt *sync.Map, min float64) []dupPair {
+	kind := strings.Split(key, "-")[0]
+	byNorm := make(map[string]*dupName)
+	vlt.Range(func(k, v interface{}) bool {
+		d := v.(*baseDoc)
+		if deletedDoc(kind, k.(string)) != nil {
+			return true
+		}
+		n := dupNorm(d.Name)
+		if n == "" {
+			return true
+		}
+		e, ok := byNorm[n]
+		if !ok {
+			e = &dupName{Name: d.Name}
+			byNorm[n] = e
+		}
+		e.Keys = append(e.Keys, k.(string))
+		e.Sales += d.Sale
+		return true
+	})
+
+	norms := make([]string, 0, len(byNorm))
+	for n := range byNorm {
+		norms = append(norms, n)
+	}
+	sort.Strings(norms)
+
+	var res []dupPair
+	grams := make([][]string, len(norms))
+	df := make(map[string]int)
+	for i, n := range norms {
+		e := byNorm[n]
+		sort.Slice(e.Keys, func(a, b int) bool { return keyLess(e.Keys[a], e.Keys[b]) })
+		if len(e.Keys) > 1 {
+			res = append(res, dupPair{Key: key, Similarity: 1, Names: []dupName{*e}})
+		}
+		grams[i] = trigrams(n)
+		for _, t := range grams[i] {
+			df[t]++
+		}
+	}
+
+	// prefix filtering: with the trigrams of a name rarest first, two names
+	// of similarity min share one of the first len-ceil(min*len)+1 of them,
+	// so only those are indexed and no pair is missed
+	posting := make(map[string][]int)
+	for i := range norms {
+		g := grams[i]
+		sort.Slice(g, func(a, b int) bool {
+			if df[g[a]] != df[g[b]] {
+				return df[g[a]] < df[g[b]]
+			}
+			return g[a] < g[b]
+		})
+		prefix := g[:len(g)-int(math.Ceil(min*float64(len(g))))+1]
+
+		seen := make(map[int]bool)
+		set := make(map[string]bool, len(g))
+		for _, t := range g {
+			set[t] = true
+		}
+		for _, t := range prefix {
+			for _, j := range posting[t] {
+				if seen[j] {
+					continue
+				}
+				seen[j] = true
+				if l := float64(len(grams[j])); l < min*float64(len(g)) || float64(len(g)) < min*l {
+					continue
+				}
+				inter := 0
+				for _, t := range grams[j] {
+					if set[t] {
+						inter++
+					}
+				}
+				sim := float64(inter) / float64(len(g)+len(grams[j])-inter)
+				if sim < min {
+					continue
+				}
+				res = append(res, dupPair{
+					Key:        key,
+					Similarity: math.Round(sim*1000) / 1000,
+					Distance:   editDistance(norms[j], norms[i]),
+					Names:      []dupName{*byNorm[norms[j]], *byNorm[norms[i]]},
+				})
+			}
+			posting[t] = append(posting[t], i)
+		}
+	}
+	return res
+}
+
+// runDuplicates runs the job in the background, false if one is running
+func runDuplicates() bool {
+	dupJob.Lock()
+	defer dupJob.Unlock()
+
+	if dupJob.running {
+		return false
+	}
+	g, _ := indexDB.generations()
+	rep := &dupReport{Similarity: cfg.DupSimilarity, Started: time.Now(), Pairs: []dupPair{}}
+	dupJob.running, dupJob.report = true, rep
+
+	go func() {
+		var pairs []dupPair
+		if g != nil {
+			pairs = findDuplicates(g, cfg.DupSimilarity)
+		}
+
+		dupJob.Lock()
+		defer dupJob.Unlock()
+		t := time.Now()
+		rep.Finished = &t
+		if g == nil {
+			rep.Error = "no data uploaded"
+		} else {
+			rep.Generation, rep.Pairs = g.ID, append(rep.Pairs, pairs...)
+		}
+		dupJob.running = false
+		log.Printf("duplicates: %d pairs in %s", len(rep.Pairs), t.Sub(rep.Started))
+	}()
+	return true
+}
+
+func adminDuplicates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !runDuplicates() {
+			internalServerError(w, fmt.Errorf("the duplicates job is running"), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, http.StatusText(http.StatusAccepted))
+		return
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dupJob.Lock()
+	rep := dupJob.report
+	if rep != nil {
+		cp := *rep
+		rep = &cp
+	}
+	dupJob.Unlock()
+	if rep == nil {
+		internalServerError(w, fmt.Errorf("no report yet, POST to start one"), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"key", "similarity", "distance", "name", "keys", "sales", "other_name", "other_keys", "other_sales"})
+		for _, p := range rep.Pairs {
+			row := []string{p.Key, strconv.FormatFloat(p.Similarity, 'f', -1, 64), strconv.Itoa(p.Distance)}
+			for _, n := range p.Names {
+				row = append(row, n.Name, strings.Join(n.Keys, " "), strconv.Itoa(n.Sales))
+			}
+			_ = cw.Write(row)
+		}
+		cw.Flush()
+		return
+	}
+
+	b, err := json.MarshalIndent(rep, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
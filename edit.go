@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Single docs of the current generation are added, replaced and deleted
+// without an upload: the doc is indexed (or unindexed) in the index of its
+// kind and language and stored in the vault, the caches are dropped. An
+// edit runs as an ingest, after the upload in progress (with ?queue=1) or
+// not at all, so it never lands in a generation being replaced. The source
+// rows of a cold generation and the stored copy are written again with
+// it, which takes as long as for an upload: many changes go in an upload.
+//
+// $ curl -i -H 'X-Api-Key: secret' -d '{"kind":"inf","lang":"ru","id":3,"name":"Парацетамол","latin":"Paracetamol"}' http://localhost:8080/test/docs
+// $ curl -i -H 'X-Api-Key: secret' -X PUT -d '{"name":"Парацетамол форте","info":1}' http://localhost:8080/test/docs/inf:ru:3
+// $ curl -i -H 'X-Api-Key: secret' -X DELETE http://localhost:8080/test/docs/inf:ru:3
+
+var (
+	errNoData     = errors.New("no data uploaded")
+	errDocExists  = errors.New("doc exists")
+	errDocMissing = errors.New("doc not found")
+)
+
+// docInput is a doc of /test/docs, the row of the csv it stands for
+type docInput struct {
+	ID    int      `json:"id"`
+	Kind  string   `json:"kind"`
+	Lang  string   `json:"lang"` // ru or ua
+	Name  string   `json:"name"`
+	Names []string `json:"names"` // other names
+	Latin string   `json:"latin"`
+	EAN   []string `json:"ean"`
+	Reg   string   `json:"reg"`
+	Info  int      `json:"info"`
+
+	Dispense string `json:"dispense"` // rx or otc
+	Age      string `json:"age"`      // see parseAge
+
+	Extra map[string]string `json:"extra"` // see extraOf
+}
+
+// row returns the csv row of the doc, see parseRow
+func (d *docInput) row() []string {
+	row := []string{d.Kind, strconv.Itoa(d.ID), "", "", strconv.Itoa(d.Info), strings.ToUpper(d.Lang), d.Latin, strings.Join(d.EAN, ";"), d.Reg, d.Dispense, d.Age}
+	name := strings.Join(append([]string{d.Name}, d.Names...), ";")
+	if d.Lang == "ru" {
+		row[2] = name
+	} else {
+		row[3] = name
+	}
+	return row
+}
+
+func (d *docInput) check() error {
+	switch {
+	case !contains(kindOrder, d.Kind):
+		return fmt.Errorf("unknown kind: %q", d.Kind)
+	case !contains(langOrder, d.Lang):
+		return fmt.Errorf("unknown lang: %q (ru, ua)", d.Lang)
+	case d.ID <= 0:
+		return fmt.Errorf("invalid id: %d", d.ID)
+	case strings.TrimSpace(d.Name) == "":
+		return fmt.Errorf("empty name")
+	case d.Dispense != "" && d.Dispense != dispenseRx && d.Dispense != dispenseOTC:
+		return fmt.Errorf("invalid dispense: %q (%s, %s)", d.Dispense, dispenseRx, dispenseOTC)
+	case d.Age != "" && parseAge(d.Age) == "":
+		return fmt.Errorf("invalid age: %q (lo-hi, lo+, adult, child)", d.Age)
+	}
+	return nil
+}
+
+func editDocs(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/test/docs"), "/")
+	switch {
+	case r.Method == "POST" && path == "":
+	case (r.Method == "PUT" || r.Method == "DELETE") && path != "":
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	in := &docInput{}
+	if path != "" {
+		key, id, err := parseKey(path)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		in.Kind, in.Lang = strings.Split(key, "-")[0], keyLang(key)
+		in.ID, err = strconv.Atoi(id)
+		if err != nil {
+			internalServerError(w, fmt.Errorf("invalid id: %q", id), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, k := in.Kind+"-"+in.Lang, strconv.Itoa(in.ID)
+	if r.Method == "DELETE" {
+		in = nil
+	} else {
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		v := *in
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		if path != "" && (v.Kind != in.Kind || v.Lang != in.Lang || v.ID != in.ID) {
+			internalServerError(w, fmt.Errorf("doc %s:%s:%d is not %s", v.Kind, v.Lang, v.ID, path), http.StatusBadRequest)
+			return
+		}
+		err = v.check()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		in, key, k = &v, v.Kind+"-"+v.Lang, strconv.Itoa(v.ID)
+	}
+
+	var doc *baseDoc
+	queued, err := runIngest("doc", 1, wantQueue(r), func() (err error) {
+		doc, err = editDoc(r.Method == "POST", key, k, in)
+		return err
+	})
+	if errors.Is(err, errUnknownColumn) {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+	switch err {
+	case errNoData:
+		internalServerError(w, err, http.StatusServiceUnavailable)
+		return
+	case errDocExists:
+		internalServerError(w, fmt.Errorf("%s: %s", err.Error(), canonicalKey(key, k)), http.StatusConflict)
+		return
+	case errDocMissing:
+		internalServerError(w, fmt.Errorf("%s: %s", err.Error(), canonicalKey(key, k)), http.StatusNotFound)
+		return
+	}
+	if ingestFailed(w, queued, err) {
+		return
+	}
+
+	if doc == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	b, err := json.MarshalIndent(keyedDocs(key, []*baseDoc{doc})[0], "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	fmt.Fprintln(w, string(b))
+}
+
+// editDoc replaces the doc k of the key in the current generation with the
+// one of in (nil deletes it) and returns it, create wants it new
+func editDoc(create bool, key, k string, in *docInput) (*baseDoc, error) {
+	start := time.Now()
+	g, _ := indexDB.generations()
+	if g == nil {
+		return nil, errNoData
+	}
+	idx, ok := g.store[key]
+	if !ok {
+		return nil, fmt.Errorf("index not found (%s)", key)
+	}
+	vlt := g.vault[key]
+
+	var doc *baseDoc
+	if in != nil {
+		row, err := extraRow(g.header, in.row(), in.Extra)
+		if err != nil {
+			return nil, err
+		}
+		_, _, doc = parseRow(g.header, row, 0)
+	}
+
+	g.edit.Lock()
+	v, exists := vlt.Load(k)
+	switch {
+	case create && exists:
+		g.edit.Unlock()
+		return nil, errDocExists
+	case !create && !exists:
+		g.edit.Unlock()
+		return nil, errDocMissing
+	}
+
+	var err error
+	if exists {
+		old := v.(*baseDoc)
+		if id, gone := g.postings.edit(key, k, old, false); gone {
+			err = idx.Delete(id)
+		}
+		if doc != nil {
+			doc.Line = old.Line
+		}
+	} else {
+		g.lines++
+		doc.Line = g.lines
+	}
+	if doc != nil && err == nil {
+		if id, add := g.postings.edit(key, k, doc, true); add {
+			err = idx.Index(id, doc.indexDoc(key))
+		}
+		vlt.Store(k, doc)
+	} else if err == nil {
+		vlt.Delete(k)
+	}
+	g.letters[key] = letterIndex(map[string]*sync.Map{key: vlt})[key]
+	g.edit.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	g.compounds.Range(func(k, _ interface{}) bool {
+		g.compounds.Delete(k)
+		return true
+	})
+	hotTier.Lock()
+	delete(hotTier.idx, key) // searched cold until refreshHot
+	hotTier.Unlock()
+	rankVaults(map[string]*sync.Map{key: vlt})
+	resCache.purge()
+	staleETags()
+
+	err = writeColdStamp(g, g.records())
+	if err != nil {
+		log.Printf("err: cold generation %d: %s", g.ID, err.Error())
+	}
+	saveGeneration(g)
+	log.Printf("doc %s: %s", canonicalKey(key, k), time.Since(start))
+	return doc, nil
+}
+
+// records returns the source rows of the vaults with the header, in
+// upload order and the added ones after
+func (g *generation) records() [][]string {
+	var docs []*baseDoc
+	for _, vlt := range g.vault {
+		vlt.Range(func(_, v interface{}) bool {
+			docs = append(docs, v.(*baseDoc))
+			return true
+		})
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Line < docs[j].Line })
+
+	rec := [][]string{g.header}
+	for _, d := range docs {
+		if d.Row != nil {
+			rec = append(rec, d.Row)
+		}
+	}
+	return rec
+}
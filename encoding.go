@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// writeResult encodes a (possibly field-filtered) search response in the
+// format the client accepts, the sections in order (see sectionOrder), and
+// stores it in the response cache under key.
+func writeResult(w http.ResponseWriter, r *http.Request, out interface{}, order []string, key string) {
+	ctype, b, err := encodeResult(r, out, order)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	if cfg.CacheTTL > 0 {
+		resCache.put(key, ctype, b)
+	}
+	if strings.HasPrefix(ctype, "application/json") {
+		validateResponse(w, r, "result", b)
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// encodeResult picks MessagePack, Protobuf (see result.proto) or compact
+// JSON by default, indented with ?pretty=1.
+func encodeResult(r *http.Request, out interface{}, order []string) (string, []byte, error) {
+	acc := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(acc, "application/msgpack"), strings.Contains(acc, "application/x-msgpack"):
+		b, err := marshalMsgpack(out)
+		return "application/msgpack", b, err
+	case strings.Contains(acc, "application/x-protobuf"), strings.Contains(acc, "application/protobuf"):
+		b, err := marshalProto(out)
+		return "application/x-protobuf", b, err
+	}
+	if len(order) > 0 {
+		b, err := orderedJSON(out, order, wantPretty(r))
+		return "application/json; charset=utf-8", append(b, '\n'), err
+	}
+	b, err := marshalJSON(r, out)
+	return "application/json; charset=utf-8", append(b, '\n'), err
+}
+
+// marshalJSON is compact, indented with ?pretty=1
+func marshalJSON(r *http.Request, v interface{}) ([]byte, error) {
+	if wantPretty(r) {
+		return json.MarshalIndent(v, "", "\t")
+	}
+	return json.Marshal(v)
+}
+
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative result.proto
+
+// marshalProto encodes the generated messages of result.proto. A filtered
+// response (a map) goes through JSON back into result, dropped fields are
+// then simply empty.
+func marshalProto(v interface{}) ([]byte, error) {
+	res, ok := v.(*result)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		res = &result{}
+		err = json.Unmarshal(b, res)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf: %v", err)
+		}
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(protoResult(res))
+}
+
+func protoResult(res *result) *Result {
+	m := &Result{
+		Find:    res.Find,
+		Sugg:    res.Sugg,
+		SuggInf: protoSuggs(res.SuggINF),
+		SuggInn: protoSuggs(res.SuggINN),
+		SuggAct: protoSuggs(res.SuggACT),
+		SuggOrg: protoSuggs(res.SuggORG),
+		SuggAtc: protoSuggs(res.SuggATC),
+	}
+	for _, k := range kindOrder {
+		if s, ok := res.SuggKinds[k]; ok && s != nil {
+			m.SuggKinds = append(m.SuggKinds, &KindSection{Kind: k, Sugg: protoSuggs(*s)})
+		}
+	}
+	if res.Meta != nil {
+		m.Meta = protoMeta(res.Meta)
+	}
+	if p := res.Parsed; p != nil {
+		m.Parsed = &Parsed{Core: p.Core, Dosage: p.Dosage, Form: p.Form, Count: int64(p.Count), Times: int64(p.Times)}
+	}
+	for _, t := range res.Tokens {
+		m.Tokens = append(m.Tokens, &TokenMatch{Token: t.Token, Names: t.Names, Keys: t.Keys, Dropped: t.Dropped, Relaxed: t.Relaxed})
+	}
+	for _, a := range res.Alternates {
+		m.Alternates = append(m.Alternates, &Alternate{Query: a.Query, Reason: a.Reason, Count: int64(a.Count)})
+	}
+	return m
+}
+
+func protoSuggs(a []sugg) []*Sugg {
+	if len(a) == 0 {
+		return nil
+	}
+	res := make([]*Sugg, len(a))
+	for i := range a {
+		res[i] = &Sugg{Name: a[i].Name, Keys: a[i].Keys}
+	}
+	return res
+}
+
+func protoMeta(m *meta) *Meta {
+	res := &Meta{
+		Labels:     m.Labels,
+		Degraded:   m.Degraded,
+		Disabled:   m.Disabled,
+		Inferred:   m.Inferred,
+		Intent:     m.Intent,
+		PrefixOnly: m.PrefixOnly,
+		Order:      m.Order,
+	}
+	if len(m.Hidden) > 0 {
+		res.Hidden = make(map[string]int64, len(m.Hidden))
+		for k, n := range m.Hidden {
+			res.Hidden[k] = int64(n)
+		}
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TestProtoResult decodes a protobuf response with the generated code and
+// checks it against the JSON one
+func TestProtoResult(t *testing.T) {
+	body := `{"name":"кислота","parse":true,"tokens":true}`
+	var want result
+	w := serve("POST", "/test/select-suggestion", body, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("json: %d %s", w.Code, w.Body)
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/test/select-suggestion", strings.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:1"
+	r.Header.Set("Accept", "application/x-protobuf")
+	w = httptest.NewRecorder()
+	testHandler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("protobuf: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var got Result
+	err = proto.Unmarshal(w.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !proto.Equal(&got, protoResult(&want)) {
+		t.Errorf("got %v, want %v", &got, protoResult(&want))
+	}
+	if got.GetFind() != "кислота" || len(got.GetSuggInf()) == 0 || got.GetMeta().GetLabels()["inf"] == "" {
+		t.Errorf("got %v", &got)
+	}
+}
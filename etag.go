@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// etagSeq is bumped when search results change without a new generation
+// (rules, disabled kinds) and with one (upload, rollback), etagModified
+// (unix nanoseconds) is the time of the last bump.
+var (
+	etagSeq      uint64
+	etagModified int64
+)
+
+func staleETags() {
+	atomic.StoreInt64(&etagModified, time.Now().UnixNano())
+	atomic.AddUint64(&etagSeq, 1)
+}
+
+// lastModified is the time the results last changed: the last staleETags
+// or the ingest of g, if later
+func lastModified(g *generation) time.Time {
+	mod := g.Created
+	if n := atomic.LoadInt64(&etagModified); n > mod.UnixNano() {
+		mod = time.Unix(0, n)
+	}
+	return mod.UTC().Truncate(time.Second)
+}
+
+// notModified sets ETag (data generation + request hash) and Last-Modified
+// (see lastModified) on a search response and answers 304 when the client
+// already has it. Both change on every upload, rollback and change of the
+// results, so the validators never outlive the data.
+func notModified(w http.ResponseWriter, r *http.Request, key string) bool {
+	g, _ := dataIndex(r).generations()
+	if g == nil {
+		return false
+	}
+
+	h := key
+	if i := strings.IndexByte(h, ':'); i >= 0 {
+		h = h[i+1:]
+	}
+	if len(h) > 16 {
+		h = h[:16]
+	}
+	etag := `"` + strconv.FormatInt(g.ID, 36) + "." + strconv.FormatUint(atomic.LoadUint64(&etagSeq), 36) + "-" + h + `"`
+	mod := lastModified(g)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", mod.Format(http.TimeFormat))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || mod.After(ims) {
+		return false
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
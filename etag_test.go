@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func serveIf(header, value string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/test/select-suggestion", strings.NewReader(`{"name":"кислота"}`))
+	r.RemoteAddr = "127.0.0.1:1"
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, r)
+	return w
+}
+
+// TestLastModified checks that a change of the results without a new
+// generation moves Last-Modified as it does the ETag
+func TestLastModified(t *testing.T) {
+	w := serveIf("", "")
+	etag, mod := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || mod == "" {
+		t.Fatalf("got %d, ETag %q, Last-Modified %q", w.Code, etag, mod)
+	}
+	if w := serveIf("If-Modified-Since", mod); w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if w := serveIf("If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// as staleETags a second or more later, the header has seconds only
+	defer atomic.StoreInt64(&etagModified, atomic.LoadInt64(&etagModified))
+	atomic.StoreInt64(&etagModified, time.Now().Add(2*time.Second).UnixNano())
+	atomic.AddUint64(&etagSeq, 1)
+
+	if w := serveIf("If-Modified-Since", mod); w.Code != http.StatusOK || w.Header().Get("Last-Modified") == mod {
+		t.Errorf("If-Modified-Since after a change: got %d, Last-Modified %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+	if w := serveIf("If-None-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match after a change: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// $ curl -i -X POST -T /path/to/judgments.csv http://localhost:8080/admin/eval?k=10
+//
+// judgments.csv: query,kind,id[,lang] - one relevant id per line
+
+type evalScore struct {
+	Queries int     `json:"queries"`
+	NDCG    float64 `json:"ndcg"`
+	MRR     float64 `json:"mrr"`
+}
+
+type evalReport struct {
+	K       int                   `json:"k"`
+	Queries int                   `json:"queries"`
+	Overall evalScore             `json:"overall"`
+	Kinds   map[string]*evalScore `json:"kinds"`
+	Errors  []string              `json:"errors,omitempty"`
+}
+
+type evalQuery struct {
+	name string
+	ua   bool
+}
+
+func evalSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := readCSV(b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	k := 10
+	if s := r.URL.Query().Get("k"); s != "" {
+		k, err = strconv.Atoi(s)
+		if err != nil || k <= 0 {
+			internalServerError(w, fmt.Errorf("invalid k: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// query -> kind -> relevant ids
+	judg := make(map[evalQuery]map[string]map[string]struct{})
+	for i := range rec {
+		if i == 0 {
+			continue
+		}
+		if len(rec[i]) < 3 {
+			err = fmt.Errorf("invalid csv: got %d, want %d", len(rec[i]), 3)
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		q := evalQuery{name: rec[i][0]}
+		if len(rec[i]) > 3 {
+			q.ua = strings.EqualFold(rec[i][3], "UA")
+		}
+		if judg[q] == nil {
+			judg[q] = make(map[string]map[string]struct{})
+		}
+		kind := rec[i][1]
+		if judg[q][kind] == nil {
+			judg[q][kind] = make(map[string]struct{})
+		}
+		judg[q][kind][rec[i][2]] = struct{}{}
+	}
+
+	rep := runEval(judg, k)
+
+	b, err = json.MarshalIndent(rep, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+func runEval(judg map[evalQuery]map[string]map[string]struct{}, k int) *evalReport {
+	rep := &evalReport{K: k, Kinds: make(map[string]*evalScore)}
+	for q, kinds := range judg {
+		res, err := suggest(context.Background(), nil, q.name, q.ua, 0, 0, cfg.Fuzziness, anyProduct)
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", q.name, err))
+			continue
+		}
+		rep.Queries++
+
+		sec := res.sections()
+		for kind, rel := range kinds {
+			s, ok := sec[kind]
+			if !ok {
+				rep.Errors = append(rep.Errors, fmt.Sprintf("%s: unknown kind %q", q.name, kind))
+				continue
+			}
+			ids := rankedKeys(*s)
+			ndcg, mrr := ndcgAt(ids, rel, k), reciprocalRank(ids, rel)
+
+			if rep.Kinds[kind] == nil {
+				rep.Kinds[kind] = &evalScore{}
+			}
+			addScore(rep.Kinds[kind], ndcg, mrr)
+			addScore(&rep.Overall, ndcg, mrr)
+		}
+	}
+	return rep
+}
+
+// addScore keeps a running mean
+func addScore(s *evalScore, ndcg, mrr float64) {
+	s.Queries++
+	s.NDCG += (ndcg - s.NDCG) / float64(s.Queries)
+	s.MRR += (mrr - s.MRR) / float64(s.Queries)
+}
+
+// rankedKeys flattens a section into the order the client shows the keys
+func rankedKeys(s []sugg) []string {
+	var res []string
+	for i := range s {
+		res = append(res, s[i].Keys...)
+	}
+	return remDupl(res)
+}
+
+// ndcgAt computes NDCG@k with binary relevance
+func ndcgAt(ids []string, rel map[string]struct{}, k int) float64 {
+	dcg := 0.0
+	for i := 0; i < len(ids) && i < k; i++ {
+		if _, ok := rel[ids[i]]; ok {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	idcg := 0.0
+	for i := 0; i < len(rel) && i < k; i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	if idcg == 0 {
+		return 0
+	}
+	return dcg / idcg
+}
+
+func reciprocalRank(ids []string, rel map[string]struct{}) float64 {
+	for i := range ids {
+		if _, ok := rel[ids[i]]; ok {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every doc keeps its source csv row, so the upload can be reproduced and
+// a single doc inspected with the columns it came with.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/export > sugg.csv
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/doc/inf-ru/1
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/doc/inf:ru:1
+
+func adminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	g, _ := indexDB.generations()
+	if g == nil {
+		internalServerError(w, fmt.Errorf("no data uploaded"), http.StatusNotFound)
+		return
+	}
+
+	var docs []*baseDoc
+	for _, vlt := range g.vault {
+		vlt.Range(func(_, v interface{}) bool {
+			docs = append(docs, v.(*baseDoc))
+			return true
+		})
+	}
+	// a row shared by several vaults is written once, in upload order
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Line < docs[j].Line })
+
+	// deleted rows get the time of deletion in an extra column, so they
+	// differ from rows that are simply gone
+	deleted := false
+	tombstones.Range(func(_, _ interface{}) bool {
+		deleted = true
+		return false
+	})
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if deleted {
+		_ = cw.Write(append(g.header[:len(g.header):len(g.header)], "deleted"))
+	} else {
+		_ = cw.Write(g.header)
+	}
+	last := -1
+	for _, d := range docs {
+		if d.Line == last || d.Row == nil {
+			continue
+		}
+		last = d.Line
+		if !deleted {
+			_ = cw.Write(d.Row)
+			continue
+		}
+		row := make([]string, len(g.header)+1)
+		copy(row, d.Row)
+		if t := deletedDoc(d.Kind, d.Row[1]); t != nil {
+			row[len(row)-1] = t.Deleted.UTC().Format(time.RFC3339)
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+}
+
+// adminDoc shows the vault doc of /admin/doc/{index}/{id} (or of a
+// canonical key, see parseKey) with its source row by column
+func adminDoc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/doc/"), "/")
+	if len(p) == 1 && strings.Contains(p[0], ":") {
+		key, id, err := parseKey(p[0])
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		p = []string{key, id}
+	}
+	if len(p) != 2 {
+		internalServerError(w, fmt.Errorf("want /admin/doc/{index}/{id} or /admin/doc/{kind:lang:id}"), http.StatusBadRequest)
+		return
+	}
+
+	vlt, err := indexDB.getVault(p[0])
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+	v, ok := vlt.Load(p[1])
+	if !ok {
+		internalServerError(w, fmt.Errorf("doc not found: %s", p[1]), http.StatusNotFound)
+		return
+	}
+	doc := v.(*baseDoc)
+
+	g, _ := indexDB.generations()
+	src := make(map[string]string, len(doc.Row))
+	for i := range doc.Row {
+		k := fmt.Sprintf("%d", i)
+		if g != nil && i < len(g.header) {
+			k = g.header[i]
+		}
+		src[k] = doc.Row[i]
+	}
+
+	res := struct {
+		Doc     *baseDoc          `json:"doc"`
+		Source  map[string]string `json:"source"`
+		Deleted *tombstone        `json:"deleted,omitempty"`
+	}{doc, src, deletedDoc(doc.Kind, p[1])}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The columns of a suggestion csv after the known ones (see parseRow) go
+// with the docs as they are: the non-empty ones are in "extra" by their
+// header names, lowercased, as {"storage": "2-8"}. A new attribute needs
+// no code change, only a header name; a doc of /test/docs can have the
+// extra columns of the uploaded header.
+//
+// $ curl -i -X POST -T sugg.csv http://localhost:8080/test/upload-sugg   (kind,...,dispense,age,storage)
+// $ curl -i http://localhost:8080/test/barcode/4820000000011
+
+// rowColumns is the number of the known columns
+const rowColumns = 11
+
+var errUnknownColumn = errors.New("unknown extra column")
+
+func extraName(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// extraOf returns the extra columns of the row, nil for none
+func extraOf(header, row []string) map[string]string {
+	var m map[string]string
+	for i := rowColumns; i < len(row) && i < len(header); i++ {
+		name, v := extraName(header[i]), strings.TrimSpace(row[i])
+		if name == "" || v == "" {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string, len(header)-rowColumns)
+		}
+		m[name] = v
+	}
+	return m
+}
+
+// extraRow puts the extra columns into the row after the known ones, by
+// the header
+func extraRow(header, row []string, extra map[string]string) ([]string, error) {
+	for name, v := range extra {
+		i := rowColumns
+		for ; i < len(header); i++ {
+			if extraName(header[i]) == extraName(name) {
+				break
+			}
+		}
+		if i >= len(header) {
+			return nil, fmt.Errorf("%w: %q", errUnknownColumn, name)
+		}
+		for len(row) <= i {
+			row = append(row, "")
+		}
+		row[i] = v
+	}
+	return row, nil
+}
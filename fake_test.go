@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSearcher is a deterministic in-memory Searcher for tests of the
+// handlers, ranking and caches: no index is built, the names are matched
+// word by word the way the bleve queries of each searchMode do, after
+// normName and lowercasing only (no stop words or stemming). Every db is
+// searched as the one generation of its docs.
+//
+//	f := newFakeSearcher()
+//	f.Add("inf-ru", &baseDoc{ID: 1, Kind: "inf", Name: "Аскорбиновая кислота", Info: 1})
+//	f.Fail("org-ru", errors.New("down"))
+//	defer f.Install()()
+type fakeSearcher struct {
+	sync.Mutex
+	docs  map[string][]*baseDoc // key: docs
+	fail  map[string]error      // key: error of every Find
+	calls map[string]int        // key: Find calls
+}
+
+func newFakeSearcher() *fakeSearcher {
+	return &fakeSearcher{
+		docs:  make(map[string][]*baseDoc),
+		fail:  make(map[string]error),
+		calls: make(map[string]int),
+	}
+}
+
+// Add puts docs into the index key
+func (f *fakeSearcher) Add(key string, docs ...*baseDoc) {
+	f.Lock()
+	defer f.Unlock()
+	f.docs[key] = append(f.docs[key], docs...)
+}
+
+// Fail makes Find on the index key fail with err (nil heals it)
+func (f *fakeSearcher) Fail(key string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.fail[key] = err
+}
+
+// Calls returns the number of Find calls on the index key
+func (f *fakeSearcher) Calls(key string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[key]
+}
+
+// Install makes f the searcher with its docs as the current generation
+// (vaults ranked as by an upload) and returns the undo, which brings the
+// previous generation back
+func (f *fakeSearcher) Install() func() {
+	f.Lock()
+	vault := make(map[string]*sync.Map, len(f.docs))
+	rows := 0
+	for key, docs := range f.docs {
+		vault[key] = new(sync.Map)
+		for _, d := range docs {
+			vault[key].Store(strconv.Itoa(d.ID), d)
+		}
+		rows += len(docs)
+	}
+	f.Unlock()
+
+	rankVaults(vault)
+	g := &generation{ID: time.Now().UnixNano(), Created: time.Now(), Rows: rows, vault: vault, letters: letterIndex(vault)}
+	g.estimateSizes()
+	indexDB.swap(g)
+	resCache.purge()
+
+	prev := searcher
+	searcher = f
+	return func() {
+		searcher = prev
+		_, _ = indexDB.rollback()
+		resCache.purge()
+	}
+}
+
+func (f *fakeSearcher) Find(_ context.Context, _ *index, key, name string, mode searchMode) (map[string][]string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.calls[key]++
+	if err := f.fail[key]; err != nil {
+		return nil, err
+	}
+
+	name, excl := splitExcluded(name)
+	name = normName(name)
+	kind := strings.Split(key, "-")[0]
+	if cfg.Mappings[kind].hasFilter("legal_forms") {
+		name = stripLegalForms(name)
+	}
+	query := strings.Fields(strings.ToLower(name))
+
+	out := make(map[string][]string)
+next:
+	for _, d := range f.docs[key] {
+		names := append([]string{d.Name}, d.Names...)
+		for _, n := range append(names, d.Latin) {
+			for _, x := range excl {
+				if contains(fakeWords(n), strings.ToLower(x)) {
+					continue next
+				}
+			}
+		}
+
+		for _, n := range append(names, d.Latin) {
+			if fakeMatch(fakeWords(n), query, mode) {
+				best, rank := d.Name, -1
+				for _, v := range names {
+					if r := matchRank(v, name); r > rank {
+						best, rank = v, r
+					}
+				}
+				out[best] = append(out[best], strconv.Itoa(d.ID))
+				break
+			}
+		}
+	}
+
+	for k, v := range out {
+		sort.Strings(v)
+		if v = liveKeys(kind, remDupl(v)); len(v) > 0 {
+			out[k] = v
+		} else {
+			delete(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSearcher) Split(_ *index, keys []string, query string) []string {
+	f.Lock()
+	defer f.Unlock()
+	return splitWith(f.dict, keys, query)
+}
+
+func (f *fakeSearcher) Terms(_ *index, ua bool) (compoundDict, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	d := compoundDict{}
+	for _, k := range kindOrder {
+		for t, n := range f.dict(kindKey(k, ua)) {
+			d[t] += n
+		}
+	}
+	return d, nil
+}
+
+func (f *fakeSearcher) Barcode(_ context.Context, _ *index, key, ean string) ([]*baseDoc, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.calls[key]++
+	if err := f.fail[key]; err != nil {
+		return nil, err
+	}
+	out := []*baseDoc{}
+	for _, d := range f.docs[key] {
+		if contains(d.EAN, ean) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// dict counts the words of the names of the key as the name terms
+func (f *fakeSearcher) dict(key string) compoundDict {
+	d := compoundDict{}
+	for _, doc := range f.docs[key] {
+		for _, n := range append([]string{doc.Name}, doc.Names...) {
+			for _, w := range queryWords(n) {
+				d[w]++
+			}
+		}
+	}
+	return d
+}
+
+func fakeWords(s string) []string {
+	return strings.Fields(strings.ToLower(normName(s)))
+}
+
+// fakeMatch mirrors nameQuery: a phrase is the query words in a row,
+// within the slop for phrase~N, the other modes match every query word against some word.
+func fakeMatch(words, query []string, mode searchMode) bool {
+	if len(query) == 0 {
+		return false
+	}
+	if mode == modePhrase {
+		for i := 0; i+len(query) <= len(words); i++ {
+			if strings.Join(words[i:i+len(query)], " ") == strings.Join(query, " ") {
+				return true
+			}
+		}
+		return false
+	}
+	if n := mode.slop(); n > 0 {
+		terms := make([][]string, len(query))
+		for i, q := range query {
+			terms[i] = []string{q}
+		}
+		pos := make(map[string][]int, len(words))
+		for i, w := range words {
+			pos[w] = append(pos[w], i+1)
+		}
+		return slopPath(terms, pos, 0, n, nil)
+	}
+
+	for _, q := range query {
+		found := false
+		for _, w := range words {
+			if fakeWordMatch(w, q, mode) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func fakeWordMatch(w, q string, mode searchMode) bool {
+	if n := wordFuzziness(q, mode); n > 0 {
+		return editDistance(w, q) <= n
+	}
+	switch mode {
+	case modePrefix:
+		return strings.HasPrefix(w, q)
+	case modePhonetic:
+		ok, _ := regexp.MatchString("^(?:"+phoneticPattern(q)+")$", w)
+		return ok
+	}
+	return strings.Contains(w, q)
+}
+
+func TestFakeFind(t *testing.T) {
+	f := newFakeSearcher()
+	f.Add("inf-ru",
+		&baseDoc{ID: 1, Kind: "inf", Name: "Аскорбиновая кислота", Info: 1},
+		&baseDoc{ID: 2, Kind: "inf", Name: "Кислота ацетилсалициловая", Info: 2},
+	)
+	defer f.Install()()
+
+	for _, v := range []struct {
+		name string
+		mode searchMode
+		want []string
+	}{
+		{"кислота", modePhrase, []string{"Аскорбиновая кислота", "Кислота ацетилсалициловая"}},
+		{"кислота аскорбиновая", modePhrase, nil},
+		{"кислота аскорбиновая", modeInfix, []string{"Аскорбиновая кислота"}},
+		{"аскорб", modePrefix, []string{"Аскорбиновая кислота"}},
+		{"корбин", modePrefix, nil},
+		{"корбин", modeInfix, []string{"Аскорбиновая кислота"}},
+		{"кислота -ацетилсалициловая", modeInfix, []string{"Аскорбиновая кислота"}},
+		{"аскарбиновая", fuzzyMode(1), []string{"Аскорбиновая кислота"}},
+	} {
+		res, err := searcher.Find(context.Background(), nil, "inf-ru", v.name, v.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for n := range res {
+			got = append(got, n)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("%q %s: got %q, want %q", v.name, v.mode, got, v.want)
+		}
+	}
+
+	f.Fail("inf-ru", errors.New("down"))
+	if _, err := searcher.Find(context.Background(), nil, "inf-ru", "кислота", modeInfix); err == nil {
+		t.Error("want the error of a failed index")
+	}
+	if n := f.Calls("inf-ru"); n != 9 {
+		t.Errorf("calls: got %d, want 9", n)
+	}
+}
+
+// TestFakeHandlers runs the handlers over the fake, no index is built
+func TestFakeHandlers(t *testing.T) {
+	f := newFakeSearcher()
+	f.Add("inf-ru",
+		&baseDoc{ID: 1, Kind: "inf", Name: "Аскорбиновая кислота", Info: 1, EAN: []string{"4820000000011"}},
+		&baseDoc{ID: 2, Kind: "inf", Name: "Парацетамол", Info: 2},
+	)
+	defer f.Install()()
+
+	for _, v := range []struct {
+		method, path, body string
+		want               string
+	}{
+		{"POST", "/test/select-sugg", `{"name":"аскорб"}`, `"sugg":["АСКОРБИНОВАЯ КИСЛОТА"]`},
+		{"POST", "/test/select-suggestion", `{"name":"аскорбиноваякислота"}`, `"sugg_inf":[{"keys":["1"]}]`},
+		{"GET", "/test/barcode/4820000000011", "", `"id":1,`},
+		{"POST", "/test/spellcheck", `{"name":"аскарбиновая"}`, `"corrected":"аскорбиновая"`},
+	} {
+		w := serve(v.method, v.path, v.body, false)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), v.want) {
+			t.Errorf("%s %s: got %d %s, want %s", v.method, v.path, w.Code, w.Body, v.want)
+		}
+	}
+	if got := f.Split(nil, []string{"inf-ru"}, "аскорбиноваякислота"); !reflect.DeepEqual(got, []string{"аскорбиновая кислота"}) {
+		t.Errorf("split: got %q", got)
+	}
+
+	f.Fail("inf-ru", errors.New("down"))
+	w := serve("POST", "/test/select-suggestion", `{"name":"парацетамол"}`, false)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"degraded":["inf"]`) {
+		t.Errorf("failed index: got %d %s", w.Code, w.Body)
+	}
+}
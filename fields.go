@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requestFields merges the "fields" list of the request body with the
+// ?fields=a,b query parameter.
+func requestFields(r *http.Request, fields []string) []string {
+	if s := r.URL.Query().Get("fields"); s != "" {
+		fields = append(fields, strings.Split(s, ",")...)
+	}
+	return fields
+}
+
+// filterFields keeps only the listed response fields. A field is either a
+// top level key ("sugg_inn", "meta") or a key of the section entries
+// ("sugg_inn.name" drops the keys and keeps the names).
+func filterFields(v interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]map[string]bool, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		p := strings.SplitN(f, ".", 2)
+		if _, ok := keep[p[0]]; !ok {
+			keep[p[0]] = nil
+		}
+		if len(p) == 2 {
+			if keep[p[0]] == nil {
+				keep[p[0]] = map[string]bool{}
+			}
+			keep[p[0]][p[1]] = true
+		}
+	}
+
+	for k, val := range m {
+		sub, ok := keep[k]
+		if !ok {
+			delete(m, k)
+			continue
+		}
+		if sub == nil {
+			continue
+		}
+		switch val := val.(type) {
+		case []interface{}:
+			for i := range val {
+				filterKeys(val[i], sub)
+			}
+		default:
+			filterKeys(val, sub)
+		}
+	}
+
+	return m, nil
+}
+
+func filterKeys(v interface{}, keep map[string]bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k := range m {
+		if !keep[k] {
+			delete(m, k)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import _ "embed" // fixtures
+
+// The sample dataset covers every kind in both languages: names with
+// dosages, a Latin column, word vs substring matches ("кислота" and
+// "Окислотан") and sales to rank by. Start the server with -fixtures to
+// play with it without a real export.
+
+//go:embed fixtures/sugg.csv
+var fixtureSugg []byte
+
+//go:embed fixtures/sales.csv
+var fixtureSales []byte
+
+func loadFixtures() error {
+	rec, err := readCSV(fixtureSugg)
+	if err != nil {
+		return err
+	}
+	err = checkRecords(rec, 6)
+	if err != nil {
+		return err
+	}
+	err = ingestSugg(rec)
+	if err != nil {
+		return err
+	}
+
+	rec, err = readCSV(fixtureSales)
+	if err != nil {
+		return err
+	}
+	err = checkRecords(rec, 2)
+	if err != nil {
+		return err
+	}
+	return ingestSales(rec)
+}
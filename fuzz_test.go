@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// $ go test -run '^$' -fuzz FuzzNormName -fuzztime 30s
+
+var fuzzNames = []string{
+	"кислота",
+	"Аскорбиновая кислота таблетки 0,5 г №10",
+	"Нурофен Форте 400мг",
+	"Ибупро́фен",
+	"М'ята пе́рцева",
+	"rbckjnf",
+	"**",
+	".*",
+	"   ",
+	"а-б/в\\г|д",
+	"A02|Препараты",
+	"ёЁїЇґҐ",
+	"\xff\xfe",
+	strings.Repeat("кислота", 200),
+}
+
+func FuzzNormName(f *testing.F) {
+	for _, s := range fuzzNames {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		n := normName(s)
+		for _, c := range n {
+			if c != ' ' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				t.Fatalf("%q: %q in %q", s, c, n)
+			}
+		}
+		for _, w := range queryWords(n) {
+			if w == "" || strings.ContainsAny(w, "*?.") {
+				t.Fatalf("%q: query word %q", s, w)
+			}
+		}
+	})
+}
+
+// FuzzNameQuery builds the query of every mode and runs it on the fixtures
+func FuzzNameQuery(f *testing.F) {
+	for _, s := range fuzzNames {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, mode := range []searchMode{modePhrase, modeInfix, modePrefix, modeFuzzy, modePhonetic} {
+			_, err := findByName(context.Background(), "inf-ru", s, mode)
+			if err != nil {
+				t.Fatalf("%q %s: %v", s, mode, err)
+			}
+		}
+	})
+}
+
+// FuzzReadCSV parses an upload the way uploadSugg does and ingests it in
+// memory: no row panics and every accepted row is in its vault
+func FuzzReadCSV(f *testing.F) {
+	f.Add(fixtureSugg)
+	f.Add(fixtureSales)
+	f.Add([]byte("kind,id,name_ru,name_ua,info,lang\ninf,1,\"a\"\"b\",в,x,RU\n"))
+	f.Add([]byte("kind,id,name_ru,name_ua,info,lang,latin\natc,2,А,Б,1,UA\n"))
+	f.Add([]byte("kind,id,name_ru,name_ua,info,lang,latin,ean,reg,dispense,age\ninfo,3,Ибупрофен,Ібупрофен,1,RU,Ibuprofen,4820000000001;x,UA/1,rx,6-\n"))
+	f.Add([]byte("kind,id,name_ru,name_ua,info,lang\nxyz,4,А,Б,1,RU\ninn,,,,,\n"))
+	f.Add([]byte("\"unterminated\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		rec, err := readCSV(b)
+		if err != nil || len(rec) < 2 || checkRecords(rec, 6) != nil {
+			return
+		}
+		keys := make([][2]string, len(rec))
+		for i := 1; i < len(rec); i++ {
+			key, k, _ := parseRow(rec[0], rec[i], i)
+			keys[i] = [2]string{key, k}
+		}
+
+		g, err := buildGenerationIn("", rec, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", b, err)
+		}
+		defer g.release()
+		for i, v := range keys[1:] {
+			vlt, ok := g.vault[v[0]]
+			if !ok {
+				continue // not a configured kind or language
+			}
+			if _, ok := vlt.Load(v[1]); !ok {
+				t.Fatalf("%q: row %d (%s %s) not in the vault", b, i+2, v[0], v[1])
+			}
+		}
+	})
+}
+
+// FuzzLayoutConv converts the keys of en to every keyboard and back
+func FuzzLayoutConv(f *testing.F) {
+	for _, s := range []string{"rbckjnf", "ghbdsn", "Yehjatyt", "[]\\;',./`", "b,eghjatyn 200"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		en := mapKB["en"]
+		s = strings.Map(func(c rune) rune {
+			for _, k := range en {
+				if k == c {
+					return c
+				}
+			}
+			return -1
+		}, s)
+		for _, kb := range keyboardNames() {
+			to := convString(s, "en", kb)
+			if back := convString(to, kb, "en"); back != s {
+				t.Fatalf("%q: en-%s %q, back %q", s, kb, to, back)
+			}
+		}
+		_ = layoutConv(s, false)
+		_ = layoutConv(s, true)
+	})
+}
+
+// FuzzRequestJSON posts the body to the search handlers, a malformed one
+// is a 400 and never a 500
+func FuzzRequestJSON(f *testing.F) {
+	// all of the inputs come from one client, which would be banned
+	defer func(n int) { cfg.AbuseRate = n }(cfg.AbuseRate)
+	cfg.AbuseRate = 0
+
+	for _, s := range []string{
+		`{"name":"кислота"}`,
+		`{"name":"кислота","limit":3,"min":1,"min_kind":{"inf":1}}`,
+		`{"name":"rbckjnf"}`,
+		`{"name":`,
+		`{"name":"\xff\xfe"}`,
+		`{"name":"**"}`,
+		`[]`,
+		`null`,
+	} {
+		f.Add(s)
+	}
+	paths := []string{"/test/select-sugg", "/test/select-suggestion", "/test/select-name", "/test/spellcheck"}
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, path := range paths {
+			if w := serve("POST", path, s, false); w.Code >= http.StatusInternalServerError {
+				t.Fatalf("%s %q: %d %q", path, s, w.Code, w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// generation is one complete upload of the suggestion data: all kind
+// indexes with their vaults, swapped in and out at once.
+type generation struct {
+	ID      int64     `json:"id"`
+	Created time.Time `json:"created"`
+	Rows    int       `json:"rows"`
+	// RowsPerSec is the indexing throughput of the upload
+	RowsPerSec float64 `json:"rows_per_sec,omitempty"`
+
+	// Versions are the mapping versions the indexes were built with,
+	// see mappingVersion
+	Versions map[string]string `json:"mapping_versions,omitempty"`
+
+	header []string // of the source csv
+	dir    string   // of the cold indexes, see coldDir
+	store  map[string]bleve.Index
+	vault  map[string]*sync.Map
+
+	letters   map[string]map[string][]sugg // key -> letter -> names, see letterIndex
+	postings  postings                     // key -> doc ID -> rows, see posting
+	sizes     []memoryPart                 // estimated, see estimateSizes
+	compounds sync.Map                     // index/field -> compoundDict, see compoundsOf
+
+	edit  sync.RWMutex // of letters and postings by editDoc
+	lines int          // of the source rows, see records
+
+	refs    sync.Mutex // of users and dropped
+	users   int        // searches running in it, see using
+	dropped bool       // out of the index, closed by its last search
+}
+
+// lettersOf returns the names of the key by letter
+func (g *generation) lettersOf(key string) map[string][]sugg {
+	g.edit.RLock()
+	defer g.edit.RUnlock()
+	return g.letters[key]
+}
+
+func (g *generation) close() {
+	g.release()
+	if g.dir != "" {
+		_ = os.RemoveAll(g.dir)
+	}
+}
+
+// use counts a search running in g until the returned func is called
+func (g *generation) use() func() {
+	g.refs.Lock()
+	g.users++
+	g.refs.Unlock()
+
+	return func() {
+		g.refs.Lock()
+		g.users--
+		last := g.users == 0 && g.dropped
+		g.refs.Unlock()
+		if last {
+			g.close()
+		}
+	}
+}
+
+// drop closes g now or, if searches still run in it, after the last one
+func (g *generation) drop() {
+	g.refs.Lock()
+	g.dropped = true
+	idle := g.users == 0
+	g.refs.Unlock()
+	if idle {
+		g.close()
+	}
+}
+
+// release closes the indexes, keeping the files
+func (g *generation) release() {
+	closed := make(map[bleve.Index]bool, len(g.store)) // shared by two keys
+	for k, idx := range g.store {
+		if closed[idx] {
+			continue
+		}
+		closed[idx] = true
+		err := idx.Close()
+		if err != nil {
+			log.Printf("err: %s: %s", k, err.Error())
+		}
+	}
+}
+
+// docCount counts the docs of every index
+func (g *generation) docCount() map[string]int {
+	res := make(map[string]int, len(g.vault))
+	for k, v := range g.vault {
+		n := 0
+		v.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		res[k] = n
+	}
+	return res
+}
+
+// swap makes g current and keeps up to cfg.KeepGenerations previous ones
+func (i *index) swap(g *generation) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.gen != nil {
+		i.history = append(i.history, i.gen)
+	}
+	for len(i.history) > cfg.KeepGenerations {
+		i.history[0].drop()
+		i.history = i.history[1:]
+	}
+	i.setGeneration(g)
+	staleETags()
+}
+
+func (i *index) setGeneration(g *generation) {
+	i.gen = g
+	i.store = make(map[string]bleve.Index, len(g.store))
+	i.vault = make(map[string]*sync.Map, len(g.vault))
+	for k, v := range g.store {
+		i.store[k] = v
+	}
+	for k, v := range g.vault {
+		i.vault[k] = v
+	}
+}
+
+// rollback brings back the previous generation, the current one is dropped
+func (i *index) rollback() (*generation, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if len(i.history) == 0 {
+		return nil, fmt.Errorf("no previous generation")
+	}
+
+	prev := i.history[len(i.history)-1]
+	i.history = i.history[:len(i.history)-1]
+	if i.gen != nil {
+		i.gen.drop()
+	}
+	i.setGeneration(prev)
+	staleETags()
+	return prev, nil
+}
+
+// using returns the generation of id, the current one for 0, counted as
+// in use until done is called, see generation.use
+func (i *index) using(id int64) (g *generation, done func()) {
+	i.RLock()
+	defer i.RUnlock()
+
+	g = i.gen
+	if id != 0 && (g == nil || g.ID != id) {
+		g = nil
+		for _, v := range i.history {
+			if v.ID == id {
+				g = v
+			}
+		}
+	}
+	if g == nil {
+		return nil, func() {}
+	}
+	return g, g.use()
+}
+
+// view is an index over the generation alone, see atGeneration
+func (g *generation) view() *index {
+	i := &index{}
+	i.setGeneration(g)
+	return i
+}
+
+func (i *index) generations() (*generation, []*generation) {
+	i.RLock()
+	defer i.RUnlock()
+
+	return i.gen, append([]*generation(nil), i.history...)
+}
+
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/generations
+// $ curl -i -H 'X-Api-Key: secret' -X POST http://localhost:8080/admin/rollback
+
+func adminGenerations(w http.ResponseWriter, r *http.Request) {
+	cur, hist := indexDB.generations()
+	res := struct {
+		Current *generation   `json:"current"`
+		History []*generation `json:"history"`
+	}{cur, hist}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+func adminRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	g, err := indexDB.rollback()
+	if err != nil {
+		internalServerError(w, err, http.StatusConflict)
+		return
+	}
+	resCache.purge()
+	go saveGeneration(g)
+
+	notify(hookEvent{Event: "ingest.rolled_back", Generation: g.ID, Rows: g.Rows, Duration: time.Since(start)})
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, g.ID)
+}
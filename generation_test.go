@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRollback uploads a generation, lists it with the kept one and rolls
+// back to the kept one while a search still runs in the dropped one: its
+// indexes stay open until the search ends
+func TestRollback(t *testing.T) {
+	defer func(n int) { cfg.KeepGenerations = n }(cfg.KeepGenerations)
+	cfg.KeepGenerations = 1
+
+	prev, _ := indexDB.generations()
+	rec, err := readCSV(fixtureSugg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ingestSugg(rec); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := indexDB.generations()
+
+	w := serve("GET", "/admin/generations", "", false)
+	var res struct {
+		Current *generation   `json:"current"`
+		History []*generation `json:"history"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Current == nil || res.Current.ID != cur.ID || len(res.History) != 1 || res.History[0].ID != prev.ID {
+		t.Fatalf("generations: %s", w.Body)
+	}
+
+	g, done := indexDB.using(cur.ID)
+	if g != cur {
+		t.Fatalf("using %d: got %v", cur.ID, g)
+	}
+	w = serve("POST", "/admin/rollback", "", false)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != strconv.FormatInt(prev.ID, 10) {
+		t.Fatalf("rollback: %d %s", w.Code, w.Body)
+	}
+	if g, _ := indexDB.generations(); g != prev {
+		t.Errorf("after the rollback: current %d, want %d", g.ID, prev.ID)
+	}
+	if w = serve("POST", "/admin/rollback", "", false); w.Code != http.StatusConflict {
+		t.Errorf("rollback without a kept generation: got %d", w.Code)
+	}
+
+	idx := cur.store["inf-ru"]
+	if _, err = idx.DocCount(); err != nil {
+		t.Errorf("dropped generation closed while in use: %v", err)
+	}
+	done()
+	if _, err = idx.DocCount(); err == nil {
+		t.Errorf("dropped generation still open after its last search")
+	}
+}
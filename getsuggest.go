@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// GET /test/suggest is /test/select-suggestion for the clients that can
+// only GET (autocomplete widgets) and for the caches on the way: the query
+// is q, the language lang (ru or ua, Accept-Language without it) and the
+// limit limit, the other options are the URL ones of select-suggestion
+// (fields, slop, dispense, patient_age, fuzziness, ...). It answers the
+// same, with Cache-Control max-age cfg.SuggestMaxAge when it is a success:
+// private for a request with a key (its section order, see clientOrder)
+// or of a kept generation, public otherwise.
+//
+// $ curl -i 'http://localhost:8080/test/suggest?q=нурофен&lang=ru&limit=20'
+
+// getSuggestion turns the GET into the POST of select-suggestion for h
+func getSuggestion(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		v := struct {
+			Name  string `json:"name"`
+			Limit int    `json:"limit,omitempty"`
+		}{Name: q.Get("q")}
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				internalServerError(w, fmt.Errorf("invalid limit: %q", s), http.StatusBadRequest)
+				return
+			}
+			v.Limit = n
+		}
+
+		r2 := r.Clone(r.Context())
+		switch lang := q.Get("lang"); lang {
+		case "":
+			w.Header().Add("Vary", "Accept-Language")
+		case "ru":
+			r2.Header.Set("Accept-Language", "ru")
+		case "ua", "uk":
+			r2.Header.Set("Accept-Language", "uk")
+		default:
+			internalServerError(w, fmt.Errorf("invalid lang: %q (ru, ua)", lang), http.StatusBadRequest)
+			return
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		r2.Method = "POST"
+		r2.URL.Path = "/test/select-suggestion"
+		r2.Body = ioutil.NopCloser(bytes.NewReader(b))
+		r2.ContentLength = int64(len(b))
+		w.Header().Add("Vary", "X-Api-Key")
+		private := r.Header.Get("X-Api-Key") != "" || r.Header.Get("Authorization") != "" || r.Header.Get(generationHeader) != ""
+		h(&maxAgeWriter{ResponseWriter: w, maxAge: cfg.SuggestMaxAge, private: private}, r2)
+	}
+}
+
+// maxAgeWriter makes the successful responses cacheable for maxAge
+// seconds, by the client alone if private
+type maxAgeWriter struct {
+	http.ResponseWriter
+	maxAge  int
+	private bool
+}
+
+func (w *maxAgeWriter) WriteHeader(code int) {
+	if w.maxAge > 0 && (code == http.StatusOK || code == http.StatusNotModified) {
+		scope := "public"
+		if w.private {
+			scope = "private"
+		}
+		w.Header().Set("Cache-Control", scope+", max-age="+strconv.Itoa(w.maxAge))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
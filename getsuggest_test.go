@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSuggestCacheControl checks that only the answers alike for every
+// client are for the shared caches
+func TestSuggestCacheControl(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.SuggestMaxAge = 60
+	cfg.ClientOrders = map[string][]string{"widget": {"org", "inf"}}
+	g, _ := indexDB.generations()
+
+	for _, v := range []struct {
+		name, header, value, want string
+	}{
+		{"anonymous", "", "", "public, max-age=60"},
+		{"client key", "X-Api-Key", "widget", "private, max-age=60"},
+		{"generation", generationHeader, strconv.FormatInt(g.ID, 10), "private, max-age=60"},
+	} {
+		r := httptest.NewRequest("GET", "/test/suggest?q=%D0%BA%D0%B8%D1%81%D0%BB%D0%BE%D1%82%D0%B0", nil)
+		r.RemoteAddr = "127.0.0.1:1"
+		if v.header != "" {
+			r.Header.Set(v.header, v.value)
+		}
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", v.name, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != v.want {
+			t.Errorf("%s: Cache-Control %q, want %q", v.name, got, v.want)
+		}
+		if vary := strings.Join(w.Header().Values("Vary"), ","); !strings.Contains(vary, "X-Api-Key") {
+			t.Errorf("%s: Vary %q without X-Api-Key", v.name, vary)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// $ go test -run TestGolden -update
+
+var update = flag.Bool("update", false, "rewrite the golden responses in testdata")
+
+// goldenRequests are compared byte for byte with testdata/golden, so a
+// change of the ordering or the format shows up as a diff of the files
+var goldenRequests = []struct {
+	file, path, body string
+	ua               bool
+}{
+	{"sugg-kislota-ru", "/test/select-sugg", `{"name":"кислота"}`, false},
+	{"sugg-kislota-ua", "/test/select-sugg", `{"name":"кислота"}`, true},
+	{"sugg-paracetamol-ru", "/test/select-sugg", `{"name":"парацетамол"}`, false},
+	{"sugg-layout-ru", "/test/select-sugg", `{"name":"yehjatyn"}`, false},
+	{"suggestion-kislota-ru", "/test/select-suggestion", `{"name":"кислота"}`, false},
+	{"suggestion-nurofen-ua", "/test/select-suggestion", `{"name":"нурофен"}`, true},
+	{"suggestion-paracetamol-ru", "/test/select-suggestion", `{"name":"парацетамол"}`, false},
+	{"suggestion-limit-ru", "/test/select-suggestion", `{"name":"кислота","limit":2,"min":1}`, false},
+	{"name-darnica-ru", "/test/select-name", `{"name":"дарница"}`, false},
+}
+
+func TestGolden(t *testing.T) {
+	// every run searches, so the second one checks the ordering and not
+	// the response cache, the memos or the zero-result cache
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.CacheTTL = 0
+	cfg.NegativeTTL = 0
+
+	for _, v := range goldenRequests {
+		path := filepath.Join("testdata", "golden", v.file+".json")
+		w := serve("POST", v.path, v.body, v.ua)
+		if *update {
+			err := os.WriteFile(path, w.Body.Bytes(), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Body.String(); got != string(want) {
+			t.Errorf("%s %s: got\n%s\nwant\n%s", v.path, v.body, got, want)
+		}
+		// the same request again must give the same bytes
+		if again := serve("POST", v.path, v.body, v.ua).Body.String(); again != w.Body.String() {
+			t.Errorf("%s %s: differs on the second run:\n%s", v.path, v.body, again)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Search responses are compact JSON (?pretty=1 indents them for humans)
+// and gzipped for clients that accept it once they reach cfg.GzipMin
+// bytes: a suggestion list repeats the same words and shrinks about three
+// times, smaller ones are not worth the CPU. The response cache keeps them
+// uncompressed, so every client gets the encoding it asked for.
+//
+// $ curl -i --compressed -d '{"name":"кислота"}' http://localhost:8080/test/select-sugg
+// $ curl -i -d '{"name":"кислота"}' 'http://localhost:8080/test/select-sugg?pretty=1'
+
+// wantPretty is ?pretty=1 (or true)
+func wantPretty(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return v
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "gzip" || strings.HasPrefix(v, "gzip;") && !strings.HasSuffix(strings.ReplaceAll(v, " ", ""), "q=0") { // gzip;q=0 refuses it
+			return true
+		}
+	}
+	return false
+}
+
+var gzipPool = sync.Pool{New: func() interface{} {
+	zw, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed) // latency over ratio
+	return zw
+}}
+
+// gzipWriter holds the response back to see its size
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *gzipWriter) flush() {
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.buf.Len() < cfg.GzipMin || h.Get("Content-Encoding") != "" {
+		if w.status == http.StatusNotModified {
+			weakETag(h) // the client may hold the gzipped one
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+
+	var out bytes.Buffer
+	zw := gzipPool.Get().(*gzip.Writer)
+	zw.Reset(&out)
+	_, _ = zw.Write(w.buf.Bytes())
+	_ = zw.Close()
+	gzipPool.Put(zw)
+
+	h.Set("Content-Encoding", "gzip")
+	h.Set("Content-Length", strconv.Itoa(out.Len()))
+	weakETag(h)
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(out.Bytes())
+}
+
+// weakETag marks the ETag of a gzipped response weak: it is not the same
+// bytes, but etagMatch takes it
+func weakETag(h http.Header) {
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// gzipped compresses the responses of h, see cfg.GzipMin
+func gzipped(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.GzipMin <= 0 || r.Method == "HEAD" || !acceptsGzip(r) {
+			if cfg.GzipMin > 0 {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+			h(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		h(gw, r)
+		gw.flush()
+	}
+}
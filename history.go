@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// For audits ("what did the search return last Tuesday?") a search
+// request can name a kept generation (cfg.KeepGenerations, listed by
+// /admin/generations) in the X-Data-Generation header. It is searched
+// alone, locally and past the hot tier, memo and negative cache, so the
+// response is the one its data gave. The rewrite rules, disabled kinds
+// and tombstones are the current ones. The header takes the admin
+// credentials, see adminOnly, or an OIDC token of a viewer.
+//
+// $ curl -i -H 'X-Api-Key: secret' -H 'X-Data-Generation: 1760540647022639443' -d '{"name":"кислота"}' http://localhost:8080/test/select-sugg
+
+const generationHeader = "X-Data-Generation"
+
+type generationKey struct{}
+
+// atGeneration runs the search in the generation of the request header
+func atGeneration(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(generationHeader)
+		if v == "" {
+			_, done := indexDB.using(0) // not closed by a rollback meanwhile
+			defer done()
+			h(w, r)
+			return
+		}
+
+		roleOnly("viewer", func(w http.ResponseWriter, r *http.Request) {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				internalServerError(w, fmt.Errorf("invalid %s: %q", generationHeader, v), http.StatusBadRequest)
+				return
+			}
+			g, done := indexDB.using(id)
+			defer done()
+			if g == nil {
+				internalServerError(w, fmt.Errorf("generation %d is not kept", id), http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set(generationHeader, v)
+			w.Header().Add("Vary", generationHeader)
+			h(w, r.WithContext(context.WithValue(r.Context(), generationKey{}, g.view())))
+		})(w, r)
+	}
+}
+
+// pinnedIndex returns the generation the request searches or nil for the
+// current one
+func pinnedIndex(r *http.Request) *index {
+	i, _ := r.Context().Value(generationKey{}).(*index)
+	return i
+}
+
+// dataIndex returns what the request searches
+func dataIndex(r *http.Request) *index {
+	if i := pinnedIndex(r); i != nil {
+		return i
+	}
+	return indexDB
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kind inference: some queries are clearly typed, an ATC code ("N02BE01")
+// is looked up in atc and a legal form ("ООО", "ТОВ") means a manufacturer.
+// Depending on cfg.KindInference the predicted kinds are searched alone
+// ("restrict") or just listed first ("boost").
+
+var (
+	atcCodeRe   = regexp.MustCompile(`^[A-Za-z]\d{2}(?:[A-Za-z](?:[A-Za-z](?:\d{2})?)?)?$`)
+	legalFormRe = regexp.MustCompile(`(?i)(?:^|[^\p{L}])(?:ооо|оао|зао|пао|ао|тов|пат|прат|ват|зат|фоп|чп|тоо|llc|ltd|inc|gmbh|ag|plc|s\.a\.|corp)(?:[^\p{L}]|$)`)
+)
+
+// inferKinds returns the kinds the query most likely targets or nil
+func inferKinds(name string) []string {
+	name = strings.TrimSpace(name)
+	switch {
+	case atcCodeRe.MatchString(name):
+		return []string{"atc"}
+	case legalFormRe.MatchString(name):
+		return []string{"org"}
+	}
+	return nil
+}
+
+// infer applies the kind inference to the result before the fan-out
+func (r *result) infer(name string) {
+	if cfg.KindInference == "" || cfg.KindInference == "off" {
+		return
+	}
+	kinds := inferKinds(name)
+	if kinds == nil {
+		return
+	}
+	r.meta().Inferred = kinds
+	if cfg.KindInference == "restrict" {
+		r.only = kinds
+	}
+}
+
+// preferInferred moves names found in the inferred kinds to the front
+func (r *result) preferInferred(names []string, kindOf map[string]string) {
+	if r.Meta == nil || len(r.Meta.Inferred) == 0 {
+		return
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return contains(r.Meta.Inferred, kindOf[names[i]]) && !contains(r.Meta.Inferred, kindOf[names[j]])
+	})
+}
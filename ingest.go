@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Ingests run one at a time. An upload arriving during another one, or
+// while others wait, gets 409, or with ?queue=1 is accepted (202) and run
+// after them in the order they came, up to cfg.IngestQueue waiting ones.
+// With ?async=1 it is accepted at once and its job reports the progress,
+// see jobs.go.
+//
+// $ curl -i -X POST -T sugg.csv 'http://localhost:8080/test/upload-sugg?queue=1'
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/status
+
+var errIngestBusy = errors.New("another ingest is running")
+
+type ingestJob struct {
+	ID       string     `json:"id"`
+	Target   string     `json:"target"`
+	Status   string     `json:"status"` // see the jobStatus constants
+	Rows     int        `json:"rows"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Processed int      `json:"processed"`        // rows read so far
+	Failed    int      `json:"failed"`           // rows not ingested
+	Errors    []string `json:"errors,omitempty"` // of the first maxJobErrors of them
+	Error     string   `json:"error,omitempty"`  // of the ingest
+}
+
+// pendingIngest is an ingest waiting in ingests.queue, log names it in
+// the log when it fails
+type pendingIngest struct {
+	job *ingestJob
+	fn  func() error
+	log string
+}
+
+var ingests = struct {
+	sync.Mutex
+	active *ingestJob
+	queue  []*pendingIngest // the first in line first
+}{}
+
+// runIngest runs fn unless another ingest is running or waiting: then it
+// fails with errIngestBusy or, with queue, waits for them in the
+// background.
+func runIngest(target string, rows int, queue bool, fn func() error) (queued bool, err error) {
+	job := newIngestJob(target, rows)
+	run, ok := submitIngest(&pendingIngest{job, fn, "queued"}, queue)
+	if !ok {
+		return false, errIngestBusy
+	}
+	if !run {
+		return true, nil
+	}
+	return false, doIngest(job, fn)
+}
+
+// submitIngest makes the job the active one when no other is running or
+// waiting (run), else puts it at the end of the queue if wanted and there
+// is room for it (ok)
+func submitIngest(p *pendingIngest, queue bool) (run, ok bool) {
+	ingests.Lock()
+	defer ingests.Unlock()
+
+	if ingests.active == nil && len(ingests.queue) == 0 {
+		startJob(p.job)
+		return true, true
+	}
+	if !queue || len(ingests.queue) >= cfg.IngestQueue {
+		return false, false
+	}
+	ingests.queue = append(ingests.queue, p)
+	return false, true
+}
+
+// startJob makes the job the active one, ingests must be locked
+func startJob(job *ingestJob) {
+	now := time.Now()
+	job.Started = &now
+	job.Status = jobRunning
+	ingests.active = job
+}
+
+// doIngest runs fn of the active job and then the next one in the queue
+func doIngest(job *ingestJob, fn func() error) (err error) {
+	defer func() {
+		end := time.Now()
+		ingests.Lock()
+		defer ingests.Unlock()
+
+		job.Finished = &end
+		job.Status = jobDone
+		if err != nil {
+			job.Status = jobFailed
+			job.Error = err.Error()
+		}
+		ingests.active = nil
+		dispatchIngest()
+	}()
+
+	return fn()
+}
+
+// dispatchIngest starts the first ingest of the queue in the background,
+// ingests must be locked. It is the only way out of the queue and nothing
+// starts while it is not empty, so the ingests run in the order they came.
+func dispatchIngest() {
+	if len(ingests.queue) == 0 {
+		return
+	}
+	p := ingests.queue[0]
+	ingests.queue = ingests.queue[1:]
+	startJob(p.job)
+	go func() {
+		err := doIngest(p.job, p.fn)
+		if err != nil {
+			log.Printf("err: %s %s ingest: %s", p.log, p.job.Target, err.Error())
+		}
+	}()
+}
+
+// ingestProgress counts a row read by the running ingest, err tells why
+// it was not ingested
+func ingestProgress(err error) {
+	ingests.Lock()
+	defer ingests.Unlock()
+
+	job := ingests.active
+	if job == nil {
+		return // not an ingest, as restoreStore
+	}
+	job.Processed++
+	if err == nil {
+		return
+	}
+	job.Failed++
+	if len(job.Errors) < maxJobErrors {
+		job.Errors = append(job.Errors, err.Error())
+	}
+}
+
+// ingestFailedRows fails n rows read by the running ingest with err
+func ingestFailedRows(n int, err error) {
+	ingests.Lock()
+	defer ingests.Unlock()
+
+	job := ingests.active
+	if job == nil {
+		return
+	}
+	job.Failed += n
+	if len(job.Errors) < maxJobErrors {
+		job.Errors = append(job.Errors, err.Error())
+	}
+}
+
+// ingestFailed answers a busy (409), failed (500) or queued (202) ingest
+// and tells if it did
+func ingestFailed(w http.ResponseWriter, queued bool, err error) bool {
+	switch {
+	case err == errIngestBusy:
+		w.Header().Set("Retry-After", "10")
+		internalServerError(w, err, http.StatusConflict)
+	case err != nil:
+		internalServerError(w, err)
+	case queued:
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "queued")
+	default:
+		return false
+	}
+	return true
+}
+
+func wantQueue(r *http.Request) bool {
+	q := r.URL.Query().Get("queue")
+	return q != "" && q != "0" && q != "false"
+}
+
+func adminStatus(w http.ResponseWriter, r *http.Request) {
+	ingests.Lock()
+	var active *ingestJob
+	if ingests.active != nil {
+		active = ingests.active.snapshot()
+	}
+	queue := make([]*ingestJob, len(ingests.queue))
+	for i, p := range ingests.queue {
+		queue[i] = p.job.snapshot()
+	}
+	res := struct {
+		Ingest     *ingestJob     `json:"ingest"`
+		Queue      []*ingestJob   `json:"queue"`
+		Generation *generation    `json:"generation"`
+		History    []*generation  `json:"history,omitempty"`
+		Sales      int            `json:"sales"`
+		Docs       map[string]int `json:"docs,omitempty"` // per index, deleted ones too
+		Tables     *tableStatus   `json:"tables,omitempty"`
+	}{Ingest: active, Queue: queue, Tables: tableStatusOf()}
+	ingests.Unlock()
+	res.Generation, res.History = indexDB.generations()
+	res.Sales = sales.count()
+	if res.Generation != nil {
+		res.Docs = res.Generation.docCount()
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
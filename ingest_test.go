@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestIngestOrder checks that the queued ingests run in the order they
+// came and that no ingest goes ahead of them while they wait
+func TestIngestOrder(t *testing.T) {
+	defer func(n int) { cfg.IngestQueue = n }(cfg.IngestQueue)
+	cfg.IngestQueue = 3
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_, _ = runIngest("test", 0, false, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for _, name := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		queued, err := runIngest(name, 0, true, func() error {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		})
+		if !queued || err != nil {
+			t.Fatalf("%s: queued %t, %v", name, queued, err)
+		}
+	}
+	if _, err := runIngest("full", 0, true, func() error { return nil }); err != errIngestBusy {
+		t.Errorf("over the queue: got %v, want %v", err, errIngestBusy)
+	}
+	if _, err := runIngest("sync", 0, false, func() error { return nil }); err != errIngestBusy {
+		t.Errorf("sync while busy: got %v, want %v", err, errIngestBusy)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := len(order); got != 3 || order[0] != "first" || order[1] != "second" || order[2] != "third" {
+		t.Errorf("got %v, want [first second third]", order)
+	}
+
+	// the last one hands over to nobody, then an ingest runs at once
+	for deadline := time.Now().Add(time.Second); ; {
+		queued, err := runIngest("after", 0, false, func() error { return nil })
+		if err == nil && !queued {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after the queue: queued %t, %v", queued, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
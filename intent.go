@@ -0,0 +1,76 @@
+package main
+
+import "strings"
+
+// Query intent: when the best hit of one kind is far ahead of the best
+// hits of the others (or the only one naming the query exactly), the
+// query is focused on that kind and capResult hands most of the limit to
+// its products (inf) and the kind itself instead of spreading it evenly.
+// "Парацетамол" that is an inn name with 200 products fills the limit
+// with those products, "пара" stays spread across the kinds.
+
+// intentScore is how well the top name of a kind matches the query:
+// matchRank over its maximum, 0..1, and 2 for the whole name.
+func intentScore(top string, queries ...string) float64 {
+	best := 0.0
+	for _, q := range queries {
+		q, _ = splitExcluded(q)
+		words := strings.Fields(strings.ToLower(normName(q)))
+		if len(words) == 0 {
+			continue
+		}
+		if strings.Join(strings.Fields(strings.ToLower(normName(top))), " ") == strings.Join(words, " ") {
+			return 2
+		}
+		if s := float64(matchRank(top, q)) / float64(3*len(words)); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// detectIntent sets the kind the query is focused on from the top names
+// of the kinds (sorted by match), if the gap to the runner-up is at least
+// cfg.IntentGap. A kind with the same top name (an inn that is also the
+// act) is no runner-up.
+func (r *result) detectIntent(tops map[string]string, queries ...string) {
+	if cfg.IntentGap <= 0 {
+		return
+	}
+	score := make(map[string]float64, len(tops))
+	kind := ""
+	for _, k := range kindOrder {
+		if top, ok := tops[k]; ok {
+			score[k] = intentScore(top, queries...)
+			if kind == "" || score[k] > score[kind] {
+				kind = k
+			}
+		}
+	}
+	if kind == "" {
+		return
+	}
+
+	second := 0.0
+	for k, s := range score {
+		if s > second && !strings.EqualFold(normName(tops[k]), normName(tops[kind])) {
+			second = s
+		}
+	}
+	if score[kind]-second >= cfg.IntentGap {
+		r.intent = kind
+		r.meta().Intent = kind
+	}
+}
+
+// intentKinds are the kinds favoured by the intent of the result, the
+// products first
+func (r *result) intentKinds() []string {
+	if r.intent == "" {
+		return nil
+	}
+	if r.intent == "inf" {
+		return []string{"inf"}
+	}
+	return []string{"inf", r.intent}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Drug interactions come as a csv of INN id pairs (inn_a, inn_b, severity,
+// optional note) and replace the previous ones in the interactions bucket
+// of the store. /test/interactions takes INN or product ids: the INNs of a
+// product are the INN docs of the vault whose name (or latin) words are all
+// in the product name (or latin), the data has no other link, so a brand
+// name alone matches none. It returns the known interactions between
+// different given ids, the most severe first.
+//
+// $ curl -i -H 'X-Api-Key: secret' -X POST -T interactions.csv http://localhost:8080/test/upload-interactions
+// $ curl -i 'http://localhost:8080/test/interactions?ids=103,105,201'
+
+const interactionsBucket = "interactions"
+
+// severities from the least to the most severe
+var severities = []string{"minor", "moderate", "major", "contraindicated"}
+
+const maxInteractionIDs = 50
+
+type interaction struct {
+	Severity string `json:"severity"`
+	Note     string `json:"note,omitempty"`
+}
+
+// pairKey is the store key of the INN pair, the same both ways
+func pairKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+func uploadInteractions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = verifySHA256(r, b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := readCSV(b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = checkRecords(rec, 3)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	pairs, err := readInteractions(rec)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	queued, err := runIngest("interactions", len(rec)-1, wantQueue(r), func() error {
+		return dataStore.SwapGeneration(map[string]map[string][]byte{interactionsBucket: pairs})
+	})
+	if ingestFailed(w, queued, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, len(rec)-1, len(pairs))
+}
+
+// readInteractions returns the csv records (inn_a, inn_b, severity, note)
+// by pairKey, the last row of a pair wins
+func readInteractions(rec [][]string) (map[string][]byte, error) {
+	pairs := make(map[string][]byte, len(rec))
+	for i := range rec {
+		if i == 0 {
+			continue
+		}
+
+		a, b := strings.TrimSpace(rec[i][0]), strings.TrimSpace(rec[i][1])
+		if a == "" || b == "" || a == b {
+			return nil, fmt.Errorf("invalid csv: line %d: want two INN ids", i+1)
+		}
+		v := interaction{Severity: strings.ToLower(strings.TrimSpace(rec[i][2]))}
+		if !contains(severities, v.Severity) {
+			return nil, fmt.Errorf("invalid csv: line %d: unknown severity %q (%s)", i+1, v.Severity, strings.Join(severities, ", "))
+		}
+		if len(rec[i]) > 3 {
+			v.Note = strings.TrimSpace(rec[i][3])
+		}
+		pairs[pairKey(a, b)], _ = json.Marshal(v)
+	}
+	return pairs, nil
+}
+
+type interactionHit struct {
+	IDs   []string `json:"ids"`   // the given ids
+	INNs  []string `json:"inns"`  // their INN ids
+	Names []string `json:"names"` // and names
+	interaction
+}
+
+// innWords are the words an INN is matched on, see innsOf
+type innWords struct {
+	id, name     string
+	words, latin []string
+}
+
+// innsOf returns the INNs of the vault doc: its own for an INN, the ones
+// named in a product
+func innsOf(doc *baseDoc, id string, inns []innWords) []innWords {
+	if doc.Kind == "inn" {
+		for _, v := range inns {
+			if v.id == id {
+				return []innWords{v}
+			}
+		}
+		return nil
+	}
+
+	name := strings.Fields(strings.ToLower(normName(strings.Join(append([]string{doc.Name}, doc.Names...), " "))))
+	latin := strings.Fields(strings.ToLower(normName(doc.Latin)))
+	var out []innWords
+	for _, v := range inns {
+		if (len(v.words) > 0 && containsAll(name, v.words)) || (len(v.latin) > 0 && containsAll(latin, v.latin)) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// severityRank orders the severities, see severities
+func severityRank(s string) int {
+	for i, v := range severities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func selectInteractions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ids []string
+	for _, v := range strings.FieldsFunc(r.URL.Query().Get("ids"), isListSep) {
+		if !contains(ids, v) {
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) < 2 || len(ids) > maxInteractionIDs {
+		internalServerError(w, fmt.Errorf("invalid ids: want 2..%d ids", maxInteractionIDs), http.StatusBadRequest)
+		return
+	}
+
+	db := dataIndex(r)
+	ua := langUA(r.Header)
+	innVlt, err := db.getVault(kindKey("inn", ua))
+	if err != nil {
+		searchFailed(w, err)
+		return
+	}
+	infVlt, err := db.getVault(kindKey("inf", ua))
+	if err != nil {
+		searchFailed(w, err)
+		return
+	}
+
+	var inns []innWords
+	innVlt.Range(func(k, v interface{}) bool {
+		d := v.(*baseDoc)
+		inns = append(inns, innWords{
+			id:    k.(string),
+			name:  d.Name,
+			words: strings.Fields(strings.ToLower(normName(d.Name))),
+			latin: strings.Fields(strings.ToLower(normName(d.Latin))),
+		})
+		return true
+	})
+	sort.Slice(inns, func(i, j int) bool { return keyLess(inns[i].id, inns[j].id) })
+
+	res := struct {
+		Interactions []interactionHit `json:"interactions"`
+		Unknown      []string         `json:"unknown,omitempty"` // ids not in the data
+	}{Interactions: []interactionHit{}}
+
+	of := make([][]innWords, len(ids))
+	for i, id := range ids {
+		v, ok := innVlt.Load(id)
+		if !ok {
+			v, ok = infVlt.Load(id)
+		}
+		if !ok {
+			res.Unknown = append(res.Unknown, id)
+			continue
+		}
+		of[i] = innsOf(v.(*baseDoc), id, inns)
+	}
+
+	var keys []string
+	var hits []interactionHit
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			seen := make(map[string]bool)
+			for _, a := range of[i] {
+				for _, b := range of[j] {
+					k := pairKey(a.id, b.id)
+					if a.id == b.id || seen[k] {
+						continue
+					}
+					seen[k] = true
+					keys = append(keys, k)
+					hits = append(hits, interactionHit{IDs: []string{ids[i], ids[j]}, INNs: []string{a.id, b.id}, Names: []string{a.name, b.name}})
+				}
+			}
+		}
+	}
+
+	if len(keys) > 0 {
+		vals, err := dataStore.Get(interactionsBucket, keys)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		for i, b := range vals {
+			if b == nil || json.Unmarshal(b, &hits[i].interaction) != nil {
+				continue
+			}
+			res.Interactions = append(res.Interactions, hits[i])
+		}
+	}
+	sort.SliceStable(res.Interactions, func(i, j int) bool {
+		return severityRank(res.Interactions[i].Severity) > severityRank(res.Interactions[j].Severity)
+	})
+
+	b, err := marshalJSON(r, res)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	validateResponse(w, r, "interactions", b)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
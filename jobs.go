@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An upload with ?async=1 is answered (202) once its csv is read and
+// checked, the ingest runs in the background as a job, after the running
+// one like with ?queue=1. The job reports the rows read and not ingested
+// (with the first errors) and its status until the last maxJobs jobs
+// have finished after it.
+//
+// $ curl -i -X POST -T sugg.csv 'http://localhost:8080/test/upload-sugg?async=1'
+// $ curl -i http://localhost:8080/test/jobs/{id}
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+const (
+	maxJobs      = 100
+	maxJobErrors = 10
+)
+
+var jobs = struct {
+	m     map[string]*ingestJob // guarded by ingests
+	order []string              // of the ids, the oldest first
+}{m: make(map[string]*ingestJob)}
+
+func newIngestJob(target string, rows int) *ingestJob {
+	return &ingestJob{Target: target, Status: jobQueued, Rows: rows, Queued: time.Now()}
+}
+
+// snapshot returns a copy of the job, ingests must be locked
+func (j *ingestJob) snapshot() *ingestJob {
+	c := *j
+	c.Errors = append([]string(nil), j.Errors...)
+	return &c
+}
+
+// startIngest runs fn as a job in the background, after the running and
+// waiting ingests if there are any
+func startIngest(target string, rows int, fn func() error) (*ingestJob, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	job := newIngestJob(target, rows)
+	job.ID = hex.EncodeToString(id)
+
+	run, ok := submitIngest(&pendingIngest{job, fn, "async"}, true)
+	if !ok {
+		return nil, errIngestBusy
+	}
+	addJob(job)
+	if run {
+		go func() {
+			err := doIngest(job, fn)
+			if err != nil {
+				log.Printf("err: async %s ingest: %s", target, err.Error())
+			}
+		}()
+	}
+	return job, nil
+}
+
+// addJob keeps the job, dropping the oldest finished ones over maxJobs
+func addJob(job *ingestJob) {
+	ingests.Lock()
+	defer ingests.Unlock()
+
+	jobs.m[job.ID] = job
+	jobs.order = append(jobs.order, job.ID)
+	for i := 0; len(jobs.m) > maxJobs && i < len(jobs.order); {
+		if old := jobs.m[jobs.order[i]]; old.Finished == nil {
+			i++
+			continue
+		}
+		delete(jobs.m, jobs.order[i])
+		jobs.order = append(jobs.order[:i], jobs.order[i+1:]...)
+	}
+}
+
+func wantAsync(r *http.Request) bool {
+	q := r.URL.Query().Get("async")
+	return q != "" && q != "0" && q != "false"
+}
+
+// ingestAsync starts fn as a job for an upload with ?async=1 and answers
+// with it, it tells if it did
+func ingestAsync(w http.ResponseWriter, r *http.Request, target string, rows int, fn func() error) bool {
+	if !wantAsync(r) {
+		return false
+	}
+
+	job, err := startIngest(target, rows, fn)
+	if ingestFailed(w, false, err) {
+		return true
+	}
+
+	ingests.Lock()
+	b, err := json.MarshalIndent(job.snapshot(), "", "\t")
+	ingests.Unlock()
+	if err != nil {
+		internalServerError(w, err)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", followURL(r, "/test/jobs/"+job.ID, false))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, string(b))
+	return true
+}
+
+func selectJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/test/jobs"), "/")
+	ingests.Lock()
+	job, ok := jobs.m[id]
+	if ok {
+		job = job.snapshot()
+	}
+	ingests.Unlock()
+	if !ok {
+		internalServerError(w, fmt.Errorf("job not found (%s)", id), http.StatusNotFound)
+		return
+	}
+
+	b, err := json.MarshalIndent(job, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
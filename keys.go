@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A key in a response is the id of its source row, the kind comes from the
+// section and the language from Accept-Language, so keys kept apart from
+// the response lose them. Asked for with "key_format": "canonical" (or
+// ?key_format=canonical) the keys are "kind:lang:id", as "inf:ru:12345",
+// and the docs of the lookups get theirs in "key". /admin/doc/ and
+// /test/click take the canonical keys as well.
+//
+// $ curl -i -d '{"name":"кислота","key_format":"canonical"}' http://localhost:8080/test/select-suggestion
+// $ curl -i 'http://localhost:8080/test/barcode/4820000000000?key_format=canonical'
+
+const (
+	keyFormatID        = "id"
+	keyFormatCanonical = "canonical"
+)
+
+// canonicalKey returns the key of the row id in the index of the key
+func canonicalKey(key, id string) string {
+	return strings.Split(key, "-")[0] + ":" + keyLang(key) + ":" + id
+}
+
+// parseKey splits a canonical key into the index key and the row id
+func parseKey(s string) (string, string, error) {
+	p := strings.SplitN(s, ":", 3)
+	if len(p) != 3 || !contains(kindOrder, p[0]) || !contains(langOrder, p[1]) || p[2] == "" {
+		return "", "", fmt.Errorf("invalid key: %q (kind:lang:id)", s)
+	}
+	return p[0] + "-" + p[1], p[2], nil
+}
+
+// requestKeyFormat tells if the request asks for canonical keys, in the
+// body (req) or the URL
+func requestKeyFormat(r *http.Request, req string) (bool, error) {
+	if req == "" {
+		req = r.URL.Query().Get("key_format")
+	}
+	switch req {
+	case "", keyFormatID:
+		return false, nil
+	case keyFormatCanonical:
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid key_format: %q (%s, %s)", req, keyFormatID, keyFormatCanonical)
+}
+
+// canonicalKeys rewrites the keys of the sections and tokens
+func (r *result) canonicalKeys(ua bool) {
+	for kind, s := range r.sections() {
+		for i := range *s {
+			keys := make([]string, len((*s)[i].Keys))
+			for j, k := range (*s)[i].Keys {
+				keys[j] = canonicalKey(kindKey(kind, ua), k)
+			}
+			(*s)[i].Keys = keys
+		}
+	}
+	for i := range r.Tokens {
+		for j, k := range r.Tokens[i].Keys {
+			r.Tokens[i].Keys[j] = canonicalKey(kindKey("inf", ua), k) // see explainTokens
+		}
+	}
+}
+
+// keyedDocs returns copies of the docs of the index key with their
+// canonical keys
+func keyedDocs(key string, docs []*baseDoc) []*baseDoc {
+	out := make([]*baseDoc, len(docs))
+	for i, d := range docs {
+		c := *d
+		c.Key = canonicalKey(key, strconv.Itoa(d.ID))
+		out[i] = &c
+	}
+	return out
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Language packs add keyboard layouts and transliteration to the built-in
+// ones (mapKB, latinCyr) for other markets, without a code change. They
+// are the JSON files of cfg.LangPacks (globs), loaded at startup and again
+// on POST /admin/lang-packs, the later ones over the earlier:
+//
+//	{
+//		"name": "kk",
+//		"keyboards": {"kk": "йцукенгшщзхъ\\фывапролджэячсмитьбю.ё..."},
+//		"layouts": {"ru": {"from": "en", "to": "kk"}},
+//		"translit": {"ru": [["sz", "ш"], ["ł", "л"]]}
+//	}
+//
+// A keyboard has the keys of the "en" one in its order, each once (see
+// convString, checkKeyboard).
+// A layout is the conversion of the layout stage for the search language
+// (ru or ua), en to ru and en to uk without one. The translit pairs of a
+// language go before the built-in ones, their Latin may be beyond ASCII.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/lang-packs
+// $ curl -i -H 'X-Api-Key: secret' -X POST http://localhost:8080/admin/lang-packs
+
+type kbLayout struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type langPack struct {
+	Name      string                 `json:"name"`
+	File      string                 `json:"file"`
+	Keyboards map[string]string      `json:"keyboards,omitempty"`
+	Layouts   map[string]kbLayout    `json:"layouts,omitempty"`
+	Translit  map[string][][2]string `json:"translit,omitempty"`
+}
+
+var langPacks = struct {
+	sync.RWMutex
+	list      []*langPack
+	keyboards map[string][]rune
+	layouts   map[string]kbLayout    // by search language
+	translit  map[string][][2]string // by search language, longest first
+}{}
+
+var defaultLayouts = map[string]kbLayout{
+	"ru": {From: "en", To: "ru"},
+	"ua": {From: "en", To: "uk"},
+}
+
+// keyboard returns the keys of the layout, nil for an unknown one
+func keyboard(name string) []rune {
+	langPacks.RLock()
+	defer langPacks.RUnlock()
+
+	if kb, ok := langPacks.keyboards[name]; ok {
+		return kb
+	}
+	return mapKB[name]
+}
+
+// layoutOf returns the conversion of the layout stage for the language
+func layoutOf(ua bool) kbLayout {
+	lang := "ru"
+	if ua {
+		lang = "ua"
+	}
+
+	langPacks.RLock()
+	defer langPacks.RUnlock()
+
+	if l, ok := langPacks.layouts[lang]; ok {
+		return l
+	}
+	return defaultLayouts[lang]
+}
+
+// layoutConv converts the query of the language typed in the wrong layout
+func layoutConv(s string, ua bool) string {
+	l := layoutOf(ua)
+	return convString(s, l.From, l.To)
+}
+
+// packTranslit returns the translit pairs of the packs for the language
+func packTranslit(ua bool) [][2]string {
+	lang := "ru"
+	if ua {
+		lang = "ua"
+	}
+
+	langPacks.RLock()
+	defer langPacks.RUnlock()
+	return langPacks.translit[lang]
+}
+
+// loadLangPacks reads and checks the packs of the globs and puts them in
+// place of the loaded ones
+func loadLangPacks(globs []string) ([]*langPack, error) {
+	var list []*langPack
+	for _, g := range globs {
+		files, err := filepath.Glob(g)
+		if err != nil {
+			return nil, fmt.Errorf("lang_packs: %v", err)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			p := &langPack{}
+			err = json.Unmarshal(b, p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f, err)
+			}
+			p.File = f
+			list = append(list, p)
+		}
+	}
+
+	keyboards := make(map[string][]rune)
+	layouts := make(map[string]kbLayout)
+	translit := make(map[string][][2]string)
+	for _, p := range list {
+		for name, keys := range p.Keyboards {
+			if name == "en" {
+				return nil, fmt.Errorf("%s: the en keyboard is the reference one", p.File)
+			}
+			if err := checkKeyboard(name, []rune(keys)); err != nil {
+				return nil, fmt.Errorf("%s: %v", p.File, err)
+			}
+			keyboards[name] = []rune(keys)
+		}
+	}
+	for _, p := range list {
+		for lang, l := range p.Layouts {
+			if _, ok := defaultLayouts[lang]; !ok {
+				return nil, fmt.Errorf("%s: unknown language %q (ru, ua)", p.File, lang)
+			}
+			for _, kb := range []string{l.From, l.To} {
+				if keyboards[kb] == nil && mapKB[kb] == nil {
+					return nil, fmt.Errorf("%s: unknown keyboard %q", p.File, kb)
+				}
+			}
+			layouts[lang] = l
+		}
+		for lang, pairs := range p.Translit {
+			if _, ok := defaultLayouts[lang]; !ok {
+				return nil, fmt.Errorf("%s: unknown language %q (ru, ua)", p.File, lang)
+			}
+			for _, v := range pairs {
+				if v[0] == "" || strings.ToLower(v[0]) != v[0] {
+					return nil, fmt.Errorf("%s: translit %q: want lowercase Latin", p.File, v[0])
+				}
+			}
+			translit[lang] = append(append([][2]string(nil), pairs...), translit[lang]...)
+		}
+	}
+	for lang := range translit {
+		sort.SliceStable(translit[lang], func(i, j int) bool {
+			return len(translit[lang][i][0]) > len(translit[lang][j][0])
+		})
+	}
+
+	langPacks.Lock()
+	langPacks.list, langPacks.keyboards, langPacks.layouts, langPacks.translit = list, keyboards, layouts, translit
+	langPacks.Unlock()
+	resCache.purge()
+	staleETags()
+	return list, nil
+}
+
+// packLatin tells if the letter is in the Latin of the translit pairs
+func packLatin(pairs [][2]string, c rune) bool {
+	for _, p := range pairs {
+		if strings.ContainsRune(p[0], c) {
+			return true
+		}
+	}
+	return false
+}
+
+func adminLangPacks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		_, err := loadLangPacks(cfg.LangPacks)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		_ = validateTables() // for /admin/status
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := struct {
+		Packs     []*langPack         `json:"packs"`
+		Keyboards []string            `json:"keyboards"`
+		Layouts   map[string]kbLayout `json:"layouts"`
+	}{Packs: []*langPack{}, Layouts: make(map[string]kbLayout, len(defaultLayouts))}
+	langPacks.RLock()
+	res.Packs = append(res.Packs, langPacks.list...)
+	for k := range langPacks.keyboards {
+		res.Keyboards = append(res.Keyboards, k)
+	}
+	langPacks.RUnlock()
+	for k := range mapKB {
+		if !contains(res.Keyboards, k) {
+			res.Keyboards = append(res.Keyboards, k)
+		}
+	}
+	sort.Strings(res.Keyboards)
+	res.Layouts["ru"], res.Layouts["ua"] = layoutOf(false), layoutOf(true)
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
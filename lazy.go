@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	bindex "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/index/store"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// The cold indexes of a restored generation are opened on first use, not
+// at startup, and every cold index (of the kept generations too) may be
+// closed again when more than cfg.MaxOpenIndexes are open: the least
+// recently used one that no call is using. lazyIndex stands in for the
+// index in the generation, so the searches do not know.
+
+var lazyIndexes = struct {
+	sync.Mutex
+	open []*lazyIndex
+	tick uint64
+}{}
+
+// lazyIndex is a bleve index on disk opened when needed
+type lazyIndex struct {
+	path   string
+	idx    bleve.Index // nil while not open
+	refs   int         // calls in progress
+	used   uint64      // lazyIndexes.tick of the last call
+	closed bool        // for good, see Close
+}
+
+// newLazyIndex returns the index at path, idx if it is open already
+func newLazyIndex(path string, idx bleve.Index) *lazyIndex {
+	l := &lazyIndex{path: path}
+	if idx != nil {
+		lazyIndexes.Lock()
+		l.idx = idx
+		lazyIndexes.open = append(lazyIndexes.open, l)
+		evictIndexes()
+		lazyIndexes.Unlock()
+	}
+	return l
+}
+
+// acquire opens the index if needed and holds it open until release
+func (l *lazyIndex) acquire() (bleve.Index, error) {
+	lazyIndexes.Lock()
+	defer lazyIndexes.Unlock()
+
+	if l.closed {
+		return nil, fmt.Errorf("index closed (%s)", l.path)
+	}
+	if l.idx == nil {
+		idx, err := bleve.Open(l.path)
+		if err != nil {
+			return nil, err
+		}
+		l.idx = idx
+		lazyIndexes.open = append(lazyIndexes.open, l)
+	}
+	l.refs++
+	lazyIndexes.tick++
+	l.used = lazyIndexes.tick
+	evictIndexes()
+	return l.idx, nil
+}
+
+func (l *lazyIndex) release() {
+	lazyIndexes.Lock()
+	defer lazyIndexes.Unlock()
+
+	l.refs--
+	evictIndexes()
+}
+
+// isOpen tells if the index is open now
+func (l *lazyIndex) isOpen() bool {
+	lazyIndexes.Lock()
+	defer lazyIndexes.Unlock()
+	return l.idx != nil
+}
+
+// evictIndexes closes the least recently used indexes not in use over
+// cfg.MaxOpenIndexes, lazyIndexes is locked
+func evictIndexes() {
+	for cfg.MaxOpenIndexes > 0 && len(lazyIndexes.open) > cfg.MaxOpenIndexes {
+		lru := -1
+		for i, l := range lazyIndexes.open {
+			if l.refs == 0 && (lru < 0 || l.used < lazyIndexes.open[lru].used) {
+				lru = i
+			}
+		}
+		if lru < 0 {
+			return // all in use, closed when released
+		}
+		l := lazyIndexes.open[lru]
+		lazyIndexes.open = append(lazyIndexes.open[:lru], lazyIndexes.open[lru+1:]...)
+		err := l.idx.Close()
+		if err != nil {
+			log.Printf("err: %s: %s", l.path, err.Error())
+		}
+		l.idx = nil
+	}
+}
+
+// Close closes the index for good
+func (l *lazyIndex) Close() error {
+	lazyIndexes.Lock()
+	defer lazyIndexes.Unlock()
+
+	l.closed = true
+	if l.idx == nil {
+		return nil
+	}
+	for i, o := range lazyIndexes.open {
+		if o == l {
+			lazyIndexes.open = append(lazyIndexes.open[:i], lazyIndexes.open[i+1:]...)
+			break
+		}
+	}
+	idx := l.idx
+	l.idx = nil
+	return idx.Close()
+}
+
+// do runs f on the open index
+func (l *lazyIndex) do(f func(bleve.Index) error) error {
+	idx, err := l.acquire()
+	if err != nil {
+		return err
+	}
+	defer l.release()
+	return f(idx)
+}
+
+func (l *lazyIndex) Index(id string, data interface{}) error {
+	return l.do(func(idx bleve.Index) error { return idx.Index(id, data) })
+}
+
+func (l *lazyIndex) Delete(id string) error {
+	return l.do(func(idx bleve.Index) error { return idx.Delete(id) })
+}
+
+func (l *lazyIndex) NewBatch() *bleve.Batch {
+	var b *bleve.Batch
+	_ = l.do(func(idx bleve.Index) error { b = idx.NewBatch(); return nil })
+	return b
+}
+
+func (l *lazyIndex) Batch(b *bleve.Batch) error {
+	return l.do(func(idx bleve.Index) error { return idx.Batch(b) })
+}
+
+func (l *lazyIndex) Document(id string) (doc *document.Document, err error) {
+	err = l.do(func(idx bleve.Index) error { doc, err = idx.Document(id); return err })
+	return doc, err
+}
+
+func (l *lazyIndex) DocCount() (n uint64, err error) {
+	err = l.do(func(idx bleve.Index) error { n, err = idx.DocCount(); return err })
+	return n, err
+}
+
+func (l *lazyIndex) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	return l.SearchInContext(context.Background(), req)
+}
+
+func (l *lazyIndex) SearchInContext(ctx context.Context, req *bleve.SearchRequest) (res *bleve.SearchResult, err error) {
+	err = l.do(func(idx bleve.Index) error { res, err = idx.SearchInContext(ctx, req); return err })
+	return res, err
+}
+
+func (l *lazyIndex) Fields() (f []string, err error) {
+	err = l.do(func(idx bleve.Index) error { f, err = idx.Fields(); return err })
+	return f, err
+}
+
+// lazyDict holds the index open until the dictionary is closed
+type lazyDict struct {
+	bindex.FieldDict
+	release func()
+}
+
+func (d *lazyDict) Close() error {
+	defer d.release()
+	return d.FieldDict.Close()
+}
+
+func (l *lazyIndex) dict(f func(bleve.Index) (bindex.FieldDict, error)) (bindex.FieldDict, error) {
+	idx, err := l.acquire()
+	if err != nil {
+		return nil, err
+	}
+	d, err := f(idx)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &lazyDict{d, l.release}, nil
+}
+
+func (l *lazyIndex) FieldDict(field string) (bindex.FieldDict, error) {
+	return l.dict(func(idx bleve.Index) (bindex.FieldDict, error) { return idx.FieldDict(field) })
+}
+
+func (l *lazyIndex) FieldDictRange(field string, startTerm []byte, endTerm []byte) (bindex.FieldDict, error) {
+	return l.dict(func(idx bleve.Index) (bindex.FieldDict, error) { return idx.FieldDictRange(field, startTerm, endTerm) })
+}
+
+func (l *lazyIndex) FieldDictPrefix(field string, termPrefix []byte) (bindex.FieldDict, error) {
+	return l.dict(func(idx bleve.Index) (bindex.FieldDict, error) { return idx.FieldDictPrefix(field, termPrefix) })
+}
+
+func (l *lazyIndex) Mapping() (m mapping.IndexMapping) {
+	_ = l.do(func(idx bleve.Index) error { m = idx.Mapping(); return nil })
+	return m
+}
+
+func (l *lazyIndex) Stats() (s *bleve.IndexStat) {
+	_ = l.do(func(idx bleve.Index) error { s = idx.Stats(); return nil })
+	return s
+}
+
+func (l *lazyIndex) StatsMap() (m map[string]interface{}) {
+	_ = l.do(func(idx bleve.Index) error { m = idx.StatsMap(); return nil })
+	return m
+}
+
+func (l *lazyIndex) GetInternal(key []byte) (val []byte, err error) {
+	err = l.do(func(idx bleve.Index) error { val, err = idx.GetInternal(key); return err })
+	return val, err
+}
+
+func (l *lazyIndex) SetInternal(key, val []byte) error {
+	return l.do(func(idx bleve.Index) error { return idx.SetInternal(key, val) })
+}
+
+func (l *lazyIndex) DeleteInternal(key []byte) error {
+	return l.do(func(idx bleve.Index) error { return idx.DeleteInternal(key) })
+}
+
+func (l *lazyIndex) Name() string { return l.path }
+
+func (l *lazyIndex) SetName(string) {}
+
+// Advanced is not held open, the index may be closed under the caller
+func (l *lazyIndex) Advanced() (i bindex.Index, s store.KVStore, err error) {
+	err = l.do(func(idx bleve.Index) error { i, s, err = idx.Advanced(); return err })
+	return i, s, err
+}
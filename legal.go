@@ -0,0 +1,34 @@
+package main
+
+import "strings"
+
+// legalForms are dropped from names at index time by the legal_forms
+// filter (org by default, see kindMapping) and from the queries of those
+// kinds, so "ТОВ «Дарниця»" is found by "дарниця".
+var legalForms = []string{
+	"ооо", "оао", "зао", "пао", "ао", "тов", "пат", "прат", "ват", "зат", "фоп", "чп", "тоо",
+	"llc", "ltd", "inc", "gmbh", "ag", "plc", "corp",
+}
+
+// stripLegalForms removes legal form words from a normalized query, unless
+// nothing else is left
+func stripLegalForms(name string) string {
+	var out []string
+	for _, v := range strings.Fields(name) {
+		if !contains(legalForms, strings.ToLower(v)) {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return name
+	}
+	return strings.Join(out, " ")
+}
+
+func legalFormTokens() []interface{} {
+	res := make([]interface{}, len(legalForms))
+	for i := range legalForms {
+		res[i] = legalForms[i]
+	}
+	return res
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kindOrder is the order of the kinds (cfg.Kinds), the remaining quota is
+// handed out in it unless the kinds are boosted, see boostedKinds. The
+// indexes, the upload rows and the search fan-out are the ones of its
+// kinds in the languages of langOrder (cfg.Languages).
+var kindOrder, langOrder = cfg.Kinds, cfg.Languages
+
+// extraKinds are the kinds of kindOrder without a field of their own in
+// result (see sectionKinds), their sections are in sugg_kinds
+func extraKinds() []string {
+	var res []string
+	for _, k := range kindOrder {
+		if !contains(sectionKinds, k) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// checkKinds checks the kinds and languages of the config: a kind is
+// lowercase letters and digits, "info" is inf in the upload rows
+func checkKinds(kinds, langs []string) error {
+	if len(kinds) == 0 || len(langs) == 0 {
+		return fmt.Errorf("kinds, languages: want at least one")
+	}
+	for i, k := range kinds {
+		if k == "" || k == "info" || strings.TrimFunc(k, func(r rune) bool {
+			return 'a' <= r && r <= 'z' || '0' <= r && r <= '9'
+		}) != "" {
+			return fmt.Errorf("kinds: invalid kind %q", k)
+		}
+		if contains(kinds[:i], k) {
+			return fmt.Errorf("kinds: %q twice", k)
+		}
+	}
+	for i, l := range langs {
+		if l != "ru" && l != "ua" {
+			return fmt.Errorf("languages: unknown language %q (ru, ua)", l)
+		}
+		if contains(langs[:i], l) {
+			return fmt.Errorf("languages: %q twice", l)
+		}
+	}
+	return nil
+}
+
+// fields returns pointers to the lists of the kinds with a field of their
+// own in result, see sectionKinds
+func (r *result) fields() map[string]*[]sugg {
+	return map[string]*[]sugg{
+		"atc": &r.SuggATC,
+		"inf": &r.SuggINF,
+		"inn": &r.SuggINN,
+		"act": &r.SuggACT,
+		"org": &r.SuggORG,
+	}
+}
+
+// sections returns pointers to the per-kind lists of a result. Every entry
+// counts as one result, except inf where the keys are the entries.
+func (r *result) sections() map[string]*[]sugg {
+	m := r.fields()
+	for _, k := range extraKinds() {
+		if s, ok := r.SuggKinds[k]; ok {
+			m[k] = s
+		} else {
+			m[k] = new([]sugg)
+		}
+	}
+	return m
+}
+
+// section returns the list of the kind to append to
+func (r *result) section(kind string) *[]sugg {
+	if s, ok := r.fields()[kind]; ok {
+		return s
+	}
+	if r.SuggKinds == nil {
+		r.SuggKinds = make(map[string]*[]sugg, 1)
+	}
+	if _, ok := r.SuggKinds[kind]; !ok {
+		r.SuggKinds[kind] = new([]sugg)
+	}
+	return r.SuggKinds[kind]
+}
+
+// empty tells if the result has no entry at all
+func (r *result) empty() bool {
+	if len(r.Sugg) > 0 {
+		return false
+	}
+	for k, s := range r.sections() {
+		if sectionLen(k, *s) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sectionLen(kind string, s []sugg) int {
+	if kind != "inf" {
+		return len(s)
+	}
+	n := 0
+	for i := range s {
+		n += len(s[i].Keys)
+	}
+	return n
+}
+
+func cutSection(kind string, s []sugg, n int) []sugg {
+	if n < 0 {
+		n = 0
+	}
+	if kind != "inf" {
+		if n < len(s) {
+			return s[:n]
+		}
+		return s
+	}
+	for i := range s {
+		if n < len(s[i].Keys) {
+			s[i].Keys = s[i].Keys[:n]
+		}
+		n -= len(s[i].Keys)
+	}
+	return s
+}
+
+// checkLimits rejects a negative limit or minimum of a request
+func checkLimits(limit int, reqAll *int, reqKind map[string]int) error {
+	if limit < 0 {
+		return fmt.Errorf("invalid limit: %d, want 0 or more", limit)
+	}
+	if reqAll != nil && *reqAll < 0 {
+		return fmt.Errorf("invalid min: %d, want 0 or more", *reqAll)
+	}
+	for k, n := range reqKind {
+		if n < 0 {
+			return fmt.Errorf("invalid min_kind %s: %d, want 0 or more", k, n)
+		}
+	}
+	return nil
+}
+
+// minPerKind resolves the guaranteed minimum for a kind: the request value
+// for the kind, the request value for all kinds, then the config ones. A
+// request value of 0 is a value, not a missing one.
+func minPerKind(kind string, reqAll *int, reqKind map[string]int) int {
+	if n, ok := reqKind[kind]; ok {
+		return n
+	}
+	if reqAll != nil {
+		return *reqAll
+	}
+	if n, ok := cfg.MinPerKind[kind]; ok {
+		return n
+	}
+	return cfg.MinPerKindDefault
+}
+
+// capResult cuts the result down to limit entries in total. Each kind keeps
+// at least its minimum (if it has that many), the rest of the limit is
+// handed out one entry per kind in turn, so no kind crowds out the others.
+// Minimums adding up to more than the limit are handed out the same way,
+// the total never exceeds the limit. A focused query (see detectIntent)
+// first gets cfg.IntentShare percent of the rest for its kinds.
+func capResult(res *result, limit int, reqAll *int, reqKind map[string]int) {
+	if limit <= 0 {
+		return
+	}
+
+	sec := res.sections()
+	have := make(map[string]int, len(sec))
+	mins := make(map[string]int, len(sec))
+	keep := make(map[string]int, len(sec))
+	for _, k := range kindOrder {
+		have[k] = sectionLen(k, *sec[k])
+		mins[k] = minPerKind(k, reqAll, reqKind)
+		if mins[k] > have[k] {
+			mins[k] = have[k]
+		}
+	}
+	left := handOut(boostedKinds(), keep, mins, limit)
+
+	if kinds := res.intentKinds(); len(kinds) > 0 && left > 0 {
+		share := left * cfg.IntentShare / 100
+		left -= share
+		share = handOut(kinds, keep, have, share)
+		left += share
+	}
+	handOut(boostedKinds(), keep, have, left)
+
+	for _, k := range kindOrder {
+		*sec[k] = cutSection(k, *sec[k], keep[k])
+	}
+}
+
+// handOut gives n more entries to the kinds one per kind in turn while
+// they have them and returns what is left
+func handOut(kinds []string, keep, have map[string]int, n int) int {
+	for more := true; n > 0 && more; {
+		more = false
+		for _, k := range kinds {
+			if n > 0 && keep[k] < have[k] {
+				keep[k]++
+				n--
+				more = true
+			}
+		}
+	}
+	return n
+}
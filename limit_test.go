@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// testResult has n entries in each of the built-in kinds
+func testResult(n int) *result {
+	res := &result{}
+	for k, s := range res.sections() {
+		if k == "inf" {
+			*s = []sugg{{}}
+			for i := 0; i < n; i++ {
+				(*s)[0].Keys = append((*s)[0].Keys, "1")
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			*s = append(*s, sugg{Name: k})
+		}
+	}
+	return res
+}
+
+func resultLen(res *result) int {
+	n := 0
+	for k, s := range res.sections() {
+		n += sectionLen(k, *s)
+	}
+	return n
+}
+
+func TestCapResult(t *testing.T) {
+	zero, two, three := 0, 2, 3
+	for _, v := range []struct {
+		name    string
+		limit   int
+		reqAll  *int
+		reqKind map[string]int
+		want    map[string]int
+	}{
+		{"config min", 5, nil, nil, map[string]int{"atc": 1, "inf": 1, "inn": 1, "act": 1, "org": 1}},
+		{"min over limit", 4, &three, nil, map[string]int{"atc": 1, "inf": 1, "inn": 1, "act": 1, "org": 0}},
+		{"min_kind over limit", 3, nil, map[string]int{"inf": 5}, map[string]int{"atc": 1, "inf": 1, "inn": 1, "act": 0, "org": 0}},
+		{"min 0", 2, &zero, map[string]int{"org": 2}, map[string]int{"org": 2}},
+		{"min 0 rest", 4, &zero, map[string]int{"org": 2}, map[string]int{"atc": 1, "inf": 1, "org": 2}},
+		{"min 2", 10, &two, nil, map[string]int{"atc": 2, "inf": 2, "inn": 2, "act": 2, "org": 2}},
+	} {
+		res := testResult(3)
+		capResult(res, v.limit, v.reqAll, v.reqKind)
+		if n := resultLen(res); n > v.limit {
+			t.Errorf("%s: %d entries over limit %d", v.name, n, v.limit)
+		}
+		for k, s := range res.sections() {
+			if n := sectionLen(k, *s); n != v.want[k] {
+				t.Errorf("%s: %s: got %d, want %d", v.name, k, n, v.want[k])
+			}
+		}
+	}
+}
+
+// TestExtraKind configures a kind without a field of its own, ingests it
+// and finds it in sugg_kinds of /test/suggest and in select-sugg
+func TestExtraKind(t *testing.T) {
+	defer func(c *config, kinds, langs []string) {
+		cfg, kindOrder, langOrder = c, kinds, langs
+		if err := loadFixtures(); err != nil {
+			t.Fatal(err)
+		}
+	}(cfg, kindOrder, langOrder)
+
+	c := *cfg
+	c.Mappings = make(map[string]kindMapping, len(cfg.Mappings)+1)
+	for k, m := range cfg.Mappings {
+		c.Mappings[k] = m
+	}
+	_, err := parseConfig([]byte(`{"kinds":["atc","inf","inn","act","org","brd"]}`), &c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CacheTTL = 0
+	cfg, kindOrder, langOrder = &c, c.Kinds, c.Languages
+	if _, ok := cfg.Mappings["brd"]; !ok {
+		t.Errorf("brd: no mapping")
+	}
+
+	rec, err := readCSV(fixtureSugg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lang := range []string{"RU", "UA"} {
+		rec = append(rec, []string{"brd", "1", "Кислотинол", "Кислотинол", "0", lang, "", "", ""})
+	}
+	err = ingestSugg(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve("GET", "/test/suggest?q="+url.QueryEscape("кислотинол"), "", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("suggest: %d %s", w.Code, w.Body)
+	}
+	var res struct {
+		SuggKinds map[string][]sugg `json:"sugg_kinds"`
+		Meta      struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"meta"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.SuggKinds["brd"]; len(s) != 1 || s[0].Name != "Кислотинол" || len(s[0].Keys) != 1 {
+		t.Errorf("suggest: sugg_kinds.brd %+v, want Кислотинол with one key", s)
+	}
+	if l := res.Meta.Labels["brd"]; l != "brd" {
+		t.Errorf("suggest: label %q, want brd", l)
+	}
+
+	w = serve("POST", "/test/select-sugg", `{"name":"кислотинол"}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("select-sugg: %d %s", w.Code, w.Body)
+	}
+	var sel struct {
+		Sugg []string `json:"sugg"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(sel.Sugg, "КИСЛОТИНОЛ") {
+		t.Errorf("select-sugg: %v, want КИСЛОТИНОЛ", sel.Sugg)
+	}
+}
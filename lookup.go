@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// $ curl -i http://localhost:8080/test/barcode/4820000000000
+// $ curl -i 'http://localhost:8080/test/regnum?q=UA/1234'
+// $ curl -i 'http://localhost:8080/test/regnum?q=UA/1234&key_format=canonical'
+//
+// EANs come in the 8th csv column, several codes separated by ";" or space,
+// the registration number (license) in the 9th one.
+
+func isListSep(r rune) bool {
+	return r == ';' || r == ',' || r == ' ' || r == '|'
+}
+
+func selectBarcode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ean := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/test/barcode/"))
+	if ean == "" || strings.IndexFunc(ean, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		internalServerError(w, fmt.Errorf("invalid barcode: %q", ean), http.StatusBadRequest)
+		return
+	}
+
+	canonical, err := requestKeyFormat(r, "")
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key := "inf-ru"
+	if langUA(r.Header) {
+		key = "inf-ua"
+	}
+
+	docs, err := searcher.Barcode(r.Context(), pinnedIndex(r), key, ean)
+	if err != nil {
+		searchFailed(w, err)
+		return
+	}
+	if len(docs) == 0 {
+		noteEmpty(r)
+		internalServerError(w, fmt.Errorf("barcode not found: %s", ean), http.StatusNotFound)
+		return
+	}
+	if canonical {
+		docs = keyedDocs(key, docs)
+	}
+
+	b, err := marshalJSON(r, docs)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	validateResponse(w, r, "docs", b)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// selectRegNum looks products up by registration number: the exact one
+// first, then the ones the query is a prefix of.
+func selectRegNum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	reg := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if n := len([]rune(reg)); n < 2 || n > 64 {
+		internalServerError(w, fmt.Errorf("invalid registration number: %q", reg), http.StatusBadRequest)
+		return
+	}
+	canonical, err := requestKeyFormat(r, "")
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key := "inf-ru"
+	if langUA(r.Header) {
+		key = "inf-ua"
+	}
+
+	exact := bleve.NewTermQuery(reg)
+	exact.SetField(field(key, "reg"))
+	exact.SetBoost(10)
+	prefix := bleve.NewPrefixQuery(reg)
+	prefix.SetField(field(key, "reg"))
+
+	docs, err := findDocs(r.Context(), dataIndex(r), key, bleve.NewDisjunctionQuery(exact, prefix))
+	if err != nil {
+		searchFailed(w, err)
+		return
+	}
+	if len(docs) == 0 {
+		noteEmpty(r)
+	}
+	if canonical {
+		docs = keyedDocs(key, docs)
+	}
+
+	b, err := marshalJSON(r, docs)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	validateResponse(w, r, "docs", b)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// findDocs returns the vault documents of the hits in score order
+func findDocs(ctx context.Context, db *index, key string, q query.Query) ([]*baseDoc, error) {
+	idx, err := db.getIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	vlt, err := db.getVault(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req := bleve.NewSearchRequest(q)
+	req.Size = cfg.MaxHits
+	res, err := searchIndex(ctx, key, idx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*baseDoc{}
+	for _, k := range liveKeys(strings.Split(key, "-")[0], remDupl(db.hitKeys(key, res))) {
+		if v, ok := vlt.Load(k); ok {
+			out = append(out, v.(*baseDoc))
+		}
+	}
+	return out, nil
+}
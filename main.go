@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/csv"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -19,9 +21,11 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unicode"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
 	"github.com/blevesearch/bleve/search/query"
 	"golang.org/x/text/collate"
 	"golang.org/x/text/language"
@@ -29,19 +33,91 @@ import (
 
 // $ curl -i -X POST -T /path/to/data.csv http://localhost:8080/test/upload-sugg
 // $ curl -i -d '{"name": "foo bar"}' http://localhost:8080/test/select-suggestion
+// $ curl -i 'http://localhost:8080/test/suggest?q=foo+bar&lang=ru&limit=20'
 
 var indexDB = &index{
 	store: make(map[string]bleve.Index, 10),
 	vault: make(map[string]*sync.Map, 10),
-	sales: make(map[int]int, 10000),
 }
 
 func main() {
 	log.SetFlags(0)
 	addr := flag.String("addr", "http://localhost:8080", "uri")
+	conf := flag.String("config", "", "path to JSON config file")
+	fixt := flag.Bool("fixtures", false, "preload the embedded sample dataset")
+	topo := flag.String("topology", "", "path to JSON topology file (coordinator mode)")
+	dbg := flag.Bool("debug", false, "validate responses against their JSON schemas")
+	nql := flag.Bool("no-querylog", false, "do not write the query log")
+	test := flag.Bool("selftest", false, "check the build on the embedded fixtures and exit")
+	data := flag.String("data-dir", "", "keep the indexes on disk there and reopen them at start (cold_dir)")
 	flag.Parse()
 
-	err := startServer(*addr, setupHandler(http.DefaultServeMux))
+	if *conf != "" {
+		err := loadConfig(*conf)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *dbg {
+		cfg.ValidateResponses = true
+	}
+	if *nql {
+		cfg.QueryLogOff = true
+	}
+	if *data != "" {
+		cfg.ColdDir = *data
+	}
+
+	err := setRules(cfg.Rules)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	_, err = loadLangPacks(cfg.LangPacks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = validateTables()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *test {
+		os.Exit(selfTest())
+	}
+	setWebhooks(cfg.Webhooks)
+
+	err = checkMappings()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go hotLoop()
+	go dbLoop()
+	go kindPriorsLoop()
+	go abuseLoop()
+
+	err = setupStores()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	restoreColdDir()
+	restoreStore()
+	restoreUploads()
+	go uploadsLoop()
+
+	if *topo != "" {
+		err = loadTopology(*topo)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if *fixt {
+		err = loadFixtures()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	err = startServer(*addr, setupHandler(http.DefaultServeMux))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -50,12 +126,59 @@ func main() {
 }
 
 func setupHandler(m *http.ServeMux) http.Handler {
-	m.HandleFunc("/test/upload-sugg", uploadSugg)
-	m.HandleFunc("/test/upload-sugg2", uploadSugg2)
-	m.HandleFunc("/test/select-sugg", selectSugg)
-	m.HandleFunc("/test/select-suggestion", selectSuggestion)
-	m.HandleFunc("/test/select-name", selectSuggestion)
-	return m
+	m.HandleFunc("/test/upload-sugg", uploadOnly(uploadSugg))
+	m.HandleFunc("/test/upload-sugg2", uploadOnly(uploadSugg2))
+	m.HandleFunc("/test/upload-interactions", uploadOnly(uploadInteractions))
+	m.HandleFunc("/test/docs", uploadOnly(editDocs))
+	m.HandleFunc("/test/docs/", uploadOnly(editDocs))
+	m.HandleFunc("/test/uploads", uploadOnly(resumableUpload))
+	m.HandleFunc("/test/uploads/", uploadOnly(resumableUpload))
+	m.HandleFunc("/test/jobs/", uploadOnly(selectJob))
+	m.HandleFunc("/test/select-sugg", gzipped(limitBody(abuseGuard(logQueries(prioritized(atGeneration(selectSugg)))))))
+	m.HandleFunc("/test/select-suggestion", gzipped(limitBody(abuseGuard(logQueries(prioritized(atGeneration(selectSuggestion)))))))
+	m.HandleFunc("/test/select-name", gzipped(limitBody(abuseGuard(logQueries(prioritized(atGeneration(selectSuggestion)))))))
+	m.HandleFunc("/test/suggest", gzipped(getSuggestion(limitBody(abuseGuard(logQueries(prioritized(atGeneration(selectSuggestion))))))))
+	m.HandleFunc("/docs", apiDocs)
+	m.HandleFunc("/ui", webUI)
+	m.HandleFunc("/ui/admin", adminUI)
+	m.HandleFunc("/docs/schema/", schemaDocs)
+	m.HandleFunc("/test/barcode/", gzipped(abuseGuard(prioritized(atGeneration(selectBarcode)))))
+	m.HandleFunc("/test/regnum", gzipped(abuseGuard(prioritized(atGeneration(selectRegNum)))))
+	m.HandleFunc("/test/interactions", gzipped(abuseGuard(prioritized(atGeneration(selectInteractions)))))
+	m.HandleFunc("/test/spellcheck", gzipped(limitBody(abuseGuard(prioritized(atGeneration(selectSpellcheck))))))
+	m.HandleFunc("/test/sample", gzipped(abuseGuard(prioritized(atGeneration(selectSample)))))
+	m.HandleFunc("/test/browse/", gzipped(abuseGuard(prioritized(atGeneration(selectBrowse)))))
+	m.HandleFunc("/test/browse-counts/", gzipped(abuseGuard(prioritized(atGeneration(browseCounts)))))
+	m.HandleFunc("/test/click", limitBody(abuseGuard(selectClick)))
+	m.HandleFunc("/admin/eval", adminOnly(evalSearch))
+	m.HandleFunc("/admin/kind-priors", adminOnly(adminKindPriors))
+	m.HandleFunc("/admin/kinds", adminOnly(adminKinds))
+	m.HandleFunc("/admin/lang-packs", adminOnly(adminLangPacks))
+	m.HandleFunc("/admin/sign", adminOnly(adminSign))
+	m.HandleFunc("/admin/terms/", adminOnly(adminTerms))
+	m.HandleFunc("/admin/rules", adminOnly(adminRules))
+	m.HandleFunc("/admin/rules/test", adminOnly(adminRulesTest))
+	m.HandleFunc("/admin/generations", adminOnly(adminGenerations))
+	m.HandleFunc("/admin/rollback", adminOnly(adminRollback))
+	m.HandleFunc("/admin/webhooks", adminOnly(adminWebhooks))
+	m.HandleFunc("/admin/doc/", adminOnly(adminDoc))
+	m.HandleFunc("/admin/tombstones", adminOnly(adminTombstones))
+	m.HandleFunc("/admin/export", adminOnly(adminExport))
+	m.HandleFunc("/admin/blocklist", adminOnly(adminBlocklist))
+	m.HandleFunc("/admin/status", adminOnly(adminStatus))
+	m.HandleFunc("/admin/schema", adminOnly(adminSchema))
+	m.HandleFunc("/admin/state/export", adminOnly(adminStateExport))
+	m.HandleFunc("/admin/state/import", adminOnly(adminStateImport))
+	m.HandleFunc("/admin/selfcheck", adminOnly(adminSelfCheck))
+	m.HandleFunc("/admin/duplicates", adminOnly(adminDuplicates))
+	m.HandleFunc("/admin/memory", adminOnly(adminMemory))
+	m.HandleFunc("/admin/db-ingest", adminOnly(adminDBIngest))
+	m.HandleFunc("/admin/migrate", adminOnly(adminMigrate))
+	m.HandleFunc("/admin/record", adminOnly(adminRecord))
+	m.HandleFunc("/admin/record/", adminOnly(adminRecord))
+	m.HandleFunc("/internal/find", nodeOnly(internalFind))
+	m.HandleFunc("/debug/vars", adminOnly(expvar.Handler().ServeHTTP))
+	return recordSamples(m)
 }
 
 func startServer(a string, h http.Handler) error {
@@ -94,11 +217,47 @@ func listenForShutdown(s *http.Server, ch <-chan os.Signal) {
 }
 
 type baseDoc struct {
-	ID   int    `json:"id,omitempty"`
-	Kind string `json:"kind,omitempty"`
-	Name string `json:"name,omitempty"`
-	Info int    `json:"info,omitempty"`
-	Sale int    `json:"sale,omitempty"`
+	ID    int      `json:"id,omitempty"`
+	Key   string   `json:"key,omitempty"` // canonical, see keyedDocs
+	Kind  string   `json:"kind,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Latin string   `json:"latin,omitempty"`
+	EAN   []string `json:"ean,omitempty"`
+	Reg   string   `json:"reg,omitempty"`
+	Info  int      `json:"info,omitempty"`
+	Sale  int      `json:"sale,omitempty"`
+
+	Dispense string `json:"dispense,omitempty"` // rx or otc, see parseDispense
+	Age      string `json:"age,omitempty"`      // years, see parseAge
+	Rank     int    `json:"-"`                  // sortMagic position, see rankVaults
+
+	Extra map[string]string `json:"extra,omitempty"` // see extraOf
+
+	Names []string `json:"names,omitempty"` // other names, see setNames
+	Raw   string   `json:"raw,omitempty"`   // source name before displayName
+
+	Row  []string `json:"row,omitempty"` // the source csv row, see adminExport
+	Line int      `json:"-"`             // its number in the upload
+}
+
+// setNames takes the name column: several names of one product are
+// separated by ";", the first one is the main name.
+func (d *baseDoc) setNames(s string) {
+	var names []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			names = append(names, displayName(v))
+		}
+	}
+	if len(names) == 0 {
+		names = []string{displayName(s)}
+	}
+
+	d.Name = names[0]
+	d.Names = names[1:]
+	if d.Name != s {
+		d.Raw = s
+	}
 }
 
 func internalServerError(w http.ResponseWriter, err error, v ...int) {
@@ -116,7 +275,7 @@ func strTo8SHA1(s string) string {
 
 func uploadSugg(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -127,181 +286,209 @@ func uploadSugg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rec, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	err = verifySHA256(r, b)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	vltATCru := &sync.Map{}
-	idxATCru, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	rec, err := readCSV(b)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	vltINFru := &sync.Map{}
-	idxINFru, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+
+	err = checkRecords(rec, 6)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	vltINNru := &sync.Map{}
-	idxINNru, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
+
+	if ingestAsync(w, r, "sugg", len(rec)-1, func() error { return ingestSugg(rec) }) {
 		return
 	}
-	vltACTru := &sync.Map{}
-	idxACTru, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
+	queued, err := runIngest("sugg", len(rec)-1, wantQueue(r), func() error { return ingestSugg(rec) })
+	if ingestFailed(w, queued, err) {
 		return
 	}
-	vltORGru := &sync.Map{}
-	idxORGru, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
-		return
+
+	// the rows and the indexing throughput of the new generation
+	var rate float64
+	if g, _ := indexDB.generations(); g != nil {
+		rate = g.RowsPerSec
 	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%d %.0f rows/s\n", len(rec)-1, rate)
+}
+
+// ingestSugg builds the kind indexes from the suggestion csv records
+// (with the header row) and swaps them in as a new generation.
+func ingestSugg(rec [][]string) error {
+	start := time.Now()
 
-	vltATCua := &sync.Map{}
-	idxATCua, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	g, err := buildGeneration(rec)
 	if err != nil {
-		internalServerError(w, err)
-		return
+		notify(hookEvent{Event: "ingest.failed", Error: err.Error(), Duration: time.Since(start)})
+		return err
 	}
-	vltINFua := &sync.Map{}
-	idxINFua, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	rankVaults(g.vault)
+	g.ID = time.Now().UnixNano()
+	g.Created = time.Now()
+	err = writeColdStamp(g, rec)
 	if err != nil {
-		internalServerError(w, err)
-		return
+		log.Printf("err: cold generation %d: %s", g.ID, err.Error())
 	}
-	vltINNua := &sync.Map{}
-	idxINNua, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
-		return
+
+	indexDB.swap(g)
+	resCache.purge()
+	saveGeneration(g)
+
+	notify(hookEvent{Event: "ingest.completed", Generation: g.ID, Rows: g.Rows, Duration: time.Since(start)})
+	return nil
+}
+
+// parseRow returns the doc of the csv row (kind, id, name_ru, name_ua,
+// info, lang, latin, ean, reg, dispense, age, extra columns of the header)
+// at line with its index and vault keys
+func parseRow(header, row []string, line int) (string, string, *baseDoc) {
+	doc := &baseDoc{Kind: row[0], Row: row, Line: line}
+	if doc.Kind == "info" {
+		doc.Kind = "inf"
 	}
-	vltACTua := &sync.Map{}
-	idxACTua, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
-		return
+	doc.ID, _ = strconv.Atoi(row[1])
+	doc.Info, _ = strconv.Atoi(row[4])
+
+	key, name := doc.Kind+"-ua", row[3]
+	if row[5] == "RU" {
+		key, name = doc.Kind+"-ru", row[2]
 	}
-	vltORGua := &sync.Map{}
-	idxORGua, err := bleve.NewMemOnly(bleve.NewIndexMapping())
-	if err != nil {
-		internalServerError(w, err)
-		return
+	doc.setNames(name)
+	if len(row) > 6 {
+		doc.Latin = strings.TrimSpace(row[6])
 	}
+	if len(row) > 7 {
+		doc.EAN = strings.FieldsFunc(row[7], isListSep)
+	}
+	if len(row) > 8 {
+		doc.Reg = strings.TrimSpace(row[8])
+	}
+	if len(row) > 9 {
+		doc.Dispense = parseDispense(row[9])
+	}
+	if len(row) > 10 {
+		doc.Age = parseAge(row[10])
+	}
+	doc.Extra = extraOf(header, row)
+	return key, row[1], doc
+}
 
-	var lang string
+func buildGeneration(rec [][]string) (*generation, error) {
+	return buildGenerationIn(coldDir(), rec, nil)
+}
+
+// buildGenerationIn builds the kind indexes in dir ("" is in memory), the
+// ones of the keys in keep are opened as they are instead, see migrate.
+func buildGenerationIn(dir string, rec [][]string, keep map[string]string) (_ *generation, err error) {
+	defer func() {
+		if err != nil && dir != "" && keep == nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	g := &generation{
+		Rows:     len(rec) - 1,
+		Versions: make(map[string]string, 2*len(kindOrder)),
+		header:   rec[0],
+		dir:      dir,
+		store:    make(map[string]bleve.Index, 2*len(kindOrder)),
+		vault:    make(map[string]*sync.Map, 2*len(kindOrder)),
+	}
+	opened := make(map[string]bleve.Index, 2*len(kindOrder)) // index name -> index
+	for _, lang := range langOrder {
+		for _, kind := range kindOrder {
+			key := kind + "-" + lang
+			name := indexName(key)
+			idx, ok := opened[name]
+			switch v, kept := keep[key]; {
+			case kept:
+				g.Versions[key] = v
+				if !ok {
+					idx = newLazyIndex(coldPath(dir, name), nil) // opened on first use
+				}
+			default:
+				g.Versions[key] = mappingVersion(name)
+				if !ok {
+					if dir != "" {
+						_ = os.RemoveAll(coldPath(dir, name))
+					}
+					idx, err = newIndex(name, coldPath(dir, name))
+				}
+			}
+			if err != nil {
+				g.release()
+				return nil, err
+			}
+			opened[name] = idx
+			g.store[key] = idx
+			g.vault[key] = &sync.Map{}
+		}
+	}
+	if keep != nil {
+		cleanIndexDirs(dir, opened)
+	}
+
+	start := time.Now()
+	x := newIndexer()
+	posts := make(postings, 2*len(kindOrder))
 	for i := range rec {
 		if i == 0 {
 			continue
 		}
-		if len(rec[i]) < 6 {
-			err = fmt.Errorf("invalid csv: got %d, want %d", len(rec[i]), 6)
+		key, k, doc := parseRow(rec[0], rec[i], i)
+		idx, ok := g.store[key]
+		if !ok {
+			ingestProgress(fmt.Errorf("line %d: unknown kind or lang: %s", i+1, key))
+			continue
 		}
-		if err != nil {
-			internalServerError(w, err, http.StatusBadRequest)
-			return
+		var ierr error
+		id, add := posts.post(key, k, doc)
+		if _, ok := keep[key]; !ok && add {
+			ierr = x.index(idx, id, doc.indexDoc(key), i+1)
 		}
-
-		docRU := &baseDoc{}
-		docRU.ID, _ = strconv.Atoi(rec[i][1])
-		docRU.Kind = rec[i][0]
-		docRU.Name = rec[i][2]
-		docRU.Info, _ = strconv.Atoi(rec[i][4])
-
-		docUA := &baseDoc{}
-		docUA.ID, _ = strconv.Atoi(rec[i][1])
-		docUA.Kind = rec[i][0]
-		docUA.Name = rec[i][3]
-		docUA.Info, _ = strconv.Atoi(rec[i][4])
-
-		if docRU.Kind == "info" {
-			docRU.Kind = "inf"
-		}
-		if docUA.Kind == "info" {
-			docUA.Kind = "inf"
-		}
-
-		key1 := rec[i][1] // fucking workaround
-		key2 := key1      // fucking workaround
-		lang = rec[i][5]
-		if lang == "RU" {
-			key1 = key1 + "|" + strTo8SHA1(docRU.Name)
-			switch docRU.Kind {
-			case "atc":
-				idxATCru.Index(key1, docRU.Name)
-				vltATCru.Store(key2, docRU)
-			case "inf":
-				idxINFru.Index(key1, docRU.Name)
-				vltINFru.Store(key2, docRU)
-			case "inn":
-				idxINNru.Index(key1, docRU.Name)
-				vltINNru.Store(key2, docRU)
-			case "act":
-				idxACTru.Index(key1, docRU.Name)
-				vltACTru.Store(key2, docRU)
-			case "org":
-				idxORGru.Index(key1, docRU.Name)
-				vltORGru.Store(key2, docRU)
-			}
-		} else {
-			key1 = key1 + "|" + strTo8SHA1(docUA.Name)
-			switch docUA.Kind {
-			case "atc":
-				idxATCua.Index(key1, docUA.Name)
-				vltATCua.Store(key2, docUA)
-			case "inf":
-				idxINFua.Index(key1, docUA.Name)
-				vltINFua.Store(key2, docUA)
-			case "inn":
-				idxINNua.Index(key1, docUA.Name)
-				vltINNua.Store(key2, docUA)
-			case "act":
-				idxACTua.Index(key1, docUA.Name)
-				vltACTua.Store(key2, docUA)
-			case "org":
-				idxORGua.Index(key1, docUA.Name)
-				vltORGua.Store(key2, docUA)
+		g.vault[key].Store(k, doc)
+		if ierr != nil {
+			ierr = fmt.Errorf("line %d: %s", i+1, ierr.Error())
+		}
+		ingestProgress(ierr)
+	}
+	x.close()
+	if d := time.Since(start); d > 0 {
+		g.RowsPerSec = float64(g.Rows) / d.Seconds()
+	}
+	if dir != "" {
+		// the new cold indexes are open, closed when over cfg.MaxOpenIndexes
+		lazy := make(map[string]bleve.Index, len(opened))
+		for name, idx := range opened {
+			if _, ok := idx.(*lazyIndex); !ok {
+				idx = newLazyIndex(coldPath(dir, name), idx)
 			}
+			lazy[name] = idx
+		}
+		for key := range g.store {
+			g.store[key] = lazy[indexName(key)]
 		}
 	}
+	g.postings = posts
+	g.lines = len(rec) - 1
+	g.letters = letterIndex(g.vault)
+	g.estimateSizes()
 
-	indexDB.setIndex("atc-ru", idxATCru)
-	indexDB.setIndex("inf-ru", idxINFru)
-	indexDB.setIndex("inn-ru", idxINNru)
-	indexDB.setIndex("act-ru", idxACTru)
-	indexDB.setIndex("org-ru", idxORGru)
-
-	indexDB.setIndex("atc-ua", idxATCua)
-	indexDB.setIndex("inf-ua", idxINFua)
-	indexDB.setIndex("inn-ua", idxINNua)
-	indexDB.setIndex("act-ua", idxACTua)
-	indexDB.setIndex("org-ua", idxORGua)
-
-	indexDB.setVault("atc-ru", vltATCru)
-	indexDB.setVault("inf-ru", vltINFru)
-	indexDB.setVault("inn-ru", vltINNru)
-	indexDB.setVault("act-ru", vltACTru)
-	indexDB.setVault("org-ru", vltORGru)
-
-	indexDB.setVault("atc-ua", vltATCua)
-	indexDB.setVault("inf-ua", vltINFua)
-	indexDB.setVault("inn-ua", vltINNua)
-	indexDB.setVault("act-ua", vltACTua)
-	indexDB.setVault("org-ua", vltORGua)
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, len(rec)-1)
+	return g, nil
 }
 
 func uploadSugg2(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -312,50 +499,143 @@ func uploadSugg2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rec, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	err = verifySHA256(r, b)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := readCSV(b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
 
+	err = checkRecords(rec, 2)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if ingestAsync(w, r, "sales", len(rec)-1, func() error { return ingestSales(rec) }) {
+		return
+	}
+	queued, err := runIngest("sales", len(rec)-1, wantQueue(r), func() error { return ingestSales(rec) })
+	if ingestFailed(w, queued, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, len(rec)-1, sales.count())
+}
+
+// ingestSales merges the sales csv records (id, sales) into the sales store
+func ingestSales(rec [][]string) error {
+	m := make(map[int]int, len(rec))
 	for i := range rec {
 		if i == 0 {
 			continue
 		}
-		if len(rec[i]) < 2 {
-			err = fmt.Errorf("invalid csv: got %d, want %d", len(rec[i]), 2)
-		}
-		if err != nil {
-			internalServerError(w, err, http.StatusBadRequest)
-			return
+
+		key, err1 := strconv.Atoi(rec[i][0])
+		val, err2 := strconv.Atoi(rec[i][1])
+		if err1 != nil || err2 != nil {
+			ingestProgress(fmt.Errorf("line %d: invalid id or sale: %q, %q", i+1, rec[i][0], rec[i][1]))
+			continue
 		}
 
-		key, _ := strconv.Atoi(rec[i][0])
-		val, _ := strconv.Atoi(rec[i][1])
+		m[key] = val
+		ingestProgress(nil)
+	}
+
+	err := sales.merge(m)
+	rankCurrent()
+	resCache.purge()
+	staleETags()
+	return err
+}
+
+// readCSV reads all records, rows may differ in the number of fields
+// (optional trailing columns), see checkRecords
+func readCSV(b []byte) ([][]string, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
 
-		indexDB.sales[key] = val
+// atcName strips the code from an ATC name ("A02|Antacids")
+func atcName(s string) string {
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
 
+// verifySHA256 checks the body against the X-Content-SHA256 header or
+// trailer (hex) if the client sent one
+func verifySHA256(r *http.Request, b []byte) error {
+	want := r.Header.Get("X-Content-SHA256")
+	if want == "" {
+		want = r.Trailer.Get("X-Content-SHA256")
+	}
+	if want == "" {
+		return nil
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, len(rec)-1, len(indexDB.sales))
+	got := fmt.Sprintf("%x", sha256.Sum256(b))
+	if !strings.EqualFold(got, strings.TrimSpace(want)) {
+		log.Printf("checksum mismatch on %s: got %s, want %s (%d bytes)", r.URL.Path, got, want, len(b))
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+// checkRecords verifies every record but the header has at least n fields
+func checkRecords(rec [][]string, n int) error {
+	for i := range rec {
+		if i == 0 {
+			continue
+		}
+		if len(rec[i]) < n {
+			return fmt.Errorf("invalid csv: got %d, want %d", len(rec[i]), n)
+		}
+	}
+	return nil
 }
 
 func selectSuggestion(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
 		return
 	}
 
 	b, err := ioutil.ReadAll(r.Body)
 	defer func() { _ = r.Body.Close() }()
 	if err != nil {
-		internalServerError(w, err, http.StatusBadRequest)
+		internalServerError(w, err, bodyStatus(err))
+		return
+	}
+
+	key := cacheKey(r, b)
+	if notModified(w, r, key) || cachedResult(w, key) {
 		return
 	}
 
 	v := struct {
-		Name string `json:"name"`
+		Name      string         `json:"name"`
+		Limit     int            `json:"limit"`
+		Min       *int           `json:"min"` // see minPerKind
+		MinKind   map[string]int `json:"min_kind"`
+		Fields    []string       `json:"fields"`
+		Exclude   []string       `json:"exclude"`
+		Parse     bool           `json:"parse"`
+		Tokens    bool           `json:"tokens"`
+		Order     []string       `json:"order"` // of the sections, see sectionOrder
+		Slop      *int           `json:"slop"`  // see requestSlop
+		KeyFormat string         `json:"key_format"`
+		Dispense  string         `json:"dispense"`    // see requestDispense
+		Age       *int           `json:"patient_age"` // see requestAge
+		Fuzziness *int           `json:"fuzziness"`   // see requestFuzziness
 	}{}
 
 	err = json.Unmarshal(b, &v)
@@ -364,174 +644,185 @@ func selectSuggestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	n := len([]rune(v.Name))
-	if n <= 2 {
-		err = fmt.Errorf("too few characters: %d", n)
-	} else if n > 1024 {
-		err = fmt.Errorf("too many characters: %d", n)
+	v.Name = sanitizeQuery(v.Name)
+	for i := range v.Exclude {
+		v.Exclude[i] = sanitizeQuery(v.Exclude[i])
 	}
+	err = checkQueryLength(r, v.Name)
 	if err != nil {
 		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	idxATC := "atc-ru"
-	idxINF := "inf-ru"
-	idxINN := "inn-ru"
-	idxACT := "act-ru"
-	idxORG := "org-ru"
-	if langUA(r.Header) {
-		idxATC = "atc-ua"
-		idxINF = "inf-ua"
-		idxINN = "inn-ua"
-		idxACT = "act-ua"
-		idxORG = "org-ua"
-	}
-
-	mATC, err := findByName(idxATC, v.Name, false)
+	err = checkLimits(v.Limit, v.Min, v.MinKind)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mINF, err := findByName(idxINF, v.Name, false)
+	order, err := sectionOrder(r, v.Order)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mINN, err := findByName(idxINN, v.Name, false)
+	slop, err := requestSlop(r, v.Slop)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mACT, err := findByName(idxACT, v.Name, false)
+	canonical, err := requestKeyFormat(r, v.KeyFormat)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mORG, err := findByName(idxORG, v.Name, false)
+	dispense, err := requestDispense(r, v.Dispense)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	convName := convString(v.Name, "en", "ru")
-	if langUA(r.Header) {
-		convName = convString(v.Name, "en", "uk")
-	}
-	if len(mATC) == 0 {
-		mATC, err = findByName(idxATC, convName, false)
-		if err != nil {
-			internalServerError(w, err)
-			return
-		}
+	age, err := requestAge(r, v.Age)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
 	}
-	if len(mINF) == 0 {
-		mINF, err = findByName(idxINF, convName, false)
-		if err != nil {
-			internalServerError(w, err)
-			return
-		}
+	fuzziness, err := requestFuzziness(r, v.Fuzziness)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
 	}
-	if len(mINN) == 0 {
-		mINN, err = findByName(idxINN, convName, false)
-		if err != nil {
-			internalServerError(w, err)
-			return
+
+	name := v.Name
+	var line *parsedLine
+	if v.Parse {
+		line = parseLine(v.Name)
+		if line.Core != "" {
+			name = line.Core
 		}
 	}
-	if len(mACT) == 0 {
-		mACT, err = findByName(idxACT, convName, false)
-		if err != nil {
-			internalServerError(w, err)
-			return
-		}
+
+	res, err := suggest(r.Context(), pinnedIndex(r), withExcluded(name, v.Exclude), langUA(r.Header), v.Limit, slop, fuzziness, productFilter{dispense, age})
+	if err != nil {
+		searchFailed(w, err)
+		return
 	}
-	if len(mORG) == 0 {
-		mORG, err = findByName(idxORG, convName, false)
-		if err != nil {
-			internalServerError(w, err)
-			return
-		}
+	res.Find = v.Name
+	res.Parsed = line
+	res.meta().Labels = cfg.labels(labelLang(r.Header))
+	res.meta().Order = order
+	if res.empty() {
+		noteEmpty(r)
 	}
+	res.addAlternates(withExcluded(name, v.Exclude), false, langUA(r.Header))
 
-	sATC := make([]string, 0, len(mATC))
-	sINF := make([]string, 0, len(mINF))
-	sINN := make([]string, 0, len(mINN))
-	sACT := make([]string, 0, len(mACT))
-	sORG := make([]string, 0, len(mORG))
-
-	for k := range mATC {
-		sATC = append(sATC, k)
-	}
-	for k := range mINF {
-		sINF = append(sINF, k)
+	capResult(res, v.Limit, v.Min, v.MinKind)
+	noteQuery(r, res)
+	if v.Tokens {
+		res.explainTokens(withExcluded(v.Name, v.Exclude), langUA(r.Header))
 	}
-	for k := range mINN {
-		sINN = append(sINN, k)
+	if canonical {
+		res.canonicalKeys(langUA(r.Header))
 	}
-	for k := range mACT {
-		sACT = append(sACT, k)
+
+	out, err := filterFields(res, requestFields(r, v.Fields))
+	if err != nil {
+		internalServerError(w, err)
+		return
 	}
-	for k := range mORG {
-		sORG = append(sORG, k)
+
+	writeResult(w, r, out, order, key)
+}
+
+// suggest runs the suggestion search for name over the kind indexes of
+// the given language, falling back to the keyboard-converted name. With
+// limit > 0 at most limit inf keys are ranked, capResult never keeps more.
+// db is a kept generation to search (nil for the current one), slop > 0
+// lets the phrase stages match sloppy, fuzziness > 0 searches fuzzy after
+// no hits, f filters the products.
+func suggest(ctx context.Context, db *index, name string, ua bool, limit, slop, fuzziness int, f productFilter) (*result, error) {
+	rankShared()
+	res := &result{Find: name, ctx: ctx, db: db, slop: slop, fuzziness: fuzziness}
+	name = rewriteQuery(name)
+	res.infer(name)
+
+	convName := layoutConv(name, ua)
+
+	var hits map[string]map[string][]string
+	res.strategy = "zero"
+	if !knownZero(false, ua, res, name) {
+		var stages map[string]string
+		hits, stages = res.findAll(epSuggestion, name, ua)
+		res.strategy = stagesStrategy(stages)
+		if err := res.failed(); err != nil {
+			return res, err
+		}
+
+		n := 0
+		for _, h := range hits {
+			n += len(h)
+		}
+		if n == 0 {
+			markZero(false, ua, res, name)
+		}
+		if _, ok := hits["inf"]; ok {
+			hits["inf"] = res.products(kindKey("inf", ua), hits["inf"], f)
+		}
 	}
 
-	// Sorting
 	c := collate.New(language.Russian)
-	if langUA(r.Header) {
+	if ua {
 		c = collate.New(language.Ukrainian)
 	}
-	c.SortStrings(sATC)
-	c.SortStrings(sINF)
-	c.SortStrings(sINN)
-	c.SortStrings(sACT)
-	c.SortStrings(sORG)
-
-	res := result{Find: v.Name}
-	for i := range sATC {
-		s := sugg{Name: sATC[i]}
-		s.Keys = append(s.Keys, mATC[s.Name]...)
-		s.Keys = sortMagic(idxATC, s.Keys...)
-		s.Name = strings.TrimSpace(strings.Replace(s.Name, "|", " ", 1))
-		res.SuggATC = append(res.SuggATC, s)
-	}
-	// fucking workaround
-	s1 := sugg{}
-	for i := range sINF {
-		s1.Keys = append(s1.Keys, mINF[sINF[i]]...)
-	}
-	s1.Keys = remDupl(s1.Keys)
-	s1.Keys = sortMagic(idxINF, s1.Keys...)
-	res.SuggINF = append(res.SuggINF, s1)
-
-	for i := range sINN {
-		s := sugg{Name: sINN[i]}
-		s.Keys = append(s.Keys, mINN[s.Name]...)
-		s.Keys = sortMagic(idxINN, s.Keys...)
-		res.SuggINN = append(res.SuggINN, s)
-	}
-	for i := range sACT {
-		s := sugg{Name: sACT[i]}
-		s.Keys = append(s.Keys, mACT[s.Name]...)
-		s.Keys = sortMagic(idxACT, s.Keys...)
-		res.SuggACT = append(res.SuggACT, s)
-	}
-	for i := range sORG {
-		s := sugg{Name: sORG[i]}
-		s.Keys = append(s.Keys, mORG[s.Name]...)
-		s.Keys = sortMagic(idxORG, s.Keys...)
-		res.SuggORG = append(res.SuggORG, s)
-	}
-
-	b, err = json.MarshalIndent(res, "", "\t")
-	if err != nil {
-		internalServerError(w, err)
-		return
+
+	// Sorting, then boosting: whole words > word prefixes > infixes
+	names := make(map[string][]string, len(kindOrder))
+	tops := make(map[string]string, len(kindOrder))
+	for _, k := range kindOrder {
+		s := make([]string, 0, len(hits[k]))
+		for n := range hits[k] {
+			s = append(s, n)
+		}
+		sortNames(c, s)
+		sortByMatch(s, name, convName)
+		names[k] = s
+		if len(s) > 0 {
+			tops[k] = strings.Replace(s[0], "|", " ", 1)
+		}
 	}
+	res.detectIntent(tops, name, convName)
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, string(b))
+	for _, k := range kindOrder {
+		sec := res.section(k)
+		if k == "inf" {
+			// fucking workaround
+			*sec = append(*sec, res.productKeys(names[k], hits[k], name, convName, ua, limit))
+			continue
+		}
+		for _, n := range names[k] {
+			s := sugg{Name: strings.TrimSpace(strings.Replace(n, "|", " ", 1))}
+			s.Keys = append(s.Keys, hits[k][n]...)
+			s.Keys = res.index().sortMagic(kindKey(k, ua), s.Keys...)
+			*sec = append(*sec, s)
+		}
+	}
+
+	return res, res.failed()
+}
+
+// productKeys returns the keys of the inf hits as one entry: the keys of
+// the best matched names first, at most limit of them if limit > 0
+func (r *result) productKeys(names []string, hits map[string][]string, name, convName string, ua bool, limit int) sugg {
+	s := sugg{}
+	match := make(map[string]int)
+	for _, n := range names {
+		m := bestMatch(n, name, convName)
+		for _, k := range hits[n] {
+			if m > match[k] {
+				match[k] = m
+			}
+		}
+		s.Keys = append(s.Keys, hits[n]...)
+	}
+	s.Keys = remDupl(s.Keys)
+	s.Keys = r.index().topMatched(kindKey("inf", ua), limit, match, s.Keys...)
+	return s
 }
 
 func remDupl(a []string) []string {
@@ -545,70 +836,119 @@ func remDupl(a []string) []string {
 	}
 	return res
 }
-func sortMagic(key string, keys ...string) []string {
+func (i *index) sortMagic(key string, keys ...string) []string {
+	return i.topMagic(key, 0, keys...)
+}
+
+// topMagic is sortMagic returning only the first limit keys (all if limit
+// is 0), selected with a heap instead of sorting all of them.
+func (i *index) topMagic(key string, limit int, keys ...string) []string {
+	return i.topMatched(key, limit, nil, keys...)
+}
+
+// topMatched is topMagic ordering the keys by their match rank (descending)
+// first and by sortMagic within equal ranks, keys without a match rank
+// rank 0.
+func (i *index) topMatched(key string, limit int, match map[string]int, keys ...string) []string {
 	if len(keys) < 2 {
 		return keys
 	}
 
-	vlt, err := indexDB.getVault(key)
+	rank := byRank
+	vlt, err := i.getVault(key)
 	if err != nil {
-		return keys
+		if vlt = remoteVault(key); vlt == nil {
+			return keys
+		}
+		rank = magicLess // the docs of several nodes have no common Rank
 	}
 
 	tmp := make([]*baseDoc, 0, len(keys))
-	for i := range keys {
-		if v, ok := vlt.Load(keys[i]); ok {
+	prio := make(map[*baseDoc]int, len(match))
+	var miss []string
+	for _, k := range keys {
+		if v, ok := vlt.Load(k); ok {
 			d := v.(*baseDoc)
-			d.Sale = indexDB.sales[d.ID]
-			//println(keys[i], d.Info, d.Sale)
 			tmp = append(tmp, d)
+			if m, ok := match[k]; ok {
+				prio[d] = m
+			}
+		} else {
+			miss = append(miss, k)
 		}
 	}
 
+	if len(miss) > 0 {
+		// the index and the vault are built together, a miss means a broken ingest
+		vaultMisses.Add(int64(len(miss)))
+		log.Printf("err: %s: %d keys not in vault: %v", key, len(miss), miss)
+	}
 	if len(tmp) == 0 {
 		return keys
 	}
 
-	sort.Slice(tmp,
-		func(i, j int) bool {
-			if tmp[i].Info > tmp[j].Info {
-				return true
-			} else if tmp[i].Info < tmp[j].Info {
-				return false
+	less := rank
+	if len(prio) > 0 {
+		less = func(a, b *baseDoc) bool {
+			if prio[a] != prio[b] {
+				return prio[a] > prio[b]
 			}
-			if tmp[i].Sale > tmp[j].Sale {
-				return true
-			} else if tmp[i].Sale < tmp[j].Sale {
-				return false
-			}
-			return tmp[i].Name < tmp[j].Name
-		},
-	)
+			return rank(a, b)
+		}
+	}
+	if limit > 0 && limit < len(tmp) {
+		tmp = topDocs(tmp, limit, less)
+		miss = nil
+	} else {
+		sort.Slice(tmp, func(i, j int) bool { return less(tmp[i], tmp[j]) })
+		if limit > 0 && limit < len(tmp)+len(miss) {
+			miss = miss[:limit-len(tmp)]
+		}
+	}
 
-	out := make([]string, len(tmp))
-	for i := range tmp {
-		//	println(tmp[i].ID, tmp[i].Info, tmp[i].Sale)
-		out[i] = strconv.Itoa(tmp[i].ID)
+	out := make([]string, len(tmp), len(tmp)+len(miss))
+	for j := range tmp {
+		//	println(tmp[j].ID, tmp[j].Info, tmp[j].Sale)
+		out[j] = strconv.Itoa(tmp[j].ID)
 	}
 
-	return out
+	// keys without a vault entry keep their original order at the end
+	return append(out, miss...)
 }
 
+// vaultMisses counts keys found in an index but not in its vault,
+// see /debug/vars
+var vaultMisses = expvar.NewInt("vault_misses")
+
 func selectSugg(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
 		return
 	}
 
 	b, err := ioutil.ReadAll(r.Body)
 	defer func() { _ = r.Body.Close() }()
 	if err != nil {
-		internalServerError(w, err, http.StatusBadRequest)
+		internalServerError(w, err, bodyStatus(err))
+		return
+	}
+
+	key := cacheKey(r, b)
+	w.Header().Set("X-Query-Token", key)
+	if notModified(w, r, key) || cachedResult(w, key) {
 		return
 	}
 
 	v := struct {
-		Name string `json:"name"`
+		Name      string   `json:"name"`
+		Fields    []string `json:"fields"`
+		Exclude   []string `json:"exclude"`
+		Parse     bool     `json:"parse"`
+		Prev      string   `json:"prev"` // X-Query-Token of the previous request, see memo
+		Tokens    bool     `json:"tokens"`
+		Dispense  string   `json:"dispense"`    // see requestDispense
+		Age       *int     `json:"patient_age"` // see requestAge
+		Fuzziness *int     `json:"fuzziness"`   // see requestFuzziness
 	}{}
 
 	err = json.Unmarshal(b, &v)
@@ -617,111 +957,96 @@ func selectSugg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	n := len([]rune(v.Name))
-	if n <= 2 {
-		err = fmt.Errorf("too few characters: %d", n)
-	} else if n > 128 {
-		err = fmt.Errorf("too many characters: %d", n)
+	v.Name = sanitizeQuery(v.Name)
+	for i := range v.Exclude {
+		v.Exclude[i] = sanitizeQuery(v.Exclude[i])
 	}
+	err = checkQueryLength(r, v.Name)
 	if err != nil {
 		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	idxATC := "atc-ru"
-	idxINF := "inf-ru"
-	idxINN := "inn-ru"
-	idxACT := "act-ru"
-	idxORG := "org-ru"
-	if langUA(r.Header) {
-		idxATC = "atc-ua"
-		idxINF = "inf-ua"
-		idxINN = "inn-ua"
-		idxACT = "act-ua"
-		idxORG = "org-ua"
-	}
-
-	mATC, err := findByName(idxATC, v.Name, true)
-	if err != nil {
-		internalServerError(w, err)
-		return
-	}
-	mINF, err := findByName(idxINF, v.Name, true)
+	dispense, err := requestDispense(r, v.Dispense)
 	if err != nil {
-		internalServerError(w, err)
-		return
-	}
-	mINN, err := findByName(idxINN, v.Name, true)
-	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mACT, err := findByName(idxACT, v.Name, true)
+	age, err := requestAge(r, v.Age)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
-	mORG, err := findByName(idxORG, v.Name, true)
+	fuzziness, err := requestFuzziness(r, v.Fuzziness)
 	if err != nil {
-		internalServerError(w, err)
+		internalServerError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	convName := convString(v.Name, "en", "ru")
-	if langUA(r.Header) {
-		convName = convString(v.Name, "en", "uk")
-	}
-	if len(mATC) == 0 {
-		mATC, err = findByName(idxATC, convName, true)
-		if err != nil {
-			internalServerError(w, err)
-			return
+	res := &result{Find: v.Name, ctx: r.Context(), db: pinnedIndex(r), fuzziness: fuzziness}
+	query := withExcluded(v.Name, v.Exclude)
+	if v.Parse {
+		res.Parsed = parseLine(v.Name)
+		if res.Parsed.Core != "" {
+			v.Name = res.Parsed.Core
 		}
 	}
-	if len(mINF) == 0 {
-		mINF, err = findByName(idxINF, convName, true)
-		if err != nil {
-			internalServerError(w, err)
-			return
-		}
+	core := withExcluded(v.Name, v.Exclude)
+	v.Name = rewriteQuery(core)
+	res.infer(v.Name)
+
+	convName := layoutConv(v.Name, langUA(r.Header))
+
+	mem, ok := narrowHits(v.Prev, v.Name, convName, langUA(r.Header), res)
+	res.strategy = "memo"
+	if !ok && knownZero(true, langUA(r.Header), res, v.Name) {
+		mem, ok = &memo{hits: map[string]map[string][]string{}}, true
+		res.strategy = "zero"
 	}
-	if len(mINN) == 0 {
-		mINN, err = findByName(idxINN, convName, true)
+	if !ok {
+		hits, stages := res.findAll(epSugg, v.Name, langUA(r.Header))
+		res.strategy = stagesStrategy(stages)
+
+		err = res.failed()
 		if err != nil {
-			internalServerError(w, err)
+			searchFailed(w, err)
 			return
 		}
-	}
-	if len(mACT) == 0 {
-		mACT, err = findByName(idxACT, convName, true)
-		if err != nil {
-			internalServerError(w, err)
-			return
+
+		n := 0
+		for _, m := range hits {
+			n += len(m)
 		}
-	}
-	if len(mORG) == 0 {
-		mORG, err = findByName(idxORG, convName, true)
-		if err != nil {
-			internalServerError(w, err)
-			return
+		if n == 0 {
+			markZero(true, langUA(r.Header), res, v.Name)
 		}
-	}
 
-	mAll := make(map[string]struct{}, len(mATC)+len(mINF)+len(mINN)+len(mACT)+len(mORG))
-	for k := range mATC {
-		mAll[strings.ToUpper(strings.TrimSpace(strings.Replace(strings.Split(k, "|")[1], "|", " ", 1)))] = struct{}{}
+		mem = &memo{hits: hits, stages: stages}
 	}
-	for k := range mINF {
-		mAll[strings.ToUpper(k)] = struct{}{}
+	if res.db == nil && (res.Meta == nil || len(res.Meta.Degraded) == 0) {
+		mem.name, mem.conv, mem.ua = v.Name, convName, langUA(r.Header)
+		mem.gen, mem.seq = memoState()
+		if res.Meta != nil {
+			mem.inferred, mem.disabled = res.Meta.Inferred, res.Meta.Disabled
+		}
+		putMemo(key, mem)
 	}
-	for k := range mINN {
-		mAll[strings.ToUpper(k)] = struct{}{}
+	hits := make(map[string]map[string][]string, len(mem.hits)) // the memo keeps its own
+	for k, h := range mem.hits {
+		hits[k] = h
 	}
-	for k := range mACT {
-		mAll[strings.ToUpper(k)] = struct{}{}
+	if _, ok := hits["inf"]; ok {
+		hits["inf"] = res.products(kindKey("inf", langUA(r.Header)), hits["inf"], productFilter{dispense, age})
 	}
-	for k := range mORG {
-		mAll[strings.ToUpper(k)] = struct{}{}
+
+	n := 0
+	for _, h := range hits {
+		n += len(h)
+	}
+	mAll := make(map[string]string, n)
+	for _, kind := range kindOrder {
+		for k := range hits[kind] {
+			mAll[strings.ToUpper(strings.TrimSpace(strings.Replace(atcName(k), "|", " ", 1)))] = kind
+		}
 	}
 	sAll := make([]string, 0, len(mAll))
 	for k := range mAll {
@@ -733,9 +1058,9 @@ func selectSugg(w http.ResponseWriter, r *http.Request) {
 	if langUA(r.Header) {
 		c = collate.New(language.Ukrainian)
 	}
-	c.SortStrings(sAll)
+	sortNames(c, sAll)
+	sortByBoostedMatch(sAll, mAll, v.Name, convName)
 
-	res := result{Find: v.Name}
 	for i := range sAll {
 		if strings.HasPrefix(strings.ToLower(sAll[i]), strings.ToLower(convName)) {
 			res.Sugg = append(res.Sugg, sAll[i])
@@ -746,16 +1071,23 @@ func selectSugg(w http.ResponseWriter, r *http.Request) {
 			res.Sugg = append(res.Sugg, sAll[i])
 		}
 	}
+	res.preferInferred(res.Sugg, mAll)
+	if res.empty() {
+		noteEmpty(r)
+	}
+	noteQuery(r, res)
+	res.addAlternates(core, true, langUA(r.Header))
+	if v.Tokens {
+		res.explainTokens(query, langUA(r.Header))
+	}
 
-	b, err = json.MarshalIndent(res, "", "\t")
+	out, err := filterFields(res, requestFields(r, v.Fields))
 	if err != nil {
 		internalServerError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, string(b))
+	writeResult(w, r, out, nil, key)
 }
 
 type result struct {
@@ -766,6 +1098,44 @@ type result struct {
 	SuggACT []sugg   `json:"sugg_act,omitempty"`
 	SuggORG []sugg   `json:"sugg_org,omitempty"`
 	SuggATC []sugg   `json:"sugg_atc,omitempty"`
+	// SuggKinds are the sections of the configured kinds other than the
+	// ones above, by kind, see extraKinds
+	SuggKinds map[string]*[]sugg `json:"sugg_kinds,omitempty"`
+	Meta      *meta              `json:"meta,omitempty"`
+
+	Parsed *parsedLine  `json:"parsed,omitempty"`
+	Tokens []tokenMatch `json:"tokens,omitempty"`
+
+	Alternates []alternate `json:"alternates,omitempty"`
+
+	err    error           // last search error, see find
+	ctx    context.Context // of the request, ends its searches, see eachKind
+	only   []string        // kinds to search, see infer
+	intent string          // kind the query is focused on, see detectIntent
+	busy   bool            // a search got no slot, see acquire
+
+	strategy string // how the hits were found, see queryEntry
+	db       *index // a kept generation to search, see atGeneration
+	slop     int    // of the phrase stages, see sloppy
+
+	fuzziness int // of the search after no hits, see findAll
+}
+
+type meta struct {
+	Labels   map[string]string `json:"labels,omitempty"`
+	Degraded []string          `json:"degraded,omitempty"`
+	Disabled []string          `json:"disabled,omitempty"`
+	Inferred []string          `json:"inferred,omitempty"`
+	Intent   string            `json:"intent,omitempty"`
+	// PrefixOnly are the query words too short to be searched inside
+	// name words, see prefixOnly
+	PrefixOnly []string `json:"prefix_only,omitempty"`
+	// Order of the sections when not the struct one, see sectionOrder
+	Order []string `json:"order,omitempty"`
+	// Hidden counts the products left out by the dispense filter by
+	// their own flag (see dispensed) and by the patient age as "age"
+	// (see aged)
+	Hidden map[string]int `json:"hidden,omitempty"`
 }
 
 type sugg struct {
@@ -778,69 +1148,228 @@ func langUA(h http.Header) bool {
 	return strings.Contains(l, "uk") || strings.Contains(l, "ua") // FIXME
 }
 
+// matchRank scores how well name matches the query words: a whole word
+// match gives 3, a word prefix gives 2 and an infix gives 1 per query word.
+func matchRank(name, query string) int {
+	words := strings.Fields(strings.ToLower(normName(name)))
+	query, _ = splitExcluded(query)
+	rank := 0
+	for _, q := range strings.Fields(strings.ToLower(normName(query))) {
+		best := 0
+		for _, w := range words {
+			switch {
+			case w == q:
+				best = 3
+			case strings.HasPrefix(w, q) && best < 2:
+				best = 2
+			case strings.Contains(w, q) && best < 1:
+				best = 1
+			}
+			if best == 3 {
+				break
+			}
+		}
+		rank += best
+	}
+	return rank
+}
+
+// sortNames sorts by collation, names that collate equal by bytes so
+// that the order never depends on map iteration. See orderingDoc.
+func sortNames(c *collate.Collator, names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		if n := c.CompareString(names[i], names[j]); n != 0 {
+			return n < 0
+		}
+		return names[i] < names[j]
+	})
+}
+
+// sortByMatch reorders names by the best matchRank against any of queries,
+// keeping the previous (collation) order for equal ranks.
+func sortByMatch(names []string, queries ...string) {
+	sortByBoostedMatch(names, nil, queries...)
+}
+
+// bestMatch is the best matchRank of name against any of queries
+func bestMatch(name string, queries ...string) int {
+	best := 0
+	for _, q := range queries {
+		if r := matchRank(name, q); r > best {
+			best = r
+		}
+	}
+	return best
+}
+
+// sortByBoostedMatch is sortByMatch with the ranks scaled by the kindBoost
+// of the names' kinds
+func sortByBoostedMatch(names []string, kinds map[string]string, queries ...string) {
+	rank := make(map[string]float64, len(names))
+	for _, n := range names {
+		boost := 1.0
+		if k, ok := kinds[n]; ok {
+			boost = kindBoost(k)
+		}
+		rank[n] = float64(bestMatch(n, queries...)) * boost
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return rank[names[i]] > rank[names[j]]
+	})
+}
+
 func normName(s string) string {
-	res := []rune(s)
+	res := []rune(normUnits(stripStress(s)))
 	for i := range res {
-		if !unicode.IsLetter(res[i]) {
+		if !unicode.IsLetter(res[i]) && !unicode.IsDigit(res[i]) {
 			res[i] = ' '
 		}
 	}
 	return string(res)
 }
 
-func findByName(key, name string, conj bool) (map[string][]string, error) {
-	idx, err := indexDB.getIndex(key)
+func findByName(ctx context.Context, key, name string, mode searchMode) (map[string][]string, error) {
+	return indexDB.findByName(ctx, key, name, mode)
+}
+
+func (i *index) findByName(ctx context.Context, key, name string, mode searchMode) (map[string][]string, error) {
+	idx, err := i.getIndex(key)
 	if err != nil {
 		return nil, err
 	}
 
+	name, excl := splitExcluded(name)
 	name = normName(name)
-
-	var qry query.Query
-	if conj {
-		str := strings.Split(strings.ToLower(name), " ")
-		cns := make([]query.Query, len(str))
-		for i, v := range str {
-			q := bleve.NewWildcardQuery("*" + strings.TrimSpace(v) + "*")
-			cns[i] = q
+	kind := strings.Split(key, "-")[0]
+	if cfg.Mappings[kind].hasFilter("legal_forms") {
+		name = stripLegalForms(name)
+	}
+	if len(queryWords(name)) == 0 {
+		return map[string][]string{}, nil
+	}
+
+	// The Latin name is searched in parallel with the local one
+	qry := bleve.NewBooleanQuery()
+	qry.AddMust(bleve.NewDisjunctionQuery(append([]query.Query{
+		nameQuery(key, "name", name, mode),
+		nameQuery(key, "latin", name, mode),
+	}, mappingQueries(key, name, !mode.phrase())...)...))
+	for _, v := range excl {
+		for _, f := range []string{"name", "latin"} {
+			q := bleve.NewMatchQuery(v)
+			q.SetField(field(key, f))
+			q.Analyzer = fieldAnalyzer(key, f)
+			qry.AddMustNot(q)
 		}
-		qry = bleve.NewConjunctionQuery(cns...)
-	} else {
-		qry = bleve.NewMatchPhraseQuery(strings.TrimSpace(name))
 	}
 
 	req := bleve.NewSearchRequest(qry)
-	req.Size = 1000
-
-	res, err := idx.Search(req)
-	if err != nil {
-		return nil, err
+	req.Size = cfg.MaxHits
+
+	// the hot tier first, the full index if it has too little
+	var res *bleve.SearchResult
+	if hot := hotIndex(key); hot != nil && i == indexDB {
+		res, err = searchIndex(ctx, key, hot, req)
+		if err == nil && len(res.Hits) >= cfg.HotMin {
+			idx = hot
+		} else {
+			res = nil
+		}
+	}
+	if res == nil {
+		res, err = searchIndex(ctx, key, idx, req)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	out := make(map[string][]string, len(res.Hits))
 	for _, v := range res.Hits {
+		if p := i.postingOf(key, v.ID); p != nil {
+			n := bestName(p.names, name)
+			out[n] = append(out[n], p.ids...)
+			continue
+		}
 		doc, err := idx.Document(v.ID)
 		if err != nil {
 			return nil, err
 		}
-		out[string(doc.Fields[0].Value())] = append(out[string(doc.Fields[0].Value())], v.ID)
+		if doc == nil {
+			continue // deleted since the search, see editDoc
+		}
+		n := docName(doc, field(key, "name"), name)
+		out[n] = append(out[n], strings.Split(v.ID, "|")[0])
 	}
 
 	for k, v := range out {
-		for i := range v {
-			v[i] = strings.Split(v[i], "|")[0]
+		if v = liveKeys(kind, remDupl(v)); len(v) > 0 {
+			out[k] = v
+		} else {
+			delete(out, k)
 		}
-		out[k] = remDupl(v)
+	}
+	if i == indexDB {
+		countReturned(key, out)
 	}
 
 	return out, nil
 }
 
+// splitExcluded separates "-word" tokens (нурофен -детский) from the query
+func splitExcluded(name string) (string, []string) {
+	var incl, excl []string
+	for _, v := range strings.Fields(name) {
+		if len(v) > 1 && v[0] == '-' {
+			if w := strings.TrimSpace(normName(v[1:])); w != "" {
+				excl = append(excl, w)
+			}
+			continue
+		}
+		incl = append(incl, v)
+	}
+	return strings.Join(incl, " "), excl
+}
+
+// withExcluded adds the words of the exclude request field as "-word"
+func withExcluded(name string, exclude []string) string {
+	for _, v := range exclude {
+		if v = strings.TrimSpace(v); v != "" {
+			name += " -" + strings.TrimPrefix(v, "-")
+		}
+	}
+	return name
+}
+
+// docName returns the name (in the field) of doc that matches name best,
+// the main one if several match equally.
+func docName(doc *document.Document, field, name string) string {
+	var names []string
+	for _, f := range doc.Fields {
+		if f.Name() == field {
+			names = append(names, string(f.Value()))
+		}
+	}
+	return bestName(names, name)
+}
+
+// bestName returns the name (of the names of a doc) that matches best
+func bestName(names []string, name string) string {
+	res, best := "", -1
+	for _, n := range names {
+		if r := matchRank(n, name); r > best {
+			res, best = n, r
+		}
+	}
+	return res
+}
+
 type index struct {
 	sync.RWMutex
 	store map[string]bleve.Index
 	vault map[string]*sync.Map
-	sales map[int]int
+
+	gen     *generation   // current, nil before the first upload
+	history []*generation // previous ones for rollback, newest last
 }
 
 func (i *index) getIndex(key string) (bleve.Index, error) {
@@ -914,12 +1443,16 @@ var mapKB = map[string][]rune{
 }
 
 func convString(s, from, to string) string {
-	lang1 := mapKB[from]
-	lang2 := mapKB[to]
+	lang1 := keyboard(from)
+	lang2 := keyboard(to)
 	if lang1 == nil || lang2 == nil {
 		return s
 	}
+	return convRunes(s, lang1, lang2)
+}
 
+// convRunes converts s key by key from the layout lang1 to lang2
+func convRunes(s string, lang1, lang2 []rune) string {
 	src := []rune(s)
 	res := make([]rune, len(src))
 	for i := range src {
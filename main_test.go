@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testHandler serves the embedded fixtures, uploaded through the upload
+// endpoints the way the data pipeline does
+var testHandler http.Handler
+
+func TestMain(m *testing.M) {
+	log.SetFlags(0)
+	cfg.QueryLogOff = true
+	testHandler = setupHandler(http.NewServeMux())
+	for _, v := range []struct {
+		path string
+		body []byte
+	}{
+		{"/test/upload-sugg", fixtureSugg},
+		{"/test/upload-sugg2", fixtureSales},
+	} {
+		w := serve("POST", v.path, string(v.body), false)
+		if w.Code != http.StatusOK {
+			log.Fatalf("%s: %d %s", v.path, w.Code, w.Body)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// serve runs the request through testHandler, ua asks for Ukrainian
+func serve(method, path, body string, ua bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:1"
+	if ua {
+		r.Header.Set("Accept-Language", "uk")
+	}
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlers(t *testing.T) {
+	for _, v := range []struct {
+		method, path, body string
+		ua                 bool
+		code               int
+		want               string
+	}{
+		{"POST", "/test/select-sugg", `{"name":"кислота"}`, false, http.StatusOK, "АСКОРБИНОВАЯ КИСЛОТА"},
+		{"POST", "/test/select-sugg", `{"name":"rbckjnf"}`, false, http.StatusOK, "АСКОРБИНОВАЯ КИСЛОТА"},
+		{"POST", "/test/select-sugg", `{"name":"кислота"}`, true, http.StatusOK, "АСКОРБІНОВА КИСЛОТА"},
+		{"POST", "/test/select-suggestion", `{"name":"кислота"}`, false, http.StatusOK, `"101"`},
+		{"POST", "/test/select-suggestion", `{"name":"кислота"}`, true, http.StatusOK, `"101"`},
+		{"POST", "/test/select-suggestion", `{"name":"yehjaty"}`, false, http.StatusOK, `"103"`},
+		{"GET", "/test/suggest?q=%D0%BA%D0%B8%D1%81%D0%BB%D0%BE%D1%82%D0%B0", "", false, http.StatusOK, `"101"`},
+		{"GET", "/test/suggest", "", false, http.StatusBadRequest, ""},
+		{"POST", "/test/spellcheck", `{"name":"кислота"}`, false, http.StatusOK, `"known":true`},
+		{"POST", "/test/select-name", `{"name":"дарница"}`, false, http.StatusOK, `"401"`},
+		{"GET", "/test/select-sugg", "", false, http.StatusMethodNotAllowed, ""},
+		{"GET", "/test/upload-sugg", "", false, http.StatusMethodNotAllowed, ""},
+		{"POST", "/test/select-suggestion", `{"name":`, false, http.StatusBadRequest, ""},
+		{"POST", "/test/select-suggestion", `{"name":"ки"}`, false, http.StatusBadRequest, ""},
+		{"POST", "/test/select-suggestion", `{"name":"кислота","limit":-1}`, false, http.StatusBadRequest, ""},
+		{"POST", "/test/select-suggestion", `{"name":"кислота","limit":1,"min":-1}`, false, http.StatusBadRequest, ""},
+		{"POST", "/test/select-suggestion", `{"name":"кислота","limit":1,"min":1,"min_kind":{"inf":-1}}`, false, http.StatusBadRequest, ""},
+		{"POST", "/test/select-suggestion", `{"name":"кислота","limit":1,"min_kind":{"inf":0}}`, false, http.StatusOK, ""},
+		{"POST", "/test/upload-sugg", "kind,id\ninf,1\n", false, http.StatusBadRequest, ""},
+	} {
+		w := serve(v.method, v.path, v.body, v.ua)
+		if w.Code != v.code || !strings.Contains(w.Body.String(), v.want) {
+			t.Errorf("%s %s %s: %d, want %d and %s in %q", v.method, v.path, v.body, w.Code, v.code, v.want, w.Body.String())
+		}
+	}
+}
+
+// TestRanking checks the order of the results: whole words before
+// substrings, then the info flag and the sales of the fixtures
+func TestRanking(t *testing.T) {
+	for _, v := range []struct {
+		path, name string
+		ua         bool
+		want       []string
+	}{
+		{"/test/select-sugg", "кислота", false, []string{`"АСКОРБИНОВАЯ КИСЛОТА ТАБЛЕТКИ`, `"ОКИСЛОТАН`}},
+		{"/test/select-sugg", "кислота", true, []string{`"АСКОРБІНОВА КИСЛОТА ТАБЛЕТКИ`, `"ОКИСЛОТАН`}},
+		{"/test/select-suggestion", "кислота", false, []string{`"sugg_inf"`, `"101"`, `"sugg_inn"`, `"203"`}},
+		{"/test/select-suggestion", "yehjaty", false, []string{`"103"`, `"104"`}},
+		{"/test/select-suggestion", "нурофен", false, []string{`"103"`, `"104"`}},
+		{"/test/select-suggestion", "парацетамол", true, []string{`"105"`, `"106"`}},
+	} {
+		b := serve("POST", v.path, `{"name":"`+v.name+`"}`, v.ua).Body.String()
+		if !inOrder(b, v.want...) {
+			t.Errorf("%s %q: want %q in order in %s", v.path, v.name, v.want, b)
+		}
+	}
+}
+
+// inOrder reports whether all of subs are in s one after the other
+func inOrder(s string, subs ...string) bool {
+	for _, sub := range subs {
+		i := strings.Index(s, sub)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(sub):]
+	}
+	return true
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru" // stemmer_ru_snowball, stop_ru
+	"github.com/blevesearch/bleve/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/token/ngram"
+	"github.com/blevesearch/bleve/analysis/token/stop"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/analysis/tokenmap"
+	"github.com/blevesearch/bleve/index/scorch"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// nameDoc is the indexed part of baseDoc: the local names are analyzed with
+// the standard chain plus stress mark and dosage unit normalization, the
+// Latin (brand) name with the English analyzer, EAN barcodes as keywords
+// and the registration number as one lowercased term (for prefix search),
+// as is the ATC code if the kind mapping asks for it.
+type nameDoc struct {
+	Name  []string `json:"name"`
+	Latin string   `json:"latin,omitempty"`
+	EAN   []string `json:"ean,omitempty"`
+	Reg   string   `json:"reg,omitempty"`
+	Code  string   `json:"code,omitempty"`
+}
+
+func (d *baseDoc) nameDoc() nameDoc {
+	doc := nameDoc{Name: append([]string{d.Name}, d.Names...), Latin: d.Latin, EAN: d.EAN, Reg: d.Reg}
+	if i := strings.IndexByte(d.Name, '|'); i >= 0 && cfg.Mappings[d.Kind].Code {
+		doc.Code = strings.TrimSpace(d.Name[:i])
+	}
+	return doc
+}
+
+// Index layouts, see config.IndexLayout
+const (
+	layoutKindLang = ""
+	layoutKind     = "kind"
+	layoutSingle   = "single"
+)
+
+// singleIndex is the name of the index of layoutSingle
+const singleIndex = "all"
+
+// indexDoc returns what is indexed of d in the index of the key: the
+// nameDoc or its fields of the key language, a single index adds the kind
+// and language keywords
+func (d *baseDoc) indexDoc(key string) interface{} {
+	doc := d.nameDoc()
+	if cfg.IndexLayout == layoutKindLang {
+		return doc
+	}
+	m := map[string]interface{}{field(key, "name"): doc.Name}
+	if doc.Latin != "" {
+		m[field(key, "latin")] = doc.Latin
+	}
+	if len(doc.EAN) > 0 {
+		m[field(key, "ean")] = doc.EAN
+	}
+	if doc.Reg != "" {
+		m[field(key, "reg")] = doc.Reg
+	}
+	if doc.Code != "" {
+		m[field(key, "code")] = doc.Code
+	}
+	if cfg.IndexLayout == layoutSingle {
+		m["kind"], m["lang"] = d.Kind, keyLang(key)
+	}
+	return m
+}
+
+// indexName returns the name of the index the key is searched in: the
+// key itself, its kind or singleIndex after cfg.IndexLayout
+func indexName(key string) string {
+	switch cfg.IndexLayout {
+	case layoutKind:
+		return strings.Split(key, "-")[0]
+	case layoutSingle:
+		return singleIndex
+	}
+	return key
+}
+
+// field returns the name of the field f in the index of the key
+func field(key, f string) string {
+	if cfg.IndexLayout != layoutKindLang {
+		return f + "_" + keyLang(key)
+	}
+	return f
+}
+
+// fieldAnalyzer returns the analyzer of the query text for the field f
+// in the index of the key, "" for the one of the field: in a single index
+// the name fields of the kinds differ
+func fieldAnalyzer(key, f string) string {
+	if cfg.IndexLayout != layoutSingle || f != "name" {
+		return ""
+	}
+	return "name_" + strings.Split(key, "-")[0] + "_" + keyLang(key)
+}
+
+func keyLang(key string) string {
+	return key[strings.LastIndexByte(key, '-')+1:]
+}
+
+// scopeQuery limits q to the docs of the key in a single index, the kind
+// and language terms do not score
+func scopeQuery(key string, q query.Query) query.Query {
+	if cfg.IndexLayout != layoutSingle {
+		return q
+	}
+	kind := bleve.NewTermQuery(strings.Split(key, "-")[0])
+	kind.SetField("kind")
+	kind.SetBoost(0)
+	lang := bleve.NewTermQuery(keyLang(key))
+	lang.SetField("lang")
+	lang.SetBoost(0)
+	return bleve.NewConjunctionQuery(q, kind, lang)
+}
+
+// kindMapping tunes the index of one kind, see config.Mappings
+type kindMapping struct {
+	// Filters are added to the token filters of the name analyzer:
+	// legal_forms or any bleve one (stemmer_ru_snowball, stop_ru, ...)
+	Filters []string `json:"filters,omitempty"`
+	// Code indexes the ATC code (the name up to "|") as a keyword, found
+	// by its prefix
+	Code bool `json:"code,omitempty"`
+	// EdgeNgram [min, max] indexes the word starts of the names, so that
+	// a whole word search also finds names by word prefixes
+	EdgeNgram []int `json:"edge_ngram,omitempty"`
+	// Ngram [min, max] indexes the parts of the name words (infix), so
+	// that an infix search looks terms up instead of scanning them all
+	// with a wildcard, see infixQuery
+	Ngram []int `json:"ngram,omitempty"`
+	// LangFilters are added to the name analyzer of one language only
+	// ("ru": ["stemmer_ru_snowball"])
+	LangFilters map[string][]string `json:"lang_filters,omitempty"`
+}
+
+func defaultMappings() map[string]kindMapping {
+	ngram := []int{3, 8}
+	return map[string]kindMapping{
+		"atc": {Code: true, Ngram: ngram},
+		"inf": {Ngram: ngram},
+		"inn": {Ngram: ngram},
+		"act": {Ngram: ngram},
+		"org": {Filters: []string{"legal_forms"}, Ngram: ngram},
+	}
+}
+
+// hasFilter tells if the name analyzer of the kind has the token filter
+func (k kindMapping) hasFilter(name string) bool {
+	return contains(k.Filters, name)
+}
+
+// newIndex creates an empty index named by indexName, in memory or at
+// path as a scorch one (bleve.Open reads the type, the upsidedown ones of
+// older dirs open as well)
+func newIndex(name, path string) (bleve.Index, error) {
+	m, err := newIndexMapping(name)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %v", name, err)
+	}
+	if path != "" {
+		return bleve.NewUsing(path, m, scorch.Name, scorch.Name, nil)
+	}
+	return bleve.NewMemOnly(m)
+}
+
+// newIndexMapping returns the mapping of the index after cfg.Mappings: a
+// kind-lang one has the fields of its language, a kind one those of both
+// suffixed with the language, each with its own name analyzer. The single
+// one maps every kind as a doc type (the kind field) with its analyzers.
+func newIndexMapping(name string) (mapping.IndexMapping, error) {
+	kinds, langs := []string{name}, langOrder
+	if name == singleIndex {
+		kinds = kindOrder
+	} else if i := strings.IndexByte(name, '-'); i >= 0 {
+		kinds, langs = []string{name[:i]}, []string{name[i+1:]}
+	}
+
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomTokenMap("legal_forms", map[string]interface{}{
+		"type":   tokenmap.Name,
+		"tokens": legalFormTokens(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = m.AddCustomTokenFilter("legal_forms", map[string]interface{}{
+		"type":           stop.Name,
+		"stop_token_map": "legal_forms",
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = m.AddCustomAnalyzer("reg", map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kinds) == 1 {
+		doc, err := addKindMapping(m, kinds[0], "", langs)
+		if err != nil {
+			return nil, err
+		}
+		m.DefaultMapping = doc
+		return m, nil
+	}
+
+	m.TypeField = "kind"
+	for _, kind := range kinds {
+		doc, err := addKindMapping(m, kind, "_"+kind, langs)
+		if err != nil {
+			return nil, err
+		}
+		kw := bleve.NewTextFieldMapping()
+		kw.Analyzer = keyword.Name
+		kw.Store = false
+		doc.AddFieldMappingsAt("kind", kw)
+		doc.AddFieldMappingsAt("lang", kw)
+		m.AddDocumentMapping(kind, doc)
+	}
+	m.DefaultMapping = bleve.NewDocumentDisabledMapping()
+	return m, nil
+}
+
+// addKindMapping adds the analyzers of the kind (suffixed with sfx) to m
+// and returns the mapping of its docs
+func addKindMapping(m *mapping.IndexMappingImpl, kind, sfx string, langs []string) (*mapping.DocumentMapping, error) {
+	km := cfg.Mappings[kind]
+
+	if len(km.EdgeNgram) == 2 {
+		err := m.AddCustomTokenFilter("edge_ngram"+sfx, map[string]interface{}{
+			"type": edgengram.Name,
+			"back": false,
+			"min":  float64(km.EdgeNgram[0]),
+			"max":  float64(km.EdgeNgram[1]),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(km.Ngram) == 2 {
+		err := m.AddCustomTokenFilter("ngram"+sfx, map[string]interface{}{
+			"type": ngram.Name,
+			"min":  float64(km.Ngram[0]),
+			"max":  float64(km.Ngram[1]),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc := bleve.NewDocumentMapping()
+	for _, lang := range langs {
+		s := "" // field suffix
+		if len(langs) > 1 {
+			s = "_" + lang
+		}
+
+		filters := append([]string{lowercase.Name, en.StopName}, km.Filters...)
+		filters = append(filters, km.LangFilters[lang]...)
+		err := m.AddCustomAnalyzer("name"+sfx+s, map[string]interface{}{
+			"type":          custom.Name,
+			"char_filters":  []string{stressCharFilterName, unitsCharFilterName},
+			"tokenizer":     unicode.Name,
+			"token_filters": filters,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		name := bleve.NewTextFieldMapping()
+		name.Analyzer = "name" + sfx + s
+		latin := bleve.NewTextFieldMapping()
+		latin.Analyzer = en.AnalyzerName
+		ean := bleve.NewTextFieldMapping()
+		ean.Analyzer = keyword.Name
+		reg := bleve.NewTextFieldMapping()
+		reg.Analyzer = "reg"
+		code := bleve.NewTextFieldMapping()
+		code.Analyzer = "reg"
+
+		names := []*mapping.FieldMapping{name}
+		doc.AddFieldMappingsAt("latin"+s, latin)
+		doc.AddFieldMappingsAt("ean"+s, ean)
+		doc.AddFieldMappingsAt("reg"+s, reg)
+		doc.AddFieldMappingsAt("code"+s, code)
+
+		if len(km.EdgeNgram) == 2 {
+			err = m.AddCustomAnalyzer("prefix"+sfx+s, map[string]interface{}{
+				"type":          custom.Name,
+				"char_filters":  []string{stressCharFilterName, unitsCharFilterName},
+				"tokenizer":     unicode.Name,
+				"token_filters": append(filters, "edge_ngram"+sfx),
+			})
+			if err != nil {
+				return nil, err
+			}
+			prefix := bleve.NewTextFieldMapping()
+			prefix.Name = "prefix" + s
+			prefix.Analyzer = "prefix" + sfx + s
+			prefix.Store = false
+			names = append(names, prefix)
+		}
+		if len(km.Ngram) == 2 {
+			err = m.AddCustomAnalyzer("infix"+sfx+s, map[string]interface{}{
+				"type":          custom.Name,
+				"char_filters":  []string{stressCharFilterName, unitsCharFilterName},
+				"tokenizer":     unicode.Name,
+				"token_filters": append(filters[:len(filters):len(filters)], "ngram"+sfx),
+			})
+			if err != nil {
+				return nil, err
+			}
+			infix := bleve.NewTextFieldMapping()
+			infix.Name = "infix" + s
+			infix.Analyzer = "infix" + sfx + s
+			infix.Store = false
+			infix.IncludeTermVectors = false
+			names = append(names, infix)
+		}
+		doc.AddFieldMappingsAt("name"+s, names...)
+	}
+	return doc, nil
+}
+
+// checkMappings builds an empty index of every kind to catch config errors
+func checkMappings() error {
+	for _, k := range kindOrder {
+		for lang := range cfg.Mappings[k].LangFilters {
+			if lang != "ru" && lang != "ua" {
+				return fmt.Errorf("mapping %s: unknown language %q", k, lang)
+			}
+		}
+		if n := cfg.Mappings[k].Ngram; len(n) > 0 && (len(n) != 2 || n[0] < 1 || n[0] > n[1]) {
+			return fmt.Errorf("mapping %s: ngram %v: want [min, max]", k, n)
+		}
+		for _, lang := range langOrder {
+			idx, err := newIndex(indexName(k+"-"+lang), "")
+			if err != nil {
+				return err
+			}
+			_ = idx.Close()
+		}
+	}
+	return nil
+}
+
+// infixQuery returns the lookup of the word anywhere in the name words of
+// the index of the key in their n-grams, nil with no n-grams or a word too
+// short for them (searched with a wildcard). A word longer than the n-grams
+// matches the names with all its longest ones, in the same word or not.
+func infixQuery(key, w string) query.Query {
+	km := cfg.Mappings[strings.Split(key, "-")[0]]
+	r := []rune(w)
+	if len(km.Ngram) != 2 || len(r) < km.Ngram[0] {
+		return nil
+	}
+
+	fld := field(key, "infix")
+	if len(r) <= km.Ngram[1] {
+		q := bleve.NewTermQuery(w)
+		q.SetField(fld)
+		return q
+	}
+	var cns []query.Query
+	for i := 0; i+km.Ngram[1] <= len(r); i++ {
+		q := bleve.NewTermQuery(string(r[i : i+km.Ngram[1]]))
+		q.SetField(fld)
+		cns = append(cns, q)
+	}
+	return bleve.NewConjunctionQuery(cns...)
+}
+
+// mappingQueries returns the extra queries of the kind mapping for a
+// normalized name searched in the index of the key: the code prefix and
+// (for a whole word search) the word starts.
+func mappingQueries(key, name string, conj bool) []query.Query {
+	km := cfg.Mappings[strings.Split(key, "-")[0]]
+	words := queryWords(name)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var res []query.Query
+	if km.Code && len(words) == 1 {
+		q := bleve.NewPrefixQuery(words[0])
+		q.SetField(field(key, "code"))
+		res = append(res, q)
+	}
+	if len(km.EdgeNgram) == 2 && !conj {
+		cns := make([]query.Query, len(words))
+		for i, w := range words {
+			if r := []rune(w); len(r) > km.EdgeNgram[1] {
+				w = string(r[:km.EdgeNgram[1]])
+			}
+			q := bleve.NewTermQuery(w)
+			q.SetField(field(key, "prefix"))
+			cns[i] = q
+		}
+		res = append(res, bleve.NewConjunctionQuery(cns...))
+	}
+	return res
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// benchSugg returns n synthetic rows of the sugg csv per kind and
+// language, names of made-up words, and queries of their words
+func benchSugg(n int) ([][]string, []string) {
+	syll := []string{"ка", "ло", "ми", "ре", "ста", "до", "фен", "ин", "ол", "ци", "на", "про", "тра", "зол", "мак", "сил"}
+	word := func(i int) string {
+		return syll[i%16] + syll[i/16%16] + syll[i/256%16]
+	}
+
+	rec := [][]string{{"kind", "id", "name_ru", "name_ua", "info", "lang", "latin", "ean", "reg"}}
+	for _, k := range []string{"inf", "inn", "org"} {
+		for i := 0; i < n; i++ {
+			name := word(i*7919%4096) + " " + word(i*104729%4096) + " " + strconv.Itoa(i%500) + " мг"
+			for _, lang := range []string{"RU", "UA"} {
+				rec = append(rec, []string{k, strconv.Itoa(i + 1), name, name, strconv.Itoa(i + 1), lang, "", "", ""})
+			}
+		}
+	}
+
+	queries := make([]string, 300)
+	for i := range queries {
+		queries[i] = word(i * 13 % 4096)
+		if i%3 == 0 {
+			queries[i] = string([]rune(word(i * 7919 % 4096))[:4])
+		}
+	}
+	return rec, queries
+}
+
+// BenchmarkIndexLayout searches the same rows in each index layout (see
+// config.IndexLayout): the name search of every kind, then select-sugg
+func BenchmarkIndexLayout(b *testing.B) {
+	defer func(c config) {
+		*cfg = c
+		if err := loadFixtures(); err != nil {
+			b.Fatal(err)
+		}
+	}(*cfg)
+
+	rec, queries := benchSugg(2000)
+	for _, layout := range []string{layoutKindLang, layoutKind, layoutSingle} {
+		cfg.IndexLayout = layout
+		if err := ingestSugg(rec); err != nil {
+			b.Fatal(err)
+		}
+
+		name := layout
+		if name == layoutKindLang {
+			name = "kind-lang"
+		}
+		b.Run(name+"/find", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				q := queries[i%len(queries)]
+				for _, k := range []string{"inf", "inn", "org"} {
+					if _, err := findByName(context.Background(), kindKey(k, false), q, modeInfix); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(name+"/select-sugg", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body := `{"name":"` + strings.ToUpper(queries[i%len(queries)]) + `"}`
+				if w := serve("POST", "/test/select-sugg", body, false); w.Code != 200 {
+					b.Fatalf("%s: %d %s", body, w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
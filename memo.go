@@ -0,0 +1,222 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Incremental prefix typing: /test/select-sugg answers with the token of
+// the request in X-Query-Token. The next request may pass it as "prev";
+// if the new query only narrows the previous one (every previous word is
+// a part of a new word, no excluded word dropped) the previous hits are
+// filtered instead of searching again. Anything else, including a new
+// generation, changed rules or truncated hits, falls back to the search.
+//
+// $ curl -i -d '{"name":"пара"}' http://localhost:8080/test/select-sugg
+// $ curl -i -d '{"name":"парац","prev":"4:5d41..."}' http://localhost:8080/test/select-sugg
+
+type memo struct {
+	name, conv string
+	ua         bool
+	gen        int64
+	seq        uint64
+	inferred   []string
+	disabled   []string
+	hits       map[string]map[string][]string // kind: name: keys
+	stages     map[string]string              // kind: the pipeline stage that found it
+	exp        time.Time
+}
+
+var memos = struct {
+	sync.RWMutex
+	m map[string]*memo
+}{m: make(map[string]*memo)}
+
+// memoState returns the generation and the rules/kinds sequence the
+// hits depend on
+func memoState() (int64, uint64) {
+	g, _ := indexDB.generations()
+	if g == nil {
+		return 0, 0
+	}
+	return g.ID, atomic.LoadUint64(&etagSeq)
+}
+
+func putMemo(key string, m *memo) {
+	if cfg.CacheTTL <= 0 {
+		return
+	}
+
+	memos.Lock()
+	defer memos.Unlock()
+
+	now := time.Now()
+	if len(memos.m) >= cfg.CacheSize {
+		for k, e := range memos.m {
+			if now.After(e.exp) {
+				delete(memos.m, k)
+			}
+		}
+		if len(memos.m) >= cfg.CacheSize {
+			return
+		}
+	}
+	m.exp = now.Add(time.Duration(cfg.CacheTTL) * time.Second)
+	memos.m[key] = m
+}
+
+func getMemo(key string) *memo {
+	memos.RLock()
+	defer memos.RUnlock()
+
+	m, ok := memos.m[key]
+	if !ok || time.Now().After(m.exp) {
+		return nil
+	}
+	return m
+}
+
+// narrowHits filters the hits of the prev request down to name (and its
+// conversion conv), ok is false when they may miss something.
+func narrowHits(prev, name, conv string, ua bool, res *result) (*memo, bool) {
+	if prev == "" || res.db != nil {
+		return nil, false
+	}
+	m := getMemo(prev)
+	if m == nil || m.ua != ua {
+		return nil, false
+	}
+	if gen, seq := memoState(); gen != m.gen || seq != m.seq {
+		return nil, false
+	}
+	var inferred []string
+	if res.Meta != nil {
+		inferred = res.Meta.Inferred
+	}
+	if strings.Join(inferred, ",") != strings.Join(m.inferred, ",") {
+		return nil, false
+	}
+	if !narrows(m.name, name) || !narrows(m.conv, conv) {
+		return nil, false
+	}
+	// fuzzy hits are not replayed, and no hits are searched fuzzy again,
+	// see findAll
+	n := 0
+	for kind, hits := range m.hits {
+		if searchMode(m.stages[kind]).fuzziness() > 0 {
+			return nil, false
+		}
+		n += len(hits)
+	}
+	if n == 0 && res.fuzziness > 0 {
+		return nil, false
+	}
+	// matchesAll replays infix matches only
+	if len(prefixOnly(m.name+" "+m.conv)) > 0 || len(prefixOnly(name+" "+conv)) > 0 {
+		return nil, false
+	}
+
+	out := make(map[string]map[string][]string, len(m.hits))
+	for kind, hits := range m.hits {
+		n := 0
+		for _, keys := range hits {
+			n += len(keys)
+		}
+		if n >= cfg.MaxHits {
+			return nil, false // truncated
+		}
+
+		// only the exact and layout stages can be replayed by matchesAll
+		stages := pipelineFor(epSugg, kind)
+		for _, st := range stages {
+			if st != "exact" && st != "layout" {
+				return nil, false
+			}
+		}
+		q := name
+		if m.stages[kind] == "layout" {
+			q = conv
+		}
+		vlt, _ := indexDB.getVault(kindKey(kind, ua))
+
+		out[kind] = make(map[string][]string, len(hits))
+		for k, keys := range hits {
+			latin := ""
+			if vlt != nil && len(keys) > 0 {
+				if d, ok := vlt.Load(keys[0]); ok {
+					latin = d.(*baseDoc).Latin
+				}
+			}
+			if matchesAll(k, q) || (latin != "" && matchesAll(latin, q)) {
+				out[kind][k] = keys
+			}
+		}
+		if len(hits) > 0 && len(out[kind]) == 0 && m.stages[kind] != stages[len(stages)-1] {
+			return nil, false // the search would go on to the next stage
+		}
+	}
+
+	if len(m.disabled) > 0 {
+		res.meta().Disabled = m.disabled
+	}
+	return &memo{hits: out, stages: m.stages}, true
+}
+
+// narrows tells if every match of query next is a match of prev too
+func narrows(prev, next string) bool {
+	pw, px := splitExcluded(prev)
+	nw, nx := splitExcluded(next)
+	for _, v := range px {
+		if !contains(nx, v) {
+			return false
+		}
+	}
+	next = strings.ToLower(normName(nw))
+	for _, p := range strings.Fields(strings.ToLower(normName(pw))) {
+		found := false
+		for _, n := range strings.Fields(next) {
+			if strings.Contains(n, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAll mimics the conjunctive search: every query word is a part of
+// a word of s and no excluded word is a word of s.
+func matchesAll(s, query string) bool {
+	words := strings.Fields(strings.ToLower(normName(s)))
+	query, excl := splitExcluded(query)
+	for _, x := range excl {
+		if contains(words, strings.ToLower(x)) {
+			return false
+		}
+	}
+	for _, q := range strings.Fields(strings.ToLower(normName(query))) {
+		found := false
+		for _, w := range words {
+			if strings.Contains(w, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func kindKey(kind string, ua bool) string {
+	if ua {
+		return kind + "-ua"
+	}
+	return kind + "-ru"
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/blevesearch/bleve"
+)
+
+// The memory report estimates what the data structures hold, to see which
+// of the indexes (or vaults, caches) is eating the heap. The indexes, their
+// vaults and postings are estimated once when a generation is built (see
+// estimateSizes), the caches when asked for, each under its own lock. The
+// figures count the strings, slices and map entries a structure keeps,
+// roughly: they are for comparing the parts, runtime.MemStats has the
+// exact heap. A cold index is on disk and mapped, its files are listed as
+// disk bytes.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/memory
+
+// rough costs of the Go structures, in bytes
+const (
+	sizeString   = int64(unsafe.Sizeof(""))
+	sizeSlice    = int64(unsafe.Sizeof([]string(nil)))
+	sizeMapEntry = 48 // key and value headers plus the bucket share
+	sizeSyncMap  = 96 // an entry of a sync.Map: the map entry and its boxed value
+	sizeTermRow  = 32 // a term frequency row of an index besides its key
+)
+
+type memoryPart struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Count int    `json:"count"`          // entries, docs or terms
+	Disk  int64  `json:"disk,omitempty"` // of a cold index
+}
+
+func strBytes(ss ...string) int64 {
+	n := int64(len(ss)) * sizeString
+	for _, s := range ss {
+		n += int64(len(s))
+	}
+	return n
+}
+
+// estimateSizes fills g.sizes with the parts of the generation
+func (g *generation) estimateSizes() {
+	var parts []memoryPart
+	keys := make([]string, 0, len(g.vault))
+	for k := range g.vault {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[bleve.Index]bool, len(g.store))
+	for _, key := range keys {
+		idx, ok := g.store[key]
+		if !ok || seen[idx] {
+			continue // a shared index, see indexName
+		}
+		seen[idx] = true
+		p := memoryPart{Name: "index " + indexName(key)}
+		if l, ok := idx.(*lazyIndex); !ok || l.isOpen() {
+			p = indexSize(p.Name, idx) // not opened for it
+		}
+		p.Disk = dirSize(coldPath(g.dir, indexName(key)))
+		parts = append(parts, p)
+	}
+
+	for _, key := range keys {
+		p := memoryPart{Name: "vault " + key}
+		g.vault[key].Range(func(k, v interface{}) bool {
+			d := v.(*baseDoc)
+			p.Count++
+			p.Bytes += sizeSyncMap + strBytes(k.(string)) + int64(unsafe.Sizeof(*d))
+			p.Bytes += strBytes(d.Name, d.Latin, d.Reg, d.Raw) + strBytes(d.EAN...) + strBytes(d.Names...) + strBytes(d.Row...)
+			return true
+		})
+		parts = append(parts, p)
+	}
+
+	for _, key := range keys {
+		p := memoryPart{Name: "postings " + key}
+		for id, ps := range g.postings[key] {
+			p.Count++
+			// the names and ids are the strings of the vault
+			p.Bytes += sizeMapEntry + strBytes(id) + int64(unsafe.Sizeof(*ps)) + int64(len(ps.names)+len(ps.ids))*sizeString
+		}
+		parts = append(parts, p)
+	}
+
+	p := memoryPart{Name: "letters"}
+	for _, byLetter := range g.letters {
+		for l, list := range byLetter {
+			p.Bytes += sizeMapEntry + strBytes(l) + sizeSlice
+			for _, s := range list {
+				p.Count++
+				p.Bytes += int64(unsafe.Sizeof(s)) + int64(len(s.Keys))*sizeString
+			}
+		}
+	}
+	parts = append(parts, p)
+
+	g.sizes = parts
+}
+
+// indexSize estimates an index by its term dictionaries: a row per term
+// and doc, keyed by the term and the doc ID
+func indexSize(name string, idx bleve.Index) memoryPart {
+	p := memoryPart{Name: name}
+	n, err := idx.DocCount()
+	if err != nil {
+		return p
+	}
+	p.Count = int(n)
+	fields, err := idx.Fields()
+	if err != nil {
+		return p
+	}
+	const idLen = 8 // "n12345|ru"
+	for _, f := range fields {
+		dict, err := idx.FieldDict(f)
+		if err != nil {
+			continue
+		}
+		for e, err := dict.Next(); e != nil && err == nil; e, err = dict.Next() {
+			p.Bytes += int64(len(e.Term)) + sizeTermRow + int64(e.Count)*(int64(len(e.Term))+idLen+sizeTermRow)
+		}
+		_ = dict.Close()
+	}
+	p.Bytes += int64(n) * (idLen + sizeTermRow) // the back index rows
+	return p
+}
+
+// dirSize sums the files under dir, 0 for ""
+func dirSize(dir string) int64 {
+	if dir == "" {
+		return 0
+	}
+	var n int64
+	_ = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n
+}
+
+// cacheSizes estimates the structures that change between generations
+func cacheSizes(g *generation) []memoryPart {
+	var parts []memoryPart
+
+	if s, ok := dataStore.(*memStore); ok {
+		s.RLock()
+		for name, b := range s.m {
+			p := memoryPart{Name: "store " + name, Count: len(b)}
+			for k, v := range b {
+				p.Bytes += sizeMapEntry + strBytes(k) + sizeSlice + int64(len(v))
+			}
+			parts = append(parts, p)
+		}
+		s.RUnlock()
+	}
+
+	if c, ok := resCache.(*memCache); ok {
+		p := memoryPart{Name: "result cache"}
+		c.RLock()
+		for k, e := range c.m {
+			p.Count++
+			p.Bytes += sizeMapEntry + strBytes(k, e.ctype) + int64(len(e.b)) + sizeSlice
+		}
+		c.RUnlock()
+		parts = append(parts, p)
+	}
+
+	p := memoryPart{Name: "memo cache"}
+	memos.RLock()
+	for k, m := range memos.m {
+		p.Count++
+		p.Bytes += sizeMapEntry + strBytes(k, m.name, m.conv) + int64(unsafe.Sizeof(*m))
+		p.Bytes += strBytes(m.inferred...) + strBytes(m.disabled...)
+		for kind, hits := range m.hits {
+			p.Bytes += sizeMapEntry + strBytes(kind)
+			for name, keys := range hits {
+				p.Bytes += sizeMapEntry + strBytes(name) + sizeSlice + int64(len(keys))*sizeString
+			}
+		}
+	}
+	memos.RUnlock()
+	parts = append(parts, p)
+
+	p = memoryPart{Name: "zero cache"}
+	zeroCache.RLock()
+	for k, e := range zeroCache.m {
+		p.Count++
+		p.Bytes += sizeMapEntry + strBytes(k) + int64(unsafe.Sizeof(e))
+	}
+	zeroCache.RUnlock()
+	parts = append(parts, p)
+
+	hotTier.RLock()
+	for key, idx := range hotTier.idx {
+		parts = append(parts, indexSize("hot index "+key, idx))
+	}
+	hotTier.RUnlock()
+
+	p = memoryPart{Name: "samplers"}
+	samplers.Lock()
+	for k, s := range samplers.m {
+		p.Count += len(s.docs)
+		p.Bytes += sizeMapEntry + strBytes(k) + 2*sizeSlice + int64(len(s.docs))*16
+	}
+	samplers.Unlock()
+	parts = append(parts, p)
+
+	if g != nil {
+		p = memoryPart{Name: "compounds"}
+		g.compounds.Range(func(_, v interface{}) bool {
+			for t := range v.(compoundDict) {
+				p.Count++
+				p.Bytes += sizeMapEntry + strBytes(t)
+			}
+			return true
+		})
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+func adminMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	g, _ := indexDB.generations()
+	res := struct {
+		Generation int64        `json:"generation,omitempty"`
+		Time       time.Time    `json:"time"`
+		Total      int64        `json:"total"` // of the parts
+		Heap       uint64       `json:"heap"`  // runtime.MemStats.HeapAlloc
+		Parts      []memoryPart `json:"parts"` // largest first
+	}{Time: time.Now()}
+	if g != nil {
+		res.Generation = g.ID
+		res.Parts = append(res.Parts, g.sizes...)
+	}
+	res.Parts = append(res.Parts, cacheSizes(g)...)
+	sort.SliceStable(res.Parts, func(i, j int) bool { return res.Parts[i].Bytes > res.Parts[j].Bytes })
+	for _, p := range res.Parts {
+		res.Total += p.Bytes
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	res.Heap = ms.HeapAlloc
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
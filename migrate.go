@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Cold indexes outlive a restart: next to them a generation keeps its
+// source CSV and a stamp (generation.json) with the mapping version of
+// every index. At startup the newest cold generation is opened again and
+// the indexes whose mapping changed since (new analyzer, new fields) are
+// rebuilt from the source, unless cfg.MigrateOnStart is off: then they
+// serve as they are until a migration is asked for, which rebuilds the
+// generation from its source as a new upload.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/migrate
+// $ curl -i -H 'X-Api-Key: secret' -X POST 'http://localhost:8080/admin/migrate?queue=1'
+
+// mappingRevision is bumped when the analysis code changes in a way the
+// mapping does not show (char filters, token maps built in code)
+const mappingRevision = 3 // 2: deduplicated docs, see posting; 3: doc IDs by content
+
+const (
+	coldStamp  = "generation.json"
+	coldSource = "source.csv"
+)
+
+// mappingVersion identifies the mapping of the index, see indexName
+func mappingVersion(name string) string {
+	m, err := newIndexMapping(name)
+	if err != nil {
+		return ""
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\n", mappingRevision)
+	_, _ = h.Write(b)
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
+
+// staleIndexes returns the index keys of g built with an older mapping
+func staleIndexes(g *generation) []string {
+	var out []string
+	for _, lang := range langOrder {
+		for _, kind := range kindOrder {
+			key := kind + "-" + lang
+			if g.Versions[key] != mappingVersion(indexName(key)) {
+				out = append(out, key)
+			}
+		}
+	}
+	return out
+}
+
+// writeColdStamp keeps the source and the stamp of a cold generation
+func writeColdStamp(g *generation, rec [][]string) error {
+	if g.dir == "" {
+		return nil
+	}
+
+	f, err := os.Create(filepath.Join(g.dir, coldSource))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	err = w.WriteAll(rec)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		return err
+	}
+	// the stamp goes last, a dir without it is not reopened
+	return ioutil.WriteFile(filepath.Join(g.dir, coldStamp), b, 0644)
+}
+
+// cleanIndexDirs removes the index dirs of a reopened generation that are
+// not in use, left by another cfg.IndexLayout
+func cleanIndexDirs(dir string, used map[string]bleve.Index) {
+	if dir == "" {
+		return
+	}
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, v := range list {
+		if _, ok := used[v.Name()]; v.IsDir() && !ok {
+			_ = os.RemoveAll(filepath.Join(dir, v.Name()))
+		}
+	}
+}
+
+func readColdSource(dir string) ([][]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, coldSource))
+	if err != nil {
+		return nil, err
+	}
+	return readCSV(b)
+}
+
+// restoreColdDir opens the newest cold generation again, migrating its
+// stale indexes, and removes the other dirs
+func restoreColdDir() {
+	if cfg.ColdDir == "" {
+		return
+	}
+	list, err := ioutil.ReadDir(cfg.ColdDir)
+	if err != nil {
+		return
+	}
+
+	newest := ""
+	for _, v := range list {
+		if _, err := strconv.ParseInt(v.Name(), 10, 64); err != nil || !v.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(cfg.ColdDir, v.Name(), coldStamp)); err == nil && v.Name() > newest {
+			newest = v.Name()
+		}
+	}
+	cleanColdDir(newest)
+	if newest == "" {
+		return
+	}
+
+	dir := filepath.Join(cfg.ColdDir, newest)
+	err = restoreGeneration(dir)
+	if err != nil {
+		log.Printf("err: cold generation %s: %s", newest, err.Error())
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func restoreGeneration(dir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, coldStamp))
+	if err != nil {
+		return err
+	}
+	var stamp generation
+	err = json.Unmarshal(b, &stamp)
+	if err != nil {
+		return err
+	}
+	rec, err := readColdSource(dir)
+	if err != nil {
+		return err
+	}
+
+	// an index is kept if it is fresh or may stay stale, and is there: a
+	// switch of cfg.IndexLayout leaves none
+	stale := staleIndexes(&stamp)
+	keep := make(map[string]string, len(stamp.Versions))
+	for k, v := range stamp.Versions {
+		if _, err := os.Stat(coldPath(dir, indexName(k))); err == nil && (!cfg.MigrateOnStart || !contains(stale, k)) {
+			keep[k] = v
+		}
+	}
+	var rebuilt []string
+	for _, k := range stale {
+		if _, ok := keep[k]; !ok {
+			rebuilt = append(rebuilt, k)
+		}
+	}
+
+	start := time.Now()
+	g, err := buildGenerationIn(dir, rec, keep)
+	if err != nil {
+		return err
+	}
+	g.ID, g.Created = stamp.ID, stamp.Created
+	rankVaults(g.vault)
+	indexDB.swap(g)
+
+	switch {
+	case len(stale) == 0:
+		log.Printf("cold generation %d: %d rows", g.ID, g.Rows)
+	case len(rebuilt) > 0:
+		log.Printf("cold generation %d: %d rows, rebuilt %s in %s", g.ID, g.Rows, strings.Join(rebuilt, ", "), time.Since(start))
+		return writeColdStamp(g, rec)
+	default:
+		log.Printf("cold generation %d: %d rows, stale %s", g.ID, g.Rows, strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// migrate rebuilds the current generation from its source if an index
+// of it is stale
+func migrate(queue bool) (stale []string, queued bool, err error) {
+	g, _ := indexDB.generations()
+	if g == nil {
+		return nil, false, fmt.Errorf("no generation")
+	}
+	stale = staleIndexes(g)
+	if len(stale) == 0 {
+		return nil, false, nil
+	}
+	if g.dir == "" {
+		return nil, false, fmt.Errorf("generation %d has no source kept", g.ID)
+	}
+
+	rec, err := readColdSource(g.dir)
+	if err != nil {
+		return nil, false, err
+	}
+	queued, err = runIngest("migrate", len(rec)-1, queue, func() error { return ingestSugg(rec) })
+	return stale, queued, err
+}
+
+func adminMigrate(w http.ResponseWriter, r *http.Request) {
+	res := struct {
+		Generation int64             `json:"generation,omitempty"`
+		Stale      []string          `json:"stale"`
+		Versions   map[string]string `json:"mapping_versions"`
+	}{Stale: []string{}, Versions: make(map[string]string, 2*len(kindOrder))}
+	for _, lang := range langOrder {
+		for _, kind := range kindOrder {
+			name := indexName(kind + "-" + lang)
+			res.Versions[name] = mappingVersion(name)
+		}
+	}
+
+	switch r.Method {
+	case "GET":
+		if g, _ := indexDB.generations(); g != nil {
+			res.Generation = g.ID
+			res.Stale = append(res.Stale, staleIndexes(g)...)
+		}
+	case "POST":
+		stale, queued, err := migrate(wantQueue(r))
+		if ingestFailed(w, queued, err) {
+			return
+		}
+		res.Stale = append(res.Stale, stale...)
+		if g, _ := indexDB.generations(); g != nil {
+			res.Generation = g.ID
+		}
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
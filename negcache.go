@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Junk queries ("asdf", emoji) repeat and cost five searches plus the
+// layout conversion each time. Their empty verdict is kept for
+// cfg.NegativeTTL seconds, per search mode, language and inferred kinds.
+// A new generation or changed rules/kinds make it stale, see memoState.
+
+type zeroEntry struct {
+	gen int64
+	seq uint64
+	exp time.Time
+}
+
+var zeroCache = struct {
+	sync.RWMutex
+	m map[string]zeroEntry
+}{m: make(map[string]zeroEntry)}
+
+func zeroKey(conj, ua bool, res *result, name string) string {
+	var inferred []string
+	if res.Meta != nil {
+		inferred = res.Meta.Inferred
+	}
+	mode := string(res.sloppy(modePhrase))
+	if conj {
+		mode = "conj"
+	}
+	lang := "ru"
+	if ua {
+		lang = "ua"
+	}
+	if res.fuzziness > 0 {
+		mode += "|" + string(fuzzyMode(res.fuzziness))
+	}
+	return mode + "|" + lang + "|" + strings.Join(inferred, ",") + "|" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// knownZero tells if the query found nothing a moment ago
+func knownZero(conj, ua bool, res *result, name string) bool {
+	if cfg.NegativeTTL <= 0 || res.db != nil {
+		return false
+	}
+
+	zeroCache.RLock()
+	e, ok := zeroCache.m[zeroKey(conj, ua, res, name)]
+	zeroCache.RUnlock()
+	if !ok || time.Now().After(e.exp) {
+		return false
+	}
+	gen, seq := memoState()
+	return e.gen == gen && e.seq == seq
+}
+
+// markZero remembers that the query found nothing, unless some kind failed
+// or the request ended before the searches did
+func markZero(conj, ua bool, res *result, name string) {
+	if cfg.NegativeTTL <= 0 || res.busy || res.db != nil || res.Meta != nil && len(res.Meta.Degraded) > 0 {
+		return
+	}
+	if res.failed() != nil || res.context().Err() != nil {
+		return
+	}
+
+	zeroCache.Lock()
+	defer zeroCache.Unlock()
+
+	now := time.Now()
+	if len(zeroCache.m) >= cfg.CacheSize {
+		for k, e := range zeroCache.m {
+			if now.After(e.exp) {
+				delete(zeroCache.m, k)
+			}
+		}
+		if len(zeroCache.m) >= cfg.CacheSize {
+			return
+		}
+	}
+	e := zeroEntry{exp: now.Add(time.Duration(cfg.NegativeTTL) * time.Second)}
+	e.gen, e.seq = memoState()
+	zeroCache.m[zeroKey(conj, ua, res, name)] = e
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestCanceledNotZero cancels a suggest: the next one still searches
+// rather than taking the query for one without results
+func TestCanceledNotZero(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.NegativeTTL = 60
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := suggest(ctx, nil, "кислота", false, 0, 0, 0, anyProduct); err == nil {
+		t.Fatal("canceled suggest: no error")
+	}
+
+	res, err := suggest(context.Background(), nil, "кислота", false, 0, 0, 0, anyProduct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.strategy == "zero" || len(res.SuggINF) == 0 || len(res.SuggINF[0].Keys) == 0 {
+		t.Errorf("after the canceled suggest: strategy %q, %d inf", res.strategy, len(res.SuggINF))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The upload and admin routes may be limited to some networks on top of
+// the keys, e.g. the office and the data pipeline hosts:
+//
+//	"admin_allow": ["10.20.0.0/16", "203.0.113.7"],
+//	"trusted_proxies": ["10.0.0.0/8"]
+//
+// X-Forwarded-For is honored only when the request comes from a trusted
+// proxy (none by default: anyone on a private network could set it), then
+// the client is the last address in it that is not a trusted proxy itself.
+
+// parseNets parses CIDRs and bare addresses
+func parseNets(list []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func inNets(nets []*net.IPNet, s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !inNets(cfg.proxyNets, host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(hops[i])
+		if ip == "" {
+			continue
+		}
+		if !inNets(cfg.proxyNets, ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+// ipAllowed tells if the client may reach the upload and admin routes,
+// and answers 403 if not
+func ipAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if len(cfg.allowNets) == 0 || inNets(cfg.allowNets, clientIP(r)) {
+		return true
+	}
+	internalServerError(w, fmt.Errorf("%s: %s", http.StatusText(http.StatusForbidden), clientIP(r)), http.StatusForbidden)
+	return false
+}
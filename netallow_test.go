@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	for _, v := range []struct {
+		proxies   []string
+		addr, xff string
+		want      string
+	}{
+		{nil, "127.0.0.1:1", "203.0.113.7", "127.0.0.1"},
+		{nil, "10.1.2.3:1", "203.0.113.7", "10.1.2.3"},
+		{[]string{"10.0.0.0/8"}, "10.1.2.3:1", "203.0.113.7", "203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "10.1.2.3:1", "203.0.113.7, 10.4.5.6", "203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "192.0.2.1:1", "203.0.113.7", "192.0.2.1"},
+	} {
+		var err error
+		cfg.proxyNets, err = parseNets(v.proxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = v.addr
+		r.Header.Set("X-Forwarded-For", v.xff)
+		if got := clientIP(r); got != v.want {
+			t.Errorf("%v %s %q: got %s, want %s", v.proxies, v.addr, v.xff, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/sync/singleflight"
+)
+
+// People reach the admin API through the company identity provider: an
+// OIDC access or ID token (RS256 JWT) is accepted as a bearer token and
+// its roles claim is mapped to viewer, operator or admin. The admin key
+// keeps working as full access for scripts and cluster nodes.
+//
+//	"oidc": {
+//		"issuer": "https://login.example.com/realms/corp",
+//		"audience": "test-bleve",
+//		"roles_claim": "groups",
+//		"roles": {"search-viewers": "viewer", "search-ops": "operator", "search-admins": "admin"}
+//	}
+//
+// $ curl -i -H "Authorization: Bearer $(oidc-token)" http://localhost:8080/admin/status
+
+type oidcConfig struct {
+	Issuer     string            `json:"issuer"`
+	Audience   string            `json:"audience,omitempty"`
+	RolesClaim string            `json:"roles_claim,omitempty"` // groups by default
+	Roles      map[string]string `json:"roles"`                 // claim value -> role
+	JWKSURL    string            `json:"jwks_url,omitempty"`    // over the discovered one
+}
+
+// roles in ascending order of rights
+var roleNames = []string{"viewer", "operator", "admin"}
+
+func roleLevel(role string) int {
+	for i, v := range roleNames {
+		if v == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (c *oidcConfig) check() error {
+	if c.Issuer == "" {
+		return fmt.Errorf("oidc: issuer is required")
+	}
+	for k, v := range c.Roles {
+		if roleLevel(v) == 0 {
+			return fmt.Errorf("oidc: %s: unknown role %q", k, v)
+		}
+	}
+	return nil
+}
+
+// needRole is the role an admin request takes: reading is for viewers,
+// changing for operators, except the dry runs below and the endpoints
+// that reach outside (webhooks, signed upload URLs) or undo an upload.
+func needRole(r *http.Request) string {
+	switch r.URL.Path {
+	case "/admin/eval", "/admin/rules/test":
+		return "viewer"
+	case "/admin/webhooks", "/admin/sign", "/admin/rollback", "/internal/find":
+		if r.Method == "GET" || r.Method == "HEAD" {
+			return "operator"
+		}
+		return "admin"
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return "viewer"
+	}
+	return "operator"
+}
+
+// oidcRole returns the best role the bearer token of the request maps to
+func oidcRole(r *http.Request) (string, error) {
+	c := cfg.OIDC
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tok == "" || tok == r.Header.Get("Authorization") {
+		return "", fmt.Errorf("no bearer token")
+	}
+
+	claims, err := verifyJWT(c, tok)
+	if err != nil {
+		return "", err
+	}
+
+	name := c.RolesClaim
+	if name == "" {
+		name = "groups"
+	}
+	var vals []string
+	switch v := claims[name].(type) {
+	case string:
+		vals = strings.Fields(v)
+	case []interface{}:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+	}
+
+	role := ""
+	for _, v := range vals {
+		if roleLevel(c.Roles[v]) > roleLevel(role) {
+			role = c.Roles[v]
+		}
+	}
+	if role == "" {
+		return "", fmt.Errorf("no role for %v", claims["sub"])
+	}
+	return role, nil
+}
+
+// verifyJWT checks the signature (RS256), issuer, audience and validity
+// period of the token with go-oidc and returns its claims
+func verifyJWT(c *oidcConfig, tok string) (map[string]interface{}, error) {
+	v, err := oidcVerifier(c)
+	if err != nil {
+		return nil, err
+	}
+	t, err := v.Verify(oidc.ClientContext(context.Background(), oidcClient), tok)
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	err = t.Claims(&claims)
+	return claims, err
+}
+
+// The verifier of the config caches the signing keys of the issuer and
+// reloads them for an unknown key id, so key rotation needs no restart.
+// It is made once per config, the discovery of the issuer runs outside of
+// the lock and is shared by the requests waiting for it.
+var oidcVerifiers = struct {
+	sync.Mutex
+	c *oidcConfig
+	v *oidc.IDTokenVerifier
+	g singleflight.Group
+}{}
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+func oidcVerifier(c *oidcConfig) (*oidc.IDTokenVerifier, error) {
+	oidcVerifiers.Lock()
+	if oidcVerifiers.c == c {
+		defer oidcVerifiers.Unlock()
+		return oidcVerifiers.v, nil
+	}
+	oidcVerifiers.Unlock()
+
+	v, err, _ := oidcVerifiers.g.Do(c.Issuer+" "+c.JWKSURL+" "+c.Audience, func() (interface{}, error) {
+		ctx := oidc.ClientContext(context.Background(), oidcClient)
+		conf := &oidc.Config{ClientID: c.Audience, SkipClientIDCheck: c.Audience == ""}
+		if c.JWKSURL != "" {
+			return oidc.NewVerifier(c.Issuer, oidc.NewRemoteKeySet(ctx, c.JWKSURL), conf), nil
+		}
+		p, err := oidc.NewProvider(ctx, c.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		return p.Verifier(conf), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	oidcVerifiers.Lock()
+	oidcVerifiers.c, oidcVerifiers.v = c, v.(*oidc.IDTokenVerifier)
+	oidcVerifiers.Unlock()
+	return v.(*oidc.IDTokenVerifier), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIdP is an identity provider serving its discovery document and keys
+type testIdP struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	jwksHits int32
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testIdP{key: key}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+		case "/keys":
+			atomic.AddInt32(&p.jwksHits, 1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// token signs the claims (RS256, key id k1)
+func (p *testIdP) token(t *testing.T, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	s := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCRoles(t *testing.T) {
+	p := newTestIdP(t)
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.AdminKey = ""
+	cfg.OIDC = &oidcConfig{Issuer: p.URL, Audience: "test-bleve", Roles: map[string]string{"ops": "operator", "view": "viewer"}}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(groups string, aud string, exp int64) map[string]interface{} {
+		return map[string]interface{}{"iss": p.URL, "aud": aud, "sub": "u", "exp": exp, "iat": time.Now().Unix(), "groups": []string{groups}}
+	}
+	for _, v := range []struct {
+		name   string
+		claims map[string]interface{}
+		method string
+		path   string
+		gen    string
+		code   int
+	}{
+		{"viewer reads", claims("view", "test-bleve", exp), "GET", "/admin/status", "", http.StatusOK},
+		{"viewer uploads", claims("view", "test-bleve", exp), "POST", "/test/upload-sugg2", "", http.StatusForbidden},
+		{"operator uploads", claims("ops", "test-bleve", exp), "POST", "/test/upload-sugg2", "", http.StatusOK},
+		{"other audience", claims("ops", "other", exp), "POST", "/test/upload-sugg2", "", http.StatusUnauthorized},
+		{"expired", claims("ops", "test-bleve", time.Now().Add(-time.Hour).Unix()), "GET", "/admin/status", "", http.StatusUnauthorized},
+		{"no role", claims("none", "test-bleve", exp), "GET", "/admin/status", "", http.StatusUnauthorized},
+		{"viewer uploads at a generation", claims("view", "test-bleve", exp), "POST", "/test/upload-sugg2", "1", http.StatusForbidden},
+		{"viewer searches at a generation", claims("view", "test-bleve", exp), "POST", "/test/select-sugg", "1", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(v.method, v.path, strings.NewReader(string(fixtureSales)))
+		r.RemoteAddr = "127.0.0.1:1"
+		r.Header.Set("Authorization", "Bearer "+p.token(t, v.claims))
+		if v.gen != "" {
+			r.Header.Set(generationHeader, v.gen)
+		}
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		if w.Code != v.code {
+			t.Errorf("%s: got %d, want %d %s", v.name, w.Code, v.code, w.Body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&p.jwksHits); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+
+	forged := p.token(t, claims("ops", "test-bleve", exp))
+	forged = forged[:strings.LastIndexByte(forged, '.')+1] + "AAAA"
+	if _, err := oidcRole(&http.Request{Header: http.Header{"Authorization": {"Bearer " + forged}}}); err == nil {
+		t.Errorf("forged signature accepted")
+	}
+}
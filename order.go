@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// The sections of /test/select-suggestion come in the struct order (inf,
+// inn, act, org, atc, then sugg_kinds) of the configured kinds unless
+// another one is asked for: the "order" of the request body or
+// ?order=org,atc, then cfg.ClientOrders by the X-Api-Key the client sends,
+// then cfg.SectionOrder. Kinds left out follow in the struct order. The JSON keys are written in that order and meta.order
+// lists it, for the clients that decode into maps and for MessagePack and
+// Protobuf.
+//
+// $ curl -i -d '{"name":"кислота","order":["org","atc"]}' http://localhost:8080/test/select-suggestion
+
+// sectionKinds is the struct order of the sections
+var sectionKinds = []string{"inf", "inn", "act", "org", "atc"}
+
+func checkOrder(list, kinds []string) error {
+	seen := make(map[string]bool, len(list))
+	for _, k := range list {
+		if !contains(kinds, k) {
+			return fmt.Errorf("order: unknown kind %q", k)
+		}
+		if seen[k] {
+			return fmt.Errorf("order: %q twice", k)
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// clientOrder is the order configured for the client's key
+func clientOrder(r *http.Request) []string {
+	if k := r.Header.Get("X-Api-Key"); k != "" {
+		return cfg.ClientOrders[k]
+	}
+	return nil
+}
+
+// sectionOrder returns the order of the sections, nil for the struct order
+func sectionOrder(r *http.Request, req []string) ([]string, error) {
+	if s := r.URL.Query().Get("order"); len(req) == 0 && s != "" {
+		req = strings.Split(s, ",")
+	}
+	err := checkOrder(req, kindOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	list := req
+	if len(list) == 0 {
+		list = clientOrder(r)
+	}
+	if len(list) == 0 {
+		list = cfg.SectionOrder
+	}
+
+	def := defaultSections()
+	order := append(make([]string, 0, len(def)), list...)
+	for _, k := range def {
+		if !contains(order, k) {
+			order = append(order, k)
+		}
+	}
+	if strings.Join(order, ",") == strings.Join(def, ",") {
+		return nil, nil
+	}
+	return order, nil
+}
+
+// defaultSections are the configured kinds in the struct order, the ones
+// of sugg_kinds last
+func defaultSections() []string {
+	var res []string
+	for _, k := range sectionKinds {
+		if contains(kindOrder, k) {
+			res = append(res, k)
+		}
+	}
+	return append(res, extraKinds()...)
+}
+
+// resultKeys are the JSON keys of result in the struct order
+var resultKeys = func() []string {
+	t := reflect.TypeOf(result{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		k := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if k != "" && k != "-" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}()
+
+// orderedJSON encodes the (possibly field-filtered) result with the
+// sections in order
+func orderedJSON(v interface{}, order []string, pretty bool) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]json.RawMessage{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	put := func(k string) {
+		raw, ok := m[k]
+		if !ok {
+			return
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+	done := false
+	for _, k := range resultKeys {
+		if !strings.HasPrefix(k, "sugg_") {
+			put(k)
+			continue
+		}
+		if !done {
+			for _, kind := range order {
+				put("sugg_" + kind)
+			}
+			put("sugg_kinds")
+			done = true
+		}
+	}
+	buf.WriteByte('}')
+
+	if !pretty {
+		return buf.Bytes(), nil
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, buf.Bytes(), "", "\t")
+	return out.Bytes(), err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parsedLine is a prescription line pasted into the search box split into
+// parts: "Ибупрофен 400мг таб. №20 х2" is core "Ибупрофен", dosage "400мг",
+// form "таб", count 20, times 2. Only the core is searched.
+type parsedLine struct {
+	Core   string `json:"core,omitempty"`
+	Dosage string `json:"dosage,omitempty"`
+	Form   string `json:"form,omitempty"`
+	Count  int    `json:"count,omitempty"`
+	Times  int    `json:"times,omitempty"`
+}
+
+var (
+	lineDosageRe = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(мкг|мг|г|мл|л|ме|од|%|mcg|mg|g|ml|iu)(?:[^\p{L}]|$)`)
+	lineCountRe  = regexp.MustCompile(`(?:№|#)\s*(\d+)`)
+	lineTimesRe  = regexp.MustCompile(`(?i)(?:^|\s)[xх×*]\s*(\d+)(?:\s|$)`)
+	lineFormRe   = regexp.MustCompile(`(?i)^(таб|табл|таблетки|капс|капсулы|капсули|р-р|розч|амп|сусп|мазь|крем|гель|сироп|пор|супп|фл|шпр|драже|спрей|капли|краплі)\.?$`)
+)
+
+func parseLine(s string) *parsedLine {
+	p := &parsedLine{}
+
+	if m := lineDosageRe.FindStringSubmatch(s); m != nil {
+		p.Dosage = m[1] + m[2]
+		s = strings.Replace(s, strings.TrimRightFunc(m[0], notLetter), " ", 1)
+	}
+	if m := lineCountRe.FindStringSubmatch(s); m != nil {
+		p.Count, _ = strconv.Atoi(m[1])
+		s = strings.Replace(s, m[0], " ", 1)
+	}
+	if m := lineTimesRe.FindStringSubmatch(s); m != nil {
+		p.Times, _ = strconv.Atoi(m[1])
+		s = strings.Replace(s, m[0], " ", 1)
+	}
+
+	var core []string
+	for _, v := range strings.Fields(s) {
+		if lineFormRe.MatchString(v) {
+			if p.Form == "" {
+				p.Form = strings.TrimSuffix(v, ".")
+			}
+			continue
+		}
+		if strings.IndexFunc(v, unicode.IsLetter) < 0 {
+			continue
+		}
+		core = append(core, strings.Trim(v, ".,;:"))
+	}
+	p.Core = strings.Join(core, " ")
+
+	return p
+}
+
+func notLetter(r rune) bool {
+	return !unicode.IsLetter(r)
+}
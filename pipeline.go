@@ -0,0 +1,612 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"golang.org/x/sync/errgroup"
+)
+
+// Every kind index is searched by a pipeline of stages tried in order
+// until one finds something. A stage rewrites the query text and/or the
+// way its words are matched:
+//
+//	exact     the query as is, matched the endpoint way (see baseMode)
+//	infix     every word is a part of a name word (exact of select-sugg)
+//	phrase    the words in a row (exact of select-suggestion), phrase~N
+//	          lets N positions slip in total (words between or swapped)
+//	prefix    every word starts a name word
+//	fuzzy     every word is one or two typos off a name word
+//	layout    the query typed in the wrong keyboard layout (qwerty)
+//	translit  the query transliterated between Latin and Cyrillic
+//	phonetic  every word sounds like the start of a name word
+//	split     the words no name has cut into name words, for the ones typed
+//	          without a space (see splitVariants)
+//
+// The pipelines are set per endpoint or per endpoint/kind in the config:
+//
+//	"pipelines": {"select-sugg": ["exact", "layout"], "select-suggestion/org": ["exact", "phrase~2", "fuzzy"]}
+//
+// Runs, hits and time of every stage are published in /debug/vars
+// (pipeline_stages), cfg.LogPipeline also logs them per search.
+
+const (
+	epSugg       = "select-sugg"
+	epSuggestion = "select-suggestion"
+)
+
+var stageNames = []string{"exact", "infix", "phrase", "prefix", "fuzzy", "layout", "translit", "phonetic", "split"}
+
+// maxSlop bounds phrase~N, a sloppy phrase tries every placement
+const maxSlop = 5
+
+// maxFuzziness bounds fuzzy~N, the most edits of bleve
+const maxFuzziness = 2
+
+var defaultPipeline = []string{"exact", "layout", "split"}
+
+// searchMode is how the query words are matched against the name words
+type searchMode string
+
+const (
+	modePhrase   searchMode = "phrase" // the words in a row
+	modeInfix    searchMode = "infix"  // every word is a part of a name word
+	modePrefix   searchMode = "prefix"
+	modeFuzzy    searchMode = "fuzzy"
+	modePhonetic searchMode = "phonetic"
+)
+
+// baseMode is the exact stage mode of the endpoint
+func baseMode(endpoint string) searchMode {
+	if endpoint == epSugg {
+		return modeInfix
+	}
+	return modePhrase
+}
+
+// slop is N of a phrase~N mode, 0 for the others
+func (m searchMode) slop() int {
+	if !strings.HasPrefix(string(m), string(modePhrase)+"~") {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(string(m), string(modePhrase)+"~"))
+	return n
+}
+
+// fuzziness is the most edits of a fuzzy or fuzzy~N mode (1 for the
+// words up to 5 letters), 0 for the others
+func (m searchMode) fuzziness() int {
+	if m == modeFuzzy {
+		return 2
+	}
+	if !strings.HasPrefix(string(m), string(modeFuzzy)+"~") {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(string(m), string(modeFuzzy)+"~"))
+	return n
+}
+
+// fuzzyMode is the fuzzy~N mode of n edits
+func fuzzyMode(n int) searchMode {
+	return searchMode(fmt.Sprintf("%s~%d", modeFuzzy, n))
+}
+
+// wordFuzziness is the edits a fuzzy mode allows in the word
+func wordFuzziness(w string, mode searchMode) int {
+	n := mode.fuzziness()
+	if n > 1 && len([]rune(w)) <= 5 {
+		n = 1
+	}
+	return n
+}
+
+// phrase is true for the phrase modes, sloppy or not
+func (m searchMode) phrase() bool {
+	return m == modePhrase || m.slop() > 0
+}
+
+// validStage is a stage name, phrase~N or fuzzy~N
+func validStage(s string) bool {
+	if n := searchMode(s).slop(); n > 0 {
+		return n <= maxSlop && s == fmt.Sprintf("%s~%d", modePhrase, n)
+	}
+	if n := searchMode(s).fuzziness(); n > 0 && s != string(modeFuzzy) {
+		return n <= maxFuzziness && s == string(fuzzyMode(n))
+	}
+	return contains(stageNames, s)
+}
+
+// requestSlop is the "slop" of the request body or ?slop=, 0..maxSlop
+func requestSlop(r *http.Request, req *int) (int, error) {
+	if req != nil {
+		if *req < 0 || *req > maxSlop {
+			return 0, fmt.Errorf("invalid slop: %d (0..%d)", *req, maxSlop)
+		}
+		return *req, nil
+	}
+	s := r.URL.Query().Get("slop")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > maxSlop {
+		return 0, fmt.Errorf("invalid slop: %q (0..%d)", s, maxSlop)
+	}
+	return n, nil
+}
+
+// sloppy turns a phrase stage into phrase~N for a request with slop N,
+// the configured phrase~N stages are kept
+func (r *result) sloppy(mode searchMode) searchMode {
+	if r.slop > 0 && mode == modePhrase {
+		return searchMode(fmt.Sprintf("%s~%d", modePhrase, r.slop))
+	}
+	return mode
+}
+
+func conjMode(conj bool) searchMode {
+	if conj {
+		return modeInfix
+	}
+	return modePhrase
+}
+
+var stageStats = expvar.NewMap("pipeline_stages")
+
+// checkPipelines rejects unknown stages, endpoints and kinds
+func checkPipelines(p map[string][]string, kinds []string) error {
+	for k, list := range p {
+		ep := strings.SplitN(k, "/", 2)
+		if ep[0] != epSugg && ep[0] != epSuggestion {
+			return fmt.Errorf("pipeline %s: unknown endpoint", k)
+		}
+		if len(ep) == 2 && !contains(kinds, ep[1]) {
+			return fmt.Errorf("pipeline %s: unknown kind", k)
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("pipeline %s: no stages", k)
+		}
+		for _, s := range list {
+			if !validStage(s) {
+				return fmt.Errorf("pipeline %s: unknown stage %q", k, s)
+			}
+		}
+	}
+	return nil
+}
+
+// pipelineFor returns the stages of the endpoint for the kind
+func pipelineFor(endpoint, kind string) []string {
+	if p, ok := cfg.Pipelines[endpoint+"/"+kind]; ok {
+		return p
+	}
+	if p, ok := cfg.Pipelines[endpoint]; ok {
+		return p
+	}
+	return defaultPipeline
+}
+
+// stageQuery returns the query text and mode of the stage
+func stageQuery(stage, name, endpoint string, ua bool) (string, searchMode) {
+	if searchMode(stage).slop() > 0 || searchMode(stage).fuzziness() > 0 {
+		return name, searchMode(stage)
+	}
+	switch stage {
+	case "infix":
+		return name, modeInfix
+	case "phrase":
+		return name, modePhrase
+	case "prefix":
+		return name, modePrefix
+	case "phonetic":
+		return name, modePhonetic
+	case "layout":
+		return layoutConv(name, ua), baseMode(endpoint)
+	case "translit":
+		return translit(name, ua), baseMode(endpoint)
+	}
+	return name, baseMode(endpoint)
+}
+
+// findAll runs the pipeline of the endpoint for every kind and returns the
+// hits (kind: name: keys) with the stage that found them ("" for none).
+// With no hits at all the kinds are searched fuzzy~N for the fuzziness of
+// the request, but the ones with a fuzzy stage of their own. The kinds are
+// searched at once (see eachKind), so a request takes as long as its
+// slowest kind and up to a slot of cfg.MaxSearches per kind.
+func (r *result) findAll(endpoint, name string, ua bool) (map[string]map[string][]string, map[string]string) {
+	found := make([]map[string][]string, len(kindOrder))
+	by := make([]string, len(kindOrder))
+	r.eachKind(kindOrder, func(i int, c *result) {
+		found[i], by[i] = c.runPipeline(endpoint, kindKey(kindOrder[i], ua), name, ua)
+	})
+
+	hits := make(map[string]map[string][]string, len(kindOrder))
+	stages := make(map[string]string, len(kindOrder))
+	n := 0
+	for i, k := range kindOrder {
+		hits[k], stages[k] = found[i], by[i]
+		n += len(hits[k])
+	}
+	if n > 0 || r.fuzziness == 0 {
+		return hits, stages
+	}
+
+	var kinds []string
+	for _, k := range kindOrder {
+		fuzzy := false
+		for _, st := range pipelineFor(endpoint, k) {
+			fuzzy = fuzzy || searchMode(st).fuzziness() > 0
+		}
+		if !fuzzy {
+			kinds = append(kinds, k)
+		}
+	}
+
+	mode := fuzzyMode(r.fuzziness)
+	found = make([]map[string][]string, len(kinds))
+	r.eachKind(kinds, func(i int, c *result) {
+		start := time.Now()
+		found[i] = c.find(kindKey(kinds[i], ua), name, mode)
+		stat := endpoint + "/" + kinds[i] + "/" + string(mode)
+		stageStats.Add(stat+".runs", 1)
+		stageStats.Add(stat+".us", time.Since(start).Microseconds())
+		if len(found[i]) > 0 {
+			stageStats.Add(stat+".hits", 1)
+		}
+	})
+	for i, k := range kinds {
+		if len(found[i]) > 0 {
+			hits[k], stages[k] = found[i], string(mode)
+		}
+	}
+	return hits, stages
+}
+
+// eachKind runs f for the kinds (by index) at once, each on a fork of r,
+// and joins the forks back in the order of the kinds, so the meta of the
+// result does not depend on which search ended first. A shed search
+// (errBusy) fails the request, so it stops the other kinds, as does the
+// end of the request.
+func (r *result) eachKind(kinds []string, f func(i int, c *result)) {
+	forks := make([]*result, len(kinds))
+	g, ctx := errgroup.WithContext(r.context())
+	for i := range kinds {
+		forks[i] = r.fork()
+		forks[i].ctx = ctx
+		c := forks[i]
+		g.Go(func() error {
+			f(i, c)
+			if c.busy {
+				return c.err
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	for _, c := range forks {
+		r.join(c)
+	}
+}
+
+// fork returns a copy of r to search with on another goroutine, the lists
+// find adds to are its own
+func (r *result) fork() *result {
+	c := *r
+	c.err = nil
+	if r.Meta != nil {
+		m := *r.Meta
+		m.Degraded = append([]string(nil), m.Degraded...)
+		m.Disabled = append([]string(nil), m.Disabled...)
+		m.PrefixOnly = append([]string(nil), m.PrefixOnly...)
+		c.Meta = &m
+	}
+	return &c
+}
+
+// join takes what the searches of the fork c noted, the error of a shed
+// search is kept over the ones of the kinds it stopped
+func (r *result) join(c *result) {
+	if c.err != nil && (c.busy || !r.busy) {
+		r.err = c.err
+	}
+	r.busy = r.busy || c.busy
+	if c.Meta == nil {
+		return
+	}
+	m := r.meta()
+	for _, v := range c.Meta.Degraded {
+		if !contains(m.Degraded, v) {
+			m.Degraded = append(m.Degraded, v)
+		}
+	}
+	for _, v := range c.Meta.Disabled {
+		if !contains(m.Disabled, v) {
+			m.Disabled = append(m.Disabled, v)
+		}
+	}
+	for _, v := range c.Meta.PrefixOnly {
+		if !contains(m.PrefixOnly, v) {
+			m.PrefixOnly = append(m.PrefixOnly, v)
+		}
+	}
+}
+
+// requestFuzziness is the "fuzziness" of the request body or ?fuzziness=,
+// 0..maxFuzziness, cfg.Fuzziness if not given
+func requestFuzziness(r *http.Request, req *int) (int, error) {
+	if req != nil {
+		if *req < 0 || *req > maxFuzziness {
+			return 0, fmt.Errorf("invalid fuzziness: %d (0..%d)", *req, maxFuzziness)
+		}
+		return *req, nil
+	}
+	s := r.URL.Query().Get("fuzziness")
+	if s == "" {
+		return cfg.Fuzziness, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > maxFuzziness {
+		return 0, fmt.Errorf("invalid fuzziness: %q (0..%d)", s, maxFuzziness)
+	}
+	return n, nil
+}
+
+// runPipeline searches one kind index stage by stage, a stage repeating a
+// query of an earlier one is skipped.
+func (r *result) runPipeline(endpoint, key, name string, ua bool) (map[string][]string, string) {
+	kind := strings.Split(key, "-")[0]
+	tried := make(map[string]bool)
+	var trace []string
+	defer func() {
+		if cfg.LogPipeline && len(trace) > 0 {
+			log.Printf("pipeline %s %s %q: %s", endpoint, key, name, strings.Join(trace, ", "))
+		}
+	}()
+
+	for _, st := range pipelineFor(endpoint, kind) {
+		q, mode := stageQuery(st, name, endpoint, ua)
+		mode = r.sloppy(mode)
+		if st == "split" {
+			v := searcher.Split(r.db, []string{key}, q)
+			if len(v) == 0 {
+				continue
+			}
+			q = v[0]
+		}
+		if tried[string(mode)+"|"+q] {
+			continue
+		}
+		tried[string(mode)+"|"+q] = true
+		if mode == modeInfix {
+			r.notePrefixOnly(q)
+		}
+
+		start := time.Now()
+		m := r.find(key, q, mode)
+		d := time.Since(start)
+
+		stat := endpoint + "/" + kind + "/" + st
+		stageStats.Add(stat+".runs", 1)
+		stageStats.Add(stat+".us", d.Microseconds())
+		trace = append(trace, fmt.Sprintf("%s %d (%s)", st, len(m), d))
+		if len(m) > 0 {
+			stageStats.Add(stat+".hits", 1)
+			return m, st
+		}
+	}
+	return nil, ""
+}
+
+// nameQuery matches the (normalized) name words in the field f of the
+// index of the key
+func nameQuery(key, f, name string, mode searchMode) query.Query {
+	str := queryWords(name)
+	if len(str) == 0 {
+		return bleve.NewMatchNoneQuery()
+	}
+
+	fld := field(key, f)
+	if n := mode.slop(); n > 0 {
+		return newSlopPhraseQuery(strings.TrimSpace(name), fld, fieldAnalyzer(key, f), n)
+	}
+	if mode == modePhrase {
+		q := bleve.NewMatchPhraseQuery(strings.TrimSpace(name))
+		q.SetField(fld)
+		q.Analyzer = fieldAnalyzer(key, f)
+		return q
+	}
+
+	cns := make([]query.Query, len(str))
+	for i, v := range str {
+		if n := wordFuzziness(v, mode); n > 0 {
+			q := bleve.NewFuzzyQuery(v)
+			q.SetFuzziness(n)
+			q.SetField(fld)
+			cns[i] = q
+			continue
+		}
+		switch mode {
+		case modePrefix:
+			q := bleve.NewPrefixQuery(v)
+			q.SetField(fld)
+			cns[i] = q
+		case modePhonetic:
+			q := bleve.NewRegexpQuery(phoneticPattern(v))
+			q.SetField(fld)
+			cns[i] = q
+		case modeInfix:
+			if len([]rune(v)) < cfg.InfixMinLen {
+				q := bleve.NewPrefixQuery(v)
+				q.SetField(fld)
+				cns[i] = q
+				break
+			}
+			if f == "name" {
+				if q := infixQuery(key, v); q != nil {
+					cns[i] = q
+					break
+				}
+			}
+			fallthrough
+		default:
+			q := bleve.NewWildcardQuery("*" + v + "*")
+			q.SetField(fld)
+			cns[i] = q
+		}
+	}
+	return bleve.NewConjunctionQuery(cns...)
+}
+
+// queryWords splits a name into lowercased query words (see stripStress) at
+// every rune that is no letter or digit, so whitespace runs and punctuation
+// leave no empty or wildcard token: "**", "" and ".*" would match every term.
+func queryWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(stripStress(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixOnly returns the words of name that an infix search downgrades to
+// word prefixes: a leading wildcard on a short word expands to most of
+// the terms of the index
+func prefixOnly(name string) []string {
+	var out []string
+	for _, v := range queryWords(name) {
+		if len([]rune(v)) < cfg.InfixMinLen && !contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// notePrefixOnly reports the downgraded words of an infix search of name
+func (r *result) notePrefixOnly(name string) {
+	for _, v := range prefixOnly(name) {
+		if m := r.meta(); !contains(m.PrefixOnly, v) {
+			m.PrefixOnly = append(m.PrefixOnly, v)
+		}
+	}
+}
+
+// soundsLike are the letters confused by ear, unstressed vowels and
+// voiced/voiceless pairs mostly
+var soundsLike = map[rune]string{
+	'а': "ао", 'о': "ао", 'е': "еёэи", 'ё': "еёо", 'э': "еэ", 'и': "иые", 'ы': "иы",
+	'б': "бп", 'п': "пб", 'в': "вф", 'ф': "фв", 'г': "гкх", 'к': "кг", 'х': "хг",
+	'д': "дт", 'т': "тд", 'ж': "жш", 'ш': "шжщ", 'щ': "щш", 'з': "зс", 'с': "сз",
+	'і': "іиы", 'ї': "їі", 'є': "єе",
+	'a': "ao", 'o': "oa", 'e': "ei", 'i': "iye", 'y': "yi",
+	'c': "ck", 'k': "kc", 's': "sz", 'z': "zs", 'v': "vw", 'w': "wv",
+	'b': "bp", 'p': "pb", 'd': "dt", 't': "td", 'g': "gk",
+}
+
+// phoneticPattern turns a word into a term regexp matching the words that
+// sound alike and start with it, doubled letters are optional.
+func phoneticPattern(word string) string {
+	var b strings.Builder
+	var prev rune
+	for _, c := range word {
+		if c == prev {
+			continue
+		}
+		prev = c
+		if s, ok := soundsLike[c]; ok {
+			b.WriteString("[" + s + "]+")
+		} else if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			b.WriteString(regexp.QuoteMeta(string(c)) + "+")
+		}
+	}
+	return b.String() + ".*"
+}
+
+// latinCyr is the transliteration of Latin to Cyrillic, longest first
+var latinCyr = [][2]string{
+	{"shch", "щ"}, {"sch", "щ"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"}, {"sh", "ш"},
+	{"yu", "ю"}, {"ya", "я"}, {"yo", "ё"}, {"ph", "ф"},
+	{"a", "а"}, {"b", "б"}, {"c", "ц"}, {"d", "д"}, {"e", "е"}, {"f", "ф"}, {"g", "г"},
+	{"h", "х"}, {"i", "и"}, {"j", "й"}, {"k", "к"}, {"l", "л"}, {"m", "м"}, {"n", "н"},
+	{"o", "о"}, {"p", "п"}, {"q", "к"}, {"r", "р"}, {"s", "с"}, {"t", "т"}, {"u", "у"},
+	{"v", "в"}, {"w", "в"}, {"x", "кс"}, {"y", "ы"}, {"z", "з"},
+}
+
+// latinUkr differs from latinCyr for Ukrainian
+var latinUkr = map[string]string{"y": "и", "i": "і", "yi": "ї", "ye": "є", "h": "г"}
+
+var cyrLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "e", 'є': "ye",
+	'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh",
+	'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e",
+	'ю': "yu", 'я': "ya",
+}
+
+// translit writes a Latin query in Cyrillic (Ukrainian one for ua) and a
+// Cyrillic one in Latin, for the brand (latin) names.
+func translit(s string, ua bool) string {
+	s = strings.ToLower(s)
+	pairs := packTranslit(ua)
+	latin := false
+	for _, c := range s {
+		if c < unicode.MaxASCII && unicode.IsLetter(c) || packLatin(pairs, c) {
+			latin = true
+			break
+		}
+	}
+
+	var b strings.Builder
+	if !latin {
+		for _, c := range s {
+			if l, ok := cyrLatin[c]; ok {
+				b.WriteString(l)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+		return b.String()
+	}
+
+next:
+	for s != "" {
+		for _, p := range pairs {
+			if strings.HasPrefix(s, p[0]) {
+				b.WriteString(p[1])
+				s = s[len(p[0]):]
+				continue next
+			}
+		}
+		if ua {
+			for _, l := range []string{"yi", "ye"} {
+				if strings.HasPrefix(s, l) {
+					b.WriteString(latinUkr[l])
+					s = s[len(l):]
+					continue next
+				}
+			}
+		}
+		for _, p := range latinCyr {
+			if strings.HasPrefix(s, p[0]) {
+				c := p[1]
+				if u, ok := latinUkr[p[0]]; ua && ok {
+					c = u
+				}
+				b.WriteString(c)
+				s = s[len(p[0]):]
+				continue next
+			}
+		}
+		c := []rune(s)[0]
+		b.WriteRune(c)
+		s = s[len(string(c)):]
+	}
+	return b.String()
+}
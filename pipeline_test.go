@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// adversarialNames leave no query word, every one of them used to make an
+// empty or wildcard term matching every name
+var adversarialNames = []string{"", "   ", "\t\n", "**", ".*", "?", "-", "- - -", "​​", "́", "*.?|\\"}
+
+func TestQueryWords(t *testing.T) {
+	for _, v := range []struct {
+		in   string
+		want []string
+	}{
+		{"кислота", []string{"кислота"}},
+		{"  Аскорбиновая   кислота ", []string{"аскорбиновая", "кислота"}},
+		{"Нурофен-Форте 400мг", []string{"нурофен", "форте", "400мг"}},
+		{"*кислота*", []string{"кислота"}},
+		{"а.*б", []string{"а", "б"}},
+		{"М'я́та", []string{"мята"}},
+	} {
+		if got := queryWords(v.in); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("%q: got %q, want %q", v.in, got, v.want)
+		}
+	}
+	for _, s := range adversarialNames {
+		if got := queryWords(s); len(got) != 0 {
+			t.Errorf("%q: got %q, want none", s, got)
+		}
+	}
+}
+
+func TestNameQueryNoWords(t *testing.T) {
+	for _, s := range adversarialNames {
+		for _, mode := range []searchMode{modePhrase, modeInfix, modePrefix, modeFuzzy, modePhonetic} {
+			if q, ok := nameQuery("inf-ru", "name", s, mode).(*query.MatchNoneQuery); !ok {
+				t.Errorf("%q %s: got %T, want a match-none query", s, mode, q)
+			}
+		}
+	}
+}
+
+func TestSearchNoWords(t *testing.T) {
+	for _, s := range adversarialNames {
+		if len([]rune(s)) <= 2 {
+			continue // too few characters for the handlers
+		}
+		for _, path := range []string{"/test/select-sugg", "/test/select-suggestion"} {
+			body := `{"name":"` + strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`).Replace(s) + `"}`
+			w := serve("POST", path, body, false)
+			if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "keys") || strings.Contains(w.Body.String(), "КИСЛОТА") {
+				t.Errorf("%s %q: %d %s", path, s, w.Code, w.Body)
+			}
+		}
+	}
+}
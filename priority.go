@@ -0,0 +1,105 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority classes keep bulk jobs from starving the search box: a request
+// is "batch" if it comes with one of cfg.BatchKeys or asks for it (header
+// X-Priority or ?priority=batch), otherwise "interactive". Each class has
+// its own pool of concurrent requests (cfg.Priorities), the batch one
+// small with a long queue, so batch requests hold only a few of the
+// search slots (see acquire) however many of them are sent.
+//
+// $ curl -i -H 'X-Api-Key: batch-secret' -d '{"name":"кислота"}' http://localhost:8080/test/select-name
+// $ curl -i -d '{"name":"кислота"}' 'http://localhost:8080/test/select-name?priority=batch'
+
+const (
+	classInteractive = "interactive"
+	classBatch       = "batch"
+)
+
+// priorityClass is the request pool of a class: Max requests at once (0 is
+// no limit), the next ones wait Wait milliseconds behind at most Queue
+// others, then get 503.
+type priorityClass struct {
+	Max   int `json:"max,omitempty"`
+	Queue int `json:"queue,omitempty"`
+	Wait  int `json:"wait,omitempty"`
+}
+
+func defaultPriorities() map[string]priorityClass {
+	return map[string]priorityClass{
+		classInteractive: {},
+		classBatch:       {Max: 2, Queue: 100, Wait: 30000},
+	}
+}
+
+func checkPriorities(p map[string]priorityClass) error {
+	for k, v := range p {
+		if k != classInteractive && k != classBatch {
+			return fmt.Errorf("priorities: unknown class %q", k)
+		}
+		if v.Max < 0 || v.Queue < 0 || v.Wait < 0 {
+			return fmt.Errorf("priorities: %s: negative value", k)
+		}
+	}
+	return nil
+}
+
+// classStats counts the requests (.requests), waits (.waits, .wait_us)
+// and rejections (.rejected) of every class
+var classStats = expvar.NewMap("priority_classes")
+
+var classPools sync.Map // class -> *semaphore
+
+// priorityOf returns the class of the request, a batch key can not ask
+// for interactive
+func priorityOf(r *http.Request) string {
+	if k := r.Header.Get("X-Api-Key"); k != "" && contains(cfg.BatchKeys, k) {
+		return classBatch
+	}
+	if r.Header.Get("X-Priority") == classBatch || r.URL.Query().Get("priority") == classBatch {
+		return classBatch
+	}
+	return classInteractive
+}
+
+// prioritized runs the request in the pool of its class
+func prioritized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		class := priorityOf(r)
+		classStats.Add(class+".requests", 1)
+		w.Header().Set("X-Priority", class)
+
+		c := cfg.Priorities[class]
+		if c.Max <= 0 {
+			h(w, r)
+			return
+		}
+		s := sized(&classPools, class, c.Max)
+
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			start := time.Now()
+			ok := s.wait(r.Context(), start.Add(time.Duration(c.Wait)*time.Millisecond), c.Queue)
+			classStats.Add(class+".waits", 1)
+			classStats.Add(class+".wait_us", time.Since(start).Microseconds())
+			if !ok {
+				classStats.Add(class+".rejected", 1)
+				w.Header().Set("Retry-After", strconv.Itoa(cfg.SearchRetryAfter))
+				internalServerError(w, fmt.Errorf("too many %s requests", class), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer release([]*semaphore{s})
+
+		h(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"unicode"
+)
+
+// queryLimit bounds the requests of a search endpoint: the body size is
+// checked first (before anything reads it), then the query length in
+// graphemes, so that "й" typed as и + combining breve or a flag emoji
+// counts as one character.
+type queryLimit struct {
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	Min      int   `json:"min,omitempty"`
+	Max      int   `json:"max,omitempty"`
+}
+
+func defaultQueryLimits() map[string]queryLimit {
+	return map[string]queryLimit{
+		"select-sugg":       {MaxBytes: 4 << 10, Min: 3, Max: 128},
+		"select-suggestion": {MaxBytes: 16 << 10, Min: 3, Max: 1024},
+		"select-name":       {MaxBytes: 16 << 10, Min: 3, Max: 1024},
+		"spellcheck":        {MaxBytes: 16 << 10, Min: 3, Max: 1024},
+	}
+}
+
+// limitFor returns the limits of the endpoint the request is for
+func limitFor(r *http.Request) queryLimit {
+	return cfg.QueryLimits[path.Base(r.URL.Path)]
+}
+
+// limitBody refuses a body over the endpoint limit by its Content-Length
+// or stops reading it there, see bodyStatus.
+func limitBody(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l := limitFor(r); l.MaxBytes > 0 {
+			if r.ContentLength > l.MaxBytes {
+				internalServerError(w, fmt.Errorf("request body over %d bytes", l.MaxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, l.MaxBytes)
+		}
+		h(w, r)
+	}
+}
+
+// bodyStatus is the status of a failed body read
+func bodyStatus(err error) int {
+	var e *http.MaxBytesError
+	if errors.As(err, &e) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// checkQueryLength checks the query against the endpoint limits
+func checkQueryLength(r *http.Request, name string) error {
+	l := limitFor(r)
+	n := graphemes(name)
+	if n < l.Min {
+		return fmt.Errorf("too few characters: %d", n)
+	}
+	if l.Max > 0 && n > l.Max {
+		return fmt.Errorf("too many characters: %d", n)
+	}
+	return nil
+}
+
+// graphemes counts the user-perceived characters of s: combining marks,
+// variation selectors and emoji modifiers extend the previous character,
+// a zero width joiner glues the next one, regional indicators pair up.
+func graphemes(s string) int {
+	n, ri := 0, 0
+	join := false
+	for _, c := range s {
+		switch {
+		case unicode.In(c, unicode.Mn, unicode.Me, unicode.Mc, unicode.Variation_Selector),
+			c >= 0x1F3FB && c <= 0x1F3FF: // skin tones
+			continue
+		case c == 0x200D:
+			join = true
+			continue
+		case join:
+			join = false
+			continue
+		case c >= 0x1F1E6 && c <= 0x1F1FF:
+			if ri++; ri%2 == 0 {
+				continue
+			}
+		default:
+			ri = 0
+		}
+		n++
+	}
+	return n
+}
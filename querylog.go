@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The query log keeps every search request as a JSON line for analytics
+// and for replaying real traffic against a build:
+//
+//	{"ts":"2026-10-15T16:04:07.5+03:00","endpoint":"select-sugg","query":"аскорбиновая кислота","lang":"ru","hits":2,"latency_ms":1.25,"strategy":"exact","status":200}
+//
+// query is the name after the rewrite rules, normalized and lowercased,
+// hits the entries of the response (-1 for a cached one, not recounted),
+// strategy how the hits were found: "cache", "memo" (narrowed from the
+// previous request), "zero" (negative cache), "none" or the pipeline
+// stages that hit joined by "+" ("exact+layout"). The format is
+// schema/querylog.json.
+//
+// The file (cfg.QueryLog) is only appended to; over cfg.QueryLogMaxSize
+// MiB it is renamed to .1 (.1 to .2 and so on, cfg.QueryLogKeep are
+// kept) and a new one is started. -no-querylog turns it off.
+
+type queryEntry struct {
+	Time     time.Time `json:"ts"`
+	Endpoint string    `json:"endpoint"`
+	Query    string    `json:"query"`
+	Lang     string    `json:"lang"`
+	Hits     int       `json:"hits"`
+	Latency  float64   `json:"latency_ms"`
+	Strategy string    `json:"strategy"`
+	Status   int       `json:"status"`
+}
+
+type queryLogKey struct{}
+
+var queryLog = struct {
+	sync.Mutex
+	f    *os.File
+	size int64
+}{}
+
+func queryLogPath() string {
+	if cfg.QueryLog != "" {
+		return cfg.QueryLog
+	}
+	return filepath.Join(os.TempDir(), "test-bleve-queries.jsonl")
+}
+
+// statusWriter keeps the status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// logQueries writes the query log entry of a search request, the handler
+// fills in the hits and strategy with noteQuery
+func logQueries(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.QueryLogOff || r.Method != "POST" {
+			h(w, r)
+			return
+		}
+
+		// the query is peeked at, the handler reports a body error
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+			h(w, r)
+			return
+		}
+		_ = r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		v := struct {
+			Name string `json:"name"`
+		}{}
+		_ = json.Unmarshal(b, &v)
+
+		lang := "ru"
+		if langUA(r.Header) {
+			lang = "ua"
+		}
+		e := &queryEntry{
+			Time:     time.Now(),
+			Endpoint: path.Base(r.URL.Path),
+			Query:    strings.Join(strings.Fields(strings.ToLower(normName(rewriteQuery(sanitizeQuery(v.Name))))), " "),
+			Lang:     lang,
+			Hits:     -1,
+			Strategy: "cache",
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r.WithContext(context.WithValue(r.Context(), queryLogKey{}, e)))
+
+		e.Latency = float64(time.Since(e.Time).Microseconds()) / 1000
+		e.Status = sw.status
+		if e.Status >= 400 {
+			e.Hits, e.Strategy = 0, ""
+		}
+		writeQueryLog(e)
+	}
+}
+
+// noteQuery records the hits and strategy of the result in the query log
+func noteQuery(r *http.Request, res *result) {
+	if e, ok := r.Context().Value(queryLogKey{}).(*queryEntry); ok {
+		e.Hits = res.total()
+		e.Strategy = res.strategy
+	}
+}
+
+// stagesStrategy is the strategy of hits found by the pipeline stages
+func stagesStrategy(stages map[string]string) string {
+	var s []string
+	for _, v := range stages {
+		if v != "" && !contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	sort.Strings(s)
+	return strings.Join(s, "+")
+}
+
+func writeQueryLog(e *queryEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	queryLog.Lock()
+	defer queryLog.Unlock()
+
+	if queryLog.f != nil && cfg.QueryLogMaxSize > 0 && queryLog.size+int64(len(line)) > int64(cfg.QueryLogMaxSize)<<20 {
+		_ = queryLog.f.Close()
+		queryLog.f = nil
+		rotateQueryLog()
+	}
+	if queryLog.f == nil {
+		f, err := os.OpenFile(queryLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			log.Printf("err: query log: %s", err.Error())
+			return
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			log.Printf("err: query log: %s", err.Error())
+			return
+		}
+		queryLog.f, queryLog.size = f, fi.Size()
+	}
+
+	n, err := queryLog.f.Write(line)
+	queryLog.size += int64(n)
+	if err != nil {
+		log.Printf("err: query log: %s", err.Error())
+	}
+}
+
+// rotateQueryLog shifts the old files, the oldest over cfg.QueryLogKeep
+// is dropped
+func rotateQueryLog() {
+	p := queryLogPath()
+	_ = os.Remove(fmt.Sprintf("%s.%d", p, cfg.QueryLogKeep))
+	for i := cfg.QueryLogKeep - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", p, i), fmt.Sprintf("%s.%d", p, i+1))
+	}
+	if cfg.QueryLogKeep > 0 {
+		_ = os.Rename(p, p+".1")
+	} else {
+		_ = os.Remove(p)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Info and Sale only change on upload, so the sortMagic order of every doc
+// is computed then and kept in the vault as baseDoc.Rank. A query then
+// only looks the ranks up. With a shared store the sales may come through
+// another replica, see rankShared.
+
+// rankVaults stores every doc again with its current sales and position
+func rankVaults(vaults map[string]*sync.Map) {
+	for _, vlt := range vaults {
+		var keys []interface{}
+		var docs []*baseDoc
+		vlt.Range(func(k, v interface{}) bool {
+			d := *v.(*baseDoc)
+			keys = append(keys, k)
+			docs = append(docs, &d)
+			return true
+		})
+
+		ids := make([]int, len(docs))
+		for i := range docs {
+			ids[i] = docs[i].ID
+		}
+		for i, v := range sales.load(ids) {
+			docs[i].Sale = v
+		}
+
+		order := make([]int, len(docs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool { return magicLess(docs[order[i]], docs[order[j]]) })
+		for n, i := range order {
+			docs[i].Rank = n + 1
+			vlt.Store(keys[i], docs[i])
+		}
+	}
+}
+
+// ranked is the sales version the vaults in use are ranked with
+var ranked struct {
+	sync.Mutex
+	sales string
+}
+
+// rankCurrent reranks the vaults in use, after a sales upload
+func rankCurrent() {
+	ranked.Lock()
+	defer ranked.Unlock()
+	v := sales.version()
+	rankVaults(currentVaults())
+	ranked.sales = v
+}
+
+// rankShared reranks the vaults in use when the sales in the store changed
+// since they were ranked, uploaded to another replica sharing it (Redis).
+// A search going on while one reranks uses the ranks it finds.
+func rankShared() {
+	v := sales.version()
+	if !ranked.TryLock() {
+		return
+	}
+	defer ranked.Unlock()
+	if v == ranked.sales {
+		return
+	}
+	rankVaults(currentVaults())
+	ranked.sales = v
+	staleETags()
+}
+
+func currentVaults() map[string]*sync.Map {
+	indexDB.RLock()
+	vaults := make(map[string]*sync.Map, len(indexDB.vault))
+	for k, v := range indexDB.vault {
+		vaults[k] = v
+	}
+	indexDB.RUnlock()
+	return vaults
+}
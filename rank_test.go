@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRankShared merges sales as another replica sharing the store does,
+// without reranking, and checks that the next search reranks
+func TestRankShared(t *testing.T) {
+	vlt, err := indexDB.getVault("inf-ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a doc ranked below another one of its Info, which its sales decide
+	var docs []*baseDoc
+	keys := make(map[*baseDoc]string)
+	vlt.Range(func(k, v interface{}) bool {
+		docs = append(docs, v.(*baseDoc))
+		keys[v.(*baseDoc)] = k.(string)
+		return true
+	})
+	var last *baseDoc
+	for _, d := range docs {
+		for _, e := range docs {
+			if e.Info == d.Info && e.Rank < d.Rank && (last == nil || d.Rank > last.Rank) {
+				last = d
+			}
+		}
+	}
+	if last == nil {
+		t.Fatal("no doc to move up")
+	}
+	key := keys[last]
+
+	defer func(id, sale int) {
+		_ = sales.merge(map[int]int{id: sale})
+		rankCurrent()
+	}(last.ID, last.Sale)
+	err = sales.merge(map[int]int{last.ID: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = suggest(context.Background(), nil, "кислота", false, 0, 0, 0, anyProduct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := vlt.Load(key)
+	if d := v.(*baseDoc); d.Sale != 1<<30 || d.Rank >= last.Rank {
+		t.Errorf("after the shared sales: sale %d rank %d, want %d and above %d", d.Sale, d.Rank, 1<<30, last.Rank)
+	}
+}
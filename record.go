@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A client complaint that does not reproduce can be caught in the act:
+// the public endpoints record their request/response pairs for some
+// minutes or requests into a JSON lines file, scrubbed of credentials,
+// client addresses, emails, phone and card numbers.
+//
+// $ curl -i -H 'X-Api-Key: secret' -d '{"minutes": 10, "requests": 500}' http://localhost:8080/admin/record
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/record
+// $ curl -i -H 'X-Api-Key: secret' -X DELETE http://localhost:8080/admin/record
+// $ curl -H 'X-Api-Key: secret' http://localhost:8080/admin/record/{file} > samples.jsonl
+
+// maxSampleBody is the part of a request or response body kept
+const maxSampleBody = 64 << 10
+
+type recording struct {
+	File     string    `json:"file"`
+	Started  time.Time `json:"started"`
+	Until    time.Time `json:"until"`
+	Requests int       `json:"requests"` // 0 is no limit
+	Recorded int       `json:"recorded"`
+
+	f *os.File
+	w *bufio.Writer
+}
+
+var recorder = struct {
+	sync.Mutex
+	cur *recording
+}{}
+
+type sample struct {
+	Time       time.Time           `json:"time"`
+	Client     string              `json:"client"` // hashed address
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Header     map[string][]string `json:"header"`
+	Body       string              `json:"body,omitempty"`
+	Status     int                 `json:"status"`
+	RespHeader map[string][]string `json:"resp_header"`
+	RespBody   string              `json:"resp_body,omitempty"`
+	Duration   float64             `json:"duration_ms"`
+}
+
+func recordDir() string {
+	if cfg.RecordDir != "" {
+		return cfg.RecordDir
+	}
+	return filepath.Join(os.TempDir(), "test-bleve-records")
+}
+
+// recorded tells if the path is one of the public endpoints
+func recorded(path string) bool {
+	return strings.HasPrefix(path, "/test/select-") || strings.HasPrefix(path, "/test/barcode/") || path == "/test/regnum"
+}
+
+// sampleWriter keeps the status and the start of the body
+type sampleWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *sampleWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sampleWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if n := maxSampleBody - w.body.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// recordSamples records the public requests while a recording is on
+func recordSamples(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.Lock()
+		if rec := recorder.cur; rec != nil && time.Now().After(rec.Until) {
+			stopRecording()
+		}
+		on := recorder.cur != nil
+		recorder.Unlock()
+		if !on || !recorded(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// the start of the body is kept, the rest still streams (and
+		// counts against the body limit)
+		b, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxSampleBody))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+
+		start := time.Now()
+		sw := &sampleWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		s := sample{
+			Time:       start,
+			Client:     strTo8SHA1(clientIP(r)),
+			Method:     r.Method,
+			URL:        scrub(r.URL.RequestURI()),
+			Header:     scrubHeader(r.Header),
+			Body:       scrub(string(b)),
+			Status:     sw.status,
+			RespHeader: scrubHeader(w.Header()),
+			RespBody:   scrub(sw.body.String()),
+			Duration:   float64(time.Since(start).Microseconds()) / 1000,
+		}
+		writeSample(&s)
+	})
+}
+
+func writeSample(s *sample) {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	rec := recorder.cur
+	if rec == nil {
+		return
+	}
+	_, _ = rec.w.Write(append(line, '\n'))
+	rec.Recorded++
+	if rec.Requests > 0 && rec.Recorded >= rec.Requests || time.Now().After(rec.Until) {
+		stopRecording()
+	}
+}
+
+// stopRecording closes the current recording, recorder is locked
+func stopRecording() {
+	if rec := recorder.cur; rec != nil {
+		_ = rec.w.Flush()
+		_ = rec.f.Close()
+		recorder.cur = nil
+	}
+}
+
+// secretHeaders are dropped from the samples
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Forwarded-For", "X-Real-Ip", "Forwarded"}
+
+func scrubHeader(h http.Header) map[string][]string {
+	res := make(map[string][]string, len(h))
+	for k, v := range h {
+		if contains(secretHeaders, http.CanonicalHeaderKey(k)) {
+			res[k] = []string{"[scrubbed]"}
+			continue
+		}
+		vals := make([]string, len(v))
+		for i := range v {
+			vals[i] = scrub(v[i])
+		}
+		res[k] = vals
+	}
+	return res
+}
+
+var (
+	emailRe = regexp.MustCompile(`[\pL\pN._%+-]+@[\pL\pN.-]+\.\pL{2,}`)
+	phoneRe = regexp.MustCompile(`\+\d{1,3}[ (-]{0,2}\d{2,3}[ )-]{0,2}\d{3}[ -]?\d{2}[ -]?\d{2}\b|\+\d{10,14}\b`)
+	cardRe  = regexp.MustCompile(`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{3,4}(?:[ -]?\d{1,3})?\b`)
+	sigRe   = regexp.MustCompile(`(sig|exp)=[^&"\s]+`)
+)
+
+// scrub masks emails, phone numbers, card numbers (EAN-13 barcodes are
+// shorter and stay) and URL signatures
+func scrub(s string) string {
+	s = emailRe.ReplaceAllString(s, "[email]")
+	s = cardRe.ReplaceAllStringFunc(s, func(m string) string {
+		if luhn(m) {
+			return "[card]"
+		}
+		return m
+	})
+	s = phoneRe.ReplaceAllString(s, "[phone]")
+	s = sigRe.ReplaceAllString(s, "$1=[scrubbed]")
+	for _, re := range cfg.recordScrub {
+		s = re.ReplaceAllString(s, "[scrubbed]")
+	}
+	return s
+}
+
+// luhn checks the card number checksum of the digits of s
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+func adminRecord(w http.ResponseWriter, r *http.Request) {
+	if name := strings.TrimPrefix(r.URL.Path, "/admin/record/"); name != r.URL.Path && name != "" {
+		if r.Method != "GET" {
+			internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		http.ServeFile(w, r, filepath.Join(recordDir(), filepath.Base(name)))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		v := struct {
+			Minutes  int `json:"minutes"`
+			Requests int `json:"requests"`
+		}{}
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		if v.Minutes <= 0 || v.Minutes > 24*60 || v.Requests < 0 {
+			internalServerError(w, fmt.Errorf("minutes must be 1..1440, requests 0 or more"), http.StatusBadRequest)
+			return
+		}
+
+		err = startRecording(v.Minutes, v.Requests)
+		if err != nil {
+			internalServerError(w, err, http.StatusConflict)
+			return
+		}
+	case "DELETE":
+		recorder.Lock()
+		stopRecording()
+		recorder.Unlock()
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := struct {
+		Recording *recording `json:"recording"`
+		Files     []string   `json:"files"`
+	}{Files: []string{}}
+
+	recorder.Lock()
+	if rec := recorder.cur; rec != nil && time.Now().After(rec.Until) {
+		stopRecording()
+	}
+	if recorder.cur != nil {
+		c := *recorder.cur
+		res.Recording = &c
+	}
+	recorder.Unlock()
+
+	fs, _ := ioutil.ReadDir(recordDir())
+	for _, f := range fs {
+		if strings.HasSuffix(f.Name(), ".jsonl") {
+			res.Files = append(res.Files, f.Name())
+		}
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+func startRecording(minutes, requests int) error {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if recorder.cur != nil {
+		return fmt.Errorf("a recording is on until %s", recorder.cur.Until.Format(time.RFC3339))
+	}
+
+	err := os.MkdirAll(recordDir(), 0700)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	now := time.Now()
+	name := now.Format("20060102-150405") + "-" + hex.EncodeToString(id) + ".jsonl"
+	f, err := os.OpenFile(filepath.Join(recordDir(), name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	recorder.cur = &recording{
+		File:     name,
+		Started:  now,
+		Until:    now.Add(time.Duration(minutes) * time.Minute),
+		Requests: requests,
+		f:        f,
+		w:        bufio.NewWriter(f),
+	}
+	return nil
+}
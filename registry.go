@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// /admin/schema describes what the service takes with its current config,
+// for the producers of the data to check their exports against: the csv
+// columns of the uploads, the JSON doc of /test/docs, the kinds and
+// languages, the analysis of each index and the ranking settings.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/schema
+
+type columnSchema struct {
+	Name     string   `json:"name"`
+	Required bool     `json:"required,omitempty"`
+	Values   []string `json:"values,omitempty"` // the ones taken, any if none
+	Format   string   `json:"format,omitempty"`
+}
+
+type csvSchema struct {
+	Columns []columnSchema `json:"columns"`
+	Extra   string         `json:"extra,omitempty"` // the columns after them
+}
+
+type indexSchema struct {
+	Kinds          []string               `json:"kinds"`
+	Languages      []string               `json:"languages"`
+	MappingVersion string                 `json:"mapping_version"`
+	Mappings       map[string]kindMapping `json:"mappings"` // by kind
+}
+
+// suggColumns are the columns of the suggestion csv, see parseRow
+func suggColumns() []columnSchema {
+	return []columnSchema{
+		{Name: "kind", Required: true, Values: append(append([]string{}, kindOrder...), "info")}, // info is inf
+		{Name: "id", Required: true, Format: "integer"},
+		{Name: "name_ru", Required: true, Format: "names separated with ;"},
+		{Name: "name_ua", Required: true, Format: "names separated with ;"},
+		{Name: "info", Required: true, Format: "integer"},
+		{Name: "lang", Required: true, Values: strings.Split(strings.ToUpper(strings.Join(langOrder, ",")), ",")},
+		{Name: "latin"},
+		{Name: "ean", Format: "list separated with ; , | or space"},
+		{Name: "reg"},
+		{Name: "dispense", Values: []string{dispenseRx, dispenseOTC, "1", "0", "true", "false", "yes", "no"}},
+		{Name: "age", Format: "lo-hi, lo+, adult or child (years)"},
+	}
+}
+
+func adminSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := struct {
+		CSV       map[string]csvSchema   `json:"csv"`  // by upload path
+		JSON      map[string]interface{} `json:"json"` // by path, an example doc
+		Kinds     []string               `json:"kinds"`
+		Languages []string               `json:"languages"`
+		Layout    string                 `json:"index_layout"`
+		Indexes   map[string]indexSchema `json:"indexes"` // by index name
+		Ranking   map[string]interface{} `json:"ranking"`
+	}{
+		CSV: map[string]csvSchema{
+			"/test/upload-sugg": {
+				Columns: suggColumns(),
+				Extra:   fmt.Sprintf("any after the first %d, by header name, in the extra of the docs", rowColumns),
+			},
+			"/test/upload-sugg2": {Columns: []columnSchema{
+				{Name: "id", Required: true, Format: "integer"},
+				{Name: "sale", Required: true, Format: "integer"},
+			}},
+			"/test/upload-interactions": {Columns: []columnSchema{
+				{Name: "inn_a", Required: true},
+				{Name: "inn_b", Required: true},
+				{Name: "severity", Required: true, Values: severities},
+				{Name: "note"},
+			}},
+		},
+		JSON: map[string]interface{}{
+			"/test/docs": &docInput{ID: 1, Kind: "inf", Lang: "ru", Names: []string{}, EAN: []string{}, Extra: map[string]string{}},
+		},
+		Kinds:     kindOrder,
+		Languages: langOrder,
+		Layout:    cfg.IndexLayout,
+		Indexes:   make(map[string]indexSchema),
+		Ranking: map[string]interface{}{
+			"kind_inference":  cfg.KindInference,
+			"kind_boost_max":  cfg.KindBoostMax,
+			"click_window":    cfg.ClickWindow,
+			"click_smoothing": cfg.ClickSmoothing,
+			"intent_gap":      cfg.IntentGap,
+			"intent_share":    cfg.IntentShare,
+			"infix_min_len":   cfg.InfixMinLen,
+			"dup_similarity":  cfg.DupSimilarity,
+			"section_order":   cfg.SectionOrder,
+			"min_per_kind":    cfg.MinPerKind,
+		},
+	}
+
+	boosts := make(map[string]float64, len(kindOrder))
+	pipelines := make(map[string][]string, 2*len(kindOrder))
+	for _, kind := range kindOrder {
+		boosts[kind] = kindBoost(kind)
+		for _, ep := range []string{epSugg, epSuggestion} {
+			pipelines[ep+"/"+kind] = pipelineFor(ep, kind)
+		}
+	}
+	res.Ranking["kind_boosts"] = boosts
+	res.Ranking["pipelines"] = pipelines
+
+	for _, lang := range res.Languages {
+		for _, kind := range kindOrder {
+			name := indexName(kind + "-" + lang)
+			s, ok := res.Indexes[name]
+			if !ok {
+				s = indexSchema{MappingVersion: mappingVersion(name), Mappings: make(map[string]kindMapping)}
+			}
+			if !contains(s.Kinds, kind) {
+				s.Kinds = append(s.Kinds, kind)
+			}
+			if !contains(s.Languages, lang) {
+				s.Languages = append(s.Languages, lang)
+			}
+			s.Mappings[kind] = cfg.Mappings[kind]
+			res.Indexes[name] = s
+		}
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
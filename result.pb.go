@@ -0,0 +1,701 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: result.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Sugg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Keys          []string               `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sugg) Reset() {
+	*x = Sugg{}
+	mi := &file_result_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sugg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sugg) ProtoMessage() {}
+
+func (x *Sugg) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sugg.ProtoReflect.Descriptor instead.
+func (*Sugg) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Sugg) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Sugg) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type Meta struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        map[string]string      `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Degraded      []string               `protobuf:"bytes,2,rep,name=degraded,proto3" json:"degraded,omitempty"`
+	Disabled      []string               `protobuf:"bytes,3,rep,name=disabled,proto3" json:"disabled,omitempty"`
+	Inferred      []string               `protobuf:"bytes,4,rep,name=inferred,proto3" json:"inferred,omitempty"`
+	Intent        string                 `protobuf:"bytes,5,opt,name=intent,proto3" json:"intent,omitempty"`
+	PrefixOnly    []string               `protobuf:"bytes,6,rep,name=prefix_only,json=prefixOnly,proto3" json:"prefix_only,omitempty"`
+	Order         []string               `protobuf:"bytes,7,rep,name=order,proto3" json:"order,omitempty"`
+	Hidden        map[string]int64       `protobuf:"bytes,8,rep,name=hidden,proto3" json:"hidden,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Meta) Reset() {
+	*x = Meta{}
+	mi := &file_result_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Meta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Meta) ProtoMessage() {}
+
+func (x *Meta) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Meta.ProtoReflect.Descriptor instead.
+func (*Meta) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Meta) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Meta) GetDegraded() []string {
+	if x != nil {
+		return x.Degraded
+	}
+	return nil
+}
+
+func (x *Meta) GetDisabled() []string {
+	if x != nil {
+		return x.Disabled
+	}
+	return nil
+}
+
+func (x *Meta) GetInferred() []string {
+	if x != nil {
+		return x.Inferred
+	}
+	return nil
+}
+
+func (x *Meta) GetIntent() string {
+	if x != nil {
+		return x.Intent
+	}
+	return ""
+}
+
+func (x *Meta) GetPrefixOnly() []string {
+	if x != nil {
+		return x.PrefixOnly
+	}
+	return nil
+}
+
+func (x *Meta) GetOrder() []string {
+	if x != nil {
+		return x.Order
+	}
+	return nil
+}
+
+func (x *Meta) GetHidden() map[string]int64 {
+	if x != nil {
+		return x.Hidden
+	}
+	return nil
+}
+
+type Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Find          string                 `protobuf:"bytes,1,opt,name=find,proto3" json:"find,omitempty"`
+	Sugg          []string               `protobuf:"bytes,2,rep,name=sugg,proto3" json:"sugg,omitempty"`
+	SuggInf       []*Sugg                `protobuf:"bytes,3,rep,name=sugg_inf,json=suggInf,proto3" json:"sugg_inf,omitempty"`
+	SuggInn       []*Sugg                `protobuf:"bytes,4,rep,name=sugg_inn,json=suggInn,proto3" json:"sugg_inn,omitempty"`
+	SuggAct       []*Sugg                `protobuf:"bytes,5,rep,name=sugg_act,json=suggAct,proto3" json:"sugg_act,omitempty"`
+	SuggOrg       []*Sugg                `protobuf:"bytes,6,rep,name=sugg_org,json=suggOrg,proto3" json:"sugg_org,omitempty"`
+	SuggAtc       []*Sugg                `protobuf:"bytes,7,rep,name=sugg_atc,json=suggAtc,proto3" json:"sugg_atc,omitempty"`
+	Meta          *Meta                  `protobuf:"bytes,8,opt,name=meta,proto3" json:"meta,omitempty"`
+	Parsed        *Parsed                `protobuf:"bytes,9,opt,name=parsed,proto3" json:"parsed,omitempty"`
+	Tokens        []*TokenMatch          `protobuf:"bytes,10,rep,name=tokens,proto3" json:"tokens,omitempty"`
+	Alternates    []*Alternate           `protobuf:"bytes,11,rep,name=alternates,proto3" json:"alternates,omitempty"`
+	SuggKinds     []*KindSection         `protobuf:"bytes,12,rep,name=sugg_kinds,json=suggKinds,proto3" json:"sugg_kinds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_result_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Result) GetFind() string {
+	if x != nil {
+		return x.Find
+	}
+	return ""
+}
+
+func (x *Result) GetSugg() []string {
+	if x != nil {
+		return x.Sugg
+	}
+	return nil
+}
+
+func (x *Result) GetSuggInf() []*Sugg {
+	if x != nil {
+		return x.SuggInf
+	}
+	return nil
+}
+
+func (x *Result) GetSuggInn() []*Sugg {
+	if x != nil {
+		return x.SuggInn
+	}
+	return nil
+}
+
+func (x *Result) GetSuggAct() []*Sugg {
+	if x != nil {
+		return x.SuggAct
+	}
+	return nil
+}
+
+func (x *Result) GetSuggOrg() []*Sugg {
+	if x != nil {
+		return x.SuggOrg
+	}
+	return nil
+}
+
+func (x *Result) GetSuggAtc() []*Sugg {
+	if x != nil {
+		return x.SuggAtc
+	}
+	return nil
+}
+
+func (x *Result) GetMeta() *Meta {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *Result) GetParsed() *Parsed {
+	if x != nil {
+		return x.Parsed
+	}
+	return nil
+}
+
+func (x *Result) GetTokens() []*TokenMatch {
+	if x != nil {
+		return x.Tokens
+	}
+	return nil
+}
+
+func (x *Result) GetAlternates() []*Alternate {
+	if x != nil {
+		return x.Alternates
+	}
+	return nil
+}
+
+func (x *Result) GetSuggKinds() []*KindSection {
+	if x != nil {
+		return x.SuggKinds
+	}
+	return nil
+}
+
+type KindSection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Sugg          []*Sugg                `protobuf:"bytes,2,rep,name=sugg,proto3" json:"sugg,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KindSection) Reset() {
+	*x = KindSection{}
+	mi := &file_result_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KindSection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KindSection) ProtoMessage() {}
+
+func (x *KindSection) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KindSection.ProtoReflect.Descriptor instead.
+func (*KindSection) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *KindSection) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *KindSection) GetSugg() []*Sugg {
+	if x != nil {
+		return x.Sugg
+	}
+	return nil
+}
+
+type Parsed struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Core          string                 `protobuf:"bytes,1,opt,name=core,proto3" json:"core,omitempty"`
+	Dosage        string                 `protobuf:"bytes,2,opt,name=dosage,proto3" json:"dosage,omitempty"`
+	Form          string                 `protobuf:"bytes,3,opt,name=form,proto3" json:"form,omitempty"`
+	Count         int64                  `protobuf:"varint,4,opt,name=count,proto3" json:"count,omitempty"`
+	Times         int64                  `protobuf:"varint,5,opt,name=times,proto3" json:"times,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Parsed) Reset() {
+	*x = Parsed{}
+	mi := &file_result_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Parsed) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Parsed) ProtoMessage() {}
+
+func (x *Parsed) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Parsed.ProtoReflect.Descriptor instead.
+func (*Parsed) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Parsed) GetCore() string {
+	if x != nil {
+		return x.Core
+	}
+	return ""
+}
+
+func (x *Parsed) GetDosage() string {
+	if x != nil {
+		return x.Dosage
+	}
+	return ""
+}
+
+func (x *Parsed) GetForm() string {
+	if x != nil {
+		return x.Form
+	}
+	return ""
+}
+
+func (x *Parsed) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Parsed) GetTimes() int64 {
+	if x != nil {
+		return x.Times
+	}
+	return 0
+}
+
+type TokenMatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	Names         []string               `protobuf:"bytes,2,rep,name=names,proto3" json:"names,omitempty"`
+	Keys          []string               `protobuf:"bytes,3,rep,name=keys,proto3" json:"keys,omitempty"`
+	Dropped       string                 `protobuf:"bytes,4,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Relaxed       string                 `protobuf:"bytes,5,opt,name=relaxed,proto3" json:"relaxed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenMatch) Reset() {
+	*x = TokenMatch{}
+	mi := &file_result_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenMatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenMatch) ProtoMessage() {}
+
+func (x *TokenMatch) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenMatch.ProtoReflect.Descriptor instead.
+func (*TokenMatch) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TokenMatch) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *TokenMatch) GetNames() []string {
+	if x != nil {
+		return x.Names
+	}
+	return nil
+}
+
+func (x *TokenMatch) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+func (x *TokenMatch) GetDropped() string {
+	if x != nil {
+		return x.Dropped
+	}
+	return ""
+}
+
+func (x *TokenMatch) GetRelaxed() string {
+	if x != nil {
+		return x.Relaxed
+	}
+	return ""
+}
+
+type Alternate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Count         int64                  `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Alternate) Reset() {
+	*x = Alternate{}
+	mi := &file_result_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Alternate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Alternate) ProtoMessage() {}
+
+func (x *Alternate) ProtoReflect() protoreflect.Message {
+	mi := &file_result_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Alternate.ProtoReflect.Descriptor instead.
+func (*Alternate) Descriptor() ([]byte, []int) {
+	return file_result_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Alternate) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *Alternate) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Alternate) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+var File_result_proto protoreflect.FileDescriptor
+
+const file_result_proto_rawDesc = "" +
+	"\n" +
+	"\fresult.proto\x12\ttestbleve\".\n" +
+	"\x04Sugg\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04keys\x18\x02 \x03(\tR\x04keys\"\x89\x03\n" +
+	"\x04Meta\x123\n" +
+	"\x06labels\x18\x01 \x03(\v2\x1b.testbleve.Meta.LabelsEntryR\x06labels\x12\x1a\n" +
+	"\bdegraded\x18\x02 \x03(\tR\bdegraded\x12\x1a\n" +
+	"\bdisabled\x18\x03 \x03(\tR\bdisabled\x12\x1a\n" +
+	"\binferred\x18\x04 \x03(\tR\binferred\x12\x16\n" +
+	"\x06intent\x18\x05 \x01(\tR\x06intent\x12\x1f\n" +
+	"\vprefix_only\x18\x06 \x03(\tR\n" +
+	"prefixOnly\x12\x14\n" +
+	"\x05order\x18\a \x03(\tR\x05order\x123\n" +
+	"\x06hidden\x18\b \x03(\v2\x1b.testbleve.Meta.HiddenEntryR\x06hidden\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vHiddenEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"\xf8\x03\n" +
+	"\x06Result\x12\x12\n" +
+	"\x04find\x18\x01 \x01(\tR\x04find\x12\x12\n" +
+	"\x04sugg\x18\x02 \x03(\tR\x04sugg\x12*\n" +
+	"\bsugg_inf\x18\x03 \x03(\v2\x0f.testbleve.SuggR\asuggInf\x12*\n" +
+	"\bsugg_inn\x18\x04 \x03(\v2\x0f.testbleve.SuggR\asuggInn\x12*\n" +
+	"\bsugg_act\x18\x05 \x03(\v2\x0f.testbleve.SuggR\asuggAct\x12*\n" +
+	"\bsugg_org\x18\x06 \x03(\v2\x0f.testbleve.SuggR\asuggOrg\x12*\n" +
+	"\bsugg_atc\x18\a \x03(\v2\x0f.testbleve.SuggR\asuggAtc\x12#\n" +
+	"\x04meta\x18\b \x01(\v2\x0f.testbleve.MetaR\x04meta\x12)\n" +
+	"\x06parsed\x18\t \x01(\v2\x11.testbleve.ParsedR\x06parsed\x12-\n" +
+	"\x06tokens\x18\n" +
+	" \x03(\v2\x15.testbleve.TokenMatchR\x06tokens\x124\n" +
+	"\n" +
+	"alternates\x18\v \x03(\v2\x14.testbleve.AlternateR\n" +
+	"alternates\x125\n" +
+	"\n" +
+	"sugg_kinds\x18\f \x03(\v2\x16.testbleve.KindSectionR\tsuggKinds\"F\n" +
+	"\vKindSection\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12#\n" +
+	"\x04sugg\x18\x02 \x03(\v2\x0f.testbleve.SuggR\x04sugg\"t\n" +
+	"\x06Parsed\x12\x12\n" +
+	"\x04core\x18\x01 \x01(\tR\x04core\x12\x16\n" +
+	"\x06dosage\x18\x02 \x01(\tR\x06dosage\x12\x12\n" +
+	"\x04form\x18\x03 \x01(\tR\x04form\x12\x14\n" +
+	"\x05count\x18\x04 \x01(\x03R\x05count\x12\x14\n" +
+	"\x05times\x18\x05 \x01(\x03R\x05times\"\x80\x01\n" +
+	"\n" +
+	"TokenMatch\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\x12\x14\n" +
+	"\x05names\x18\x02 \x03(\tR\x05names\x12\x12\n" +
+	"\x04keys\x18\x03 \x03(\tR\x04keys\x12\x18\n" +
+	"\adropped\x18\x04 \x01(\tR\adropped\x12\x18\n" +
+	"\arelaxed\x18\x05 \x01(\tR\arelaxed\"O\n" +
+	"\tAlternate\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\x03R\x05countB*Z(github.com/runningmaster/test-bleve;mainb\x06proto3"
+
+var (
+	file_result_proto_rawDescOnce sync.Once
+	file_result_proto_rawDescData []byte
+)
+
+func file_result_proto_rawDescGZIP() []byte {
+	file_result_proto_rawDescOnce.Do(func() {
+		file_result_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_result_proto_rawDesc), len(file_result_proto_rawDesc)))
+	})
+	return file_result_proto_rawDescData
+}
+
+var file_result_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_result_proto_goTypes = []any{
+	(*Sugg)(nil),        // 0: testbleve.Sugg
+	(*Meta)(nil),        // 1: testbleve.Meta
+	(*Result)(nil),      // 2: testbleve.Result
+	(*KindSection)(nil), // 3: testbleve.KindSection
+	(*Parsed)(nil),      // 4: testbleve.Parsed
+	(*TokenMatch)(nil),  // 5: testbleve.TokenMatch
+	(*Alternate)(nil),   // 6: testbleve.Alternate
+	nil,                 // 7: testbleve.Meta.LabelsEntry
+	nil,                 // 8: testbleve.Meta.HiddenEntry
+}
+var file_result_proto_depIdxs = []int32{
+	7,  // 0: testbleve.Meta.labels:type_name -> testbleve.Meta.LabelsEntry
+	8,  // 1: testbleve.Meta.hidden:type_name -> testbleve.Meta.HiddenEntry
+	0,  // 2: testbleve.Result.sugg_inf:type_name -> testbleve.Sugg
+	0,  // 3: testbleve.Result.sugg_inn:type_name -> testbleve.Sugg
+	0,  // 4: testbleve.Result.sugg_act:type_name -> testbleve.Sugg
+	0,  // 5: testbleve.Result.sugg_org:type_name -> testbleve.Sugg
+	0,  // 6: testbleve.Result.sugg_atc:type_name -> testbleve.Sugg
+	1,  // 7: testbleve.Result.meta:type_name -> testbleve.Meta
+	4,  // 8: testbleve.Result.parsed:type_name -> testbleve.Parsed
+	5,  // 9: testbleve.Result.tokens:type_name -> testbleve.TokenMatch
+	6,  // 10: testbleve.Result.alternates:type_name -> testbleve.Alternate
+	3,  // 11: testbleve.Result.sugg_kinds:type_name -> testbleve.KindSection
+	0,  // 12: testbleve.KindSection.sugg:type_name -> testbleve.Sugg
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_result_proto_init() }
+func file_result_proto_init() {
+	if File_result_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_result_proto_rawDesc), len(file_result_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_result_proto_goTypes,
+		DependencyIndexes: file_result_proto_depIdxs,
+		MessageInfos:      file_result_proto_msgTypes,
+	}.Build()
+	File_result_proto = out.File
+	file_result_proto_goTypes = nil
+	file_result_proto_depIdxs = nil
+}
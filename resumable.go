@@ -0,0 +1,432 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads for big CSVs over flaky links:
+//
+// $ curl -i -X POST 'http://localhost:8080/test/uploads?target=sugg'
+// $ curl -i -X PUT -T chunk1 'http://localhost:8080/test/uploads/{id}?offset=0'
+// $ curl -i http://localhost:8080/test/uploads/{id}     (current offset to resume from)
+// $ curl -i -X PUT -T chunk2 'http://localhost:8080/test/uploads/{id}?offset=N'
+// $ curl -i -X POST http://localhost:8080/test/uploads/{id}/finalize
+//
+// Chunks are appended to a file in cfg.UploadDir; finalize ingests the
+// assembled file at once (X-Content-SHA256 of the whole file is honored).
+// The state of an upload is kept next to its chunks, so that it can be
+// resumed after a restart; uploads untouched for cfg.UploadTTL hours are
+// removed at startup and then every hour, see sweepUploads.
+// The Location of a new upload is its URL, signed for the requests under it
+// when the POST was, see followURL.
+
+type upload struct {
+	sync.Mutex
+	target  string // sugg or sales
+	path    string
+	size    int64
+	updated time.Time
+	gone    bool // finalized or swept, the files are removed
+	writing bool // a chunk is being received, see putChunk
+}
+
+// uploadState is an upload as kept in {id}.json next to its {id}.part
+type uploadState struct {
+	Target  string    `json:"target"`
+	Size    int64     `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+func statePath(part string) string {
+	return strings.TrimSuffix(part, ".part") + ".json"
+}
+
+// save writes the state of the upload, the caller holds its lock
+func (u *upload) save() error {
+	u.updated = time.Now()
+	b, err := json.Marshal(uploadState{Target: u.target, Size: u.size, Updated: u.updated})
+	if err != nil {
+		return err
+	}
+	tmp := statePath(u.path) + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath(u.path))
+}
+
+func (u *upload) remove() {
+	_ = os.Remove(u.path)
+	_ = os.Remove(statePath(u.path))
+}
+
+var uploads = struct {
+	sync.Mutex
+	m map[string]*upload
+}{m: make(map[string]*upload)}
+
+func uploadDir() string {
+	if cfg.UploadDir != "" {
+		return cfg.UploadDir
+	}
+	return filepath.Join(os.TempDir(), "test-bleve-uploads")
+}
+
+func resumableUpload(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/test/uploads"), "/")
+	switch {
+	case rest == "" && r.Method == "POST":
+		initUpload(w, r)
+		return
+	case rest == "":
+	case strings.HasSuffix(rest, "/finalize") && r.Method == "POST":
+		finalizeUpload(w, r, strings.TrimSuffix(rest, "/finalize"))
+		return
+	case r.Method == "PUT":
+		putChunk(w, r, rest)
+		return
+	case r.Method == "GET" || r.Method == "HEAD":
+		u, err := getUpload(rest)
+		if err != nil {
+			internalServerError(w, err, http.StatusNotFound)
+			return
+		}
+		u.Lock()
+		defer u.Unlock()
+		if u.gone {
+			internalServerError(w, fmt.Errorf("upload not found (%s)", rest), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.size, 10))
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, u.size)
+		return
+	}
+	internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+}
+
+func initUpload(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target != "sugg" && target != "sales" {
+		internalServerError(w, fmt.Errorf("invalid target: %q", target), http.StatusBadRequest)
+		return
+	}
+
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	err = os.MkdirAll(uploadDir(), 0700)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	u := &upload{target: target, path: filepath.Join(uploadDir(), hex.EncodeToString(id)+".part")}
+	err = ioutil.WriteFile(u.path, nil, 0600)
+	if err == nil {
+		err = u.save()
+	}
+	if err != nil {
+		u.remove()
+		internalServerError(w, err)
+		return
+	}
+
+	uploads.Lock()
+	uploads.m[hex.EncodeToString(id)] = u
+	uploads.Unlock()
+
+	// the chunks, the offset and finalize are all under the upload
+	w.Header().Set("Location", followURL(r, "/test/uploads/"+hex.EncodeToString(id), true))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, hex.EncodeToString(id))
+}
+
+func getUpload(id string) (*upload, error) {
+	uploads.Lock()
+	defer uploads.Unlock()
+
+	if u, ok := uploads.m[id]; ok {
+		return u, nil
+	}
+	return nil, fmt.Errorf("upload not found (%s)", id)
+}
+
+// putChunk writes a chunk at ?offset=N. The offset may not go beyond the
+// received size, so a chunk can be resent but no gap can appear.
+func putChunk(w http.ResponseWriter, r *http.Request, id string) {
+	u, err := getUpload(id)
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+
+	off, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || off < 0 {
+		internalServerError(w, fmt.Errorf("invalid offset: %q", r.URL.Query().Get("offset")), http.StatusBadRequest)
+		return
+	}
+
+	u.Lock()
+	switch {
+	case u.gone:
+		u.Unlock()
+		internalServerError(w, fmt.Errorf("upload not found (%s)", id), http.StatusNotFound)
+		return
+	case u.writing:
+		u.Unlock()
+		internalServerError(w, fmt.Errorf("a chunk is being received"), http.StatusConflict)
+		return
+	case off > u.size:
+		size := u.size
+		u.Unlock()
+		internalServerError(w, fmt.Errorf("offset %d is beyond received size %d", off, size), http.StatusConflict)
+		return
+	}
+	u.writing = true
+	u.Unlock()
+
+	// the body comes as slow as the client sends it, the upload is not
+	// locked meanwhile: its offset can be read, other chunks and finalize
+	// get 409 and the sweep keeps it
+	n, err := writeChunk(u.path, off, r)
+
+	u.Lock()
+	defer u.Unlock()
+	u.writing = false
+
+	if off+n > u.size {
+		u.size = off + n
+	}
+	if err != nil {
+		// what arrived is kept, the client resumes from Upload-Offset
+		_ = u.save()
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+	err = u.save()
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.size, 10))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, u.size)
+}
+
+// writeChunk writes the body into the file at off and returns how much
+// of it was written
+func writeChunk(path string, off int64, r *http.Request) (int64, error) {
+	defer func() { _ = r.Body.Close() }()
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(f, r.Body)
+}
+
+func finalizeUpload(w http.ResponseWriter, r *http.Request, id string) {
+	u, err := getUpload(id)
+	if err != nil {
+		internalServerError(w, err, http.StatusNotFound)
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	if u.gone {
+		internalServerError(w, fmt.Errorf("upload not found (%s)", id), http.StatusNotFound)
+		return
+	}
+	if u.writing {
+		internalServerError(w, fmt.Errorf("a chunk is being received"), http.StatusConflict)
+		return
+	}
+
+	b, err := ioutil.ReadFile(u.path)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	if int64(len(b)) < u.size {
+		// the file lost its tail, the client resumes from Upload-Offset
+		err = fmt.Errorf("received %d bytes, the file has %d", u.size, len(b))
+		u.size = int64(len(b))
+		_ = u.save()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.size, 10))
+		internalServerError(w, err, http.StatusConflict)
+		return
+	}
+	b = b[:u.size]
+
+	err = verifySHA256(r, b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := readCSV(b)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if u.target == "sugg" {
+		err = checkRecords(rec, 6)
+	} else {
+		err = checkRecords(rec, 2)
+	}
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	fn := func() error { return ingestSales(rec) }
+	if u.target == "sugg" {
+		fn = func() error { return ingestSugg(rec) }
+	}
+	queued, err := runIngest(u.target, len(rec)-1, wantQueue(r), fn)
+	if err != nil {
+		ingestFailed(w, queued, err) // the chunks stay for a retry
+		return
+	}
+
+	uploads.Lock()
+	delete(uploads.m, id)
+	uploads.Unlock()
+	u.remove()
+	u.gone = true
+
+	if ingestFailed(w, queued, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, len(rec)-1)
+}
+
+// restoreUploads takes up the uploads kept in the upload dir, the expired
+// ones are removed
+func restoreUploads() {
+	names, err := filepath.Glob(filepath.Join(uploadDir(), "*.json"))
+	if err != nil {
+		log.Printf("err: uploads: %s", err.Error())
+		return
+	}
+
+	uploads.Lock()
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		if _, ok := uploads.m[id]; ok {
+			continue
+		}
+		u, err := readUpload(strings.TrimSuffix(name, ".json") + ".part")
+		if err != nil {
+			log.Printf("err: upload %s: %s", id, err.Error())
+			continue
+		}
+		uploads.m[id] = u
+	}
+	uploads.Unlock()
+
+	sweepUploads(time.Now())
+}
+
+func readUpload(part string) (*upload, error) {
+	b, err := ioutil.ReadFile(statePath(part))
+	if err != nil {
+		return nil, err
+	}
+	var v uploadState
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(part)
+	if err != nil {
+		return nil, err
+	}
+
+	// a chunk written after the last state is sent again by the client
+	u := &upload{target: v.Target, path: part, size: v.Size, updated: v.Updated}
+	if fi.Size() < u.size {
+		u.size = fi.Size()
+	}
+	return u, nil
+}
+
+// sweepUploads removes the uploads untouched for cfg.UploadTTL hours by
+// now, and the files in the upload dir of no upload as old as them.
+// An upload is locked only after uploads is released, as finalizeUpload
+// takes uploads under the lock of its upload.
+func sweepUploads(now time.Time) {
+	old := now.Add(-time.Duration(cfg.UploadTTL) * time.Hour)
+
+	uploads.Lock()
+	m := make(map[string]*upload, len(uploads.m))
+	for id, u := range uploads.m {
+		m[id] = u
+	}
+	uploads.Unlock()
+
+	keep := make(map[string]bool, len(m))
+	for id, u := range m {
+		u.Lock()
+		switch {
+		case u.gone:
+		case u.updated.Before(old) && !u.writing:
+			uploads.Lock()
+			if uploads.m[id] == u {
+				delete(uploads.m, id)
+			}
+			uploads.Unlock()
+			u.remove()
+			u.gone = true
+		default:
+			keep[u.path] = true
+			keep[statePath(u.path)] = true
+		}
+		u.Unlock()
+	}
+
+	names, _ := filepath.Glob(filepath.Join(uploadDir(), "*"))
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err == nil && !keep[name] && fi.ModTime().Before(old) {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+func uploadsLoop() {
+	for now := range time.Tick(time.Hour) {
+		sweepUploads(now)
+	}
+}
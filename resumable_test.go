@@ -0,0 +1,239 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resetUploads forgets the uploads in memory, as a restart does
+func resetUploads() {
+	uploads.Lock()
+	uploads.m = make(map[string]*upload)
+	uploads.Unlock()
+}
+
+func TestUploadRestart(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.UploadDir = t.TempDir()
+
+	w := serve("POST", "/test/uploads?target=sales", "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	path := w.Header().Get("Location")
+	half := len(fixtureSales) / 2
+	if w := serve("PUT", path+"?offset=0", string(fixtureSales[:half]), false); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+
+	resetUploads()
+	restoreUploads()
+
+	w = serve("GET", path, "", false)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("offset after restart: %d %q, want %d", w.Code, w.Header().Get("Upload-Offset"), half)
+	}
+	if w := serve("PUT", path+"?offset="+strconv.Itoa(half), string(fixtureSales[half:]), false); w.Code != http.StatusOK {
+		t.Fatalf("put after restart: %d %s", w.Code, w.Body)
+	}
+	if w := serve("POST", path+"/finalize", "", false); w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body)
+	}
+	if names, _ := filepath.Glob(filepath.Join(cfg.UploadDir, "*")); len(names) != 0 {
+		t.Errorf("left after finalize: %v", names)
+	}
+}
+
+func TestSweepUploads(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.UploadDir = t.TempDir()
+	defer resetUploads()
+
+	w := serve("POST", "/test/uploads?target=sales", "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	path := w.Header().Get("Location")
+
+	orphan := filepath.Join(cfg.UploadDir, "orphan.part")
+	err := os.WriteFile(orphan, []byte("x"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Duration(cfg.UploadTTL+1) * time.Hour)
+	err = os.Chtimes(orphan, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sweepUploads(time.Now())
+	if w := serve("GET", path, "", false); w.Code != http.StatusOK {
+		t.Errorf("fresh upload swept: %d", w.Code)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan not swept: %v", err)
+	}
+
+	sweepUploads(time.Now().Add(time.Duration(cfg.UploadTTL+1) * time.Hour))
+	if w := serve("GET", path, "", false); w.Code != http.StatusNotFound {
+		t.Errorf("expired upload: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if names, _ := filepath.Glob(filepath.Join(cfg.UploadDir, "*")); len(names) != 0 {
+		t.Errorf("left after sweep: %v", names)
+	}
+}
+
+// TestSweepDuringFinalize sweeps while a finalize is ingesting under the
+// lock of its upload, the sales store is held to keep the ingest running
+func TestSweepDuringFinalize(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.UploadDir = t.TempDir()
+	defer resetUploads()
+
+	w := serve("POST", "/test/uploads?target=sales", "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	path := w.Header().Get("Location")
+	if w := serve("PUT", path+"?offset=0", string(fixtureSales), false); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+
+	st, ok := dataStore.(*memStore)
+	if !ok {
+		t.Skip("not the memory store")
+	}
+	st.Lock()
+	finalized := make(chan int)
+	go func() {
+		finalized <- serve("POST", path+"/finalize", "", false).Code
+	}()
+	for running := false; !running; {
+		time.Sleep(time.Millisecond)
+		ingests.Lock()
+		running = ingests.active != nil
+		ingests.Unlock()
+	}
+
+	swept := make(chan struct{})
+	go func() {
+		sweepUploads(time.Now().Add(time.Duration(cfg.UploadTTL+1) * time.Hour))
+		close(swept)
+	}()
+	time.Sleep(10 * time.Millisecond) // the sweep waits for the upload
+	st.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	select {
+	case code := <-finalized:
+		if code != http.StatusOK {
+			t.Errorf("finalize: %d", code)
+		}
+	case <-timeout:
+		t.Fatal("finalize deadlocked with the sweep")
+	}
+	select {
+	case <-swept:
+	case <-timeout:
+		t.Fatal("sweep deadlocked with the finalize")
+	}
+	if w := serve("GET", path, "", false); w.Code != http.StatusNotFound {
+		t.Errorf("finalized upload: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestSlowChunk sends a chunk slowly: the upload answers its offset
+// meanwhile and refuses another chunk and finalize until it is received
+func TestSlowChunk(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.UploadDir = t.TempDir()
+	defer resetUploads()
+
+	w := serve("POST", "/test/uploads?target=sales", "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	path := w.Header().Get("Location")
+
+	pr, pw := io.Pipe()
+	put := make(chan *httptest.ResponseRecorder)
+	go func() {
+		r := httptest.NewRequest("PUT", path+"?offset=0", pr)
+		r.RemoteAddr = "127.0.0.1:1"
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		put <- w
+	}()
+	half := len(fixtureSales) / 2
+	if _, err := pw.Write(fixtureSales[:half]); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if w := serve("GET", path, "", false); w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "0" {
+			t.Errorf("offset while receiving: %d %q", w.Code, w.Header().Get("Upload-Offset"))
+		}
+		if w := serve("PUT", path+"?offset=0", string(fixtureSales), false); w.Code != http.StatusConflict {
+			t.Errorf("another chunk while receiving: got %d, want %d", w.Code, http.StatusConflict)
+		}
+		if w := serve("POST", path+"/finalize", "", false); w.Code != http.StatusConflict {
+			t.Errorf("finalize while receiving: got %d, want %d", w.Code, http.StatusConflict)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upload is locked while a chunk is received")
+	}
+
+	if _, err := pw.Write(fixtureSales[half:]); err != nil {
+		t.Fatal(err)
+	}
+	_ = pw.Close()
+	if w := <-put; w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != strconv.Itoa(len(fixtureSales)) {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if w := serve("POST", path+"/finalize", "", false); w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestFinalizeShortFile finalizes an upload whose file lost its tail
+func TestFinalizeShortFile(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.UploadDir = t.TempDir()
+	defer resetUploads()
+
+	w := serve("POST", "/test/uploads?target=sales", "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	path := w.Header().Get("Location")
+	if w := serve("PUT", path+"?offset=0", string(fixtureSales), false); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+
+	half := len(fixtureSales) / 2
+	part := filepath.Join(cfg.UploadDir, filepath.Base(path)+".part")
+	if err := os.Truncate(part, int64(half)); err != nil {
+		t.Fatal(err)
+	}
+	w = serve("POST", path+"/finalize", "", false)
+	if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("finalize of a short file: %d %q, want %d %d", w.Code, w.Header().Get("Upload-Offset"), http.StatusConflict, half)
+	}
+	if w := serve("PUT", path+"?offset="+strconv.Itoa(half), string(fixtureSales[half:]), false); w.Code != http.StatusOK {
+		t.Fatalf("put the rest: %d %s", w.Code, w.Body)
+	}
+	if w := serve("POST", path+"/finalize", "", false); w.Code != http.StatusOK {
+		t.Fatalf("finalize: %d %s", w.Code, w.Body)
+	}
+}
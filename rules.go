@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/rules
+// $ curl -i -H 'X-Api-Key: secret' -d '[{"pattern": "(?i)нурофен", "replace": "ибупрофен"}]' http://localhost:8080/admin/rules
+// $ curl -i -H 'X-Api-Key: secret' -d '{"name": "нурофен форте"}' http://localhost:8080/admin/rules/test
+
+// rewriteRule rewrites matching queries before search, e.g. a brand name
+// to its generic one. Replace may refer to groups ($1).
+type rewriteRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+	re      *regexp.Regexp
+}
+
+var rewriteRules = struct {
+	sync.RWMutex
+	list []rewriteRule
+}{}
+
+func compileRules(list []rewriteRule) ([]rewriteRule, error) {
+	res := make([]rewriteRule, len(list))
+	for i := range list {
+		re, err := regexp.Compile(list[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		res[i] = list[i]
+		res[i].re = re
+	}
+	return res, nil
+}
+
+func setRules(list []rewriteRule) error {
+	list, err := compileRules(list)
+	if err != nil {
+		return err
+	}
+
+	rewriteRules.Lock()
+	rewriteRules.list = list
+	rewriteRules.Unlock()
+	resCache.purge()
+	staleETags()
+	return nil
+}
+
+func getRules() []rewriteRule {
+	rewriteRules.RLock()
+	defer rewriteRules.RUnlock()
+	return rewriteRules.list
+}
+
+// applyRules runs the rules in order and returns the rewritten query
+// along with the indexes of the rules that fired.
+func applyRules(list []rewriteRule, s string) (string, []int) {
+	var fired []int
+	for i := range list {
+		if list[i].re.MatchString(s) {
+			s = list[i].re.ReplaceAllString(s, list[i].Replace)
+			fired = append(fired, i)
+		}
+	}
+	return strings.Join(strings.Fields(s), " "), fired
+}
+
+func rewriteQuery(s string) string {
+	list := getRules()
+	if len(list) == 0 {
+		return s
+	}
+	res, _ := applyRules(list, s)
+	return res
+}
+
+func adminRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var list []rewriteRule
+		err = json.Unmarshal(b, &list)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		err = setRules(list)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := getRules()
+	if list == nil {
+		list = []rewriteRule{}
+	}
+	b, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// adminRulesTest is a dry run: it rewrites the name with the active rules
+// or with the rules given in the request, without changing anything.
+func adminRulesTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := struct {
+		Name  string        `json:"name"`
+		Rules []rewriteRule `json:"rules"`
+	}{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	list := getRules()
+	if v.Rules != nil {
+		list, err = compileRules(v.Rules)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := struct {
+		Name  string        `json:"name"`
+		Query string        `json:"query"`
+		Fired []rewriteRule `json:"fired,omitempty"`
+	}{Name: v.Name}
+
+	var fired []int
+	res.Query, fired = applyRules(list, v.Name)
+	for _, i := range fired {
+		res.Fired = append(res.Fired, list[i])
+	}
+
+	b, err = json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
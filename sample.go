@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The sample feeds "you may be looking for" carousels: n docs of a kind
+// drawn at random in proportion to their sales (the last sales upload),
+// without repeats. Docs without sales and deleted ones are never drawn.
+// The weights are collected once per generation and sales upload (see
+// memoState), a draw then costs n binary searches.
+//
+// $ curl -i 'http://localhost:8080/test/sample?kind=inf&n=20'
+
+const sampleMax = 100
+
+type sampler struct {
+	docs []*baseDoc
+	cum  []int64 // cumulative sales
+}
+
+var samplers = struct {
+	sync.Mutex
+	gen int64
+	seq uint64
+	m   map[string]*sampler
+}{}
+
+// samplerFor returns the sampler of the vault key in db, built on first use
+func samplerFor(db *index, key string) (*sampler, error) {
+	g, _ := db.generations()
+	if g == nil {
+		return nil, fmt.Errorf("no data uploaded")
+	}
+	seq := atomic.LoadUint64(&etagSeq)
+
+	samplers.Lock()
+	defer samplers.Unlock()
+
+	if samplers.gen != g.ID || samplers.seq != seq || samplers.m == nil {
+		samplers.gen, samplers.seq, samplers.m = g.ID, seq, make(map[string]*sampler)
+	}
+	if s, ok := samplers.m[key]; ok {
+		return s, nil
+	}
+
+	vlt, err := db.getVault(key)
+	if err != nil {
+		return nil, err
+	}
+	kind := strings.Split(key, "-")[0]
+	s := &sampler{}
+	vlt.Range(func(k, v interface{}) bool {
+		d := v.(*baseDoc)
+		if d.Sale > 0 && deletedDoc(kind, k.(string)) == nil {
+			s.docs = append(s.docs, d)
+		}
+		return true
+	})
+	sort.Slice(s.docs, func(i, j int) bool { return s.docs[i].Sale > s.docs[j].Sale }) // best sellers first, see draw
+	s.cum = make([]int64, len(s.docs))
+	var sum int64
+	for i, d := range s.docs {
+		sum += int64(d.Sale)
+		s.cum[i] = sum
+	}
+	samplers.m[key] = s
+	return s, nil
+}
+
+// draw picks n docs without repeats, all of them if there are no more
+func (s *sampler) draw(n int) []*baseDoc {
+	if n >= len(s.docs) {
+		out := append([]*baseDoc{}, s.docs...)
+		rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+		return out
+	}
+
+	out := make([]*baseDoc, 0, n)
+	seen := make(map[int]bool, n)
+	total := s.cum[len(s.cum)-1]
+	// the best sellers are drawn again and again, the tries are bounded
+	// and the rest is filled in by sales
+	for try := 0; len(out) < n && try < 20*n; try++ {
+		x := rand.Int63n(total)
+		i := sort.Search(len(s.cum), func(i int) bool { return s.cum[i] > x })
+		if !seen[i] {
+			seen[i] = true
+			out = append(out, s.docs[i])
+		}
+	}
+	for i := 0; len(out) < n; i++ {
+		if !seen[i] {
+			out = append(out, s.docs[i])
+		}
+	}
+	return out
+}
+
+func selectSample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind == "" {
+		kind = "inf"
+	}
+	if !contains(kindOrder, kind) {
+		internalServerError(w, fmt.Errorf("unknown kind: %q", kind), http.StatusBadRequest)
+		return
+	}
+	n := 20
+	if s := q.Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n <= 0 || n > sampleMax {
+			internalServerError(w, fmt.Errorf("invalid n: %q (1..%d)", s, sampleMax), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := kind + "-ru"
+	if langUA(r.Header) {
+		key = kind + "-ua"
+	}
+
+	docs := []*baseDoc{}
+	if !kindDisabled(kind) {
+		s, err := samplerFor(dataIndex(r), key)
+		if err != nil {
+			internalServerError(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		docs = s.draw(n)
+	}
+
+	b, err := marshalJSON(r, docs)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	validateResponse(w, r, "docs", b)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store") // another draw every time
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
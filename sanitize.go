@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Queries pasted from messengers carry zero width joiners and spaces, soft
+// hyphens, direction marks, NBSP and emoji. An invisible character inside
+// a word splits it in two (see normName) and an emoji counts as a
+// character of the query. sanitizeQuery runs on the query before it is
+// checked (see checkQueryLength) and searched:
+//
+//	format characters (Cf), controls, variation selectors  removed
+//	NBSP and the other spaces, emoji                        a space
+//
+// and the spaces are collapsed.
+
+// sanitizeQuery returns the query without invisible characters and emoji
+func sanitizeQuery(s string) string {
+	clean := true
+	for _, c := range s {
+		if c >= 0x80 && (unicode.In(c, unicode.Cf, unicode.Cc, unicode.Zs, unicode.Variation_Selector) || isEmoji(c)) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+
+	return strings.Join(strings.Fields(strings.Map(func(c rune) rune {
+		switch {
+		case unicode.In(c, unicode.Cf, unicode.Variation_Selector),
+			unicode.Is(unicode.Cc, c) && !unicode.IsSpace(c):
+			return -1
+		case unicode.Is(unicode.Zs, c), isEmoji(c):
+			return ' '
+		}
+		return c
+	}, s)), " ")
+}
+
+// isEmoji tells the pictographs, dingbats, flags, skin tones and keycaps
+func isEmoji(c rune) bool {
+	switch {
+	case c >= 0x1F000 && c <= 0x1FAFF, // mahjong to symbols and pictographs extended-a
+		c >= 0x2600 && c <= 0x27BF,   // miscellaneous symbols, dingbats
+		c >= 0x2B00 && c <= 0x2BFF,   // arrows, stars
+		c >= 0xE0000 && c <= 0xE007F, // tags (subdivision flags)
+		c == 0x20E3:                  // combining enclosing keycap
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// The JSON responses are described by the schemas in schema/ (served at
+// /docs/schema/{name}.json). With cfg.ValidateResponses every JSON
+// response is checked against its schema and a mismatch is logged and
+// reported in the X-Schema-Errors header, so drift shows before clients
+// break. It costs a full decode per response, for debugging only.
+//
+// $ curl -i http://localhost:8080/docs/schema/result.json
+
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+var schemas = map[string]*gojsonschema.Schema{}
+
+func init() {
+	for _, name := range []string{"result", "docs", "interactions", "spellcheck"} {
+		b, err := schemaFS.ReadFile("schema/" + name + ".json")
+		if err != nil {
+			panic(err)
+		}
+		s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+		if err != nil {
+			panic(fmt.Errorf("schema %s: %v", name, err))
+		}
+		schemas[name] = s
+	}
+}
+
+// validateResponse checks the JSON body against the named schema when
+// response validation is on
+func validateResponse(w http.ResponseWriter, r *http.Request, name string, b []byte) {
+	if !cfg.ValidateResponses {
+		return
+	}
+
+	res, err := schemas[name].Validate(gojsonschema.NewBytesLoader(b))
+	if err != nil {
+		log.Printf("err: schema %s: %s", name, err.Error())
+		return
+	}
+	if res.Valid() {
+		return
+	}
+
+	errs := make([]string, len(res.Errors()))
+	for i, e := range res.Errors() {
+		errs[i] = e.String()
+	}
+	log.Printf("err: %s: response does not match schema %s: %s", r.URL.Path, name, strings.Join(errs, "; "))
+	w.Header().Set("X-Schema-Errors", strings.Join(errs, "; "))
+}
+
+func schemaDocs(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/docs/schema/")
+	b, err := schemaFS.ReadFile("schema/" + name)
+	if err != nil || !strings.HasSuffix(name, ".json") {
+		internalServerError(w, fmt.Errorf("schema not found: %s", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
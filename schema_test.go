@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// loadSchemas compiles every schema in schema/, not only the ones
+// validateResponse uses
+func loadSchemas(t *testing.T) map[string]*gojsonschema.Schema {
+	files, err := fs.ReadDir(schemaFS, "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]*gojsonschema.Schema, len(files))
+	for _, f := range files {
+		b, err := schemaFS.ReadFile("schema/" + f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name(), err)
+		}
+		res[strings.TrimSuffix(f.Name(), ".json")] = s
+	}
+	for name := range schemas {
+		if res[name] == nil {
+			t.Errorf("%s: no schema file", name)
+		}
+	}
+	return res
+}
+
+func validate(t *testing.T, s *gojsonschema.Schema, what string, b []byte) {
+	t.Helper()
+	res, err := s.Validate(gojsonschema.NewBytesLoader(b))
+	if err != nil {
+		t.Errorf("%s: %v", what, err)
+		return
+	}
+	for _, e := range res.Errors() {
+		t.Errorf("%s: %s\n%s", what, e.String(), b)
+	}
+}
+
+// TestSchemas checks the responses to the fixtures against their schemas
+func TestSchemas(t *testing.T) {
+	all := loadSchemas(t)
+	for _, v := range []struct {
+		schema, method, path, body string
+		ua                         bool
+	}{
+		{"result", "POST", "/test/select-sugg", `{"name":"кислота"}`, false},
+		{"result", "POST", "/test/select-sugg", `{"name":"кислота"}`, true},
+		{"result", "POST", "/test/select-suggestion", `{"name":"кислота","parse":true,"tokens":true}`, false},
+		{"result", "POST", "/test/select-suggestion", `{"name":"нурофен 200 мг","limit":2,"key_format":"canonical"}`, true},
+		{"result", "POST", "/test/select-suggestion", `{"name":"zzzzzz"}`, false},
+		{"result", "POST", "/test/select-name", `{"name":"дарница"}`, false},
+		{"result", "GET", "/test/suggest?q=%D0%BA%D0%B8%D1%81%D0%BB%D0%BE%D1%82%D0%B0", "", false},
+		{"docs", "GET", "/test/barcode/5000158062375", "", false},
+		{"docs", "GET", "/test/regnum?q=UA/4120/01/01", "", false},
+		{"docs", "GET", "/test/sample?kind=inf&n=2", "", false},
+		{"spellcheck", "POST", "/test/spellcheck", `{"name":"кислота"}`, false},
+		{"spellcheck", "POST", "/test/spellcheck", `{"name":"кислата"}`, false},
+		{"interactions", "GET", "/test/interactions?ids=103,105,201", "", false},
+	} {
+		w := serve(v.method, v.path, v.body, v.ua)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: %d %s", v.method, v.path, w.Code, w.Body)
+			continue
+		}
+		validate(t, all[v.schema], v.method+" "+v.path+" "+v.body, w.Body.Bytes())
+	}
+}
+
+// TestQueryLogSchema checks the query log lines of the fixture searches
+func TestQueryLogSchema(t *testing.T) {
+	all := loadSchemas(t)
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.QueryLogOff = false
+	cfg.QueryLog = filepath.Join(t.TempDir(), "queries.jsonl")
+	defer func() {
+		queryLog.Lock()
+		if queryLog.f != nil {
+			_ = queryLog.f.Close()
+			queryLog.f = nil
+		}
+		queryLog.Unlock()
+	}()
+
+	for _, v := range []struct{ path, body string }{
+		{"/test/select-sugg", `{"name":"кислота"}`},
+		{"/test/select-suggestion", `{"name":"rbckjnf"}`},
+		{"/test/select-name", `{"name":"дарница"}`},
+		{"/test/select-suggestion", `{"name":"x"}`},
+	} {
+		serve("POST", v.path, v.body, false)
+	}
+
+	f, err := os.Open(cfg.QueryLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	n := 0
+	for s := bufio.NewScanner(f); s.Scan(); n++ {
+		validate(t, all["querylog"], "query log", s.Bytes())
+	}
+	if n != 4 {
+		t.Errorf("got %d query log lines, want 4", n)
+	}
+}
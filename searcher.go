@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+)
+
+// Searcher is how the handlers, ranking and caches reach the indexes of
+// db (nil: the current generation, on the cluster nodes too), so they can
+// run over the in-memory fakeSearcher of the tests instead of real bleve
+// indexes.
+type Searcher interface {
+	// Find returns the names matching in the kind index key ("inf-ru")
+	// with the ids of their docs
+	Find(ctx context.Context, db *index, key, name string, mode searchMode) (map[string][]string, error)
+	// Split returns the query with its unknown words split by the name
+	// terms of the keys (see splitVariants)
+	Split(db *index, keys []string, query string) []string
+	// Terms returns the name terms of every kind in the language
+	Terms(db *index, ua bool) (compoundDict, error)
+	// Barcode returns the docs of the key with the EAN
+	Barcode(ctx context.Context, db *index, key, ean string) ([]*baseDoc, error)
+}
+
+// bleveSearcher searches the bleve indexes, local or on the cluster nodes
+type bleveSearcher struct{}
+
+func (bleveSearcher) Find(ctx context.Context, db *index, key, name string, mode searchMode) (map[string][]string, error) {
+	if db == nil {
+		return findAny(ctx, key, name, mode)
+	}
+	return db.findByName(ctx, key, name, mode)
+}
+
+func (bleveSearcher) Split(db *index, keys []string, query string) []string {
+	return orCurrent(db).splitVariants(keys, query)
+}
+
+func (bleveSearcher) Terms(db *index, ua bool) (compoundDict, error) {
+	g, _ := orCurrent(db).generations()
+	if g == nil {
+		return nil, errNoData
+	}
+	return g.spellDict(ua), nil
+}
+
+func (bleveSearcher) Barcode(ctx context.Context, db *index, key, ean string) ([]*baseDoc, error) {
+	q := bleve.NewTermQuery(ean)
+	q.SetField(field(key, "ean"))
+	return findDocs(ctx, orCurrent(db), key, q)
+}
+
+func orCurrent(db *index) *index {
+	if db == nil {
+		return indexDB
+	}
+	return db
+}
+
+var searcher Searcher = bleveSearcher{}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// The self check tells an uptime monitor that the search still finds what
+// it should, not just that the process is up: the canary queries of
+// cfg.Canaries are run and every kind must return at least the entries
+// asked for (products counted by keys, as in the limit), disabled kinds
+// are skipped. Any miss, or no data at all, answers 503.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/selfcheck
+
+// canary is a query that must find at least Min entries of each kind
+type canary struct {
+	Query string         `json:"query"`
+	Lang  string         `json:"lang,omitempty"` // ru (default) or ua
+	Min   map[string]int `json:"min"`
+}
+
+func checkCanaries(list []canary, kinds []string) error {
+	for _, c := range list {
+		if c.Query == "" {
+			return fmt.Errorf("canaries: empty query")
+		}
+		if c.Lang != "" && c.Lang != "ru" && c.Lang != "ua" {
+			return fmt.Errorf("canaries: %s: unknown lang %q", c.Query, c.Lang)
+		}
+		for k := range c.Min {
+			if !contains(kinds, k) {
+				return fmt.Errorf("canaries: %s: unknown kind %q", c.Query, k)
+			}
+		}
+	}
+	return nil
+}
+
+type canaryKind struct {
+	Min  int `json:"min"`
+	Hits int `json:"hits"`
+}
+
+type canaryCheck struct {
+	Query string                `json:"query"`
+	Lang  string                `json:"lang"`
+	OK    bool                  `json:"ok"`
+	Kinds map[string]canaryKind `json:"kinds,omitempty"`
+	Error string                `json:"error,omitempty"`
+}
+
+// runCanary searches the canary as /test/select-suggestion does
+func runCanary(c canary) canaryCheck {
+	res := canaryCheck{Query: c.Query, Lang: c.Lang, OK: true, Kinds: make(map[string]canaryKind, len(c.Min))}
+	if res.Lang == "" {
+		res.Lang = "ru"
+	}
+
+	r, err := suggest(context.Background(), nil, c.Query, res.Lang == "ua", 0, 0, cfg.Fuzziness, anyProduct)
+	if err == nil {
+		err = r.failed()
+	}
+	if err != nil {
+		res.OK, res.Error = false, err.Error()
+		return res
+	}
+
+	sec := r.sections()
+	for k, min := range c.Min {
+		if kindDisabled(k) {
+			continue // switched off on purpose, see adminKinds
+		}
+		n := sectionLen(k, *sec[k])
+		res.Kinds[k] = canaryKind{Min: min, Hits: n}
+		if n < min {
+			res.OK = false
+		}
+	}
+	return res
+}
+
+func adminSelfCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := struct {
+		OK         bool          `json:"ok"`
+		Generation int64         `json:"generation,omitempty"`
+		Checks     []canaryCheck `json:"checks"`
+		Error      string        `json:"error,omitempty"`
+	}{OK: true, Checks: make([]canaryCheck, 0, len(cfg.Canaries))}
+
+	if g, _ := indexDB.generations(); g != nil {
+		res.Generation = g.ID
+		for _, c := range cfg.Canaries {
+			ch := runCanary(c)
+			res.OK = res.OK && ch.OK
+			res.Checks = append(res.Checks, ch)
+		}
+	} else {
+		res.OK, res.Error = false, "no data uploaded"
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	code := http.StatusOK
+	if !res.OK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, string(b))
+}
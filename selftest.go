@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// The startup self-test (-selftest) keeps a bad build out of the load
+// balancer: it indexes the embedded fixtures in memory, checks the
+// collators and keyboard maps, runs canary searches through the handlers
+// (and cfg.Canaries over the fixtures), logs every check and exits 1 if
+// one failed. Nothing outside the process is touched.
+//
+// $ go run . -config config.json -selftest && echo ok
+
+// selfTestSearch is a canary search of the fixtures: the response must
+// be 200 and contain want
+type selfTestSearch struct {
+	path, body string
+	ua         bool
+	want       string
+}
+
+var selfTestSearches = []selfTestSearch{
+	{"/test/select-sugg", `{"name":"кислота"}`, false, "АСКОРБИНОВАЯ КИСЛОТА"},
+	{"/test/select-sugg", `{"name":"rbckjnf"}`, false, "АСКОРБИНОВАЯ КИСЛОТА"},
+	{"/test/select-suggestion", `{"name":"кислота"}`, false, `"101"`},
+	{"/test/select-suggestion", `{"name":"кислота"}`, true, `"101"`},
+	{"/test/select-name", `{"name":"дарница"}`, false, `"401"`},
+}
+
+// selfTest runs the checks and returns the exit code
+func selfTest() int {
+	// in memory and quiet: no cold dir, database, hot tier or query log
+	cfg.ColdDir, cfg.DB, cfg.HotSize, cfg.QueryLogOff = "", nil, 0, true
+
+	h := setupHandler(http.NewServeMux())
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"fixtures", loadFixtures},
+		{"collation", checkCollation},
+		{"keyboard maps", checkKeyboardMaps},
+		{"searches", func() error { return checkSearches(h) }},
+		{"canaries", checkConfigCanaries},
+	}
+
+	failed := 0
+	for _, c := range checks {
+		start := time.Now()
+		err := c.run()
+		if err != nil {
+			failed++
+			log.Printf("selftest %s: FAIL: %s", c.name, err.Error())
+			continue
+		}
+		log.Printf("selftest %s: ok (%s)", c.name, time.Since(start))
+	}
+	if failed > 0 {
+		log.Printf("selftest: %d of %d checks failed", failed, len(checks))
+		return 1
+	}
+	return 0
+}
+
+// checkCollation sorts names the way the alphabet does, not by bytes
+func checkCollation() error {
+	for _, v := range []struct {
+		tag  language.Tag
+		want []string
+	}{
+		{language.Russian, []string{"Абрикос", "ёж", "Ель", "Яблоко"}},
+		{language.Ukrainian, []string{"Гора", "Ґанок", "Іній", "Їжак"}},
+	} {
+		names := make([]string, len(v.want))
+		for i := range v.want {
+			names[i] = v.want[len(v.want)-1-i]
+		}
+		sortNames(collate.New(v.tag), names)
+		if strings.Join(names, ",") != strings.Join(v.want, ",") {
+			return fmt.Errorf("%s: got %v, want %v", v.tag, names, v.want)
+		}
+	}
+	return nil
+}
+
+// checkKeyboardMaps converts between the layouts key by key, every keyboard
+// to en and back
+func checkKeyboardMaps() error {
+	for _, k := range keyboardNames() {
+		err := checkKeyboard(k, keyboard(k))
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range []struct{ s, from, to, want string }{
+		{"rbckjnf", "en", "ru", "кислота"},
+		{"ghbdsn", "en", "uk", "привіт"},
+		{"кислота", "ru", "en", "rbckjnf"},
+	} {
+		if got := convString(v.s, v.from, v.to); got != v.want {
+			return fmt.Errorf("%s-%s %q: got %q, want %q", v.from, v.to, v.s, got, v.want)
+		}
+	}
+	return nil
+}
+
+func checkSearches(h http.Handler) error {
+	for _, v := range selfTestSearches {
+		r := httptest.NewRequest("POST", v.path, strings.NewReader(v.body))
+		r.RemoteAddr = "127.0.0.1:1"
+		if v.ua {
+			r.Header.Set("Accept-Language", "uk")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), v.want) {
+			return fmt.Errorf("%s %s: %d, want %s in %q", v.path, v.body, w.Code, v.want, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	return nil
+}
+
+// checkConfigCanaries runs cfg.Canaries over the fixtures: they are meant
+// for the real data, so a miss is only logged, a failed search fails
+func checkConfigCanaries() error {
+	for _, c := range cfg.Canaries {
+		ch := runCanary(c)
+		if ch.Error != "" {
+			return fmt.Errorf("%s: %s", c.Query, ch.Error)
+		}
+		if !ch.OK {
+			log.Printf("selftest canary %q: misses on the fixtures", c.Query)
+		}
+	}
+	return nil
+}
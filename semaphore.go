@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Concurrent index searches are bounded by a global semaphore of
+// cfg.MaxSearches slots and optional per-kind ones (cfg.MaxKindSearches).
+// A search waits for a slot at most cfg.SearchQueueWait milliseconds and
+// behind at most cfg.SearchQueue others, otherwise the request is shed
+// with 503 and Retry-After instead of piling up on the CPU.
+//
+// $ curl -s http://localhost:8080/debug/vars | jq .search_queue
+
+// errBusy is the error of a search that got no slot
+var errBusy = errors.New("too many concurrent searches")
+
+// searchQueue counts the waits (waits, wait_us) and the rejected
+// searches (rejected, rejected.{kind}) at the semaphores
+var searchQueue = expvar.NewMap("search_queue")
+
+type semaphore struct {
+	slots   chan struct{}
+	waiting int32
+}
+
+// searchSems are the semaphores of the searches by kind, "" is the
+// global one
+var searchSems sync.Map // kind -> *semaphore
+
+func newSemaphore(n int) *semaphore {
+	return &semaphore{slots: make(chan struct{}, n)}
+}
+
+// sized returns the semaphore of key in m with n slots, a new one when n
+// is not the size it has (the config changed); the searches holding the
+// old one release it as they end
+func sized(m *sync.Map, key string, n int) *semaphore {
+	for {
+		v, ok := m.Load(key)
+		if ok && cap(v.(*semaphore).slots) == n {
+			return v.(*semaphore)
+		}
+		s := newSemaphore(n)
+		if !ok {
+			if _, loaded := m.LoadOrStore(key, s); !loaded {
+				return s
+			}
+		} else if m.CompareAndSwap(key, v, s) {
+			return s
+		}
+	}
+}
+
+// semaphoresFor returns the semaphores a search of the index key takes,
+// in order
+func semaphoresFor(key string) []*semaphore {
+	var out []*semaphore
+	kind := strings.Split(key, "-")[0]
+	if n := cfg.MaxKindSearches[kind]; n > 0 {
+		out = append(out, sized(&searchSems, kind, n))
+	}
+	if cfg.MaxSearches > 0 {
+		out = append(out, sized(&searchSems, "", cfg.MaxSearches))
+	}
+	return out
+}
+
+// acquire takes a slot of every semaphore of the index key and returns
+// the release of them all, errBusy or the error of ctx ended while waiting
+func acquire(ctx context.Context, key string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sems := semaphoresFor(key)
+	var deadline time.Time
+	for i, s := range sems {
+		select {
+		case s.slots <- struct{}{}:
+			continue
+		default:
+		}
+
+		if deadline.IsZero() {
+			deadline = time.Now().Add(time.Duration(cfg.SearchQueueWait) * time.Millisecond)
+		}
+		start := time.Now()
+		ok := s.wait(ctx, deadline, cfg.SearchQueue)
+		searchQueue.Add("waits", 1)
+		searchQueue.Add("wait_us", time.Since(start).Microseconds())
+		if err := ctx.Err(); err != nil {
+			if ok {
+				release(sems[i : i+1])
+			}
+			release(sems[:i])
+			return nil, err
+		}
+		if !ok {
+			release(sems[:i])
+			searchQueue.Add("rejected", 1)
+			searchQueue.Add("rejected."+strings.Split(key, "-")[0], 1)
+			return nil, errBusy
+		}
+	}
+	return func() { release(sems) }, nil
+}
+
+// wait queues for a slot until the deadline or the end of ctx, if there
+// are fewer than queue waiting
+func (s *semaphore) wait(ctx context.Context, deadline time.Time, queue int) bool {
+	if n := atomic.AddInt32(&s.waiting, 1); int(n) > queue {
+		atomic.AddInt32(&s.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt32(&s.waiting, -1)
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func release(sems []*semaphore) {
+	for _, s := range sems {
+		<-s.slots
+	}
+}
+
+// searchFailed writes the error of a failed search: 503 with Retry-After
+// when the searches are saturated, 500 otherwise
+func searchFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBusy) {
+		w.Header().Set("Retry-After", strconv.Itoa(cfg.SearchRetryAfter))
+		internalServerError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	internalServerError(w, err)
+}
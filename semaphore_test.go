@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCanceledSearchFreesSlots cancels a request waiting for the slot of
+// inf: it ends at once and leaves no slot taken
+func TestCanceledSearchFreesSlots(t *testing.T) {
+	defer func(c config) {
+		*cfg = c
+		searchSems.Delete("inf")
+	}(*cfg)
+	cfg.CacheTTL = 0
+	cfg.MaxKindSearches = map[string]int{"inf": 1}
+	cfg.SearchQueueWait = 10000
+	searchSems.Delete("inf")
+
+	hold, err := acquire(context.Background(), "inf-ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := true
+	defer func() {
+		if held {
+			hold()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/test/select-suggestion", strings.NewReader(`{"name":"кислота"}`)).WithContext(ctx)
+	r.RemoteAddr = "127.0.0.1:1"
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		testHandler.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // inf waits for the slot
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("canceled request still waits for its slot")
+	}
+	if w.Code == 200 {
+		t.Errorf("canceled request: got %d", w.Code)
+	}
+
+	hold()
+	held = false
+	for _, s := range semaphoresFor("inf-ru") {
+		if n := len(s.slots); n != 0 {
+			t.Errorf("%d slots taken after the canceled request", n)
+		}
+	}
+}
+
+// TestSemaphoresResize changes the slots in the config: the semaphores
+// follow, a search holding a slot of the old ones still releases it
+func TestSemaphoresResize(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.MaxSearches = 2
+	cfg.MaxKindSearches = map[string]int{"inf": 1}
+
+	hold, err := acquire(context.Background(), "inf-ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := semaphoresFor("inf-ru")
+
+	cfg.MaxSearches = 3
+	cfg.MaxKindSearches = map[string]int{"inf": 2}
+	sems := semaphoresFor("inf-ru")
+	if len(sems) != 2 || cap(sems[0].slots) != 2 || cap(sems[1].slots) != 3 {
+		t.Fatalf("after the config change: %d semaphores", len(sems))
+	}
+	for _, s := range sems {
+		if n := len(s.slots); n != 0 {
+			t.Errorf("%d slots taken in a new semaphore", n)
+		}
+	}
+
+	hold()
+	for _, s := range old {
+		if n := len(s.slots); n != 0 {
+			t.Errorf("%d slots taken in an old semaphore", n)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignedFollowUp runs a resumable upload with a scoped signature of
+// /test/uploads only, the upload URL comes back signed
+func TestSignedFollowUp(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.AdminKey = "admin"
+	cfg.UploadSecret = "secret"
+	cfg.UploadDir = t.TempDir()
+
+	exp := time.Now().Add(time.Minute).Unix()
+	w := serve("POST", "/test/uploads?target=sales&"+signedQuery("/test/uploads", exp, false).Encode(), "", false)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	if !strings.Contains(loc, "sig=") {
+		t.Fatalf("init: unsigned Location %q", loc)
+	}
+	path, query := loc[:strings.Index(loc, "?")], loc[strings.Index(loc, "?"):]
+
+	for _, v := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PUT", path + "?offset=0", string(fixtureSales), http.StatusUnauthorized},
+		{"PUT", path + query + "&offset=0", string(fixtureSales), http.StatusOK},
+		{"GET", path + query, "", http.StatusOK},
+		{"GET", path + "x" + query, "", http.StatusUnauthorized},
+		{"GET", "/test/uploads" + query, "", http.StatusUnauthorized},
+		{"POST", path + "/finalize" + query, "", http.StatusOK},
+	} {
+		if w := serve(v.method, v.path, v.body, false); w.Code != v.code {
+			t.Errorf("%s %s: got %d, want %d %s", v.method, v.path, w.Code, v.code, w.Body)
+		}
+	}
+
+	if w := serve("POST", "/test/uploads?target=sales&expires=1&sig=00", "", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestUploadOIDCOnly checks that the upload routes are closed with OIDC and
+// no admin key, short of a token or a signature
+func TestUploadOIDCOnly(t *testing.T) {
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.AdminKey = ""
+	cfg.OIDC = &oidcConfig{Issuer: "https://idp.invalid", JWKSURL: "https://idp.invalid/keys"}
+	cfg.UploadSecret = "secret"
+
+	exp := time.Now().Add(time.Minute).Unix()
+	for _, v := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/test/upload-sugg2", string(fixtureSales), http.StatusUnauthorized},
+		{"POST", "/test/docs", `{}`, http.StatusUnauthorized},
+		{"POST", "/test/uploads?target=sales", "", http.StatusUnauthorized},
+		{"GET", "/test/jobs/x", "", http.StatusUnauthorized},
+		{"POST", "/test/upload-sugg2?" + signedQuery("/test/upload-sugg2", exp, false).Encode(), string(fixtureSales), http.StatusOK},
+	} {
+		if w := serve(v.method, v.path, v.body, false); w.Code != v.code {
+			t.Errorf("%s %s: got %d, want %d %s", v.method, v.path, w.Code, v.code, w.Body)
+		}
+	}
+}
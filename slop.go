@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/blevesearch/bleve"
+	bindex "github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// bleve 1.0 matches a phrase with no slop at all, so the phrase~N stages
+// (see stageQuery) use slopPhraseQuery: the docs with every phrase word
+// are checked for the words in a row, where N positions may slip in
+// total, as a Lucene sloppy phrase: "нурофен форте" finds "Нурофен экспресс
+// форте" with phrase~1 and "форте нурофен" with phrase~2. A request may
+// ask for it too, its "slop" (or ?slop=) turns the phrase stages sloppy.
+//
+// $ curl -i -d '{"name":"кислота аскорбиновая","slop":2}' http://localhost:8080/test/select-suggestion
+
+type slopPhraseQuery struct {
+	Phrase   string
+	Field    string
+	Analyzer string
+	Slop     int
+	boost    float64
+}
+
+func newSlopPhraseQuery(phrase, field, analyzer string, slop int) *slopPhraseQuery {
+	return &slopPhraseQuery{Phrase: phrase, Field: field, Analyzer: analyzer, Slop: slop, boost: 1}
+}
+
+func (q *slopPhraseQuery) SetBoost(b float64) { q.boost = b }
+func (q *slopPhraseQuery) Boost() float64     { return q.boost }
+
+func (q *slopPhraseQuery) Searcher(i bindex.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	name := q.Analyzer
+	if name == "" {
+		name = m.AnalyzerNameForPath(q.Field)
+	}
+	a := m.AnalyzerNamed(name)
+	if a == nil {
+		return nil, fmt.Errorf("no analyzer named %q registered", name)
+	}
+
+	// the terms by position, a gap (a dropped stop word) is empty
+	var terms [][]string
+	first := -1
+	for _, t := range a.Analyze([]byte(q.Phrase)) {
+		if first < 0 {
+			first = t.Position
+		}
+		for len(terms) <= t.Position-first {
+			terms = append(terms, nil)
+		}
+		terms[t.Position-first] = append(terms[t.Position-first], string(t.Term))
+	}
+
+	var must []query.Query
+	for _, alt := range terms {
+		if len(alt) == 0 {
+			continue
+		}
+		alts := make([]query.Query, len(alt))
+		for j, t := range alt {
+			tq := bleve.NewTermQuery(t)
+			tq.SetField(q.Field)
+			alts[j] = tq
+		}
+		must = append(must, bleve.NewDisjunctionQuery(alts...))
+	}
+	if len(must) == 0 {
+		return bleve.NewMatchNoneQuery().Searcher(i, m, options)
+	}
+
+	cq := bleve.NewConjunctionQuery(must...)
+	cq.SetBoost(q.boost)
+	options.IncludeTermVectors = true
+	s, err := cq.Searcher(i, m, options)
+	if err != nil {
+		return nil, err
+	}
+	return &slopSearcher{Searcher: s, terms: terms, slop: q.Slop}, nil
+}
+
+// slopSearcher passes on the docs of the wrapped conjunction with the
+// phrase in one field value within the slop
+type slopSearcher struct {
+	search.Searcher
+	terms [][]string
+	slop  int
+}
+
+func (s *slopSearcher) Next(ctx *search.SearchContext) (*search.DocumentMatch, error) {
+	for {
+		dm, err := s.Searcher.Next(ctx)
+		if dm == nil || err != nil {
+			return dm, err
+		}
+		if s.match(dm) {
+			return dm, nil
+		}
+		ctx.DocumentMatchPool.Put(dm)
+	}
+}
+
+func (s *slopSearcher) Advance(ctx *search.SearchContext, ID bindex.IndexInternalID) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Advance(ctx, ID)
+	if dm == nil || err != nil {
+		return dm, err
+	}
+	if s.match(dm) {
+		return dm, nil
+	}
+	ctx.DocumentMatchPool.Put(dm)
+	return s.Next(ctx)
+}
+
+func (s *slopSearcher) Min() int { return 0 }
+
+func (s *slopSearcher) DocumentMatchPoolSize() int {
+	return s.Searcher.DocumentMatchPoolSize() + 1
+}
+
+// match looks for the phrase in every value (field and array position)
+func (s *slopSearcher) match(dm *search.DocumentMatch) bool {
+	values := make(map[string]map[string][]int)
+	for _, l := range dm.FieldTermLocations {
+		v := fmt.Sprint(l.Field, l.Location.ArrayPositions)
+		if values[v] == nil {
+			values[v] = make(map[string][]int)
+		}
+		values[v][l.Term] = append(values[v][l.Term], int(l.Location.Pos))
+	}
+	for _, pos := range values {
+		if slopPath(s.terms, pos, 0, s.slop, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// slopPath places the terms one after another from prev (0 before the
+// first), every position off the next one costs its distance of the slop
+// left, a term position is used once
+func slopPath(terms [][]string, pos map[string][]int, prev, slop int, used []int) bool {
+	if len(terms) == 0 {
+		return true
+	}
+	if len(terms[0]) == 0 {
+		next := prev
+		if prev > 0 {
+			next++
+		}
+		return slopPath(terms[1:], pos, next, slop, used)
+	}
+
+	for _, t := range terms[0] {
+	next:
+		for _, p := range pos[t] {
+			dist := 0
+			if prev > 0 {
+				dist = prev + 1 - p
+				if dist < 0 {
+					dist = -dist
+				}
+			}
+			if dist > slop {
+				continue
+			}
+			for _, u := range used {
+				if u == p {
+					continue next
+				}
+			}
+			if slopPath(terms[1:], pos, p, slop-dist, append(used, p)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// POST /test/spellcheck checks the words of a text against the terms of
+// the names (the dictionaries of the split stage, see compoundsOf) of
+// every kind in the language of the request, without a search: a word
+// not in them gets the terms within its fuzzy distance (see wordFuzziness)
+// or sounding like it (see phoneticPattern), the most likely first. The
+// confidence of a candidate is its share of the weight of the candidates
+// and of the word as typed, the weight grows with the term count and
+// falls with the distance, twice as much for a term that sounds alike.
+// "corrected" is the text with the best candidates taken.
+//
+// $ curl -i -d '{"name": "нурафен 200"}' http://localhost:8080/test/spellcheck
+
+const (
+	spellMinLen        = 3  // runes of a checked word
+	spellMaxWords      = 20 // per request
+	spellCandidates    = 3  // per word by default
+	spellMaxCandidates = 10
+)
+
+type spellCandidate struct {
+	Term       string   `json:"term"`
+	Confidence float64  `json:"confidence"`
+	Distance   int      `json:"distance"`
+	Count      uint64   `json:"count"`
+	Match      []string `json:"match"` // fuzzy, phonetic
+	weight     float64
+}
+
+type spellToken struct {
+	Token      string           `json:"token"`
+	Known      bool             `json:"known"`
+	Confidence float64          `json:"confidence"` // of the best, 1 if known
+	Candidates []spellCandidate `json:"candidates,omitempty"`
+}
+
+// spellDict returns the name terms of every kind in the language of g,
+// built on first use
+func (g *generation) spellDict(ua bool) compoundDict {
+	name := "spell/ru"
+	if ua {
+		name = "spell/ua"
+	}
+	if d, ok := g.compounds.Load(name); ok {
+		return d.(compoundDict)
+	}
+
+	d := compoundDict{}
+	seen := make(map[string]bool, len(kindOrder))
+	for _, k := range kindOrder {
+		key := kindKey(k, ua)
+		if f := indexName(key) + "/" + field(key, "name"); !seen[f] {
+			seen[f] = true
+			for t, n := range g.compoundsOf(key) {
+				d[t] += n
+			}
+		}
+	}
+	v, _ := g.compounds.LoadOrStore(name, d)
+	return v.(compoundDict)
+}
+
+// spellToken checks the word against d
+func (d compoundDict) spellToken(word string, limit int) spellToken {
+	t := spellToken{Token: word}
+	if d[word] > 0 || len([]rune(word)) < spellMinLen || !hasLetter(word) {
+		t.Known, t.Confidence = true, 1
+		return t
+	}
+
+	n := wordFuzziness(word, fuzzyMode(maxFuzziness))
+	sound := regexp.MustCompile("^(?:" + strings.TrimSuffix(phoneticPattern(word), ".*") + ")$")
+	size := len([]rune(word))
+	total := 1.0 // the word as typed
+	var res []spellCandidate
+	for term, count := range d {
+		c := spellCandidate{Term: term, Count: count, Distance: -1}
+		if l := len([]rune(term)); l-size <= n && size-l <= n {
+			if dist := editDistance(word, term); dist <= n {
+				c.Distance = dist
+				c.Match = append(c.Match, "fuzzy")
+			}
+		}
+		if sound.MatchString(term) {
+			if c.Distance < 0 {
+				c.Distance = editDistance(word, term)
+			}
+			c.Match = append(c.Match, "phonetic")
+		}
+		if c.Match == nil {
+			continue
+		}
+		c.weight = float64(count+1) / float64((c.Distance+1)*(c.Distance+1))
+		if contains(c.Match, "phonetic") {
+			c.weight *= 2
+		}
+		total += c.weight
+		res = append(res, c)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].weight != res[j].weight {
+			return res[i].weight > res[j].weight
+		}
+		return res[i].Term < res[j].Term
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	for i := range res {
+		res[i].Confidence = math.Round(res[i].weight/total*1000) / 1000
+	}
+	if len(res) > 0 {
+		t.Confidence = res[0].Confidence
+	}
+	t.Candidates = res
+	return t
+}
+
+func hasLetter(s string) bool {
+	for _, c := range s {
+		if unicode.IsLetter(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func selectSpellcheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, bodyStatus(err))
+		return
+	}
+
+	v := struct {
+		Name  string `json:"name"`
+		Limit int    `json:"limit"` // candidates per word
+	}{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v.Name = sanitizeQuery(v.Name)
+	err = checkQueryLength(r, v.Name)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+	words := queryWords(v.Name)
+	if len(words) > spellMaxWords {
+		internalServerError(w, fmt.Errorf("too many words: %d, want up to %d", len(words), spellMaxWords), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case v.Limit == 0:
+		v.Limit = spellCandidates
+	case v.Limit < 0 || v.Limit > spellMaxCandidates:
+		internalServerError(w, fmt.Errorf("invalid limit: %d (1..%d)", v.Limit, spellMaxCandidates), http.StatusBadRequest)
+		return
+	}
+
+	d, err := searcher.Terms(pinnedIndex(r), langUA(r.Header))
+	if err != nil {
+		internalServerError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	res := struct {
+		Find      string       `json:"find"`
+		Corrected string       `json:"corrected"`
+		Tokens    []spellToken `json:"tokens"`
+	}{Find: v.Name, Tokens: make([]spellToken, 0, len(words))}
+	fixed := make([]string, len(words))
+	for i, word := range words {
+		t := d.spellToken(word, v.Limit)
+		fixed[i] = word
+		if len(t.Candidates) > 0 {
+			fixed[i] = t.Candidates[0].Term
+		}
+		res.Tokens = append(res.Tokens, t)
+	}
+	res.Corrected = strings.Join(fixed, " ")
+
+	b, err = marshalJSON(r, res)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	validateResponse(w, r, "spellcheck", b)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+// editDistance is the Levenshtein distance of a and b in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// /admin/state/export returns what an operator set up on top of the
+// indexes: the config (with the synonyms and the ranking settings) without
+// the secrets and the environment (see keepEnv), the live rewrite rules,
+// the tombstones, the disabled kinds and the kind clicks of the priors.
+// POSTed to /admin/state/import of another instance it makes it search the
+// same on the same data: all of it is checked before any is applied, the
+// config replaces the running one but for the environment (it is read as
+// it is, not over the defaults, as the zero settings are left out of the
+// export), the tombstones and the disabled kinds replace the ones there
+// and the clicks seed its kind priors. The settings read only at startup
+// are not applied and are listed in "not_applied" if they differ. A
+// missing part is left as is.
+//
+// $ curl -H 'X-Api-Key: secret' http://localhost:8080/admin/state/export > state.json
+// $ curl -i -H 'X-Api-Key: secret' -d @state.json http://localhost:8089/admin/state/import
+
+type serviceState struct {
+	Exported      time.Time        `json:"exported"`
+	Config        *json.RawMessage `json:"config,omitempty"`
+	Tombstones    []*tombstone     `json:"tombstones"`
+	DisabledKinds []string         `json:"disabled_kinds"`
+	KindClicks    map[string]int   `json:"kind_clicks"`
+}
+
+// keepEnv sets the secrets and the settings of the environment (paths,
+// stores, hooks, clients) from env
+func (c *config) keepEnv(env *config) {
+	c.AdminKey, c.AdminAllow, c.TrustedProxies = env.AdminKey, env.AdminAllow, env.TrustedProxies
+	c.OIDC, c.UploadSecret, c.ClientOrders, c.NodeToken = env.OIDC, env.UploadSecret, env.ClientOrders, env.NodeToken
+	c.RecordDir, c.UploadDir, c.ColdDir = env.RecordDir, env.UploadDir, env.ColdDir
+	c.QueryLog, c.QueryLogMaxSize, c.QueryLogKeep, c.QueryLogOff = env.QueryLog, env.QueryLogMaxSize, env.QueryLogKeep, env.QueryLogOff
+	c.ClickLog, c.Webhooks, c.DB, c.LangPacks = env.ClickLog, env.Webhooks, env.DB, env.LangPacks
+	c.Store, c.StorePath, c.RedisURL = env.Store, env.StorePath, env.RedisURL
+	c.MigrateOnStart, c.MaxOpenIndexes, c.ValidateResponses = env.MigrateOnStart, env.MaxOpenIndexes, env.ValidateResponses
+}
+
+// startupOnly returns the settings of c read only at startup that differ
+// from the running ones, they stay as they are
+func (c *config) startupOnly() []string {
+	var res []string
+	if c.IndexLayout != cfg.IndexLayout {
+		res = append(res, "index_layout")
+		c.IndexLayout = cfg.IndexLayout
+	}
+	if !reflect.DeepEqual(c.Mappings, cfg.Mappings) {
+		res = append(res, "mappings")
+		c.Mappings = cfg.Mappings
+	}
+	if strings.Join(c.Kinds, ",") != strings.Join(cfg.Kinds, ",") {
+		res = append(res, "kinds")
+		c.Kinds = cfg.Kinds
+	}
+	if strings.Join(c.Languages, ",") != strings.Join(cfg.Languages, ",") {
+		res = append(res, "languages")
+		c.Languages = cfg.Languages
+	}
+	if c.KindPriorsEvery != cfg.KindPriorsEvery {
+		res = append(res, "kind_priors_every")
+		c.KindPriorsEvery = cfg.KindPriorsEvery
+	}
+	return res
+}
+
+func exportState() (*serviceState, error) {
+	c := *cfg
+	c.keepEnv(defaultConfig())
+	c.Rules = getRules()
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(b)
+
+	res := &serviceState{
+		Exported:      time.Now(),
+		Config:        &raw,
+		Tombstones:    []*tombstone{},
+		DisabledKinds: []string{},
+		KindClicks:    make(map[string]int, len(kindOrder)),
+	}
+	tombstones.Range(func(_, v interface{}) bool {
+		res.Tombstones = append(res.Tombstones, v.(*tombstone))
+		return true
+	})
+	sort.Slice(res.Tombstones, func(i, j int) bool { return res.Tombstones[i].Deleted.Before(res.Tombstones[j].Deleted) })
+	for _, k := range kindOrder {
+		if kindDisabled(k) {
+			res.DisabledKinds = append(res.DisabledKinds, k)
+		}
+	}
+	kindPriors.RLock()
+	for k, v := range kindPriors.clicks {
+		res.KindClicks[k] = v
+	}
+	kindPriors.RUnlock()
+	return res, nil
+}
+
+func adminStateExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := exportState()
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=state.json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
+
+func adminStateImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer func() { _ = r.Body.Close() }()
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var v serviceState
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		internalServerError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var c *config
+	if v.Config != nil {
+		c, err = parseConfig(*v.Config, &config{}, cfg)
+		if err != nil {
+			internalServerError(w, fmt.Errorf("config: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		_, err = compileRules(c.Rules)
+		if err != nil {
+			internalServerError(w, fmt.Errorf("config: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, t := range v.Tombstones {
+		if t == nil || !contains(kindOrder, t.Kind) || t.ID == "" {
+			internalServerError(w, fmt.Errorf("invalid tombstone"), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, k := range v.DisabledKinds {
+		if !contains(kindOrder, k) {
+			internalServerError(w, fmt.Errorf("unknown kind: %s", k), http.StatusBadRequest)
+			return
+		}
+	}
+	for k, n := range v.KindClicks {
+		if !contains(kindOrder, k) || n < 0 {
+			internalServerError(w, fmt.Errorf("invalid kind_clicks: %s %d", k, n), http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := struct {
+		Imported   []string `json:"imported"`
+		NotApplied []string `json:"not_applied,omitempty"`
+	}{Imported: []string{}}
+
+	if c != nil {
+		res.NotApplied = c.startupOnly()
+		_ = setRules(c.Rules)
+		cfg = c
+		res.Imported = append(res.Imported, "config")
+	}
+	if v.Tombstones != nil {
+		tombstones.Range(func(k, _ interface{}) bool {
+			tombstones.Delete(k)
+			return true
+		})
+		for _, t := range v.Tombstones {
+			if t.Deleted.IsZero() {
+				t.Deleted = time.Now()
+			}
+			tombstones.Store(t.Kind+"/"+t.ID, t)
+		}
+		res.Imported = append(res.Imported, "tombstones")
+	}
+	if v.DisabledKinds != nil {
+		for _, k := range kindOrder {
+			if contains(v.DisabledKinds, k) {
+				disabledKinds.Store(k, struct{}{})
+			} else {
+				disabledKinds.Delete(k)
+			}
+		}
+		res.Imported = append(res.Imported, "disabled_kinds")
+	}
+	if v.KindClicks != nil {
+		kindPriors.Lock()
+		kindPriors.seed = v.KindClicks
+		kindPriors.Unlock()
+		err = learnKindPriors()
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		res.Imported = append(res.Imported, "kind_clicks")
+	}
+	resCache.purge()
+	staleETags()
+
+	b, err = json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The sales and a copy of the current vaults go to the Store of
+// cfg.Store: in memory (the default, fast and lost on restart), a bbolt
+// file at cfg.StorePath or Redis at cfg.RedisURL (shared by replicas).
+// The vaults of a generation replace the previous ones at once
+// (SwapGeneration) after an upload or a rollback, and a durable store
+// brings the last upload back at startup when no cold generation does,
+// see restoreStore. The searches keep reading the vaults in memory.
+
+// Store backends, see config.Store
+const (
+	storeMemory = "memory"
+	storeBolt   = "bolt"
+	storeRedis  = "redis"
+)
+
+// Store keeps values by key in named buckets
+type Store interface {
+	// Get returns the values of the keys, nil for the missing ones
+	Get(bucket string, keys []string) ([][]byte, error)
+	Put(bucket string, kv map[string][]byte) error
+	// Scan calls fn for every key of the bucket until it returns false
+	Scan(bucket string, fn func(key string, val []byte) bool) error
+	Len(bucket string) (int, error)
+	// SwapGeneration replaces the buckets with the ones given at once
+	SwapGeneration(buckets map[string]map[string][]byte) error
+	Close() error
+}
+
+var dataStore Store = newMemStore()
+
+// buckets of the store
+const (
+	salesBucket      = "sales"
+	salesVersion     = "sales:version" // "v": changes with every merge
+	generationBucket = "generation"    // id and header of the stored vaults
+	vaultBucket      = "vault:"        // + index key
+)
+
+// openStore returns the store of cfg.Store, Redis through pool
+func openStore(pool *redis.Pool) (Store, error) {
+	switch cfg.Store {
+	case storeBolt:
+		db, err := bolt.Open(cfg.StorePath, 0644, &bolt.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("bolt: %v", err)
+		}
+		return &boltStore{db}, nil
+	case storeRedis:
+		return &redisStore{pool}, nil
+	}
+	return newMemStore(), nil
+}
+
+type memStore struct {
+	sync.RWMutex
+	m map[string]map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{m: make(map[string]map[string][]byte)}
+}
+
+func (s *memStore) Get(bucket string, keys []string) ([][]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make([][]byte, len(keys))
+	for i, k := range keys {
+		res[i] = s.m[bucket][k]
+	}
+	return res, nil
+}
+
+func (s *memStore) Put(bucket string, kv map[string][]byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	b := s.m[bucket]
+	if b == nil {
+		b = make(map[string][]byte, len(kv))
+		s.m[bucket] = b
+	}
+	for k, v := range kv {
+		b[k] = v
+	}
+	return nil
+}
+
+func (s *memStore) Scan(bucket string, fn func(string, []byte) bool) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	for k, v := range s.m[bucket] {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Len(bucket string) (int, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.m[bucket]), nil
+}
+
+func (s *memStore) SwapGeneration(buckets map[string]map[string][]byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for k, v := range buckets {
+		s.m[k] = v
+	}
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Get(bucket string, keys []string) ([][]byte, error) {
+	res := make([][]byte, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		for i, k := range keys {
+			if v := b.Get([]byte(k)); v != nil {
+				res[i] = append([]byte(nil), v...) // valid in the tx only
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *boltStore) Put(bucket string, kv map[string][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for k, v := range kv {
+			err = b.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Scan(bucket string, fn func(string, []byte) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !fn(string(k), append([]byte(nil), v...)) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Len(bucket string) (int, error) {
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+// SwapGeneration rewrites the buckets in one transaction
+func (s *boltStore) SwapGeneration(buckets map[string]map[string][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for name, kv := range buckets {
+			if tx.Bucket([]byte(name)) != nil {
+				err := tx.DeleteBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range kv {
+				err = b.Put([]byte(k), v)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error { return s.db.Close() }
+
+// redisStore keeps a bucket as the hash "test-bleve:<bucket>", the sales
+// where they were before the store
+type redisStore struct {
+	pool *redis.Pool
+}
+
+func redisKey(bucket string) string {
+	return "test-bleve:" + bucket
+}
+
+func (s *redisStore) Get(bucket string, keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	c := s.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	return redis.ByteSlices(c.Do("HMGET", redis.Args{}.Add(redisKey(bucket)).AddFlat(keys)...))
+}
+
+func (s *redisStore) Put(bucket string, kv map[string][]byte) error {
+	c := s.pool.Get()
+	defer func() { _ = c.Close() }()
+	return redisHSet(c, redisKey(bucket), kv)
+}
+
+// redisHSet sets the fields of the hash by a thousand
+func redisHSet(c redis.Conn, key string, kv map[string][]byte) error {
+	args := redis.Args{}.Add(key)
+	n := 0
+	for k, v := range kv {
+		args = args.Add(k, v)
+		if n++; n%1000 == 0 {
+			_, err := c.Do("HSET", args...)
+			if err != nil {
+				return err
+			}
+			args = redis.Args{}.Add(key)
+		}
+	}
+	if len(args) > 1 {
+		_, err := c.Do("HSET", args...)
+		return err
+	}
+	return nil
+}
+
+func (s *redisStore) Scan(bucket string, fn func(string, []byte) bool) error {
+	c := s.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	cursor := 0
+	for {
+		v, err := redis.Values(c.Do("HSCAN", redisKey(bucket), cursor, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+		cursor, _ = redis.Int(v[0], nil)
+		kv, err := redis.ByteSlices(v[1], nil)
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			if !fn(string(kv[i]), kv[i+1]) {
+				return nil
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *redisStore) Len(bucket string) (int, error) {
+	c := s.pool.Get()
+	defer func() { _ = c.Close() }()
+	return redis.Int(c.Do("HLEN", redisKey(bucket)))
+}
+
+// SwapGeneration fills new hashes aside and renames them over the old
+// ones in one transaction
+func (s *redisStore) SwapGeneration(buckets map[string]map[string][]byte) error {
+	c := s.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	for name, kv := range buckets {
+		_, err := c.Do("DEL", redisKey(name)+":new")
+		if err != nil {
+			return err
+		}
+		err = redisHSet(c, redisKey(name)+":new", kv)
+		if err != nil {
+			return err
+		}
+	}
+	_ = c.Send("MULTI")
+	for name, kv := range buckets {
+		if len(kv) == 0 {
+			_ = c.Send("DEL", redisKey(name)) // no empty hash to rename
+			continue
+		}
+		_ = c.Send("RENAME", redisKey(name)+":new", redisKey(name))
+	}
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (s *redisStore) Close() error { return nil }
+
+// storeSales keeps the sales of the ids in the sales bucket
+type storeSales struct {
+	Store
+}
+
+func (s *storeSales) load(ids []int) []int {
+	res := make([]int, len(ids))
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = strconv.Itoa(id)
+	}
+	vals, err := s.Get(salesBucket, keys)
+	if err != nil {
+		log.Printf("err: store: %s", err.Error())
+		return res
+	}
+	for i, v := range vals {
+		if v != nil && i < len(res) {
+			res[i], _ = strconv.Atoi(string(v))
+		}
+	}
+	return res
+}
+
+func (s *storeSales) merge(m map[int]int) error {
+	kv := make(map[string][]byte, len(m))
+	for k, v := range m {
+		kv[strconv.Itoa(k)] = []byte(strconv.Itoa(v))
+	}
+	err := s.Put(salesBucket, kv)
+	if err != nil {
+		return err
+	}
+	return s.Put(salesVersion, map[string][]byte{"v": []byte(strconv.FormatInt(time.Now().UnixNano(), 10))})
+}
+
+func (s *storeSales) version() string {
+	vals, err := s.Get(salesVersion, []string{"v"})
+	if err != nil {
+		log.Printf("err: store: %s", err.Error())
+		return ""
+	}
+	return string(vals[0])
+}
+
+func (s *storeSales) count() int {
+	n, err := s.Len(salesBucket)
+	if err != nil {
+		log.Printf("err: store: %s", err.Error())
+	}
+	return n
+}
+
+// saveGeneration copies the vaults of g to a durable store
+func saveGeneration(g *generation) {
+	if _, mem := dataStore.(*memStore); mem || g == nil {
+		return
+	}
+	start := time.Now()
+
+	header, _ := json.Marshal(g.header)
+	buckets := map[string]map[string][]byte{
+		generationBucket: {"id": []byte(strconv.FormatInt(g.ID, 10)), "header": header},
+	}
+	for key, vlt := range g.vault {
+		kv := make(map[string][]byte)
+		vlt.Range(func(k, v interface{}) bool {
+			b, err := json.Marshal(v.(*baseDoc))
+			if err == nil {
+				kv[k.(string)] = b
+			}
+			return true
+		})
+		buckets[vaultBucket+key] = kv
+	}
+
+	err := dataStore.SwapGeneration(buckets)
+	if err != nil {
+		log.Printf("err: store generation %d: %s", g.ID, err.Error())
+		return
+	}
+	log.Printf("store: generation %d saved in %s", g.ID, time.Since(start))
+}
+
+// restoreStore builds the stored generation again from the source rows of
+// its docs, unless there is a generation already
+func restoreStore() {
+	if _, mem := dataStore.(*memStore); mem {
+		return
+	}
+	if g, _ := indexDB.generations(); g != nil {
+		return
+	}
+
+	vals, err := dataStore.Get(generationBucket, []string{"id", "header"})
+	if err != nil || len(vals) != 2 || vals[0] == nil {
+		return
+	}
+	id, _ := strconv.ParseInt(string(vals[0]), 10, 64)
+	var header []string
+	err = json.Unmarshal(vals[1], &header)
+	if err != nil {
+		log.Printf("err: store generation %d: %s", id, err.Error())
+		return
+	}
+
+	var rows [][]string
+	for _, lang := range langOrder {
+		for _, kind := range kindOrder {
+			err = dataStore.Scan(vaultBucket+kind+"-"+lang, func(_ string, v []byte) bool {
+				d := &baseDoc{}
+				if json.Unmarshal(v, d) == nil && len(d.Row) > 0 {
+					rows = append(rows, d.Row)
+				}
+				return true
+			})
+			if err != nil {
+				log.Printf("err: store generation %d: %s", id, err.Error())
+				return
+			}
+		}
+	}
+	// ids in order, the docs of an id in the upload order do not matter
+	sort.SliceStable(rows, func(i, j int) bool { return keyLess(rows[i][1], rows[j][1]) })
+
+	g, err := buildGeneration(append([][]string{header}, rows...))
+	if err != nil {
+		log.Printf("err: store generation %d: %s", id, err.Error())
+		return
+	}
+	rankVaults(g.vault)
+	g.ID, g.Created = id, time.Now()
+	indexDB.swap(g)
+	log.Printf("store: generation %d restored from %s, %d rows", id, cfg.Store, len(rows))
+}
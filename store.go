@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Stores shared by replicas. By default the sales and the response cache
+// live in memory; with cfg.RedisURL set both go to Redis, so one upload
+// is seen by every replica. The sales go to the Store of cfg.Store, see
+// openStore.
+
+type salesStore interface {
+	load(ids []int) []int
+	merge(m map[int]int) error
+	count() int
+	// version changes with every merge, of any replica
+	version() string
+}
+
+type cacheStore interface {
+	get(key string) (string, []byte, bool)
+	put(key, ctype string, b []byte)
+	purge()
+}
+
+var (
+	sales    salesStore = &storeSales{dataStore}
+	resCache cacheStore = &memCache{m: make(map[string]cacheEntry)}
+)
+
+func setupStores() error {
+	var pool *redis.Pool
+	if cfg.RedisURL != "" {
+		pool = &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(cfg.RedisURL)
+			},
+		}
+		c := pool.Get()
+		defer func() { _ = c.Close() }()
+		_, err := c.Do("PING")
+		if err != nil {
+			return fmt.Errorf("redis: %v", err)
+		}
+		resCache = &redisCache{pool}
+	}
+
+	st, err := openStore(pool)
+	if err != nil {
+		return err
+	}
+	dataStore, sales = st, &storeSales{st}
+	return nil
+}
+
+type cacheEntry struct {
+	ctype string
+	b     []byte
+	exp   time.Time
+}
+
+type memCache struct {
+	sync.RWMutex
+	m map[string]cacheEntry
+}
+
+func (c *memCache) get(key string) (string, []byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	e, ok := c.m[key]
+	if !ok || time.Now().After(e.exp) {
+		return "", nil, false
+	}
+	return e.ctype, e.b, true
+}
+
+func (c *memCache) put(key, ctype string, b []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if len(c.m) >= cfg.CacheSize {
+		for k, e := range c.m {
+			if now.After(e.exp) {
+				delete(c.m, k)
+			}
+		}
+		if len(c.m) >= cfg.CacheSize {
+			return
+		}
+	}
+	c.m[key] = cacheEntry{ctype, b, now.Add(time.Duration(cfg.CacheTTL) * time.Second)}
+}
+
+func (c *memCache) purge() {
+	c.Lock()
+	c.m = make(map[string]cacheEntry)
+	c.Unlock()
+}
+
+// redisCache keys carry a generation counter, purge just bumps it and the
+// old entries expire by TTL.
+type redisCache struct {
+	pool *redis.Pool
+}
+
+const redisCacheGen = "test-bleve:cache:gen"
+
+func (rc *redisCache) get(key string) (string, []byte, bool) {
+	c := rc.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	gen, _ := redis.Int(c.Do("GET", redisCacheGen))
+	v, err := redis.ByteSlices(c.Do("HMGET", fmt.Sprintf("test-bleve:cache:%d:%s", gen, key), "t", "b"))
+	if err != nil || len(v) != 2 || v[1] == nil {
+		return "", nil, false
+	}
+	return string(v[0]), v[1], true
+}
+
+func (rc *redisCache) put(key, ctype string, b []byte) {
+	c := rc.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	gen, _ := redis.Int(c.Do("GET", redisCacheGen))
+	k := fmt.Sprintf("test-bleve:cache:%d:%s", gen, key)
+	_ = c.Send("MULTI")
+	_ = c.Send("HSET", k, "t", ctype, "b", b)
+	_ = c.Send("EXPIRE", k, cfg.CacheTTL)
+	_, err := c.Do("EXEC")
+	if err != nil {
+		log.Printf("err: redis: %s", err.Error())
+	}
+}
+
+func (rc *redisCache) purge() {
+	c := rc.pool.Get()
+	defer func() { _ = c.Close() }()
+
+	_, err := c.Do("INCR", redisCacheGen)
+	if err != nil {
+		log.Printf("err: redis: %s", err.Error())
+	}
+}
+
+// cacheKey identifies a search request: endpoint, languages, encoding,
+// the client's section order and the request itself.
+func cacheKey(r *http.Request, body []byte) string {
+	h := sha1.New()
+	for _, v := range []string{r.URL.Path, r.URL.RawQuery, r.Header.Get("Accept-Language"), r.Header.Get("Accept"), r.Header.Get(generationHeader), strings.Join(clientOrder(r), ",")} {
+		_, _ = io.WriteString(h, v)
+		_, _ = h.Write([]byte{0})
+	}
+	_, _ = h.Write(body)
+	return strconv.Itoa(len(body)) + ":" + fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// cachedResult writes the cached response of the request if there is one
+func cachedResult(w http.ResponseWriter, key string) bool {
+	if cfg.CacheTTL <= 0 {
+		return false
+	}
+	ctype, b, ok := resCache.get(key)
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/registry"
+	"golang.org/x/text/unicode/norm"
+)
+
+const stressCharFilterName = "stress"
+
+// stressMarks are the combining accents used as stress marks (наголос).
+// Other combining marks must survive: NFD splits "й" and "ї" into a base
+// letter plus a breve or a diaeresis.
+var stressMarks = strings.NewReplacer(
+	"\u0300", "", // combining grave accent
+	"\u0301", "", // combining acute accent
+	"\u0341", "", // combining acute tone mark
+)
+
+// apostrophes are the marks typed for the Ukrainian apostrophe. The
+// tokenizer keeps "м'ята" and "мʼята" whole but as different words and
+// normName splits some of them off, so they are dropped: any of them and
+// none at all make the same word.
+var apostrophes = strings.NewReplacer(
+	"'", "",
+	"`", "",
+	"\u2018", "", // left single quotation mark
+	"\u2019", "", // right single quotation mark
+	"\u02bc", "", // modifier letter apostrophe
+)
+
+// stripStress removes stress marks and apostrophes, e.g. "Ибупро́фен" ->
+// "Ибупрофен", "Мʼя́та" -> "Мята"
+func stripStress(s string) string {
+	return norm.NFC.String(apostrophes.Replace(stressMarks.Replace(norm.NFD.String(s))))
+}
+
+type stressCharFilter struct{}
+
+func (stressCharFilter) Filter(input []byte) []byte {
+	return []byte(stripStress(string(input)))
+}
+
+func init() {
+	registry.RegisterCharFilter(stressCharFilterName,
+		func(map[string]interface{}, *registry.Cache) (analysis.CharFilter, error) {
+			return stressCharFilter{}, nil
+		},
+	)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripStress(t *testing.T) {
+	for _, v := range []struct{ in, want string }{
+		{"Ибупро́фен", "Ибупрофен"},
+		{"Нуро́фен Фо́рте", "Нурофен Форте"},
+		{"Аскорбі́нова кисло́та", "Аскорбінова кислота"},
+		{"Парацетамо̀л", "Парацетамол"},
+		{"Лідокаї́н", "Лідокаїн"}, // the diaeresis of ї stays
+		{"Йо́д", "Йод"}, // the breve of й stays
+		{"М'я́та пе́рцева", "Мята перцева"},
+		{"М’я́та пе́рцева", "Мята перцева"},
+		{"Мʼя́та пе́рцева", "Мята перцева"},
+		{"М`ята перцева", "Мята перцева"},
+		{"Валеріа́ни екстра́кт", "Валеріани екстракт"},
+	} {
+		if got := stripStress(v.in); got != v.want {
+			t.Errorf("%q: got %q, want %q", v.in, got, v.want)
+		}
+	}
+}
+
+// TestStressApostrophes checks that the apostrophe variants, with stress
+// marks or not, make the same query words as no apostrophe
+func TestStressApostrophes(t *testing.T) {
+	want := normName("Мята перцева")
+	for _, s := range []string{"М'я́та пе́рцева", "М’ята перцева", "Мʼята пе́рцева", "М`ята перцева"} {
+		if got := normName(s); got != want {
+			t.Errorf("%q: got %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestStressSearch(t *testing.T) {
+	for _, v := range []struct {
+		path, plain, stressed string
+		ua                    bool
+	}{
+		{"/test/select-sugg", "Ибупрофен", "Ибупро́фен", false},
+		{"/test/select-sugg", "Ібупрофен", "Ібупро́фен", true},
+		{"/test/select-suggestion", "нурофен форте", "нуро́фен фо́рте", false},
+		{"/test/select-suggestion", "аскорбінова кислота", "аскорбі́нова кисло́та", true},
+		{"/test/select-suggestion", "дарниця", "дарни́ця", true},
+	} {
+		want := serve("POST", v.path, `{"name":"`+v.plain+`"}`, v.ua).Body.String()
+		got := stripStress(serve("POST", v.path, `{"name":"`+v.stressed+`"}`, v.ua).Body.String())
+		if got != want || !strings.Contains(got, `"`+strings.ToUpper(v.plain)) && !strings.Contains(got, "keys") {
+			t.Errorf("%s %q: got %q, want %q", v.path, v.stressed, got, want)
+		}
+	}
+}
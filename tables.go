@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The keyboard maps (mapKB and the ones of the language packs) and the
+// collators are checked at startup and on every reload of the packs: a
+// keyboard of another size than "en" or with a key twice converts the
+// wrong letters without an error, so startup fails on one and a reload
+// keeps the loaded packs (see loadLangPacks). The results are in the
+// "tables" of GET /admin/status.
+
+type tableCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type tableStatus struct {
+	Checked time.Time    `json:"checked"`
+	Checks  []tableCheck `json:"checks"`
+}
+
+var tableChecks = struct {
+	sync.RWMutex
+	status tableStatus
+}{}
+
+// checkKeyboard tells if the keys convert to and from the en keyboard
+// without a loss: as many keys as en has, none of them twice
+func checkKeyboard(name string, keys []rune) error {
+	en := mapKB["en"]
+	if len(keys) != len(en) {
+		return fmt.Errorf("keyboard %s: %d keys, en has %d", name, len(keys), len(en))
+	}
+	seen := make(map[rune]int, len(keys))
+	for i, c := range keys {
+		if j, ok := seen[c]; ok {
+			return fmt.Errorf("keyboard %s: %q on keys %d and %d", name, c, j+1, i+1)
+		}
+		seen[c] = i
+	}
+	if s := convRunes(convRunes(string(en), en, keys), keys, en); s != string(en) {
+		return fmt.Errorf("keyboard %s: en-%s-en gives %q", name, name, s)
+	}
+	if s := convRunes(convRunes(string(keys), keys, en), en, keys); s != string(keys) {
+		return fmt.Errorf("keyboard %s: %s-en-%s gives %q", name, name, name, s)
+	}
+	return nil
+}
+
+// keyboardNames returns the built-in and the loaded keyboards, sorted
+func keyboardNames() []string {
+	var names []string
+	for k := range mapKB {
+		names = append(names, k)
+	}
+	langPacks.RLock()
+	for k := range langPacks.keyboards {
+		if mapKB[k] == nil {
+			names = append(names, k)
+		}
+	}
+	langPacks.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// validateTables checks the keyboards and the collators, keeps the results
+// for /admin/status and returns the first failure
+func validateTables() error {
+	var first error
+	var checks []tableCheck
+	add := func(name string, err error) {
+		c := tableCheck{Name: name, OK: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+			if first == nil {
+				first = err
+			}
+		}
+		checks = append(checks, c)
+	}
+	for _, k := range keyboardNames() {
+		add("keyboard "+k, checkKeyboard(k, keyboard(k)))
+	}
+	add("collation", checkCollation())
+
+	tableChecks.Lock()
+	tableChecks.status = tableStatus{Checked: time.Now().UTC(), Checks: checks}
+	tableChecks.Unlock()
+	return first
+}
+
+// tableStatusOf returns the results of the last validateTables
+func tableStatusOf() *tableStatus {
+	tableChecks.RLock()
+	defer tableChecks.RUnlock()
+	if tableChecks.status.Checked.IsZero() {
+		return nil
+	}
+	s := tableChecks.status
+	s.Checks = append([]tableCheck(nil), s.Checks...)
+	return &s
+}
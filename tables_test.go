@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestConvStringRoundTrip types the same keys on the ru, en and uk
+// keyboards and converts them around and back
+func TestConvStringRoundTrip(t *testing.T) {
+	for _, v := range []struct{ en, ru, uk string }{
+		{"rbckjnf", "кислота", "кислота"},
+		{"Yehjatyt", "Нурофене", "Нурофене"},
+		{"fcrjh,syjdf", "аскорбынова", "аскорбінова"},
+		{"[kjhuty ä", "хлорген ä", "хлорген ä"}, // runes of no keyboard stay
+		{"ltrcnhjvtnjhatyb 200", "декстрометорфени 200", "декстрометорфени 200"},
+		{"]tkmyf'", "ъельнаэ", "їельнає"},
+	} {
+		for _, c := range []struct{ from, to, s, want string }{
+			{"en", "ru", v.en, v.ru},
+			{"ru", "en", v.ru, v.en},
+			{"en", "uk", v.en, v.uk},
+			{"uk", "en", v.uk, v.en},
+		} {
+			if got := convString(c.s, c.from, c.to); got != c.want {
+				t.Errorf("%s-%s %q: got %q, want %q", c.from, c.to, c.s, got, c.want)
+			}
+		}
+		if got := convString(convString(v.ru, "ru", "en"), "en", "uk"); got != v.uk {
+			t.Errorf("ru-en-uk %q: got %q, want %q", v.ru, got, v.uk)
+		}
+		if got := convString(convString(v.uk, "uk", "en"), "en", "ru"); got != v.ru {
+			t.Errorf("uk-en-ru %q: got %q, want %q", v.uk, got, v.ru)
+		}
+	}
+}
+
+func TestCheckKeyboard(t *testing.T) {
+	en := mapKB["en"]
+	for _, v := range []struct {
+		name string
+		keys []rune
+		want string
+	}{
+		{"ru", mapKB["ru"], ""},
+		{"uk", mapKB["uk"], ""},
+		{"short", en[1:], "keys, en has"},
+		{"twice", append([]rune{en[1]}, en[1:]...), "on keys 1 and 2"},
+	} {
+		err := checkKeyboard(v.name, v.keys)
+		if v.want == "" && err != nil || v.want != "" && (err == nil || !strings.Contains(err.Error(), v.want)) {
+			t.Errorf("%s: %v, want %q", v.name, err, v.want)
+		}
+	}
+	if err := validateTables(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Tiering for big catalogs: with cfg.ColdDir (or -data-dir) the kind
+// indexes of an upload are built on disk (cold) and opened again after a
+// restart with the source rows of the vaults, see restoreColdDir. With
+// cfg.HotSize the docs returned most often are copied every
+// cfg.HotRefresh seconds into small in-memory indexes (hot). findByName
+// searches the hot index first and the cold one only if it got fewer than
+// cfg.HotMin hits.
+
+var hotTier = struct {
+	sync.RWMutex
+	gen   int64                     // the generation the hot indexes come from
+	idx   map[string]bleve.Index    // index key -> hot index
+	count map[string]map[string]int // index key -> doc key -> times returned
+}{count: make(map[string]map[string]int)}
+
+// coldDir returns a new directory for the indexes of an upload or "" to
+// keep them in memory
+func coldDir() string {
+	if cfg.ColdDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.ColdDir, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+func coldPath(dir, key string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, key)
+}
+
+// cleanColdDir removes the indexes left by a previous run but the keep
+// dir, see restoreColdDir
+func cleanColdDir(keep string) {
+	if cfg.ColdDir == "" {
+		return
+	}
+	list, err := ioutil.ReadDir(cfg.ColdDir)
+	if err != nil {
+		return
+	}
+	for _, v := range list {
+		if _, err := strconv.ParseInt(v.Name(), 10, 64); err == nil && v.IsDir() && v.Name() != keep {
+			_ = os.RemoveAll(filepath.Join(cfg.ColdDir, v.Name()))
+		}
+	}
+}
+
+// hotIndex returns the hot index of the key for the current generation
+func hotIndex(key string) bleve.Index {
+	g, _ := indexDB.generations()
+	hotTier.RLock()
+	defer hotTier.RUnlock()
+
+	if g == nil || g.ID != hotTier.gen {
+		return nil
+	}
+	return hotTier.idx[key]
+}
+
+// countReturned records the doc keys returned from the index
+func countReturned(key string, docs map[string][]string) {
+	if cfg.HotSize <= 0 {
+		return
+	}
+
+	hotTier.Lock()
+	defer hotTier.Unlock()
+
+	m := hotTier.count[key]
+	if m == nil {
+		m = make(map[string]int)
+		hotTier.count[key] = m
+	}
+	for _, keys := range docs {
+		for _, k := range keys {
+			m[k]++
+		}
+	}
+}
+
+func hotLoop() {
+	if cfg.HotSize <= 0 {
+		return
+	}
+	for range time.Tick(time.Duration(cfg.HotRefresh) * time.Second) {
+		refreshHot()
+	}
+}
+
+// refreshHot rebuilds the hot indexes from the top cfg.HotSize docs of
+// each index, the counts are halved so that old popularity fades.
+func refreshHot() {
+	g, _ := indexDB.generations()
+	if g == nil {
+		return
+	}
+
+	top := make(map[string][]string, len(g.store))
+	hotTier.Lock()
+	for key, m := range hotTier.count {
+		keys := make([]string, 0, len(m))
+		for k, n := range m {
+			keys = append(keys, k)
+			if m[k] = n / 2; m[k] == 0 {
+				delete(m, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if m[keys[i]] != m[keys[j]] {
+				return m[keys[i]] > m[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		if len(keys) > cfg.HotSize {
+			keys = keys[:cfg.HotSize]
+		}
+		top[key] = keys
+	}
+	hotTier.Unlock()
+
+	hot := make(map[string]bleve.Index, len(top))
+	for key, keys := range top {
+		vlt, ok := g.vault[key]
+		if !ok || len(keys) == 0 {
+			continue
+		}
+		idx, err := newIndex(indexName(key), "")
+		if err != nil {
+			log.Printf("err: %s", err.Error())
+			continue
+		}
+		b := idx.NewBatch()
+		for _, k := range keys {
+			if v, ok := vlt.Load(k); ok {
+				d := v.(*baseDoc)
+				_ = b.Index(k+"|"+strTo8SHA1(d.Name), d.nameDoc())
+			}
+		}
+		err = idx.Batch(b)
+		if err != nil {
+			log.Printf("err: %s", err.Error())
+			continue
+		}
+		hot[key] = idx
+	}
+
+	hotTier.Lock()
+	hotTier.gen, hotTier.idx = g.ID, hot
+	hotTier.Unlock()
+}
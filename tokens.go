@@ -0,0 +1,112 @@
+package main
+
+import "strings"
+
+// tokenMatch tells the UI what became of one query word: the suggestions
+// it matched (names, or keys for the inf section), why it was not
+// searched at all, or the form that matched instead of it (keyboard
+// layout conversion). Requested with "tokens": true.
+type tokenMatch struct {
+	Token   string   `json:"token"`
+	Names   []string `json:"names,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+	Dropped string   `json:"dropped,omitempty"`
+	Relaxed string   `json:"relaxed,omitempty"`
+}
+
+// explainTokens fills r.Tokens for the query as the user typed it (with
+// the excluded words), over the suggestions left in the result.
+func (r *result) explainTokens(query string, ua bool) {
+	core := query
+	if r.Parsed != nil && r.Parsed.Core != "" {
+		core = r.Parsed.Core
+	}
+	parsed := strings.Fields(strings.ToLower(normName(core)))
+	rewritten := strings.Fields(strings.ToLower(normName(rewriteQuery(core))))
+
+	// every shown suggestion with the texts it can match on
+	type entry struct {
+		name, key string
+		texts     []string
+	}
+	var list []entry
+	for _, n := range r.Sugg {
+		list = append(list, entry{name: n, texts: []string{n}})
+	}
+	inf := kindKey("inf", ua)
+	sec := r.sections()
+	for _, k := range kindOrder {
+		for _, v := range *sec[k] {
+			if k != "inf" {
+				list = append(list, entry{name: v.Name, texts: []string{v.Name}})
+				continue
+			}
+			vlt, err := r.index().getVault(inf)
+			if err != nil {
+				continue
+			}
+			for _, key := range v.Keys {
+				if d, ok := vlt.Load(key); ok {
+					doc := d.(*baseDoc)
+					list = append(list, entry{key: key, texts: append([]string{doc.Name, doc.Latin}, doc.Names...)})
+				}
+			}
+		}
+	}
+
+	r.Tokens = make([]tokenMatch, 0, 4)
+	for _, t := range strings.Fields(query) {
+		m := tokenMatch{Token: t}
+		w := strings.ToLower(strings.TrimSpace(normName(t)))
+		switch {
+		case len(t) > 1 && t[0] == '-':
+			m.Dropped = "excluded"
+		case w == "":
+			m.Dropped = "punctuation"
+		case !containsAll(parsed, strings.Fields(w)):
+			m.Dropped = "parsed" // dosage, form or count, see parseLine
+		case !containsAll(rewritten, strings.Fields(w)):
+			m.Dropped = "rewritten"
+		}
+		if m.Dropped != "" {
+			r.Tokens = append(r.Tokens, m)
+			continue
+		}
+
+		conv := strings.ToLower(layoutConv(w, ua))
+		for _, e := range list {
+			hit, relaxed := false, false
+			for _, s := range e.texts {
+				if matchRank(s, w) > 0 {
+					hit = true
+				} else if conv != w && matchRank(s, conv) > 0 {
+					relaxed = true
+				}
+			}
+			if !hit && !relaxed {
+				continue
+			}
+			if !hit {
+				m.Relaxed = conv
+			}
+			if e.key != "" {
+				m.Keys = append(m.Keys, e.key)
+			} else {
+				m.Names = append(m.Names, e.name)
+			}
+		}
+		if len(m.Names) == 0 && len(m.Keys) == 0 && contains(legalForms, w) {
+			m.Dropped = "legal form" // not indexed for org, see legal.go
+		}
+		r.Tokens = append(r.Tokens, m)
+	}
+}
+
+func containsAll(a, b []string) bool {
+	for _, v := range b {
+		if !contains(a, v) {
+			return false
+		}
+	}
+	return true
+}
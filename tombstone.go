@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tombstones mark docs deleted without removing them: the docs stay in the
+// vault (and in /admin/doc and /admin/export, marked) but are left out of
+// every search. They survive uploads, so a product stays deleted until it
+// is restored.
+//
+// $ curl -i -H 'X-Api-Key: secret' http://localhost:8080/admin/tombstones
+// $ curl -i -H 'X-Api-Key: secret' -d '[{"kind":"inf","id":"3","reason":"recalled"}]' http://localhost:8080/admin/tombstones
+// $ curl -i -H 'X-Api-Key: secret' -d '[{"kind":"inf","id":"3","restore":true}]' http://localhost:8080/admin/tombstones
+
+type tombstone struct {
+	Kind    string    `json:"kind"`
+	ID      string    `json:"id"`
+	Reason  string    `json:"reason,omitempty"`
+	Deleted time.Time `json:"deleted"`
+}
+
+var tombstones sync.Map // kind/id -> *tombstone
+
+func deletedDoc(kind, id string) *tombstone {
+	if v, ok := tombstones.Load(kind + "/" + id); ok {
+		return v.(*tombstone)
+	}
+	return nil
+}
+
+// liveKeys drops the deleted keys of the kind
+func liveKeys(kind string, keys []string) []string {
+	res := keys[:0:0]
+	for _, k := range keys {
+		if deletedDoc(kind, k) == nil {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+func adminTombstones(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var v []struct {
+			tombstone
+			Restore bool `json:"restore"`
+		}
+		err = json.Unmarshal(b, &v)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		for i := range v {
+			if !contains(kindOrder, v[i].Kind) || v[i].ID == "" {
+				internalServerError(w, fmt.Errorf("invalid tombstone: %q %q", v[i].Kind, v[i].ID), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for i := range v {
+			k := v[i].Kind + "/" + v[i].ID
+			if v[i].Restore {
+				tombstones.Delete(k)
+				continue
+			}
+			t := v[i].tombstone
+			t.Deleted = time.Now()
+			tombstones.Store(k, &t)
+		}
+		resCache.purge()
+		staleETags()
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := []*tombstone{}
+	tombstones.Range(func(_, v interface{}) bool {
+		res = append(res, v.(*tombstone))
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Deleted.Before(res[j].Deleted) })
+
+	b, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
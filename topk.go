@@ -0,0 +1,57 @@
+package main
+
+import "container/heap"
+
+// magicLess is the key order of sortMagic: info and sales descending, then
+// name and id, see orderingDoc.
+func magicLess(a, b *baseDoc) bool {
+	if a.Info != b.Info {
+		return a.Info > b.Info
+	}
+	if a.Sale != b.Sale {
+		return a.Sale > b.Sale
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.ID < b.ID
+}
+
+// byRank orders docs by their precomputed Rank
+func byRank(a, b *baseDoc) bool { return a.Rank < b.Rank }
+
+// docHeap keeps the worst of the selected docs on top
+type docHeap struct {
+	docs []*baseDoc
+	less func(a, b *baseDoc) bool
+}
+
+func (h docHeap) Len() int            { return len(h.docs) }
+func (h docHeap) Less(i, j int) bool  { return h.less(h.docs[j], h.docs[i]) }
+func (h docHeap) Swap(i, j int)       { h.docs[i], h.docs[j] = h.docs[j], h.docs[i] }
+func (h *docHeap) Push(x interface{}) { h.docs = append(h.docs, x.(*baseDoc)) }
+func (h *docHeap) Pop() interface{} {
+	old := h.docs
+	x := old[len(old)-1]
+	h.docs = old[:len(old)-1]
+	return x
+}
+
+// topDocs returns the k first docs by less in order in O(n log k)
+func topDocs(docs []*baseDoc, k int, less func(a, b *baseDoc) bool) []*baseDoc {
+	h := &docHeap{docs: make([]*baseDoc, 0, k+1), less: less}
+	for _, d := range docs {
+		if h.Len() < k {
+			heap.Push(h, d)
+		} else if less(d, h.docs[0]) {
+			h.docs[0] = d
+			heap.Fix(h, 0)
+		}
+	}
+
+	out := make([]*baseDoc, h.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(h).(*baseDoc)
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testVault makes an index with one vault of docs, ranked in order
+func testVault(key string, docs ...*baseDoc) *index {
+	vlt := &sync.Map{}
+	for i, d := range docs {
+		d.Rank = i + 1
+		vlt.Store(strconv.Itoa(d.ID), d)
+	}
+	return &index{vault: map[string]*sync.Map{key: vlt}}
+}
+
+// TestTopMatched checks that an exact match outranks a better selling
+// partial match, with and without a limit
+func TestTopMatched(t *testing.T) {
+	idx := testVault("inf-ru",
+		&baseDoc{ID: 1, Name: "Окислотан раствор", Sale: 500},
+		&baseDoc{ID: 2, Name: "Кислотан гель", Sale: 300},
+		&baseDoc{ID: 3, Name: "Кислота аскорбиновая", Sale: 10},
+	)
+	match := make(map[string]int)
+	for _, k := range []string{"1", "2", "3"} {
+		d, _ := idx.vault["inf-ru"].Load(k)
+		match[k] = bestMatch(d.(*baseDoc).Name, "кислота", "rbckjnf")
+	}
+
+	for _, v := range []struct {
+		limit int
+		want  []string
+	}{
+		{0, []string{"3", "2", "1"}},
+		{1, []string{"3"}},
+		{2, []string{"3", "2"}},
+	} {
+		got := idx.topMatched("inf-ru", v.limit, match, "1", "2", "3")
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("limit %d: got %v, want %v", v.limit, got, v.want)
+		}
+	}
+	if got, want := idx.topMagic("inf-ru", 0, "3", "2", "1"), []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("no match: got %v, want %v", got, want)
+	}
+}
+
+// benchKeys makes n ranked docs in a vault and their keys in reverse order
+func benchKeys(n int) (*index, []string) {
+	docs := make([]*baseDoc, n)
+	keys := make([]string, n)
+	for i := range docs {
+		docs[i] = &baseDoc{ID: i, Name: "doc", Sale: n - i}
+		keys[n-1-i] = strconv.Itoa(i)
+	}
+	return testVault("inf-ru", docs...), keys
+}
+
+// BenchmarkTopMagic is the limited path of the select-suggestion products:
+// the heap of topDocs, against sorting all keys in BenchmarkSortMagic
+func BenchmarkTopMagic(b *testing.B) {
+	idx, keys := benchKeys(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.topMagic("inf-ru", 20, keys...)
+	}
+}
+
+func BenchmarkSortMagic(b *testing.B) {
+	idx, keys := benchKeys(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.topMagic("inf-ru", 0, keys...)
+	}
+}
+
+func BenchmarkTopDocs(b *testing.B) {
+	docs := make([]*baseDoc, 10000)
+	for i := range docs {
+		docs[i] = &baseDoc{ID: i, Rank: (i * 7919) % len(docs)}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		topDocs(docs, 20, byRank)
+	}
+}
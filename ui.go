@@ -0,0 +1,54 @@
+package main
+
+import (
+	_ "embed" // ui
+	"fmt"
+	"net/http"
+)
+
+// The page at /ui tries the search by hand (QA, content managers): a
+// search box over /test/select-sugg and /test/select-suggestion as you
+// type, with the language, the kinds to show (the fields of the request)
+// and a debug view of the match ranks, meta and token explanations.
+//
+// The page at /ui/admin runs the data operations: uploads with the ingest
+// progress, docs per index and their terms, generations with rollback and
+// migrate, the kinds, deleted docs and the self check. The page is static,
+// only reachable from cfg.AdminAllow like the admin routes; the key typed in
+// is kept for the tab and sent with every call, the endpoints check it.
+//
+// $ curl -i http://localhost:8080/ui
+// $ curl -i http://localhost:8080/ui/admin
+
+//go:embed ui/index.html
+var uiPage []byte
+
+//go:embed ui/admin.html
+var adminPage []byte
+
+func webUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(uiPage)
+}
+
+func adminUI(w http.ResponseWriter, r *http.Request) {
+	if !ipAllowed(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(adminPage)
+}
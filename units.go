@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/registry"
+)
+
+// unit converts a dosage unit into the smallest unit of its dimension, so
+// "0,5 г" and "500 мг" both become "500000мкг" in the index and in queries.
+type unit struct {
+	Base   string  `json:"base"`
+	Factor float64 `json:"factor"`
+}
+
+const unitsCharFilterName = "units"
+
+func defaultUnits() map[string]unit {
+	return map[string]unit{
+		"г":   {"мкг", 1e6},
+		"мг":  {"мкг", 1e3},
+		"мкг": {"мкг", 1},
+		"g":   {"мкг", 1e6},
+		"mg":  {"мкг", 1e3},
+		"mcg": {"мкг", 1},
+		"л":   {"мкл", 1e6},
+		"мл":  {"мкл", 1e3},
+		"l":   {"мкл", 1e6},
+		"ml":  {"мкл", 1e3},
+	}
+}
+
+// unitsRegexp matches a number (with a decimal comma or point) followed by
+// one of the units, longest unit names first.
+func unitsRegexp(units map[string]unit) *regexp.Regexp {
+	names := make([]string, 0, len(units))
+	for k := range units {
+		names = append(names, regexp.QuoteMeta(k))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(` + strings.Join(names, "|") + `)(\P{L}|$)`)
+}
+
+func normUnits(s string) string {
+	if cfg.unitsRe == nil {
+		return s
+	}
+	return cfg.unitsRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := cfg.unitsRe.FindStringSubmatch(m)
+		u, ok := cfg.Units[strings.ToLower(sub[2])]
+		if !ok {
+			return m
+		}
+		v, err := strconv.ParseFloat(strings.Replace(sub[1], ",", ".", 1), 64)
+		if err != nil {
+			return m
+		}
+		return strconv.FormatFloat(math.Round(v*u.Factor), 'f', -1, 64) + u.Base + sub[3]
+	})
+}
+
+type unitsCharFilter struct{}
+
+func (unitsCharFilter) Filter(input []byte) []byte {
+	return []byte(normUnits(string(input)))
+}
+
+func init() {
+	registry.RegisterCharFilter(unitsCharFilterName,
+		func(map[string]interface{}, *registry.Cache) (analysis.CharFilter, error) {
+			return unitsCharFilter{}, nil
+		},
+	)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Webhooks are called with a hookEvent (JSON POST) whenever an ingest
+// completes, fails or is rolled back, so that caches downstream can purge.
+//
+// $ curl -i -H 'X-Api-Key: secret' -d '["https://cdn.example.com/purge"]' http://localhost:8080/admin/webhooks
+
+type hookEvent struct {
+	Event      string        `json:"event"`
+	Generation int64         `json:"generation,omitempty"`
+	Rows       int           `json:"rows,omitempty"`
+	Duration   time.Duration `json:"-"`
+	DurationMS int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+	Time       time.Time     `json:"time"`
+}
+
+var webhooks = struct {
+	sync.RWMutex
+	urls []string
+}{}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func setWebhooks(urls []string) {
+	webhooks.Lock()
+	webhooks.urls = append([]string(nil), urls...)
+	webhooks.Unlock()
+}
+
+func getWebhooks() []string {
+	webhooks.RLock()
+	defer webhooks.RUnlock()
+	return webhooks.urls
+}
+
+// notify posts the event to every webhook in the background, with a few
+// retries for the ones that fail.
+func notify(e hookEvent) {
+	e.DurationMS = int64(e.Duration / time.Millisecond)
+	e.Time = time.Now()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("err: %s", err.Error())
+		return
+	}
+
+	for _, u := range getWebhooks() {
+		go func(u string) {
+			for i := 0; i < 3; i++ {
+				err := postHook(u, b)
+				if err == nil {
+					return
+				}
+				log.Printf("err: webhook %s: %s", u, err.Error())
+				time.Sleep(time.Duration(i+1) * time.Second)
+			}
+		}(u)
+	}
+}
+
+func postHook(u string, b []byte) error {
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func adminWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		defer func() { _ = r.Body.Close() }()
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var urls []string
+		err = json.Unmarshal(b, &urls)
+		if err != nil {
+			internalServerError(w, err, http.StatusBadRequest)
+			return
+		}
+		for _, v := range urls {
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				internalServerError(w, fmt.Errorf("invalid webhook url: %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+		setWebhooks(urls)
+	default:
+		internalServerError(w, fmt.Errorf("%s", http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	urls := getWebhooks()
+	if urls == nil {
+		urls = []string{}
+	}
+	b, err := json.MarshalIndent(urls, "", "\t")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, string(b))
+}
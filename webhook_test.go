@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestNotifyIngest checks the events of an ingest that completes, one
+// that fails and a rollback
+func TestNotifyIngest(t *testing.T) {
+	events := make(chan hookEvent, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e hookEvent
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+	defer setWebhooks(nil)
+	defer func(c config) { *cfg = c }(*cfg)
+	cfg.KeepGenerations = 1
+	if w := serve("POST", "/admin/webhooks", `["`+srv.URL+`"]`, false); w.Code != http.StatusOK {
+		t.Fatalf("webhooks: %d %s", w.Code, w.Body)
+	}
+
+	next := func(want string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Event != want {
+				t.Errorf("got %q, want %q", e.Event, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %q", want)
+		}
+	}
+
+	rec, err := readCSV(fixtureSugg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ingestSugg(rec); err != nil {
+		t.Fatal(err)
+	}
+	next("ingest.completed")
+
+	// the cold dir is under a file, no index can be made there
+	f := filepath.Join(t.TempDir(), "file")
+	if err = os.WriteFile(f, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ColdDir = f
+	if err = ingestSugg(rec); err == nil {
+		t.Fatal("ingest into a file: no error")
+	}
+	cfg.ColdDir = ""
+	next("ingest.failed")
+
+	if w := serve("POST", "/admin/rollback", "", false); w.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", w.Code, w.Body)
+	}
+	next("ingest.rolled_back")
+}
+
+func TestWebhookURLs(t *testing.T) {
+	defer setWebhooks(nil)
+	for _, v := range []struct {
+		body string
+		code int
+	}{
+		{`["https://cdn.example.com/purge", "http://10.0.0.1:8080/purge"]`, http.StatusOK},
+		{`["file:///etc/passwd"]`, http.StatusBadRequest},
+		{`["gopher://example.com/"]`, http.StatusBadRequest},
+		{`["https:///purge"]`, http.StatusBadRequest},
+		{`["cdn.example.com/purge"]`, http.StatusBadRequest},
+	} {
+		if w := serve("POST", "/admin/webhooks", v.body, false); w.Code != v.code {
+			t.Errorf("%s: got %d, want %d", v.body, w.Code, v.code)
+		}
+	}
+	if urls := getWebhooks(); len(urls) != 2 {
+		t.Errorf("after the invalid ones: %v", urls)
+	}
+}